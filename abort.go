@@ -0,0 +1,219 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AbortSummary describes a single AbortUploadsCreatedBefore run.
+type AbortSummary struct {
+	Aborted []string `json:"aborted"`
+	// Skipped are the uploads which were being written to, running the abort again later picks them up.
+	Skipped []string `json:"skipped"`
+}
+
+// AbortUploadsBefore aborts all unfinished uploads created before a given time and returns how many were aborted.
+func (c *ChunkedUploaderService) AbortUploadsBefore(t time.Time) (aborted int, err error) {
+	summary, err := c.AbortUploadsCreatedBefore(t, false)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(summary.Aborted), nil
+}
+
+// AbortUploadsCreatedBefore removes the files of all unfinished uploads created before a given time and releases
+// their reserved space. Uploads with chunks in flight are skipped. With dryRun nothing is removed and the uploads
+// which would be aborted are only listed.
+func (c *ChunkedUploaderService) AbortUploadsCreatedBefore(t time.Time, dryRun bool) (*AbortSummary, error) {
+	summary := &AbortSummary{Aborted: []string{}, Skipped: []string{}}
+
+	var uploadIds []string
+	err := c.walkMetadata(func(meta *UploadMetadata) error {
+		if meta.State == UploadStateUploading && meta.CreatedAt.Before(t) {
+			uploadIds = append(uploadIds, meta.UploadId)
+		}
+		return nil
+	})
+	if err != nil {
+		return summary, fmt.Errorf("ChunkedUploaderService.AbortUploadsCreatedBefore failed to list uploads %w", err)
+	}
+
+	for _, uploadId := range uploadIds {
+		if dryRun {
+			summary.Aborted = append(summary.Aborted, uploadId)
+			continue
+		}
+
+		aborted, err := c.abortUpload(uploadId, time.Time{})
+		if err != nil {
+			return summary, fmt.Errorf("ChunkedUploaderService.AbortUploadsCreatedBefore failed to abort upload %s %w", uploadId, err)
+		}
+		if aborted {
+			summary.Aborted = append(summary.Aborted, uploadId)
+		} else {
+			summary.Skipped = append(summary.Skipped, uploadId)
+		}
+	}
+
+	return summary, nil
+}
+
+// CancelUploadsOlderThan removes the files of all unfinished uploads created more than a given duration ago. When
+// notModifiedAfter is not zero, uploads last modified after it are skipped, so an upload which received a chunk in the
+// meantime is kept.
+func (c *ChunkedUploaderService) CancelUploadsOlderThan(ctx context.Context, age time.Duration, notModifiedAfter time.Time) (*AbortSummary, error) {
+	summary := &AbortSummary{Aborted: []string{}, Skipped: []string{}}
+	createdBefore := time.Now().Add(-age)
+
+	var uploadIds []string
+	err := c.walkMetadata(func(meta *UploadMetadata) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if meta.State == UploadStateUploading && meta.CreatedAt.Before(createdBefore) {
+			uploadIds = append(uploadIds, meta.UploadId)
+		}
+		return nil
+	})
+	if err != nil {
+		return summary, fmt.Errorf("ChunkedUploaderService.CancelUploadsOlderThan failed to list uploads %w", err)
+	}
+
+	for _, uploadId := range uploadIds {
+		aborted, err := c.abortUpload(uploadId, notModifiedAfter)
+		if err != nil {
+			return summary, fmt.Errorf("ChunkedUploaderService.CancelUploadsOlderThan failed to cancel upload %s %w", uploadId, err)
+		}
+		if aborted {
+			summary.Aborted = append(summary.Aborted, uploadId)
+		} else {
+			summary.Skipped = append(summary.Skipped, uploadId)
+		}
+	}
+
+	return summary, nil
+}
+
+// abortUpload removes an upload unless it is being written to or, with a non zero notModifiedAfter, it was modified
+// after that time. New chunks are rejected until the upload is removed, and the modification time is checked under
+// the upload lock, so neither can change before the removal.
+func (c *ChunkedUploaderService) abortUpload(uploadId string, notModifiedAfter time.Time) (bool, error) {
+	release, ok := c.writers.exclude(uploadId)
+	if !ok {
+		return false, nil
+	}
+	defer release()
+
+	unlock, ok := c.locks.tryLock(uploadId)
+	if !ok {
+		return false, nil
+	}
+	defer unlock()
+
+	if c.hasWriteBuffer(uploadId) {
+		return false, nil
+	}
+
+	meta, err := c.readMetadata(uploadId)
+	if errors.Is(err, UploadNotFoundError) {
+		// removed since it was listed
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if meta.State != UploadStateUploading {
+		return false, nil
+	}
+	if !notModifiedAfter.IsZero() && meta.lastModified().After(notModifiedAfter) {
+		return false, nil
+	}
+
+	err = c.RemovePendingFile(uploadId)
+	if err != nil {
+		return false, err
+	}
+
+	c.log(LogLevelInfo, "Aborted upload", LogField{"upload_id", uploadId})
+	return true, nil
+}
+
+type AbortUploadsRequest struct {
+	Before time.Time `json:"before"`
+	// Confirm must be set to actually abort the uploads, otherwise they are only listed.
+	Confirm bool `json:"confirm"`
+}
+
+// AbortUploadsHandler aborts all unfinished uploads created before a given time, it requires admin access. Without
+// confirm it only lists the uploads which would be aborted.
+func (c *ChunkedUploaderHandler) AbortUploadsHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.requireAdmin(w, r) {
+		return
+	}
+
+	var req AbortUploadsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Before.IsZero() {
+		writeJSONError(w, http.StatusBadRequest, "before is required")
+		return
+	}
+
+	summary, err := c.service.AbortUploadsCreatedBefore(req.Before, !req.Confirm)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to abort uploads: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dry_run": !req.Confirm,
+		"aborted": summary.Aborted,
+		"skipped": summary.Skipped,
+	})
+}
+
+// CancelUploadsHandler removes the unfinished uploads created more than older_than_seconds ago, it requires admin
+// access. With an If-Unmodified-Since header only the uploads not modified after the given time are removed.
+func (c *ChunkedUploaderHandler) CancelUploadsHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.requireAdmin(w, r) {
+		return
+	}
+
+	seconds, err := strconv.ParseInt(r.URL.Query().Get("older_than_seconds"), 10, 64)
+	if err != nil || seconds < 0 {
+		writeJSONError(w, http.StatusBadRequest, "older_than_seconds must be a non-negative number")
+		return
+	}
+
+	var notModifiedAfter time.Time
+	if header := r.Header.Get("If-Unmodified-Since"); header != "" {
+		notModifiedAfter, err = http.ParseTime(header)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid If-Unmodified-Since header")
+			return
+		}
+		if notModifiedAfter.After(time.Now()) {
+			writeJSONError(w, http.StatusPreconditionFailed, "If-Unmodified-Since is in the future")
+			return
+		}
+	}
+
+	summary, err := c.service.CancelUploadsOlderThan(r.Context(), time.Duration(seconds)*time.Second, notModifiedAfter)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to cancel uploads: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cancelled":       summary.Aborted,
+		"cancelled_count": len(summary.Aborted),
+		"skipped_count":   len(summary.Skipped),
+	})
+}
@@ -0,0 +1,142 @@
+package chunkeduploader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestAbortSkipsChunkInFlight(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	uploadId, err := service.CreateUpload(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := service.getUploadFilePath(uploadId)
+
+	req := httptest.NewRequest(http.MethodPost, "/"+uploadId+"/upload", &slowReader{remaining: 200, delay: time.Millisecond})
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(rec, req)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := service.fs.Stat(path)
+		if err == nil && info.Size() > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("chunk did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	summary, err := service.AbortUploadsCreatedBefore(time.Now().Add(time.Hour), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Aborted) != 0 || len(summary.Skipped) != 1 {
+		t.Errorf("abort during a chunk: %+v, want the upload skipped", summary)
+	}
+	<-done
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("chunk: %d %s, want 200", rec.Code, rec.Body)
+	}
+	if info, err := service.fs.Stat(path); err != nil || info.Size() != 200 {
+		t.Errorf("pending file after the skipped abort: %v %v, want 200 bytes", info, err)
+	}
+
+	summary, err = service.AbortUploadsCreatedBefore(time.Now().Add(time.Hour), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Aborted) != 1 || summary.Aborted[0] != uploadId {
+		t.Errorf("abort after the chunk: %+v, want the upload aborted", summary)
+	}
+	if exists(t, service.fs, path) {
+		t.Error("pending file exists after the abort")
+	}
+}
+
+func TestChunkWritersExclude(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(w *chunkWriters) (cleanup func())
+		want  bool
+	}{
+		{"idle", func(w *chunkWriters) func() { return func() {} }, true},
+		{"writing", func(w *chunkWriters) func() {
+			_, done, _ := w.start("upload")
+			return done
+		}, false},
+		{"finishing", func(w *chunkWriters) func() {
+			_, release, _ := w.finish("upload")
+			return release
+		}, false},
+		{"cancelled", func(w *chunkWriters) func() {
+			_, release, _ := w.cancel("upload")
+			return release
+		}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &chunkWriters{}
+			cleanup := tc.setup(w)
+			defer cleanup()
+
+			release, ok := w.exclude("upload")
+			if ok != tc.want {
+				t.Fatalf("exclude: %v, want %v", ok, tc.want)
+			}
+			if !ok {
+				return
+			}
+
+			if _, _, err := w.start("upload"); !errors.Is(err, UploadCancelledError) {
+				t.Errorf("start while excluded: %v, want UploadCancelledError", err)
+			}
+			release()
+			_, done, err := w.start("upload")
+			if err != nil {
+				t.Fatalf("start after the release: %v", err)
+			}
+			done()
+		})
+	}
+}
+
+func TestUploadLocksTryLock(t *testing.T) {
+	var l uploadLocks
+
+	unlock, ok := l.tryLock("upload")
+	if !ok {
+		t.Fatal("tryLock of a free upload failed")
+	}
+	if _, ok := l.tryLock("upload"); ok {
+		t.Error("tryLock of a locked upload succeeded")
+	}
+	if other, ok := l.tryLock("other"); !ok {
+		t.Error("tryLock of another upload failed")
+	} else {
+		other()
+	}
+	unlock()
+
+	unlock, ok = l.tryLock("upload")
+	if !ok {
+		t.Fatal("tryLock after the unlock failed")
+	}
+	unlock()
+	if len(l.locks) != 0 {
+		t.Errorf("%d locks left after unlocking", len(l.locks))
+	}
+}
@@ -0,0 +1,69 @@
+package chunkeduploader
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+type ChunkAckFormat int
+
+const (
+	// ChunkAckHeaders acknowledges chunks with headers only, clients asking for application/json still get a body.
+	ChunkAckHeaders ChunkAckFormat = iota
+	// ChunkAckJSON acknowledges every chunk with the headers and a ChunkAck body.
+	ChunkAckJSON
+)
+
+// WithChunkAckFormat sets how chunks are acknowledged, by default only headers are sent.
+func WithChunkAckFormat(format ChunkAckFormat) ChunkedUploaderHandlerOption {
+	return func(c *ChunkedUploaderHandler) {
+		c.chunkAckFormat = format
+	}
+}
+
+// ChunkAck is the JSON acknowledgment of a chunk.
+type ChunkAck struct {
+	Received      int64  `json:"received"`
+	Offset        int64  `json:"offset"`
+	ChunkChecksum string `json:"chunk_checksum"`
+	// Generation is the generation of the upload after the chunk, see UploadStatus.
+	Generation int64 `json:"generation,omitempty"`
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+// wantsJSONAck reports whether a chunk request should be acknowledged with a JSON body.
+func (c *ChunkedUploaderHandler) wantsJSONAck(r *http.Request) bool {
+	if c.chunkAckFormat == ChunkAckJSON {
+		return true
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeChunkAck finishes the acknowledgment of a chunk whose headers are already set.
+func (c *ChunkedUploaderHandler) writeChunkAck(w http.ResponseWriter, r *http.Request, ack ChunkAck) {
+	w.WriteHeader(http.StatusOK)
+	if c.wantsJSONAck(r) {
+		json.NewEncoder(w).Encode(ack)
+	}
+}
@@ -0,0 +1,54 @@
+package chunkeduploader
+
+import "net/http"
+
+type ChunkedUploaderHandlerOption func(*ChunkedUploaderHandler)
+
+// WithAdminAuthorizer sets the function deciding whether a request may use the admin endpoints. Without it all
+// admin endpoints respond with 403.
+func WithAdminAuthorizer(authorize func(r *http.Request) bool) ChunkedUploaderHandlerOption {
+	return func(c *ChunkedUploaderHandler) {
+		c.authorizeAdmin = authorize
+	}
+}
+
+// requireAdmin reports whether a request is allowed to use the admin endpoints, it writes the error response itself.
+func (c *ChunkedUploaderHandler) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if c.authorizeAdmin == nil || !c.authorizeAdmin(r) {
+		writeJSONError(w, http.StatusForbidden, "admin access required")
+		return false
+	}
+
+	return true
+}
+
+// WithOwner sets the function identifying the caller of a request, uploads remember who created them and endpoints
+// exposing upload data only serve their owner. Without it uploads have no owner and are served to everyone.
+func WithOwner(owner func(r *http.Request) string) ChunkedUploaderHandlerOption {
+	return func(c *ChunkedUploaderHandler) {
+		c.ownerOf = owner
+	}
+}
+
+// ownerOptions returns the options recording the caller of a request as the owner of a new upload.
+func (c *ChunkedUploaderHandler) ownerOptions(r *http.Request) []CreateUploadOption {
+	if c.ownerOf == nil {
+		return nil
+	}
+	return []CreateUploadOption{WithUploadOwner(c.ownerOf(r))}
+}
+
+// isOwner reports whether a request comes from the owner of an upload, uploads without an owner belong to everyone.
+func (c *ChunkedUploaderHandler) isOwner(r *http.Request, meta *UploadMetadata) bool {
+	return c.ownerOf == nil || meta.Owner == "" || c.ownerOf(r) == meta.Owner
+}
+
+// requireOwner is isOwner which writes the error response itself.
+func (c *ChunkedUploaderHandler) requireOwner(w http.ResponseWriter, r *http.Request, meta *UploadMetadata) bool {
+	if !c.isOwner(r, meta) {
+		writeJSONError(w, http.StatusForbidden, "not the owner of the upload")
+		return false
+	}
+
+	return true
+}
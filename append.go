@@ -0,0 +1,281 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type UploadMode string
+
+const (
+	// UploadModeRandom uploads accept chunks at any offset.
+	UploadModeRandom UploadMode = "random"
+	// UploadModeAppend uploads only accept chunks appended in sequence, see AppendChunk.
+	UploadModeAppend UploadMode = "append"
+)
+
+var AppendSequenceRequiredError = errors.New("append uploads require a sequence number")
+var NotAppendUploadError = errors.New("upload is not in append mode")
+
+// AppendSequenceError is returned when an append carries a sequence number other than the next expected one.
+type AppendSequenceError struct {
+	Expected int64
+	Got      int64
+}
+
+func (e *AppendSequenceError) Error() string {
+	return fmt.Sprintf("unexpected append sequence %d, expected %d", e.Got, e.Expected)
+}
+
+func WithUploadMode(mode UploadMode) CreateUploadOption {
+	return func(m *UploadMetadata) {
+		m.Mode = mode
+	}
+}
+
+// AppendChunk appends data to an upload created in append mode. The sequence must be exactly one more than the
+// sequence of the last applied append; re-sending the last applied sequence is acknowledged as a duplicate without
+// writing anything, which makes retries after a timeout safe. Appends to a single upload are serialized.
+func (c *ChunkedUploaderService) AppendChunk(uploadId string, sequence int64, data io.Reader) (h string, duplicate bool, err error) {
+	h, _, duplicate, err = c.appendChunk(uploadId, sequence, data)
+	return h, duplicate, err
+}
+
+// appendChunk is AppendChunk which also returns the offset the chunk was written at, for a duplicate it is the
+// current length of the upload.
+func (c *ChunkedUploaderService) appendChunk(uploadId string, sequence int64, data io.Reader) (h string, offset int64, duplicate bool, err error) {
+	ctx, done, err := c.writers.start(uploadId)
+	if err != nil {
+		return "", 0, false, err
+	}
+	defer done()
+	data = cancellableReader(ctx, data)
+
+	// the state of an upload past the maximum duration is changed once the lock is released
+	var expire *UploadMetadata
+	defer func() {
+		if expire != nil {
+			c.expireUpload(expire)
+		}
+	}()
+
+	unlock := c.locks.lock(uploadId)
+	defer unlock()
+
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("ChunkedUploaderService.AppendChunk failed to read metadata %w", err)
+	}
+
+	if meta.Mode != UploadModeAppend {
+		return "", 0, false, NotAppendUploadError
+	}
+
+	if sequence == meta.Sequence && sequence > 0 {
+		return meta.LastChunkChecksum, meta.length(), true, nil
+	}
+
+	if meta.finished() {
+		return "", 0, false, UploadAlreadyFinishedError
+	}
+
+	if err := meta.expired(); err != nil {
+		return "", 0, false, err
+	}
+
+	if err := c.exceededDuration(meta); err != nil {
+		expire = meta
+		return "", 0, false, err
+	}
+
+	if sequence != meta.Sequence+1 {
+		return "", 0, false, &AppendSequenceError{Expected: meta.Sequence + 1, Got: sequence}
+	}
+
+	offset = meta.length()
+	h, written, leaves, err := c.writePart(c.getUploadFilePath(uploadId), data, offset, meta.Durable)
+	if err != nil {
+		// the sequence is not consumed, the client retries the whole append
+		return "", 0, false, fmt.Errorf("ChunkedUploaderService.AppendChunk failed to write chunk %w", err)
+	}
+
+	if written != nil {
+		meta.recordWrite(*written, time.Now())
+		meta.Regions = addRegion(meta.Regions, *written)
+		meta.invalidateChecksum()
+		if c.treeLeaves {
+			meta.TreeLeaves = addTreeLeaves(meta.TreeLeaves, *written, leaves)
+		}
+		c.setWritten(uploadId, regionsLength(meta.Regions))
+	}
+	meta.Sequence = sequence
+	meta.LastChunkChecksum = h
+
+	err = c.saveMetadata(meta)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("ChunkedUploaderService.AppendChunk failed to write metadata %w", err)
+	}
+
+	return h, offset, false, nil
+}
+
+type UploadStatus struct {
+	UploadId string      `json:"upload_id"`
+	State    UploadState `json:"state"`
+	Mode     UploadMode  `json:"mode"`
+	FileSize int64       `json:"file_size"`
+	// Length is the end of the written data, for append uploads it is the current byte length.
+	Length   int64 `json:"length"`
+	Sequence int64 `json:"sequence"`
+	// FailureReason tells why a failed upload was rejected.
+	FailureReason string `json:"failure_reason,omitempty"`
+	// Source and Policy are the source of the upload and the policy applied to it, see WithSourcePolicies.
+	Source string        `json:"source,omitempty"`
+	Policy *SourcePolicy `json:"policy,omitempty"`
+	// Generation changes with every change of the upload, like a chunk or a finish, and never goes backwards. It is
+	// sent as the ETag of the status.
+	Generation int64 `json:"generation"`
+	// ClientContext is the opaque client context of the upload, see WithClientContext.
+	ClientContext string `json:"client_context,omitempty"`
+	// RemainingSeconds is the time left before the upload exceeds the maximum upload duration, it is only set with
+	// WithMaxUploadDuration.
+	RemainingSeconds *int64 `json:"remaining_seconds,omitempty"`
+}
+
+// GetUploadStatus returns the current status of a given upload.
+func (c *ChunkedUploaderService) GetUploadStatus(ctx context.Context, uploadId string) (*UploadStatus, error) {
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetUploadStatus failed to read metadata %w", err)
+	}
+
+	status := &UploadStatus{
+		UploadId: meta.UploadId,
+		State:    meta.State,
+		Mode:     meta.mode(),
+		FileSize: meta.FileSize,
+		Length:   meta.length(),
+		Sequence: meta.Sequence,
+
+		Generation:    meta.Generation,
+		ClientContext: meta.ClientContext,
+		FailureReason: meta.FailureReason,
+		Source:        meta.Source,
+		Policy:        meta.Policy,
+	}
+	if remaining := c.remainingDuration(meta); remaining != nil && !meta.finished() {
+		seconds := int64(remaining.Seconds())
+		status.RemainingSeconds = &seconds
+	}
+
+	return status, nil
+}
+
+// StatusHandler returns the status of a given uploadId.
+func (c *ChunkedUploaderHandler) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	status, err := c.service.GetUploadStatus(r.Context(), uploadId)
+	if err != nil {
+		if errors.Is(err, UploadNotFoundError) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get status: "+err.Error())
+		return
+	}
+
+	etag := generationETag(status.Generation)
+	w.Header().Set("ETag", etag)
+	// the generation is the only validator, If-Modified-Since does not apply
+	if r.Header.Get("If-None-Match") != "" && notModified(r, etag, time.Time{}) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
+
+// appendChunk handles a chunk carrying the X-Append-Sequence header.
+func (c *ChunkedUploaderHandler) appendChunk(w http.ResponseWriter, r *http.Request, uploadId string, sequenceHeader string) {
+	sequence, err := strconv.ParseInt(sequenceHeader, 10, 64)
+	if err != nil || sequence < 1 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid X-Append-Sequence header")
+		return
+	}
+
+	counter := &countingReader{reader: r.Body}
+	h, offset, duplicate, err := c.service.appendChunk(uploadId, sequence, counter)
+	if err != nil {
+		if timedOut(r) {
+			writeTimeoutError(w, HandlerUploadChunk)
+			return
+		}
+		var sequenceErr *AppendSequenceError
+		var expired *UploadExpiredError
+		var exceeded *UploadDurationExceededError
+		switch {
+		case errors.As(err, &expired):
+			c.writeExpiredError(w, r, uploadId, expired)
+		case errors.As(err, &exceeded):
+			writeDurationExceededError(w, exceeded)
+		case errors.As(err, &sequenceErr):
+			w.Header().Set("X-Append-Sequence", strconv.FormatInt(sequenceErr.Expected, 10))
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":             err.Error(),
+				"expected_sequence": sequenceErr.Expected,
+			})
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, UploadCancelledError):
+			writeCancelledError(w, err)
+		case errors.Is(err, UploadAlreadyFinishedError), errors.Is(err, UploadFinishingError):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, NotAppendUploadError):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to append chunk: "+err.Error())
+		}
+		return
+	}
+
+	if duplicate {
+		w.Header().Set("X-Append-Duplicate", "true")
+	}
+	w.Header().Set("X-Checksum", h)
+	c.setExpiresHeader(w, uploadId)
+	generation := c.setGenerationHeader(w, uploadId)
+	c.writeChunkAck(w, r, ChunkAck{Received: counter.count, Offset: offset, ChunkChecksum: h, Generation: generation})
+}
+
+// mode returns the upload mode, uploads created before modes were introduced accept random offsets.
+func (m *UploadMetadata) mode() UploadMode {
+	if m.Mode == "" {
+		return UploadModeRandom
+	}
+	return m.Mode
+}
+
+// length returns the end of the written data.
+func (m *UploadMetadata) length() int64 {
+	if len(m.Regions) == 0 {
+		return 0
+	}
+	return m.Regions[len(m.Regions)-1].End + 1
+}
@@ -0,0 +1,126 @@
+package chunkeduploader
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// appendRequest sends a chunk to an upload with a given X-Append-Sequence header, none when it is empty.
+func appendRequest(handler http.Handler, uploadId string, sequence string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/"+uploadId+"/upload", strings.NewReader(body))
+	if sequence != "" {
+		req.Header.Set("X-Append-Sequence", sequence)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAppendSequence(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	uploadId, err := service.CreateUpload(-1, WithUploadMode(UploadModeAppend))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []struct {
+		name          string
+		sequence      string
+		body          string
+		wantStatus    int
+		wantDuplicate bool
+		// wantExpected is the X-Append-Sequence header of a rejected append
+		wantExpected string
+	}{
+		{"first", "1", "aaa", http.StatusOK, false, ""},
+		{"retry", "1", "aaa", http.StatusOK, true, ""},
+		{"gap", "3", "ccc", http.StatusConflict, false, "2"},
+		{"stale", "0", "xxx", http.StatusBadRequest, false, ""},
+		{"without sequence", "", "xxx", http.StatusBadRequest, false, ""},
+		{"invalid sequence", "two", "xxx", http.StatusBadRequest, false, ""},
+		{"next", "2", "bbb", http.StatusOK, false, ""},
+	} {
+		rec := appendRequest(handler, uploadId, step.sequence, step.body)
+		if rec.Code != step.wantStatus {
+			t.Fatalf("%s: got %d %s, want %d", step.name, rec.Code, rec.Body, step.wantStatus)
+		}
+		if duplicate := rec.Header().Get("X-Append-Duplicate") == "true"; duplicate != step.wantDuplicate {
+			t.Errorf("%s: duplicate %v, want %v", step.name, duplicate, step.wantDuplicate)
+		}
+		if step.wantExpected != "" && rec.Header().Get("X-Append-Sequence") != step.wantExpected {
+			t.Errorf("%s: expected sequence %q, want %q", step.name, rec.Header().Get("X-Append-Sequence"), step.wantExpected)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+uploadId+"/status", nil))
+	var status UploadStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Mode != UploadModeAppend || status.Sequence != 2 || status.Length != 6 {
+		t.Errorf("status: %+v, want append mode at sequence 2 with 6 bytes", status)
+	}
+	if data, err := afero.ReadFile(service.fs, service.getUploadFilePath(uploadId)); err != nil || string(data) != "aaabbb" {
+		t.Errorf("appended data: %q %v, want aaabbb", data, err)
+	}
+}
+
+func TestAppendRetriesApplyOnce(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	uploadId, err := service.CreateUpload(-1, WithUploadMode(UploadModeAppend))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// concurrent retries of the same append are serialized, one of them writes and the others are duplicates
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, 8)
+	for i := range recs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recs[i] = appendRequest(handler, uploadId, "1", "data")
+		}(i)
+	}
+	wg.Wait()
+
+	applied := 0
+	for _, rec := range recs {
+		if rec.Code != http.StatusOK {
+			t.Errorf("append: %d %s, want 200", rec.Code, rec.Body)
+		}
+		if rec.Header().Get("X-Append-Duplicate") != "true" {
+			applied++
+		}
+	}
+	if applied != 1 {
+		t.Errorf("%d appends applied, want 1", applied)
+	}
+	if data, err := afero.ReadFile(service.fs, service.getUploadFilePath(uploadId)); err != nil || string(data) != "data" {
+		t.Errorf("appended data: %q %v, want data", data, err)
+	}
+}
+
+func TestAppendToRandomUpload(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	uploadId, err := service.CreateUpload(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := appendRequest(handler, uploadId, "1", "data"); rec.Code != http.StatusBadRequest {
+		t.Errorf("append to a random upload: %d %s, want 400", rec.Code, rec.Body)
+	}
+}
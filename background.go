@@ -0,0 +1,192 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ServiceAlreadyRunningError = errors.New("service is already running")
+
+// ComponentStatus describes a single background component of the service.
+type ComponentStatus struct {
+	Name         string    `json:"name"`
+	Running      bool      `json:"running"`
+	LastActivity time.Time `json:"last_activity"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// backgroundComponent is a named long-running task owned by the service. The run function must return once the
+// context is done and should call heartbeat whenever it does a unit of work.
+type backgroundComponent struct {
+	name string
+	run  func(ctx context.Context, heartbeat func()) error
+
+	mu           sync.Mutex
+	running      bool
+	lastActivity time.Time
+	lastError    error
+}
+
+// background owns every goroutine spawned by the service, so they can all be stopped with a single Shutdown.
+type background struct {
+	mu         sync.Mutex
+	components []*backgroundComponent
+	cancel     context.CancelFunc
+	done       chan struct{}
+	// stopped is set by Shutdown, so a Run which did not start yet returns right away.
+	stopped bool
+}
+
+// register adds a component which will be started by Run.
+func (b *background) register(name string, run func(ctx context.Context, heartbeat func()) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.components = append(b.components, &backgroundComponent{name: name, run: run})
+}
+
+// Run starts all background components enabled by the service options and blocks until the context is done or
+// Shutdown is called. All components are stopped before it returns. Once Shutdown was called, also before Run, Run
+// returns immediately without starting anything.
+func (c *ChunkedUploaderService) Run(ctx context.Context) error {
+	b := &c.background
+
+	b.mu.Lock()
+	if b.done != nil {
+		b.mu.Unlock()
+		return ServiceAlreadyRunningError
+	}
+	if b.stopped {
+		b.mu.Unlock()
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	b.cancel = cancel
+	b.done = make(chan struct{})
+	done := b.done
+	components := b.components
+	b.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, component := range components {
+		wg.Add(1)
+		go func(component *backgroundComponent) {
+			defer wg.Done()
+			component.start(ctx, c.logger)
+		}(component)
+	}
+
+	<-ctx.Done()
+	wg.Wait()
+
+	b.mu.Lock()
+	b.cancel = nil
+	b.done = nil
+	b.mu.Unlock()
+	close(done)
+
+	return nil
+}
+
+// Shutdown stops all background components and waits until they exit or the context is done. A Shutdown racing
+// with the start of Run, like when Run is started in a goroutine, stops it as well.
+func (c *ChunkedUploaderService) Shutdown(ctx context.Context) error {
+	b := &c.background
+
+	b.mu.Lock()
+	b.stopped = true
+	cancel, done := b.cancel, b.done
+	b.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Components lists the background components of the service together with their last activity.
+func (c *ChunkedUploaderService) Components() []ComponentStatus {
+	b := &c.background
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	statuses := make([]ComponentStatus, 0, len(b.components))
+	for _, component := range b.components {
+		statuses = append(statuses, component.status())
+	}
+
+	return statuses
+}
+
+// start runs the component until the context is done.
+func (b *backgroundComponent) start(ctx context.Context, logger Logger) {
+	b.mu.Lock()
+	b.running = true
+	b.mu.Unlock()
+
+	err := b.run(ctx, b.heartbeat)
+	if err != nil && ctx.Err() == nil {
+		logger.Log(LogLevelError, "Background component stopped", LogField{"component", b.name}, LogField{"error", err})
+	}
+
+	b.mu.Lock()
+	b.running = false
+	if err != nil && ctx.Err() == nil {
+		b.lastError = err
+	}
+	b.mu.Unlock()
+}
+
+func (b *backgroundComponent) heartbeat() {
+	b.mu.Lock()
+	b.lastActivity = time.Now()
+	b.mu.Unlock()
+}
+
+func (b *backgroundComponent) status() ComponentStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := ComponentStatus{
+		Name:         b.name,
+		Running:      b.running,
+		LastActivity: b.lastActivity,
+	}
+	if b.lastError != nil {
+		status.LastError = b.lastError.Error()
+	}
+
+	return status
+}
+
+// runEvery calls fn every interval until the context is done, errors are logged and do not stop the loop.
+func (c *ChunkedUploaderService) runEvery(interval time.Duration, fn func() error) func(ctx context.Context, heartbeat func()) error {
+	return func(ctx context.Context, heartbeat func()) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				err := fn()
+				if err != nil {
+					c.log(LogLevelError, "Background task failed", LogField{"error", err})
+				}
+				heartbeat()
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package chunkeduploader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"go.uber.org/goleak"
+)
+
+// runService starts Run in a goroutine and returns a channel receiving its result.
+func runService(service *ChunkedUploaderService) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- service.Run(context.Background())
+	}()
+	return result
+}
+
+func waitRun(t *testing.T, result <-chan error) {
+	t.Helper()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("Run returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+}
+
+func TestShutdownStopsComponents(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	service := newTestService(afero.NewMemMapFs())
+	started := make(chan struct{})
+	service.background.register("test", func(ctx context.Context, heartbeat func()) error {
+		close(started)
+		<-ctx.Done()
+		return nil
+	})
+
+	result := runService(service)
+	<-started
+
+	if err := service.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitRun(t, result)
+
+	for _, status := range service.Components() {
+		if status.Running {
+			t.Errorf("component %s still running after Shutdown", status.Name)
+		}
+	}
+}
+
+func TestShutdownBeforeRun(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	service := newTestService(afero.NewMemMapFs())
+	service.background.register("test", func(ctx context.Context, heartbeat func()) error {
+		t.Error("component started after Shutdown")
+		<-ctx.Done()
+		return nil
+	})
+
+	if err := service.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitRun(t, runService(service))
+}
+
+func TestRunTwice(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	service := newTestService(afero.NewMemMapFs())
+	started := make(chan struct{})
+	service.background.register("test", func(ctx context.Context, heartbeat func()) error {
+		close(started)
+		<-ctx.Done()
+		return nil
+	})
+
+	result := runService(service)
+	<-started
+
+	if err := service.Run(context.Background()); err != ServiceAlreadyRunningError {
+		t.Errorf("second Run returned %v, want ServiceAlreadyRunningError", err)
+	}
+
+	if err := service.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitRun(t, result)
+}
@@ -0,0 +1,33 @@
+package chunkeduploader
+
+import "github.com/Craftserve/chunked-uploader/utils"
+
+// WithBackgroundIOPriority makes the full file reads of verification, reprocessing, imports and snapshots run with
+// the lowest best-effort I/O priority on Linux, so they compete less with chunk writes. bytesPerSecond additionally
+// throttles these reads, on other systems it is the only effect, zero does not throttle. Memory mapped checksums are
+// not throttled. Chunk writes are never affected.
+func WithBackgroundIOPriority(bytesPerSecond int64) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.backgroundIO = &backgroundIO{bytesPerSecond: bytesPerSecond}
+	}
+}
+
+type backgroundIO struct {
+	bytesPerSecond int64
+}
+
+// backgroundRead runs fn, which reads whole files on the calling goroutine, with the background I/O priority.
+func (c *ChunkedUploaderService) backgroundRead(fn func() error) error {
+	if c.backgroundIO == nil {
+		return fn()
+	}
+	return utils.RunWithLowIOPriority(fn)
+}
+
+// backgroundReadRate returns the rate background reads are throttled to, zero if they are not.
+func (c *ChunkedUploaderService) backgroundReadRate() int64 {
+	if c.backgroundIO == nil {
+		return 0
+	}
+	return c.backgroundIO.bytesPerSecond
+}
@@ -0,0 +1,180 @@
+package chunkeduploader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var DestinationNotAllowedError = errors.New("destination is outside of the destination root")
+
+const defaultMaxBatchConcurrency = 4
+
+// WithMaxBatchConcurrency sets how many uploads of a batch finish are finished at once, it defaults to 4.
+func WithMaxBatchConcurrency(n int) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.maxBatchConcurrency = n
+	}
+}
+
+// WithDestinationRoot allows clients to move finished uploads to a destination below a given directory of the
+// service filesystem. Without it requests carrying a destination are rejected.
+func WithDestinationRoot(root string) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.destinationRoot = filepath.Clean(root)
+	}
+}
+
+// confineDestination resolves a destination requested by a client below the destination root, it never points into
+// the pending directory or the root of the path builder.
+func (c *ChunkedUploaderService) confineDestination(destination string) (string, error) {
+	if c.destinationRoot == "" {
+		return "", DestinationNotAllowedError
+	}
+
+	// cleaning a rooted path drops every "..", so the result stays below the root
+	path := filepath.Join(c.destinationRoot, filepath.Clean("/"+destination))
+	if path == c.destinationRoot {
+		return "", DestinationNotAllowedError
+	}
+	for _, root := range []string{pendingDirectory, filepath.Clean(c.paths.Root())} {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return "", DestinationNotAllowedError
+		}
+	}
+
+	return path, nil
+}
+
+type BatchFinishItem struct {
+	UploadId    string `json:"upload_id"`
+	Checksum    string `json:"checksum"`
+	Destination string `json:"destination"`
+}
+
+type BatchFinishRequest struct {
+	Uploads []BatchFinishItem `json:"uploads"`
+}
+
+type BatchFinishResult struct {
+	UploadId string `json:"upload_id"`
+	Path     string `json:"path,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type BatchFinishResponse struct {
+	Results []BatchFinishResult `json:"results"`
+	// BatchChecksum is the SHA-256 of the "upload_id:checksum" lines of all uploads sorted by upload id, it is only
+	// set when every upload finished.
+	BatchChecksum string `json:"batch_checksum,omitempty"`
+}
+
+// BatchFinishHandler finishes several uploads in one request and optionally moves them to their destinations. It
+// always responds with 207 and a result per upload.
+func (c *ChunkedUploaderHandler) BatchFinishHandler(w http.ResponseWriter, r *http.Request) {
+	var req BatchFinishRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || len(req.Uploads) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "uploads are required")
+		return
+	}
+
+	concurrency := c.service.maxBatchConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	results := make([]BatchFinishResult, len(req.Uploads))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, item := range req.Uploads {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, item BatchFinishItem) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			results[i] = c.finishBatchItem(r, item)
+		}(i, item)
+	}
+	wg.Wait()
+
+	response := BatchFinishResponse{Results: results}
+	if batchChecksum, ok := batchChecksum(req.Uploads, results); ok {
+		response.BatchChecksum = batchChecksum
+	}
+
+	w.WriteHeader(http.StatusMultiStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (c *ChunkedUploaderHandler) finishBatchItem(r *http.Request, item BatchFinishItem) BatchFinishResult {
+	result := BatchFinishResult{UploadId: item.UploadId}
+
+	if item.UploadId == "" || item.Checksum == "" {
+		result.Error = "upload_id and checksum are required"
+		return result
+	}
+
+	var destination string
+	if item.Destination != "" {
+		var err error
+		destination, err = c.service.confineDestination(item.Destination)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	if meta, err := c.service.GetMetadata(r.Context(), item.UploadId); err == nil && !c.isOwner(r, meta) {
+		result.Error = "not the owner of the upload"
+		return result
+	}
+
+	path, err := c.service.FinishUpload(r.Context(), item.UploadId, item.Checksum)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if destination != "" {
+		err = c.service.RenameUploadedFile(item.UploadId, destination)
+		if err != nil {
+			result.Error = fmt.Sprintf("finished, but failed to move to destination: %s", err)
+			result.Path = path
+			return result
+		}
+		path = destination
+	}
+
+	result.Path = path
+	return result
+}
+
+// batchChecksum hashes the checksums of a batch sorted by upload id, it returns false if any upload failed.
+func batchChecksum(items []BatchFinishItem, results []BatchFinishResult) (string, bool) {
+	sorted := make([]BatchFinishItem, len(items))
+	copy(sorted, items)
+	for _, result := range results {
+		if result.Error != "" {
+			return "", false
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].UploadId < sorted[j].UploadId
+	})
+
+	hash := sha256.New()
+	for _, item := range sorted {
+		fmt.Fprintf(hash, "%s:%s\n", item.UploadId, item.Checksum)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
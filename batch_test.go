@@ -0,0 +1,96 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestBatchFinish(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// failing are the indexes of the uploads sent with a wrong checksum
+		failing []int
+	}{
+		{name: "all succeed"},
+		{name: "some fail", failing: []int{1}},
+		{name: "all fail", failing: []int{0, 1, 2}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs(), WithMaxBatchConcurrency(2))
+			handler := NewHTTPHandler(service)
+
+			var req BatchFinishRequest
+			for i := 0; i < 3; i++ {
+				data := randomBytes(t, 100+i)
+				uploadId, err := service.CreateUpload(int64(len(data)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+					t.Fatal(err)
+				}
+				req.Uploads = append(req.Uploads, BatchFinishItem{UploadId: uploadId, Checksum: sha256Hex(data)})
+			}
+			for _, i := range tc.failing {
+				req.Uploads[i].Checksum = sha256Hex([]byte("other"))
+			}
+
+			body, err := json.Marshal(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/batch-finish", bytes.NewReader(body)))
+			if rec.Code != http.StatusMultiStatus {
+				t.Fatalf("batch finish: %d %s, want %d", rec.Code, rec.Body, http.StatusMultiStatus)
+			}
+
+			var resp BatchFinishResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Results) != len(req.Uploads) {
+				t.Fatalf("%d results, want %d", len(resp.Results), len(req.Uploads))
+			}
+
+			failing := map[int]bool{}
+			for _, i := range tc.failing {
+				failing[i] = true
+			}
+			for i, result := range resp.Results {
+				if result.UploadId != req.Uploads[i].UploadId {
+					t.Errorf("result %d is for %s, want %s", i, result.UploadId, req.Uploads[i].UploadId)
+				}
+				if failing[i] && (result.Error == "" || result.Path != "") {
+					t.Errorf("result %d: %+v, want an error", i, result)
+				}
+				if !failing[i] && (result.Error != "" || result.Path == "") {
+					t.Errorf("result %d: %+v, want a path", i, result)
+				}
+			}
+
+			want := ""
+			if len(tc.failing) == 0 {
+				items := append([]BatchFinishItem(nil), req.Uploads...)
+				sort.Slice(items, func(i, j int) bool { return items[i].UploadId < items[j].UploadId })
+				hash := sha256.New()
+				for _, item := range items {
+					fmt.Fprintf(hash, "%s:%s\n", item.UploadId, item.Checksum)
+				}
+				want = hex.EncodeToString(hash.Sum(nil))
+			}
+			if resp.BatchChecksum != want {
+				t.Errorf("batch_checksum: got %q, want %q", resp.BatchChecksum, want)
+			}
+		})
+	}
+}
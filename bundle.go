@@ -0,0 +1,177 @@
+package chunkeduploader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+var InvalidBundleError = errors.New("invalid bundle")
+
+type BundleFormat string
+
+const (
+	BundleFormatTar BundleFormat = "tar"
+	BundleFormatZip BundleFormat = "zip"
+)
+
+// maxBundleUploads is the number of uploads a single bundle may contain.
+const maxBundleUploads = 100
+
+type bundleEntry struct {
+	name string
+	file afero.File
+	size int64
+}
+
+// OpenBundle streams a tar or zip archive of given finished uploads. The archive is written while it is read, it is
+// never stored. Entries are named after the stored filenames, colliding names get a numeric suffix.
+func (c *ChunkedUploaderService) OpenBundle(uploadIds []string, format BundleFormat) (io.ReadCloser, error) {
+	if format != BundleFormatTar && format != BundleFormatZip {
+		return nil, fmt.Errorf("ChunkedUploaderService.OpenBundle %w - unsupported format %q", InvalidBundleError, format)
+	}
+	if len(uploadIds) == 0 || len(uploadIds) > maxBundleUploads {
+		return nil, fmt.Errorf("ChunkedUploaderService.OpenBundle %w - between 1 and %d uploads are allowed", InvalidBundleError, maxBundleUploads)
+	}
+
+	entries := make([]bundleEntry, 0, len(uploadIds))
+	closeAll := func() {
+		for _, entry := range entries {
+			entry.file.Close()
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, uploadId := range uploadIds {
+		file, meta, err := c.openCompleteUpload(uploadId)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("ChunkedUploaderService.OpenBundle failed to open upload %s %w", uploadId, err)
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			closeAll()
+			return nil, fmt.Errorf("ChunkedUploaderService.OpenBundle failed to stat upload %s %w", uploadId, err)
+		}
+
+		name := uploadId
+		if meta != nil && meta.Filename != "" {
+			name = meta.Filename
+		}
+		entries = append(entries, bundleEntry{name: uniqueEntryName(names, name), file: file, size: info.Size()})
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		defer closeAll()
+		writer.CloseWithError(writeBundle(writer, entries, format))
+	}()
+
+	return reader, nil
+}
+
+// uniqueEntryName makes a name safe as an archive entry and distinct from the names already used.
+func uniqueEntryName(used map[string]bool, name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		name = "file"
+	}
+
+	unique := name
+	ext := path.Ext(name)
+	for i := 1; used[unique]; i++ {
+		unique = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	used[unique] = true
+
+	return unique
+}
+
+func writeBundle(w io.Writer, entries []bundleEntry, format BundleFormat) error {
+	if format == BundleFormatZip {
+		archive := zip.NewWriter(w)
+		for _, entry := range entries {
+			// the files are usually compressed already
+			dst, err := archive.CreateHeader(&zip.FileHeader{Name: entry.name, Method: zip.Store})
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(dst, entry.file)
+			if err != nil {
+				return err
+			}
+		}
+		return archive.Close()
+	}
+
+	archive := tar.NewWriter(w)
+	for _, entry := range entries {
+		err := archive.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: entry.size, Typeflag: tar.TypeReg})
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(archive, entry.file, entry.size)
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// BundleHandler streams a tar or zip archive of the finished uploads given as a comma separated ids query parameter,
+// the format query parameter selects the archive format and defaults to zip.
+func (c *ChunkedUploaderHandler) BundleHandler(w http.ResponseWriter, r *http.Request) {
+	format := BundleFormat(r.URL.Query().Get("format"))
+	if format == "" {
+		format = BundleFormatZip
+	}
+
+	var uploadIds []string
+	for _, uploadId := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if uploadId != "" {
+			uploadIds = append(uploadIds, uploadId)
+		}
+	}
+
+	for _, uploadId := range uploadIds {
+		if !c.requireUploadOwner(w, r, uploadId) {
+			return
+		}
+	}
+
+	bundle, err := c.service.OpenBundle(uploadIds, format)
+	if err != nil {
+		switch {
+		case errors.Is(err, InvalidBundleError):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, UploadNotCompleteError):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to open bundle: "+err.Error())
+		}
+		return
+	}
+	defer bundle.Close()
+
+	contentType := "application/zip"
+	if format == BundleFormatTar {
+		contentType = "application/x-tar"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="bundle.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+
+	// once the body started there is no way to report an error except for cutting the archive short
+	io.Copy(w, bundle)
+}
@@ -0,0 +1,197 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+)
+
+var UploadCancelledError = errors.New("upload was cancelled")
+var UploadFinishingError = errors.New("upload is being finished")
+
+// chunkWriters tracks the chunks being written to each upload, so CancelUpload can stop them and wait for them
+// before removing the files they write to, and FinishUpload and the snapshots can wait for them before reading the
+// file.
+type chunkWriters struct {
+	mu      sync.Mutex
+	uploads map[string]*uploadWriters
+}
+
+type uploadWriters struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	refs   int
+	// drained is closed when the last writer of a cancelled upload is done.
+	drained chan struct{}
+	// idle is set while the upload is being finished and closed when its last writer is done, new writers are
+	// rejected in the meantime.
+	idle chan struct{}
+}
+
+// start registers a writer of a given upload and returns a context which is cancelled with UploadCancelledError
+// when the upload is cancelled, done must be called once the writer stopped writing. It fails right away when the
+// upload is being cancelled, or with UploadFinishingError when it is being finished.
+func (w *chunkWriters) start(uploadId string) (ctx context.Context, done func(), err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	writers := w.get(uploadId)
+	if writers.ctx.Err() != nil {
+		return nil, nil, UploadCancelledError
+	}
+	if writers.idle != nil {
+		return nil, nil, UploadFinishingError
+	}
+	writers.refs++
+
+	return writers.ctx, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		writers.refs--
+		if writers.refs > 0 {
+			return
+		}
+		if writers.idle != nil {
+			close(writers.idle)
+		}
+		if writers.drained != nil {
+			close(writers.drained)
+			return
+		}
+		if writers.idle == nil && w.uploads[uploadId] == writers {
+			delete(w.uploads, uploadId)
+		}
+	}, nil
+}
+
+// get returns the writers of a given upload, creating them if there are none. The caller holds mu.
+func (w *chunkWriters) get(uploadId string) *uploadWriters {
+	if w.uploads == nil {
+		w.uploads = make(map[string]*uploadWriters)
+	}
+	writers, ok := w.uploads[uploadId]
+	if !ok {
+		writers = &uploadWriters{}
+		writers.ctx, writers.cancel = context.WithCancelCause(context.Background())
+		w.uploads[uploadId] = writers
+	}
+	return writers
+}
+
+// cancel stops the writers of a given upload and rejects new ones until the returned function is called, which the
+// caller does once the files of the upload are removed. The returned channel is closed when every writer is done. An
+// upload being finished cannot be cancelled, it fails with UploadFinishingError.
+func (w *chunkWriters) cancel(uploadId string) (drained <-chan struct{}, release func(), err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	writers := w.get(uploadId)
+	if writers.idle != nil {
+		return nil, nil, UploadFinishingError
+	}
+	if writers.drained == nil {
+		writers.drained = make(chan struct{})
+		if writers.refs == 0 {
+			close(writers.drained)
+		}
+	}
+	writers.cancel(UploadCancelledError)
+
+	return writers.drained, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		if w.uploads[uploadId] == writers {
+			delete(w.uploads, uploadId)
+		}
+	}, nil
+}
+
+// exclude rejects new writers of a given upload until the returned function is called, as cancel does, but only
+// when no writer is running and the upload is neither being finished nor cancelled. Otherwise it leaves the writers
+// alone and returns false.
+func (w *chunkWriters) exclude(uploadId string) (release func(), ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	writers := w.get(uploadId)
+	if writers.refs > 0 || writers.idle != nil || writers.ctx.Err() != nil {
+		return nil, false
+	}
+	writers.drained = make(chan struct{})
+	close(writers.drained)
+	writers.cancel(UploadCancelledError)
+
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		if w.uploads[uploadId] == writers {
+			delete(w.uploads, uploadId)
+		}
+	}, true
+}
+
+// finish rejects new writers of a given upload until the returned function is called, which the caller does once
+// the upload is finished or failed to finish. The returned channel is closed when every writer is done. Only one
+// caller may finish an upload at a time, the others get UploadFinishingError.
+func (w *chunkWriters) finish(uploadId string) (idle <-chan struct{}, release func(), err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	writers := w.get(uploadId)
+	if writers.ctx.Err() != nil {
+		return nil, nil, UploadCancelledError
+	}
+	if writers.idle != nil {
+		return nil, nil, UploadFinishingError
+	}
+	writers.idle = make(chan struct{})
+	if writers.refs == 0 {
+		close(writers.idle)
+	}
+
+	return writers.idle, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		writers.idle = nil
+		if writers.refs == 0 && writers.drained == nil && w.uploads[uploadId] == writers {
+			delete(w.uploads, uploadId)
+		}
+	}, nil
+}
+
+// cancellableReader stops reading with the cause of its context once the context is done, it is checked between the
+// buffers io.Copy reads.
+func cancellableReader(ctx context.Context, r io.Reader) io.Reader {
+	return &causeReader{Reader: utils.NewContextReader(ctx, r), ctx: ctx}
+}
+
+type causeReader struct {
+	io.Reader
+	ctx context.Context
+}
+
+func (r *causeReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && r.ctx.Err() != nil {
+		return n, context.Cause(r.ctx)
+	}
+	return n, err
+}
+
+// writeCancelledError responds to a chunk of an upload which was cancelled while it was written with 409.
+func writeCancelledError(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": err.Error(),
+		"code":  "cancelled",
+	})
+}
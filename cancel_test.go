@@ -0,0 +1,118 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// slowReader returns one byte at a time, waiting before each.
+type slowReader struct {
+	remaining int
+	delay     time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	p[0] = 'x'
+	r.remaining--
+	return 1, nil
+}
+
+func TestCancelStopsChunkInFlight(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	uploadId, err := service.CreateUpload(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := service.getUploadFilePath(uploadId)
+
+	req := httptest.NewRequest(http.MethodPost, "/"+uploadId+"/upload", &slowReader{remaining: 1 << 20, delay: time.Millisecond})
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(rec, req)
+	}()
+
+	// cancel once the chunk is being written
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := service.fs.Stat(path)
+		if err == nil && info.Size() > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("chunk did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := service.CancelUpload(ctx, uploadId); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	var body map[string]string
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusConflict || body["code"] != "cancelled" {
+		t.Errorf("chunk: %d %v, want 409 cancelled", rec.Code, body)
+	}
+	for _, path := range []string{path, service.getMetadataFilePath(uploadId)} {
+		if exists(t, service.fs, path) {
+			t.Errorf("%s exists after the cancel", path)
+		}
+	}
+}
+
+func TestCancelDuringFinish(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+
+	uploadId, err := service.CreateUpload(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, release, err := service.writers.finish(uploadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodDelete, "/"+uploadId, nil)
+	rec := httptest.NewRecorder()
+	NewHTTPHandler(service).ServeHTTP(rec, req)
+	release()
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("cancel during finish: %d %s, want 409", rec.Code, rec.Body)
+	}
+	if !exists(t, service.fs, service.getUploadFilePath(uploadId)) {
+		t.Error("upload removed while it was being finished")
+	}
+}
+
+func TestCancelKeepsFinishedUpload(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	uploadId, _, path := newFinishedTestUpload(t, service)
+
+	err := service.CancelUpload(context.Background(), uploadId)
+	if !errors.Is(err, UploadAlreadyFinishedError) {
+		t.Errorf("got %v, want UploadAlreadyFinishedError", err)
+	}
+	if !exists(t, service.fs, path) {
+		t.Error("finished upload removed")
+	}
+}
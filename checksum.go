@@ -0,0 +1,127 @@
+package chunkeduploader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+	"github.com/gorilla/mux"
+)
+
+var InvalidChecksumRangeError = errors.New("invalid checksum range")
+
+// ChecksumResponse is the SHA-256 of a byte range of an upload, both Start and End are inclusive. Size is the size of
+// the whole file.
+type ChecksumResponse struct {
+	Algorithm utils.ChecksumAlgorithm `json:"algorithm"`
+	Checksum  string                  `json:"checksum"`
+	Start     int64                   `json:"start"`
+	End       int64                   `json:"end"`
+	Size      int64                   `json:"size"`
+}
+
+// ChecksumRange computes the SHA-256 of the bytes from start to end, both inclusive, of an upload. A negative end
+// means the end of the file. For unfinished uploads the range must be committed.
+func (c *ChunkedUploaderService) ChecksumRange(ctx context.Context, uploadId string, start int64, end int64) (*ChecksumResponse, error) {
+	meta, err := c.readMetadata(uploadId)
+	if err != nil && !errors.Is(err, UploadNotFoundError) {
+		return nil, fmt.Errorf("ChunkedUploaderService.ChecksumRange failed to read metadata %w", err)
+	}
+
+	path, err := c.UploadedFilePath(uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.ChecksumRange %w", err)
+	}
+
+	file, err := c.fs.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = UploadNotFoundError
+		}
+		return nil, fmt.Errorf("ChunkedUploaderService.ChecksumRange failed to open file %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.ChecksumRange failed to stat file %w", err)
+	}
+
+	if end < 0 || end >= info.Size() {
+		end = info.Size() - 1
+	}
+	if start < 0 || (start > end && !(start == 0 && info.Size() == 0)) {
+		return nil, fmt.Errorf("ChunkedUploaderService.ChecksumRange %w %d-%d", InvalidChecksumRangeError, start, end)
+	}
+	if meta != nil && !meta.finished() && end >= start && !regionsCover(meta.Regions, ByteRange{Start: start, End: end}) {
+		return nil, fmt.Errorf("ChunkedUploaderService.ChecksumRange %w", RangeNotCommittedError)
+	}
+
+	hash := sha256.New()
+	err = c.backgroundRead(func() error {
+		reader := utils.NewContextReader(ctx, io.NewSectionReader(file, start, end-start+1))
+		_, err := io.Copy(hash, utils.NewThrottledReader(ctx, reader, c.backgroundReadRate()))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.ChecksumRange failed to read file %w", err)
+	}
+
+	return &ChecksumResponse{
+		Algorithm: utils.ChecksumSHA256,
+		Checksum:  hex.EncodeToString(hash.Sum(nil)),
+		Start:     start,
+		End:       end,
+		Size:      info.Size(),
+	}, nil
+}
+
+// ChecksumHandler returns the SHA-256 of a given upload, or of the range given by the start and end query parameters,
+// so clients can compare it with their local copy without downloading the file.
+func (c *ChunkedUploaderHandler) ChecksumHandler(w http.ResponseWriter, r *http.Request) {
+	uploadId := mux.Vars(r)["upload_id"]
+
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	start, end := int64(0), int64(-1)
+	if r.URL.Query().Has("start") || r.URL.Query().Has("end") {
+		var startErr, endErr error
+		start, startErr = strconv.ParseInt(r.URL.Query().Get("start"), 10, 64)
+		end, endErr = strconv.ParseInt(r.URL.Query().Get("end"), 10, 64)
+		if startErr != nil || endErr != nil || start < 0 || end < start {
+			writeJSONError(w, http.StatusBadRequest, "start and end must be a valid byte range")
+			return
+		}
+	}
+
+	if !c.requireUploadOwner(w, r, uploadId) {
+		return
+	}
+
+	response, err := c.service.ChecksumRange(r.Context(), uploadId, start, end)
+	if err != nil {
+		switch {
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, RangeNotCommittedError), errors.Is(err, InvalidChecksumRangeError):
+			writeJSONError(w, http.StatusRequestedRangeNotSatisfiable, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to compute checksum: "+err.Error())
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
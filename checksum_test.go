@@ -0,0 +1,133 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Craftserve/chunked-uploader/pkg/client"
+	"github.com/spf13/afero"
+)
+
+func TestVerifyLocalFile(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		blockSize int64
+		// change modifies a copy of the uploaded data used as the local file
+		change       func(local []byte) []byte
+		wantMatch    bool
+		wantMismatch *client.VerificationMismatch
+	}{
+		{name: "match", wantMatch: true},
+		{name: "mismatch", change: func(local []byte) []byte { local[600] ^= 0xff; return local }},
+		{name: "longer local file", change: func(local []byte) []byte { return append(local, 'x') }},
+		{name: "match in blocks", blockSize: 256, wantMatch: true},
+		{name: "partial mismatch in blocks", blockSize: 256, change: func(local []byte) []byte { local[600] ^= 0xff; return local }, wantMismatch: &client.VerificationMismatch{Start: 512, End: 767}},
+		{name: "mismatch in the last block", blockSize: 256, change: func(local []byte) []byte { local[999] ^= 0xff; return local }, wantMismatch: &client.VerificationMismatch{Start: 768, End: 999}},
+		{name: "longer local file in blocks", blockSize: 256, change: func(local []byte) []byte { return append(local, make([]byte, 100)...) }, wantMismatch: &client.VerificationMismatch{Start: 1000, End: 1099}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs())
+			server := httptest.NewServer(NewHTTPHandler(service))
+			defer server.Close()
+
+			uploadId, data := newExportTestUpload(t, service, 1000)
+			local := append([]byte(nil), data...)
+			if tc.change != nil {
+				local = tc.change(local)
+			}
+			localPath := filepath.Join(t.TempDir(), "local")
+			if err := os.WriteFile(localPath, local, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			c := client.Client{Endpoint: server.URL, DoRequest: http.DefaultClient.Do, VerifyBlockSize: tc.blockSize}
+			match, err := c.VerifyLocalFile(context.Background(), uploadId, localPath)
+			if match != tc.wantMatch {
+				t.Errorf("match: got %t, want %t", match, tc.wantMatch)
+			}
+			var mismatch *client.VerificationMismatch
+			switch {
+			case tc.wantMismatch == nil && err != nil:
+				t.Errorf("VerifyLocalFile: %v, want no error", err)
+			case tc.wantMismatch != nil && (!errors.As(err, &mismatch) || *mismatch != *tc.wantMismatch):
+				t.Errorf("VerifyLocalFile: %v, want a mismatch of %d-%d", err, tc.wantMismatch.Start, tc.wantMismatch.End)
+			}
+		})
+	}
+}
+
+func TestVerifyLocalFileFailures(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	server := httptest.NewServer(NewHTTPHandler(service))
+	defer server.Close()
+
+	uploadId, data := newExportTestUpload(t, service, 1000)
+	localPath := filepath.Join(t.TempDir(), "local")
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := client.Client{Endpoint: server.URL, DoRequest: http.DefaultClient.Do}
+	for _, tc := range []struct {
+		name      string
+		uploadId  string
+		localPath string
+	}{
+		{"missing local file", uploadId, filepath.Join(t.TempDir(), "missing")},
+		{"unknown upload", "0123456789abcdef0123456789abcdef", localPath},
+	} {
+		match, err := c.VerifyLocalFile(context.Background(), tc.uploadId, tc.localPath)
+		if match || err == nil {
+			t.Errorf("%s: %t %v, want an error", tc.name, match, err)
+		}
+	}
+}
+
+func TestUploadWithChecksum(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		parallelism int
+	}{
+		{name: "sequential"},
+		{name: "parallel", parallelism: 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs())
+			server := httptest.NewServer(NewHTTPHandler(service))
+			defer server.Close()
+
+			data := randomBytes(t, 5000)
+			localPath := filepath.Join(t.TempDir(), "local")
+			if err := os.WriteFile(localPath, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.Open(localPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			c := client.Client{Endpoint: server.URL, ChunkSize: 1000, Parallelism: tc.parallelism, DoRequest: http.DefaultClient.Do}
+			path, checksum, err := c.UploadWithChecksum(context.Background(), file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checksum != sha256Hex(data) {
+				t.Errorf("checksum %s, want %s", checksum, sha256Hex(data))
+			}
+			if c.Finished == nil || c.Finished.Path != path || c.Finished.LocalChecksum != checksum {
+				t.Errorf("Finished %+v, want path %s and checksum %s", c.Finished, path, checksum)
+			}
+
+			local, err := c.ComputeLocalChecksum(context.Background(), localPath, "sha256")
+			if err != nil || local != checksum {
+				t.Errorf("ComputeLocalChecksum: %s %v, want %s", local, err, checksum)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+)
+
+// ComputeAndCacheChecksum computes the checksum of the file of an upload, with the algorithm it was verified with or
+// else the one of the service, and keeps it in the metadata together with the modification time of the file. A
+// cached checksum is returned instead of reading the file again, also by the verification of FinishUpload, until the
+// file is modified or a chunk is written.
+func (c *ChunkedUploaderService) ComputeAndCacheChecksum(ctx context.Context, uploadId string) (string, error) {
+	path, err := c.UploadedFilePath(uploadId)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.ComputeAndCacheChecksum %w", err)
+	}
+
+	algorithm := c.checksumAlgorithm
+	if meta, err := c.readMetadata(uploadId); err == nil && meta.ChecksumAlgorithm != "" {
+		algorithm = meta.ChecksumAlgorithm
+	}
+	checksum, err := c.checksumWithCache(uploadId, path, algorithm, func() (checksum string, err error) {
+		err = c.backgroundRead(func() error {
+			if c.customAlgorithm(algorithm) {
+				checksum, err = c.computeCustomChecksum(ctx, c.servingFs(), path)
+				return err
+			}
+			checksum, err = utils.ComputeChecksumThrottled(ctx, c.servingFs(), path, algorithm, c.backgroundReadRate())
+			return err
+		})
+		return checksum, err
+	})
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.ComputeAndCacheChecksum %w", err)
+	}
+
+	return checksum, nil
+}
+
+// checksumWithCache returns the checksum of the file of an upload at a given path with a given algorithm, the cached
+// one when it was computed from the file as it is now. Otherwise it is computed by compute and cached, unless the
+// file was modified or a chunk was written while it was read. A checksum which cannot be cached is still returned.
+func (c *ChunkedUploaderService) checksumWithCache(uploadId string, path string, algorithm utils.ChecksumAlgorithm, compute func() (string, error)) (string, error) {
+	info, err := c.fs.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file %w", err)
+	}
+	meta, err := c.readMetadata(uploadId)
+	if err != nil && !errors.Is(err, UploadNotFoundError) {
+		return "", fmt.Errorf("failed to read metadata %w", err)
+	}
+	if meta != nil {
+		if checksum, ok := meta.cachedChecksum(algorithm, info.ModTime()); ok {
+			return checksum, nil
+		}
+	}
+
+	computedAt := time.Now()
+	checksum, err := compute()
+	if err != nil {
+		return "", err
+	}
+	if meta == nil {
+		// uploads created before metadata was introduced have nowhere to cache it
+		return checksum, nil
+	}
+
+	lastWriteAt := meta.LastWriteAt
+	err = c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		// a write may not change the modification time within its resolution
+		if (meta.LastWriteAt == nil) != (lastWriteAt == nil) || (lastWriteAt != nil && !meta.LastWriteAt.Equal(*lastWriteAt)) {
+			return nil
+		}
+		current, err := c.fs.Stat(path)
+		if err != nil || !current.ModTime().Equal(info.ModTime()) {
+			return nil
+		}
+		modTime := info.ModTime()
+		meta.ComputedChecksum = checksum
+		meta.ComputedChecksumAlgorithm = algorithm
+		meta.ChecksumComputedAt = &computedAt
+		meta.ChecksumModTime = &modTime
+		return nil
+	})
+	if err != nil {
+		c.log(LogLevelWarn, "Failed to cache checksum", LogField{"upload_id", uploadId}, LogField{"error", err})
+	}
+
+	return checksum, nil
+}
+
+// cachedChecksum returns the cached checksum when it was computed with a given algorithm from the file as it is at
+// a given modification time.
+func (meta *UploadMetadata) cachedChecksum(algorithm utils.ChecksumAlgorithm, modTime time.Time) (string, bool) {
+	if meta.ComputedChecksum == "" || meta.ComputedChecksumAlgorithm != algorithm || meta.ChecksumModTime == nil || !meta.ChecksumModTime.Equal(modTime) {
+		return "", false
+	}
+	return meta.ComputedChecksum, true
+}
+
+// invalidateChecksum drops the cached checksum, a write may not change the modification time within its resolution.
+func (meta *UploadMetadata) invalidateChecksum() {
+	meta.ComputedChecksum = ""
+	meta.ComputedChecksumAlgorithm = ""
+	meta.ChecksumComputedAt = nil
+	meta.ChecksumModTime = nil
+}
@@ -0,0 +1,182 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+	"github.com/spf13/afero"
+)
+
+// modTimeFs reports a modification time set by the test for every file, so writes do not change it.
+type modTimeFs struct {
+	afero.Fs
+
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+func (fs *modTimeFs) setModTime(modTime time.Time) {
+	fs.mu.Lock()
+	fs.modTime = modTime
+	fs.mu.Unlock()
+}
+
+func (fs *modTimeFs) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return modTimeInfo{FileInfo: info, modTime: fs.modTime}, nil
+}
+
+type modTimeInfo struct {
+	os.FileInfo
+	modTime time.Time
+}
+
+func (i modTimeInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+// newCacheTestUpload creates an upload with some data on a filesystem with controllable modification times.
+func newCacheTestUpload(t *testing.T) (*ChunkedUploaderService, *modTimeFs, string, []byte) {
+	t.Helper()
+
+	fs := &modTimeFs{Fs: afero.NewMemMapFs(), modTime: time.Unix(1000, 0)}
+	service := newTestService(fs)
+	data := randomBytes(t, 4096)
+
+	uploadId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+	return service, fs, uploadId, data
+}
+
+// cachedChecksum calls checksumWithCache and reports whether the checksum was computed.
+func cachedChecksum(t *testing.T, service *ChunkedUploaderService, uploadId string, algorithm utils.ChecksumAlgorithm) (string, bool) {
+	t.Helper()
+
+	path := service.getUploadFilePath(uploadId)
+	computed := false
+	checksum, err := service.checksumWithCache(uploadId, path, algorithm, func() (string, error) {
+		computed = true
+		return utils.ComputeChecksumWith(context.Background(), service.fs, path, algorithm)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return checksum, computed
+}
+
+func TestChecksumCacheHitAndMiss(t *testing.T) {
+	service, fs, uploadId, data := newCacheTestUpload(t)
+
+	checksum, computed := cachedChecksum(t, service, uploadId, utils.ChecksumSHA256)
+	if !computed || checksum != sha256Hex(data) {
+		t.Fatalf("first call: computed %v, checksum %s", computed, checksum)
+	}
+
+	checksum, computed = cachedChecksum(t, service, uploadId, utils.ChecksumSHA256)
+	if computed || checksum != sha256Hex(data) {
+		t.Errorf("unchanged file: computed %v, checksum %s", computed, checksum)
+	}
+
+	if _, computed = cachedChecksum(t, service, uploadId, utils.ChecksumCRC32C); !computed {
+		t.Error("checksum of another algorithm served from the cache")
+	}
+
+	fs.setModTime(time.Unix(2000, 0))
+	if _, computed = cachedChecksum(t, service, uploadId, utils.ChecksumCRC32C); !computed {
+		t.Error("checksum served from the cache after the modification time changed")
+	}
+}
+
+func TestChecksumCacheInvalidatedByChunk(t *testing.T) {
+	service, _, uploadId, data := newCacheTestUpload(t)
+	cachedChecksum(t, service, uploadId, utils.ChecksumSHA256)
+
+	// the modification time stays the same, as it may within its resolution
+	if _, err := service.UploadChunk(uploadId, bytes.NewReader([]byte("changed")), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	checksum, computed := cachedChecksum(t, service, uploadId, utils.ChecksumSHA256)
+	if !computed || checksum == sha256Hex(data) {
+		t.Errorf("checksum served from the cache after a chunk was written: computed %v", computed)
+	}
+}
+
+func TestChecksumCacheSkipsConcurrentWrite(t *testing.T) {
+	service, _, uploadId, _ := newCacheTestUpload(t)
+	path := service.getUploadFilePath(uploadId)
+
+	_, err := service.checksumWithCache(uploadId, path, utils.ChecksumSHA256, func() (string, error) {
+		checksum, err := utils.ComputeChecksumWith(context.Background(), service.fs, path, utils.ChecksumSHA256)
+		// a chunk lands after the file was read
+		service.UploadChunk(uploadId, bytes.NewReader([]byte("late")), 0)
+		return checksum, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, computed := cachedChecksum(t, service, uploadId, utils.ChecksumSHA256); !computed {
+		t.Error("checksum read before a concurrent write was cached")
+	}
+}
+
+func TestChecksumCacheInvalidatedByRestoreSnapshot(t *testing.T) {
+	service, _, uploadId, data := newCacheTestUpload(t)
+
+	name, err := service.SnapshotUpload(context.Background(), uploadId, "before")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, bytes.NewReader([]byte("changed")), 0); err != nil {
+		t.Fatal(err)
+	}
+	cachedChecksum(t, service, uploadId, utils.ChecksumSHA256)
+
+	if err := service.RestoreSnapshot(context.Background(), uploadId, name); err != nil {
+		t.Fatal(err)
+	}
+
+	checksum, computed := cachedChecksum(t, service, uploadId, utils.ChecksumSHA256)
+	if !computed || checksum != sha256Hex(data) {
+		t.Errorf("checksum served from the cache after a restore: computed %v", computed)
+	}
+}
+
+func TestComputeAndCacheChecksum(t *testing.T) {
+	service, _, uploadId, data := newCacheTestUpload(t)
+
+	for i := 0; i < 2; i++ {
+		checksum, err := service.ComputeAndCacheChecksum(context.Background(), uploadId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checksum != sha256Hex(data) {
+			t.Errorf("got %s, want %s", checksum, sha256Hex(data))
+		}
+	}
+
+	meta, err := service.readMetadata(uploadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ComputedChecksum != sha256Hex(data) || meta.ChecksumModTime == nil || !meta.ChecksumModTime.Equal(time.Unix(1000, 0)) {
+		t.Errorf("checksum not cached in the metadata: %+v", meta)
+	}
+}
@@ -0,0 +1,61 @@
+package chunkeduploader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ChunkCountExceededError is returned when an upload would need more chunks of its declared chunk size than allowed.
+type ChunkCountExceededError struct {
+	Chunks               int64
+	MaxChunks            int64
+	RecommendedChunkSize int64
+}
+
+func (e *ChunkCountExceededError) Error() string {
+	return fmt.Sprintf("upload needs %d chunks, at most %d are allowed, use a chunk size of at least %d bytes", e.Chunks, e.MaxChunks, e.RecommendedChunkSize)
+}
+
+// WithMaxChunkCount rejects uploads created with a file size and a chunk size which together need more than a given
+// number of chunks.
+func WithMaxChunkCount(limit int64) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.maxChunkCount = limit
+	}
+}
+
+// WithChunkSize records the chunk size the client is going to use, see WithMaxChunkCount.
+func WithChunkSize(chunkSize int64) CreateUploadOption {
+	return func(m *UploadMetadata) {
+		m.ChunkSize = chunkSize
+	}
+}
+
+// checkChunkCount checks the declared chunk size of a new upload against the maximum chunk count.
+func (c *ChunkedUploaderService) checkChunkCount(meta *UploadMetadata) error {
+	if c.maxChunkCount <= 0 || meta.FileSize <= 0 || meta.ChunkSize <= 0 {
+		return nil
+	}
+
+	chunks := (meta.FileSize + meta.ChunkSize - 1) / meta.ChunkSize
+	if chunks <= c.maxChunkCount {
+		return nil
+	}
+
+	return &ChunkCountExceededError{
+		Chunks:               chunks,
+		MaxChunks:            c.maxChunkCount,
+		RecommendedChunkSize: (meta.FileSize + c.maxChunkCount - 1) / c.maxChunkCount,
+	}
+}
+
+func writeChunkCountError(w http.ResponseWriter, err *ChunkCountExceededError) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":                  err.Error(),
+		"code":                   "too_many_chunks",
+		"max_chunks":             err.MaxChunks,
+		"recommended_chunk_size": err.RecommendedChunkSize,
+	})
+}
@@ -0,0 +1,164 @@
+package chunkeduploader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+var ChunkChecksumMismatchError = errors.New("chunk checksum mismatch")
+var LastChunkMismatchError = errors.New("last chunk does not end at the declared file size")
+
+// WithQueryParameters lets clients which cannot set custom headers send the parameters of a chunk in the query
+// string instead: offset and length instead of the Range header, chunk_checksum instead of X-Chunk-Checksum and
+// last=true instead of X-Last-Chunk. A header takes precedence over its parameter when both are present and both
+// are validated the same way.
+func WithQueryParameters() ChunkedUploaderHandlerOption {
+	return func(c *ChunkedUploaderHandler) {
+		c.queryParameters = true
+	}
+}
+
+// chunkParameters are the parameters of a chunk request besides its body.
+type chunkParameters struct {
+	// rangeHeader is in the form of the Range header, it is empty when the chunk is appended.
+	rangeHeader string
+	// checksum is the hex encoded SHA-256 the chunk must have, empty when the chunk is not checked.
+	checksum string
+	// last marks the chunk ending the file.
+	last bool
+}
+
+// parseChunkParameters reads the parameters of a chunk from its headers and, with WithQueryParameters, from the
+// query parameters replacing the headers which are absent.
+func (c *ChunkedUploaderHandler) parseChunkParameters(r *http.Request) (chunkParameters, error) {
+	params := chunkParameters{
+		rangeHeader: r.Header.Get("Range"),
+		checksum:    r.Header.Get("X-Chunk-Checksum"),
+	}
+	last := r.Header.Get("X-Last-Chunk")
+
+	if c.queryParameters {
+		query := r.URL.Query()
+		if params.rangeHeader == "" {
+			rangeHeader, err := rangeFromQuery(query.Get("offset"), query.Get("length"))
+			if err != nil {
+				return params, err
+			}
+			params.rangeHeader = rangeHeader
+		}
+		if params.checksum == "" {
+			params.checksum = query.Get("chunk_checksum")
+		}
+		if last == "" {
+			last = query.Get("last")
+		}
+	}
+
+	if params.checksum != "" {
+		if _, err := hex.DecodeString(params.checksum); err != nil || len(params.checksum) != sha256.Size*2 {
+			return params, fmt.Errorf("chunk checksum must be a hex encoded SHA-256")
+		}
+	}
+	if last != "" {
+		var err error
+		params.last, err = strconv.ParseBool(last)
+		if err != nil {
+			return params, fmt.Errorf("last chunk flag must be a boolean")
+		}
+	}
+
+	return params, nil
+}
+
+// rangeFromQuery turns the offset and length query parameters into a value of the Range header, so they are parsed
+// by parseRangeHeader like the header. A length is optional like the end of the range.
+func rangeFromQuery(offset string, length string) (string, error) {
+	switch {
+	case offset == "" && length == "":
+		return "", nil
+	case offset == "":
+		return "", fmt.Errorf("length requires an offset")
+	case length == "":
+		return "bytes=" + offset + "-", nil
+	}
+
+	start, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid offset")
+	}
+	n, err := strconv.ParseInt(length, 10, 64)
+	if err != nil || n <= 0 {
+		return "", fmt.Errorf("invalid length")
+	}
+	return fmt.Sprintf("bytes=%d-%d", start, start+n-1), nil
+}
+
+// verifyChunkChecksum compares the SHA-256 of the bytes of a chunk with a hex encoded checksum.
+func verifyChunkChecksum(data []byte, checksum string) error {
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != checksum {
+		return ChunkChecksumMismatchError
+	}
+	return nil
+}
+
+// readCheckedChunk reads a chunk of a given length which has to match a checksum before any of it is written.
+func readCheckedChunk(body io.Reader, length int64, checksum string) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, length+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %w", err)
+	}
+	if int64(len(data)) != length {
+		return nil, fmt.Errorf("chunk length does not match its range of %d bytes", length)
+	}
+
+	err = verifyChunkChecksum(data, checksum)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// checkLastChunk checks that a chunk marked as the last one ends at the declared size of its upload.
+func (c *ChunkedUploaderService) checkLastChunk(uploadId string, end int64) error {
+	meta, err := c.readMetadata(uploadId)
+	if errors.Is(err, UploadNotFoundError) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.checkLastChunk failed to read metadata %w", err)
+	}
+
+	if meta.FileSize >= 0 && meta.FileSize != end+1 {
+		return fmt.Errorf("ChunkedUploaderService.checkLastChunk %w: chunk ends at %d, file has %d bytes", LastChunkMismatchError, end+1, meta.FileSize)
+	}
+	return nil
+}
+
+// declareFileSize records the size of an upload created without one once its last chunk was written.
+func (c *ChunkedUploaderService) declareFileSize(uploadId string, size int64) error {
+	err := c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		if meta.FileSize < 0 {
+			meta.FileSize = size
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, UploadNotFoundError) {
+		return fmt.Errorf("ChunkedUploaderService.declareFileSize failed to update metadata %w", err)
+	}
+	return nil
+}
+
+// features returns the enabled features of the service and of the handler.
+func (c *ChunkedUploaderHandler) features() []string {
+	features := c.service.EnabledFeatures()
+	if c.queryParameters {
+		features = append(features, "query_parameters")
+	}
+	return features
+}
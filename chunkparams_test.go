@@ -0,0 +1,76 @@
+package chunkeduploader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestParseChunkParameters(t *testing.T) {
+	checksum := strings.Repeat("ab", 32)
+
+	for _, tc := range []struct {
+		name    string
+		query   string
+		headers map[string]string
+		want    chunkParameters
+		wantErr bool
+	}{
+		{name: "headers", headers: map[string]string{"Range": "bytes=0-9", "X-Chunk-Checksum": checksum, "X-Last-Chunk": "true"}, want: chunkParameters{rangeHeader: "bytes=0-9", checksum: checksum, last: true}},
+		{name: "query", query: "offset=10&length=5&chunk_checksum=" + checksum + "&last=true", want: chunkParameters{rangeHeader: "bytes=10-14", checksum: checksum, last: true}},
+		{name: "open range", query: "offset=10", want: chunkParameters{rangeHeader: "bytes=10-"}},
+		{name: "header takes precedence", query: "offset=10&length=5&last=false", headers: map[string]string{"Range": "bytes=0-9", "X-Last-Chunk": "true"}, want: chunkParameters{rangeHeader: "bytes=0-9", last: true}},
+		{name: "length without offset", query: "length=5", wantErr: true},
+		{name: "zero length", query: "offset=0&length=0", wantErr: true},
+		{name: "invalid query checksum", query: "chunk_checksum=xyz", wantErr: true},
+		{name: "invalid header checksum", headers: map[string]string{"X-Chunk-Checksum": "xyz"}, wantErr: true},
+		{name: "invalid last", query: "last=maybe", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := NewChunkedUploaderHandler(newTestService(afero.NewMemMapFs()), WithQueryParameters())
+			r := httptest.NewRequest(http.MethodPost, "/id/upload?"+tc.query, nil)
+			for key, value := range tc.headers {
+				r.Header.Set(key, value)
+			}
+
+			params, err := handler.parseChunkParameters(r)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want an error", params)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if params != tc.want {
+				t.Errorf("got %+v, want %+v", params, tc.want)
+			}
+		})
+	}
+}
+
+func TestQueryParametersDisabledByDefault(t *testing.T) {
+	handler := NewChunkedUploaderHandler(newTestService(afero.NewMemMapFs()))
+	r := httptest.NewRequest(http.MethodPost, "/id/upload?offset=10&length=5", nil)
+
+	params, err := handler.parseChunkParameters(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.rangeHeader != "" {
+		t.Errorf("query parameters used without WithQueryParameters: %+v", params)
+	}
+	if slices.Contains(handler.features(), "query_parameters") {
+		t.Error("query_parameters advertised without WithQueryParameters")
+	}
+
+	enabled := NewChunkedUploaderHandler(newTestService(afero.NewMemMapFs()), WithQueryParameters())
+	if !slices.Contains(enabled.features(), "query_parameters") {
+		t.Error("query_parameters not advertised with WithQueryParameters")
+	}
+}
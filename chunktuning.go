@@ -0,0 +1,209 @@
+package chunkeduploader
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	chunkTuningSamples         = 1024
+	defaultChunkTuningInterval = time.Minute
+	minChunkTuningSamples      = 16
+)
+
+// ChunkSizeTuning configures the chunk size recommended to clients, see WithChunkSizeTuning.
+type ChunkSizeTuning struct {
+	// MinChunkSize and MaxChunkSize bound the recommendation, it starts at MinChunkSize.
+	MinChunkSize int64
+	MaxChunkSize int64
+	// TargetDuration is how long a single chunk should take to upload, like 5 seconds.
+	TargetDuration time.Duration
+	// Interval is how often the recommendation is recalculated by Run, it defaults to a minute.
+	Interval time.Duration
+}
+
+// WithChunkSizeTuning makes the service recommend a chunk size derived from the chunks it received recently. The
+// durations of the last chunks are fitted to a per-chunk overhead plus a transfer rate, and the recommendation is
+// the size a chunk of TargetDuration would have, within the configured bounds. It is recalculated by Run every
+// interval and advertised in the X-Recommended-Chunk-Size header of created uploads and by the version handler.
+func WithChunkSizeTuning(tuning ChunkSizeTuning) ChunkedUploaderServiceOption {
+	if tuning.MinChunkSize <= 0 || tuning.MaxChunkSize < tuning.MinChunkSize || tuning.TargetDuration <= 0 {
+		panic("chunkeduploader: invalid chunk size tuning")
+	}
+	if tuning.Interval <= 0 {
+		tuning.Interval = defaultChunkTuningInterval
+	}
+
+	return func(c *ChunkedUploaderService) {
+		c.chunkTuner = &chunkTuner{tuning: tuning, recommended: tuning.MinChunkSize}
+		c.background.register("chunk-size-tuner", c.runEvery(tuning.Interval, func() error {
+			c.chunkTuner.recalculate()
+			return nil
+		}))
+	}
+}
+
+type chunkSample struct {
+	bytes    int64
+	duration time.Duration
+}
+
+// chunkTuner keeps the last chunks received in a ring buffer and the recommendation derived from them.
+type chunkTuner struct {
+	tuning ChunkSizeTuning
+
+	mu      sync.Mutex
+	samples [chunkTuningSamples]chunkSample
+	next    int
+	count   int
+
+	recommended int64
+	// overhead and rate are the inputs of the last recalculation, rate is in bytes per second.
+	overhead time.Duration
+	rate     float64
+	// histogram is the count and total duration of the sampled chunks by size, see chunkSizeBucket.
+	histogram map[int64]chunkBucket
+}
+
+type chunkBucket struct {
+	count    int64
+	duration time.Duration
+}
+
+// recordChunk adds the transfer of a received chunk to the samples of the tuner.
+func (c *ChunkedUploaderService) recordChunk(bytes int64, duration time.Duration) {
+	t := c.chunkTuner
+	if t == nil || bytes <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.samples[t.next] = chunkSample{bytes: bytes, duration: duration}
+	t.next = (t.next + 1) % chunkTuningSamples
+	if t.count < chunkTuningSamples {
+		t.count++
+	}
+}
+
+// recalculate fits the samples with least squares to duration = overhead + bytes / rate and derives the chunk size
+// taking the target duration. Without enough samples of different sizes the previous recommendation is kept.
+func (t *chunkTuner) recalculate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	histogram := make(map[int64]chunkBucket)
+	var sumX, sumY, sumXX, sumXY float64
+	for _, sample := range t.samples[:t.count] {
+		x, y := float64(sample.bytes), sample.duration.Seconds()
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+
+		bucket := histogram[chunkSizeBucket(sample.bytes)]
+		bucket.count++
+		bucket.duration += sample.duration
+		histogram[chunkSizeBucket(sample.bytes)] = bucket
+	}
+	t.histogram = histogram
+
+	n := float64(t.count)
+	denominator := n*sumXX - sumX*sumX
+	if t.count < minChunkTuningSamples || denominator <= 0 {
+		return
+	}
+
+	slope := (n*sumXY - sumX*sumY) / denominator
+	if slope <= 0 {
+		// the chunks took no longer the larger they were, the network is not what limits them
+		t.recommended = t.tuning.MaxChunkSize
+		return
+	}
+	intercept := (sumY - slope*sumX) / n
+	if intercept < 0 {
+		intercept = 0
+	}
+
+	t.overhead = time.Duration(intercept * float64(time.Second))
+	t.rate = 1 / slope
+
+	recommended := int64((t.tuning.TargetDuration.Seconds() - intercept) / slope)
+	if recommended < t.tuning.MinChunkSize {
+		recommended = t.tuning.MinChunkSize
+	}
+	if recommended > t.tuning.MaxChunkSize {
+		recommended = t.tuning.MaxChunkSize
+	}
+	t.recommended = recommended
+}
+
+// chunkSizeBucket returns the smallest power of two at least as large as a given chunk size.
+func chunkSizeBucket(size int64) int64 {
+	if size <= 1 {
+		return 1
+	}
+	return 1 << bits.Len64(uint64(size-1))
+}
+
+// RecommendedChunkSize returns the chunk size currently recommended to clients, zero without WithChunkSizeTuning.
+func (c *ChunkedUploaderService) RecommendedChunkSize() int64 {
+	t := c.chunkTuner
+	if t == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.recommended
+}
+
+// setRecommendedChunkSizeHeader advertises the recommended chunk size, if there is one.
+func (c *ChunkedUploaderHandler) setRecommendedChunkSizeHeader(w http.ResponseWriter) {
+	if size := c.service.RecommendedChunkSize(); size > 0 {
+		w.Header().Set("X-Recommended-Chunk-Size", strconv.FormatInt(size, 10))
+	}
+}
+
+// writeChunkTuningMetrics writes the recommendation and its inputs in the Prometheus text format.
+func (c *ChunkedUploaderService) writeChunkTuningMetrics(w io.Writer) {
+	t := c.chunkTuner
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP chunkeduploader_recommended_chunk_size_bytes Chunk size recommended to clients.")
+	fmt.Fprintln(w, "# TYPE chunkeduploader_recommended_chunk_size_bytes gauge")
+	fmt.Fprintf(w, "chunkeduploader_recommended_chunk_size_bytes %d\n", t.recommended)
+	fmt.Fprintln(w, "# HELP chunkeduploader_chunk_overhead_seconds Estimated fixed cost of a chunk.")
+	fmt.Fprintln(w, "# TYPE chunkeduploader_chunk_overhead_seconds gauge")
+	fmt.Fprintf(w, "chunkeduploader_chunk_overhead_seconds %g\n", t.overhead.Seconds())
+	fmt.Fprintln(w, "# HELP chunkeduploader_chunk_rate_bytes_per_second Estimated transfer rate of a chunk.")
+	fmt.Fprintln(w, "# TYPE chunkeduploader_chunk_rate_bytes_per_second gauge")
+	fmt.Fprintf(w, "chunkeduploader_chunk_rate_bytes_per_second %g\n", t.rate)
+
+	sizes := make([]int64, 0, len(t.histogram))
+	for size := range t.histogram {
+		sizes = append(sizes, size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+
+	fmt.Fprintln(w, "# HELP chunkeduploader_chunk_duration_seconds Duration of the sampled chunks by size, up to the size label.")
+	fmt.Fprintln(w, "# TYPE chunkeduploader_chunk_duration_seconds summary")
+	for _, size := range sizes {
+		bucket := t.histogram[size]
+		fmt.Fprintf(w, "chunkeduploader_chunk_duration_seconds_sum{size=\"%d\"} %g\n", size, bucket.duration.Seconds())
+		fmt.Fprintf(w, "chunkeduploader_chunk_duration_seconds_count{size=\"%d\"} %d\n", size, bucket.count)
+	}
+}
@@ -0,0 +1,303 @@
+package chunkeduploader
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WithStrictCleanup makes Cleanup also remove old files in the pending directory which do not belong to any upload.
+// By default they are only reported.
+func WithStrictCleanup() ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.strictCleanup = true
+	}
+}
+
+type pendingFileKind int
+
+const (
+	pendingFileUnknown pendingFileKind = iota
+	pendingFileData
+	pendingFileMetadata
+	pendingFileMetadataTemp
+	pendingFileMetadataBackup
+)
+
+// classifyPendingFile tells which upload artifact a file in the pending directory is by its path. Metadata files are
+// recognized when their path is a data path with up to two extensions.
+func (c *ChunkedUploaderService) classifyPendingFile(path string) (kind pendingFileKind, uploadId string) {
+	if uploadId, ok := c.paths.ParseID(path); ok {
+		return pendingFileData, uploadId
+	}
+
+	for _, candidate := range []struct {
+		suffix string
+		kind   pendingFileKind
+	}{
+		{".tmp", pendingFileMetadataTemp},
+		{".bak", pendingFileMetadataBackup},
+		{"", pendingFileMetadata},
+	} {
+		metaPath, ok := strings.CutSuffix(path, candidate.suffix)
+		if !ok {
+			continue
+		}
+
+		dataPath := metaPath
+		for i := 0; i < 2 && filepath.Ext(dataPath) != ""; i++ {
+			dataPath = strings.TrimSuffix(dataPath, filepath.Ext(dataPath))
+			if uploadId, ok := c.paths.ParseID(dataPath); ok && c.paths.MetaPath(uploadId) == metaPath {
+				return candidate.kind, uploadId
+			}
+		}
+	}
+
+	return pendingFileUnknown, ""
+}
+
+// CleanupSummary describes a single cleanup run.
+type CleanupSummary struct {
+	RemovedUploads int `json:"removed_uploads"`
+	RemovedFiles   int `json:"removed_files"`
+	// UnknownFiles are the files which do not belong to any upload, they are kept unless strict cleanup is enabled.
+	UnknownFiles []string `json:"unknown_files"`
+}
+
+// Cleanup removes old uploads that were created before a given timeLimit.
+func (c *ChunkedUploaderService) Cleanup(duration time.Duration) error {
+	summary, err := c.CleanupUploads(duration)
+	if err != nil {
+		return err
+	}
+
+	if len(summary.UnknownFiles) > 0 {
+		c.log(LogLevelWarn, "Cleanup found unknown files in the pending directory", LogField{"count", len(summary.UnknownFiles)}, LogField{"paths", strings.Join(summary.UnknownFiles, ", ")})
+	}
+
+	return nil
+}
+
+// CleanupUploads removes the files of uploads which were not modified for a given duration and returns what it did.
+// An upload is removed as a whole, its data, metadata, metadata backup and snapshots, once none of its files was
+// modified for the duration. Files which are not recognized as upload artifacts are never removed unless strict
+// cleanup is enabled.
+func (c *ChunkedUploaderService) CleanupUploads(duration time.Duration) (*CleanupSummary, error) {
+	run := c.newCleanupRun(duration)
+	summary := &CleanupSummary{UnknownFiles: []string{}}
+	removed := map[string]bool{}
+	// the writers of an upload are cancelled before its first file is removed and rejected until the run is over, an
+	// upload which is being finished is kept
+	stopped := map[string]bool{}
+	finishing := map[string]bool{}
+	var releases []func()
+	defer func() {
+		for _, release := range releases {
+			release()
+		}
+	}()
+
+	err := c.walkCleanup(run, func(file pendingFile, decision cleanupDecision) error {
+		if file.kind == pendingFileUnknown {
+			summary.UnknownFiles = append(summary.UnknownFiles, file.path)
+		}
+		if !decision.remove || finishing[file.uploadId] {
+			return nil
+		}
+
+		if file.kind != pendingFileUnknown && !stopped[file.uploadId] {
+			drained, release, err := c.writers.cancel(file.uploadId)
+			if errors.Is(err, UploadFinishingError) {
+				finishing[file.uploadId] = true
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			stopped[file.uploadId] = true
+			releases = append(releases, release)
+			<-drained
+		}
+
+		c.log(LogLevelInfo, "Removing old upload", LogField{"path", file.path}, LogField{"modified_at", file.info.ModTime()}, LogField{"bytes", file.info.Size()})
+		err := c.fs.Remove(file.path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove old upload %w", err)
+		}
+		summary.RemovedFiles++
+
+		if file.kind != pendingFileUnknown && !removed[file.uploadId] {
+			removed[file.uploadId] = true
+			c.releaseSpace(file.uploadId)
+			summary.RemovedUploads++
+
+			err = c.fs.RemoveAll(c.getSnapshotDirectory(file.uploadId))
+			if err != nil {
+				return fmt.Errorf("failed to remove snapshots of old upload %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return summary, fmt.Errorf("ChunkedUploaderService.Cleanup %w", err)
+	}
+
+	return summary, nil
+}
+
+// pendingFile is a file of the pending directory seen by a cleanup run.
+type pendingFile struct {
+	path     string
+	info     fs.FileInfo
+	kind     pendingFileKind
+	uploadId string
+}
+
+// walkCleanup walks the pending directory and calls fn with every file and the decision about it. All files of an
+// upload are decided by the one modified last, so they are removed together and never leave a part of the upload
+// behind.
+func (c *ChunkedUploaderService) walkCleanup(run *cleanupRun, fn func(file pendingFile, decision cleanupDecision) error) error {
+	var files []pendingFile
+	err := c.walkPending(c.paths, func(path string, info fs.FileInfo) error {
+		kind, uploadId := c.classifyPendingFile(path)
+		files = append(files, pendingFile{path: path, info: info, kind: kind, uploadId: uploadId})
+		if kind != pendingFileUnknown && info.ModTime().After(run.lastModified[uploadId]) {
+			run.lastModified[uploadId] = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		err = fn(file, c.decideCleanup(run, file))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cleanupReason tells which rule decides about a file in a cleanup run.
+type cleanupReason int
+
+const (
+	// cleanupReasonUnknown files do not belong to any upload, they are only removed with strict cleanup.
+	cleanupReasonUnknown cleanupReason = iota
+	// cleanupReasonActivity files are removed once they were not modified for the maximum age of the run.
+	cleanupReasonActivity
+	// cleanupReasonRetention files are removed once they were not modified for the retention of their source policy.
+	cleanupReasonRetention
+	// cleanupReasonExceeded files belong to an upload past the maximum upload duration.
+	cleanupReasonExceeded
+	// cleanupReasonExpired files belong to an unfinished upload past its deadline, see WithUploadTTL.
+	cleanupReasonExpired
+)
+
+// cleanupDecision is what a cleanup run does with a file, removeAt is when a later run removes a kept file unless it is
+// modified in the meantime, zero if never.
+type cleanupDecision struct {
+	remove   bool
+	reason   cleanupReason
+	removeAt time.Time
+}
+
+// cleanupRun caches what a cleanup run knows about the uploads, they are looked up once per run so they are still
+// known when the metadata is removed before the other files of the upload.
+type cleanupRun struct {
+	now     time.Time
+	maxAge  time.Duration
+	uploads map[string]cleanupUpload
+	// lastModified is the latest modification time among the files of every upload.
+	lastModified map[string]time.Time
+}
+
+type cleanupUpload struct {
+	policy *SourcePolicy
+	// deadline is when the unfinished upload exceeds the maximum upload duration, zero without a limit.
+	deadline time.Time
+	exceeded bool
+	// expiresAt is the deadline of the unfinished upload from its metadata, zero without one.
+	expiresAt time.Time
+}
+
+func (c *ChunkedUploaderService) newCleanupRun(maxAge time.Duration) *cleanupRun {
+	return &cleanupRun{now: time.Now(), maxAge: maxAge, uploads: map[string]cleanupUpload{}, lastModified: map[string]time.Time{}}
+}
+
+// decideCleanup decides about a single file of the pending directory, the files of an upload by the last modification
+// of any of them. It is shared by CleanupUploads and CleanupPreview, so the preview always matches what cleanup does.
+func (c *ChunkedUploaderService) decideCleanup(run *cleanupRun, file pendingFile) cleanupDecision {
+	if file.kind == pendingFileUnknown && !c.strictCleanup {
+		return cleanupDecision{reason: cleanupReasonUnknown}
+	}
+
+	upload := c.cleanupUpload(run, file.kind, file.uploadId)
+	// uploads past the maximum upload duration are removed however recently they were written to
+	if upload.exceeded {
+		return cleanupDecision{remove: true, reason: cleanupReasonExceeded, removeAt: run.now}
+	}
+	// and so are uploads past their deadline, which no longer accept chunks
+	if !upload.expiresAt.IsZero() && !upload.expiresAt.After(run.now) {
+		return cleanupDecision{remove: true, reason: cleanupReasonExpired, removeAt: run.now}
+	}
+
+	decision := cleanupDecision{reason: cleanupReasonActivity}
+	modTime := file.info.ModTime()
+	if file.kind == pendingFileUnknown {
+		decision.reason = cleanupReasonUnknown
+	} else {
+		modTime = run.lastModified[file.uploadId]
+	}
+	age := run.maxAge
+	if upload.policy != nil {
+		decision.reason = cleanupReasonRetention
+		if upload.policy.Retention <= 0 {
+			decision.removeAt = upload.expiresAt
+			return decision
+		}
+		age = upload.policy.Retention
+	}
+
+	decision.remove = modTime.Before(run.now.Add(-age))
+	decision.removeAt = modTime.Add(age)
+	for _, deadline := range []time.Time{upload.deadline, upload.expiresAt} {
+		if !deadline.IsZero() && deadline.Before(decision.removeAt) {
+			decision.removeAt = deadline
+		}
+	}
+	return decision
+}
+
+// cleanupUpload returns the source policy of an upload, its deadline and whether it is past the maximum upload
+// duration.
+func (c *ChunkedUploaderService) cleanupUpload(run *cleanupRun, kind pendingFileKind, uploadId string) cleanupUpload {
+	if kind == pendingFileUnknown {
+		return cleanupUpload{}
+	}
+
+	upload, ok := run.uploads[uploadId]
+	if !ok {
+		if meta, err := c.readMetadata(uploadId); err == nil {
+			if c.sourcePolicies != nil {
+				upload.policy = meta.Policy
+			}
+			if c.maxUploadDuration > 0 && !meta.finished() {
+				upload.deadline, _ = c.uploadDeadline(meta)
+				upload.exceeded = c.exceededDuration(meta) != nil
+			}
+			if meta.ExpiresAt != nil && !meta.finished() {
+				upload.expiresAt = *meta.ExpiresAt
+			}
+		}
+		run.uploads[uploadId] = upload
+	}
+
+	return upload
+}
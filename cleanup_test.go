@@ -0,0 +1,249 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// ageFiles sets the modification time of given files to a given time ago.
+func ageFiles(t *testing.T, fs afero.Fs, age time.Duration, paths ...string) {
+	t.Helper()
+
+	modTime := time.Now().Add(-age)
+	for _, path := range paths {
+		if err := fs.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func exists(t *testing.T, fs afero.Fs, path string) bool {
+	t.Helper()
+
+	ok, err := afero.Exists(fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+// newCleanupTestUpload creates an upload with a metadata backup and a snapshot and returns the paths of its files.
+func newCleanupTestUpload(t *testing.T, service *ChunkedUploaderService) (string, []string) {
+	t.Helper()
+
+	uploadId, err := service.CreateUpload(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, bytes.NewReader([]byte("data")), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.SnapshotUpload(context.Background(), uploadId, "snapshot"); err != nil {
+		t.Fatal(err)
+	}
+
+	backupPath := service.paths.MetaPath(uploadId) + ".bak"
+	if err := afero.WriteFile(service.fs, backupPath, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return uploadId, []string{service.getUploadFilePath(uploadId), service.paths.MetaPath(uploadId), backupPath}
+}
+
+func TestCleanupRemovesUploadsAsAWhole(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	service := newTestService(fs)
+
+	oldId, oldFiles := newCleanupTestUpload(t, service)
+	ageFiles(t, fs, 48*time.Hour, oldFiles...)
+
+	// the data is old, but the metadata was just written, so the upload is still in use
+	activeId, activeFiles := newCleanupTestUpload(t, service)
+	ageFiles(t, fs, 48*time.Hour, activeFiles[0], activeFiles[2])
+
+	summary, err := service.CleanupUploads(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.RemovedUploads != 1 || summary.RemovedFiles != len(oldFiles) {
+		t.Errorf("removed %d uploads and %d files, want 1 and %d", summary.RemovedUploads, summary.RemovedFiles, len(oldFiles))
+	}
+
+	for _, path := range append(oldFiles, service.getSnapshotDirectory(oldId)) {
+		if exists(t, fs, path) {
+			t.Errorf("%s of the old upload kept", path)
+		}
+	}
+	for _, path := range append(activeFiles, service.getSnapshotDirectory(activeId)) {
+		if !exists(t, fs, path) {
+			t.Errorf("%s of the active upload removed", path)
+		}
+	}
+}
+
+func TestCleanupKeepsForeignFiles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	service := newTestService(fs)
+
+	foreign := []string{
+		filepath.Join(service.paths.Root(), "operator-notes.txt"),
+		filepath.Join(service.paths.Root(), "journal.lock"),
+	}
+	for _, path := range foreign {
+		if err := afero.WriteFile(fs, path, []byte("keep"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ageFiles(t, fs, 48*time.Hour, foreign...)
+
+	summary, err := service.CleanupUploads(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.UnknownFiles) != len(foreign) {
+		t.Errorf("reported %v as unknown, want %v", summary.UnknownFiles, foreign)
+	}
+	for _, path := range foreign {
+		if !exists(t, fs, path) {
+			t.Errorf("foreign file %s removed", path)
+		}
+	}
+
+	strict := newTestService(fs, WithStrictCleanup())
+	if _, err := strict.CleanupUploads(24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range foreign {
+		if exists(t, fs, path) {
+			t.Errorf("foreign file %s kept with strict cleanup", path)
+		}
+	}
+}
+
+func TestCleanupPreviewMatchesCleanup(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	service := newTestService(fs)
+
+	_, oldFiles := newCleanupTestUpload(t, service)
+	ageFiles(t, fs, 72*time.Hour, oldFiles...)
+	_, activeFiles := newCleanupTestUpload(t, service)
+	ageFiles(t, fs, 72*time.Hour, activeFiles[0])
+
+	report, err := service.CleanupPreview(context.Background(), 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Reclaimable.Uploads != 1 || report.Reclaimable.Files != len(oldFiles) {
+		t.Errorf("preview reclaims %+v, want 1 upload and %d files", report.Reclaimable, len(oldFiles))
+	}
+	if report.RetainedActivity.Files != len(activeFiles) {
+		t.Errorf("preview retains %+v, want %d files", report.RetainedActivity, len(activeFiles))
+	}
+}
+
+func TestCleanupStopsChunkWriters(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// hold registers a writer or a finish of the upload while the cleanup runs and returns its release
+		hold   func(t *testing.T, service *ChunkedUploaderService, uploadId string) func()
+		remove bool
+	}{
+		{"writing", func(t *testing.T, service *ChunkedUploaderService, uploadId string) func() {
+			ctx, done, err := service.writers.start(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the writer notices the cancel and stops, only then the cleanup may remove the files
+			go func() {
+				<-ctx.Done()
+				if cause := context.Cause(ctx); cause != UploadCancelledError {
+					t.Errorf("writer stopped with %v, want UploadCancelledError", cause)
+				}
+				done()
+			}()
+			return func() {}
+		}, true},
+		{"finishing", func(t *testing.T, service *ChunkedUploaderService, uploadId string) func() {
+			_, release, err := service.writers.finish(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return release
+		}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs())
+			uploadId, paths := newCleanupTestUpload(t, service)
+			ageFiles(t, service.fs, 2*time.Hour, paths...)
+
+			release := tc.hold(t, service, uploadId)
+			defer release()
+
+			if err := service.Cleanup(time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range paths {
+				if got := exists(t, service.fs, path); got == tc.remove {
+					t.Errorf("%s exists: %v, want %v", path, got, !tc.remove)
+				}
+			}
+			if tc.remove {
+				if _, _, err := service.writers.start(uploadId); err != nil {
+					t.Errorf("start after the cleanup: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestCleanupRemovesExpiredUploads(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// expiresIn moves the deadline of the upload relative to now, zero leaves it without one
+		expiresIn time.Duration
+		remove    bool
+		bucket    func(report *CleanupReport) CleanupBucket
+	}{
+		{"expired", -time.Minute, true, func(report *CleanupReport) CleanupBucket { return report.Reclaimable }},
+		{"expiring", 30 * time.Minute, false, func(report *CleanupReport) CleanupBucket { return report.ExpiringWithin1h }},
+		{"without deadline", 0, false, func(report *CleanupReport) CleanupBucket { return report.RetainedActivity }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs())
+			uploadId, paths := newCleanupTestUpload(t, service)
+			if tc.expiresIn != 0 {
+				err := service.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+					expiresAt := time.Now().Add(tc.expiresIn)
+					meta.ExpiresAt = &expiresAt
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// the files were just written, only the deadline can make the cleanup remove them
+			report, err := service.CleanupPreview(context.Background(), 48*time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uploads := tc.bucket(report).Uploads; uploads != 1 {
+				t.Errorf("preview: %d uploads in the bucket, want 1: %+v", uploads, report)
+			}
+
+			if err := service.Cleanup(48 * time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range paths {
+				if got := exists(t, service.fs, path); got == tc.remove {
+					t.Errorf("%s exists: %v, want %v", path, got, !tc.remove)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+// CleanupBucket counts the files in the pending directory sharing a cleanup outcome, Uploads counts the data files
+// among them.
+type CleanupBucket struct {
+	Uploads int   `json:"uploads"`
+	Files   int   `json:"files"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func (b *CleanupBucket) add(kind pendingFileKind, info fs.FileInfo) {
+	if kind == pendingFileData {
+		b.Uploads++
+	}
+	b.Files++
+	b.Bytes += info.Size()
+}
+
+// CleanupReport tells what a cleanup run with a given maximum age would do at the time of the report. Every file of
+// the pending directory is counted in exactly one bucket.
+type CleanupReport struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	MaxAge      string    `json:"max_age"`
+	// Reclaimable files are removed by the next run.
+	Reclaimable CleanupBucket `json:"reclaimable"`
+	// ExpiringWithin1h, 6h and 24h are removed by a run in that time unless they are modified before, each bucket
+	// leaves out the files of the shorter ones.
+	ExpiringWithin1h  CleanupBucket `json:"expiring_within_1h"`
+	ExpiringWithin6h  CleanupBucket `json:"expiring_within_6h"`
+	ExpiringWithin24h CleanupBucket `json:"expiring_within_24h"`
+	// RetainedActivity files were modified within the maximum age, RetainedTTL files are kept for the retention of
+	// their source policy, see WithSourcePolicies.
+	RetainedActivity CleanupBucket `json:"retained_activity"`
+	RetainedTTL      CleanupBucket `json:"retained_ttl"`
+	// UnknownFiles do not belong to any upload, they are also counted as reclaimable with strict cleanup.
+	UnknownFiles CleanupBucket `json:"unknown_files"`
+}
+
+// CleanupPreview walks the pending directory without removing anything and reports what CleanupUploads would do with
+// a given maximum age, zero uses the one of WithCleanupInterval. Both use the same decision for every file.
+func (c *ChunkedUploaderService) CleanupPreview(ctx context.Context, maxAge time.Duration) (*CleanupReport, error) {
+	if maxAge <= 0 {
+		maxAge = c.cleanupMaxAge
+	}
+	if maxAge <= 0 {
+		return nil, fmt.Errorf("ChunkedUploaderService.CleanupPreview the maximum age is required without a cleanup interval")
+	}
+
+	run := c.newCleanupRun(maxAge)
+	report := &CleanupReport{GeneratedAt: run.now, MaxAge: maxAge.String()}
+
+	err := c.walkCleanup(run, func(file pendingFile, decision cleanupDecision) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		kind, info := file.kind, file.info
+		if kind == pendingFileUnknown {
+			report.UnknownFiles.add(kind, info)
+		}
+
+		switch {
+		case decision.remove:
+			report.Reclaimable.add(kind, info)
+		case kind == pendingFileUnknown:
+		case !decision.removeAt.IsZero() && decision.removeAt.Sub(run.now) <= time.Hour:
+			report.ExpiringWithin1h.add(kind, info)
+		case !decision.removeAt.IsZero() && decision.removeAt.Sub(run.now) <= 6*time.Hour:
+			report.ExpiringWithin6h.add(kind, info)
+		case !decision.removeAt.IsZero() && decision.removeAt.Sub(run.now) <= 24*time.Hour:
+			report.ExpiringWithin24h.add(kind, info)
+		case decision.reason == cleanupReasonRetention:
+			report.RetainedTTL.add(kind, info)
+		default:
+			report.RetainedActivity.add(kind, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.CleanupPreview %w", err)
+	}
+
+	return report, nil
+}
+
+// CleanupPreviewHandler reports what cleanup would do, the max_age query parameter is a duration like "24h" and
+// defaults to the one of WithCleanupInterval.
+func (c *ChunkedUploaderHandler) CleanupPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.requireAdmin(w, r) {
+		return
+	}
+
+	maxAge := c.service.cleanupMaxAge
+	if value := r.URL.Query().Get("max_age"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "max_age must be a positive duration")
+			return
+		}
+		maxAge = parsed
+	}
+	if maxAge <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "max_age is required without a cleanup interval")
+		return
+	}
+
+	report, err := c.service.CleanupPreview(r.Context(), maxAge)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to preview cleanup: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,111 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// maxClientContextLength caps the client context of an upload.
+const maxClientContextLength = 4096
+
+// WithClientContext attaches an opaque string of the application to the upload, like the id of the job it belongs
+// to. It is never interpreted, only returned as it is in the status, the metadata and every event of the upload,
+// including the deliveries of notifiers.
+func WithClientContext(clientContext string) CreateUploadOption {
+	return func(m *UploadMetadata) {
+		m.ClientContext = clientContext
+	}
+}
+
+// MetadataPatch changes the user provided metadata fields which are set and keeps the others, unlike ReplaceMetadata.
+// Tags replace all tags when they are not nil.
+type MetadataPatch struct {
+	Filename      *string           `json:"filename"`
+	ContentType   *string           `json:"content_type"`
+	Tags          map[string]string `json:"tags"`
+	Fingerprint   *string           `json:"fingerprint"`
+	ClientContext *string           `json:"client_context"`
+}
+
+// PatchMetadata applies a patch to the user provided metadata of a given upload, the result is checked like the
+// metadata of a new upload.
+func (c *ChunkedUploaderService) PatchMetadata(ctx context.Context, uploadId string, patch MetadataPatch) error {
+	err := c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		if meta.finished() && !c.mutableCompletedMetadata {
+			return MetadataLockedError
+		}
+
+		if patch.Filename != nil {
+			meta.Filename = *patch.Filename
+		}
+		if patch.ContentType != nil {
+			meta.ContentType = *patch.ContentType
+		}
+		if patch.Tags != nil {
+			meta.Tags = patch.Tags
+		}
+		if patch.Fingerprint != nil {
+			meta.Fingerprint = *patch.Fingerprint
+		}
+		if patch.ClientContext != nil {
+			meta.ClientContext = *patch.ClientContext
+		}
+		return meta.sanitize()
+	})
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.PatchMetadata failed to update metadata %w", err)
+	}
+
+	return nil
+}
+
+// PatchMetadataHandler changes the metadata fields of a given uploadId which are set in the request body, only the
+// owner of the upload may do so.
+func (c *ChunkedUploaderHandler) PatchMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	uploadId := mux.Vars(r)["upload_id"]
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	meta, err := c.service.readMetadata(uploadId)
+	if err == nil && !c.requireOwner(w, r, meta) {
+		return
+	}
+
+	var patch MetadataPatch
+	err = json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	err = c.service.PatchMetadata(r.Context(), uploadId, patch)
+	if err != nil {
+		switch {
+		case errors.Is(err, InvalidMetadataError), errors.Is(err, ProtectedMetadataKeyError):
+			writeMetadataError(w, err)
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, MetadataLockedError):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to update metadata: "+err.Error())
+		}
+		return
+	}
+
+	updated, err := c.service.GetMetadata(r.Context(), uploadId)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to read metadata: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(updated)
+}
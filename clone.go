@@ -0,0 +1,317 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var DestinationExistsError = errors.New("destination exists")
+var InvalidDestinationError = errors.New("invalid destination upload id")
+
+// CloneOptions configures CloneUpload.
+type CloneOptions struct {
+	// Overwrite replaces an existing destination upload, without it CloneUpload fails with DestinationExistsError.
+	Overwrite bool
+	// Metadata copies the user provided metadata too, like the filename, content type and tags. Otherwise only the
+	// data of the upload is copied together with the bookkeeping of it, like the state, regions and checksum.
+	Metadata bool
+	// Owner owns the copy, it defaults to the owner of the source.
+	Owner string
+}
+
+// CloneUpload copies an upload to a given destination upload id. The destination must be an id the service could
+// have generated itself. It reports whether an existing destination was overwritten.
+func (c *ChunkedUploaderService) CloneUpload(ctx context.Context, uploadId string, destinationId string, opts CloneOptions) (overwritten bool, err error) {
+	if parsed, ok := c.paths.ParseID(c.getUploadFilePath(destinationId)); !ok || parsed != destinationId || destinationId == uploadId {
+		return false, fmt.Errorf("ChunkedUploaderService.CloneUpload %w: %s", InvalidDestinationError, destinationId)
+	}
+
+	err = c.flushWriteBuffer(uploadId)
+	if err != nil {
+		return false, fmt.Errorf("ChunkedUploaderService.CloneUpload failed to flush write buffer %w", err)
+	}
+
+	// both uploads are locked in the same order by everyone, so two clones in opposite directions cannot deadlock
+	first, second := uploadId, destinationId
+	if second < first {
+		first, second = second, first
+	}
+	defer c.locks.lock(first)()
+	defer c.locks.lock(second)()
+
+	source, err := c.readMetadata(uploadId)
+	if err != nil {
+		return false, fmt.Errorf("ChunkedUploaderService.CloneUpload failed to read metadata %w", err)
+	}
+	sourcePath := source.Path
+	if sourcePath == "" {
+		sourcePath = c.getUploadFilePath(uploadId)
+	}
+
+	_, err = c.readMetadata(destinationId)
+	switch {
+	case err == nil:
+		if !opts.Overwrite {
+			return false, fmt.Errorf("ChunkedUploaderService.CloneUpload %w", DestinationExistsError)
+		}
+		err = c.RemovePendingFile(destinationId)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return false, fmt.Errorf("ChunkedUploaderService.CloneUpload failed to remove destination %w", err)
+		}
+		overwritten = true
+	case !errors.Is(err, UploadNotFoundError):
+		return false, fmt.Errorf("ChunkedUploaderService.CloneUpload failed to read destination metadata %w", err)
+	}
+
+	err = c.backgroundRead(func() error {
+		return copyFile(c.fs, sourcePath, c.getUploadFilePath(destinationId))
+	})
+	if err != nil {
+		return false, fmt.Errorf("ChunkedUploaderService.CloneUpload failed to copy data %w", err)
+	}
+
+	clone := &UploadMetadata{
+		UploadId:          destinationId,
+		CreatedAt:         time.Now(),
+		State:             source.State,
+		FileSize:          source.FileSize,
+		Mode:              source.Mode,
+		ChunkSize:         source.ChunkSize,
+		Owner:             source.Owner,
+		Source:            source.Source,
+		Policy:            source.Policy,
+		Durable:           source.Durable,
+		StrictResume:      source.StrictResume,
+		ExpiresAt:         source.ExpiresAt,
+		Sequence:          source.Sequence,
+		LastChunkChecksum: source.LastChunkChecksum,
+		Checksum:          source.Checksum,
+		ChecksumAlgorithm: source.ChecksumAlgorithm,
+		FailureReason:     source.FailureReason,
+		Signature:         source.Signature,
+		Regions:           source.Regions,
+		TreeLeaves:        source.TreeLeaves,
+		LastWriteAt:       source.LastWriteAt,
+	}
+	if opts.Metadata {
+		clone.Filename = source.Filename
+		clone.ContentType = source.ContentType
+		clone.Tags = source.Tags
+		clone.Fingerprint = source.Fingerprint
+	}
+	if opts.Owner != "" {
+		clone.Owner = opts.Owner
+	}
+
+	err = c.saveMetadata(clone)
+	if err != nil {
+		c.fs.Remove(c.getUploadFilePath(destinationId))
+		return false, fmt.Errorf("ChunkedUploaderService.CloneUpload failed to write metadata %w", err)
+	}
+	c.emit(EventUploadCreated, destinationId, map[string]string{"state": string(clone.State), "copied_from": uploadId})
+
+	return overwritten, nil
+}
+
+// CopyUploadHandler copies a given uploadId like the WebDAV COPY method does. The Destination header holds the url or
+// path of the destination, its last segment is the upload id. Overwrite: F refuses to replace an existing destination
+// with 412, Depth: 0 copies only the data and not the user provided metadata. The response is 201 for a new
+// destination and 204 for an overwritten one.
+func (c *ChunkedUploaderHandler) CopyUploadHandler(w http.ResponseWriter, r *http.Request) {
+	uploadId := mux.Vars(r)["upload_id"]
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	destinationId, err := parseDestination(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if destinationId == uploadId {
+		writeJSONError(w, http.StatusForbidden, "source and destination are the same upload")
+		return
+	}
+
+	overwrite, err := parseOverwrite(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts := CloneOptions{Overwrite: overwrite, Metadata: true}
+	switch strings.ToLower(r.Header.Get("Depth")) {
+	case "", "infinity":
+	case "0":
+		opts.Metadata = false
+	default:
+		writeJSONError(w, http.StatusBadRequest, "Depth must be 0 or infinity")
+		return
+	}
+
+	for _, id := range []string{uploadId, destinationId} {
+		meta, err := c.service.readMetadata(id)
+		if err != nil {
+			continue
+		}
+		if !c.requireOwner(w, r, meta) {
+			return
+		}
+	}
+	if c.ownerOf != nil {
+		opts.Owner = c.ownerOf(r)
+	}
+
+	overwritten, err := c.service.CloneUpload(r.Context(), uploadId, destinationId, opts)
+	if err != nil {
+		switch {
+		case errors.Is(err, InvalidDestinationError):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, DestinationExistsError):
+			writeJSONError(w, http.StatusPreconditionFailed, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to copy upload: "+err.Error())
+		}
+		return
+	}
+
+	if overwritten {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Location", destinationId)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// parseDestinationPath returns the path in the Destination header, which is an absolute url or a path.
+func parseDestinationPath(r *http.Request) (string, error) {
+	destination := r.Header.Get("Destination")
+	if destination == "" {
+		return "", fmt.Errorf("Destination header is required")
+	}
+
+	u, err := url.Parse(destination)
+	if err != nil || u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return "", fmt.Errorf("invalid Destination header")
+	}
+
+	return u.Path, nil
+}
+
+// parseDestination returns the upload id in the last segment of the Destination header.
+func parseDestination(r *http.Request) (string, error) {
+	destination, err := parseDestinationPath(r)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Base(destination), nil
+}
+
+// MoveUpload moves the file of a complete upload to a destination below the destination root, see
+// WithDestinationRoot. Without overwrite an existing destination fails with DestinationExistsError. It reports
+// whether an existing file was overwritten.
+func (c *ChunkedUploaderService) MoveUpload(ctx context.Context, uploadId string, destination string, overwrite bool) (overwritten bool, err error) {
+	path, err := c.confineDestination(destination)
+	if err != nil {
+		return false, fmt.Errorf("ChunkedUploaderService.MoveUpload %w", err)
+	}
+
+	err = c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		if meta.State != UploadStateComplete {
+			return UploadNotCompleteError
+		}
+
+		info, err := c.fs.Stat(path)
+		switch {
+		case err == nil && info.IsDir():
+			return fmt.Errorf("%w: %s is a directory", DestinationExistsError, destination)
+		case err == nil && !overwrite:
+			return DestinationExistsError
+		case err == nil:
+			overwritten = true
+		case !errors.Is(err, fs.ErrNotExist):
+			return fmt.Errorf("failed to stat destination %w", err)
+		}
+
+		return c.moveUploadedFile(meta, path)
+	})
+	if err != nil {
+		return false, fmt.Errorf("ChunkedUploaderService.MoveUpload %w", err)
+	}
+
+	return overwritten, nil
+}
+
+// MoveUploadHandler moves the file of a complete uploadId like the WebDAV MOVE method does. The Destination header
+// holds the url or path of the destination below the destination root, Overwrite: F refuses to replace an existing
+// file with 412. The response is 201 for a new file and 204 for an overwritten one.
+func (c *ChunkedUploaderHandler) MoveUploadHandler(w http.ResponseWriter, r *http.Request) {
+	uploadId := mux.Vars(r)["upload_id"]
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	destination, err := parseDestinationPath(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	overwrite, err := parseOverwrite(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	meta, err := c.service.readMetadata(uploadId)
+	if err == nil && !c.requireOwner(w, r, meta) {
+		return
+	}
+
+	overwritten, err := c.service.MoveUpload(r.Context(), uploadId, destination, overwrite)
+	if err != nil {
+		switch {
+		case errors.Is(err, DestinationNotAllowedError):
+			writeJSONError(w, http.StatusForbidden, err.Error())
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, UploadNotCompleteError):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, DestinationExistsError):
+			writeJSONError(w, http.StatusPreconditionFailed, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to move upload: "+err.Error())
+		}
+		return
+	}
+
+	if overwritten {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// parseOverwrite reads the WebDAV Overwrite header, which defaults to T.
+func parseOverwrite(r *http.Request) (bool, error) {
+	switch strings.ToUpper(r.Header.Get("Overwrite")) {
+	case "", "T":
+		return true, nil
+	case "F":
+		return false, nil
+	default:
+		return false, fmt.Errorf("Overwrite must be T or F")
+	}
+}
@@ -0,0 +1,280 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/spf13/afero"
+)
+
+func TestCopyUploadHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// existing creates the destination before the copy
+		existing     bool
+		overwrite    string
+		depth        string
+		absoluteURL  bool
+		want         int
+		wantCopied   bool
+		wantFilename string
+	}{
+		{name: "new destination", want: http.StatusCreated, wantCopied: true, wantFilename: "source.bin"},
+		{name: "new destination without overwrite", overwrite: "F", want: http.StatusCreated, wantCopied: true, wantFilename: "source.bin"},
+		{name: "existing destination with overwrite", existing: true, overwrite: "T", want: http.StatusNoContent, wantCopied: true, wantFilename: "source.bin"},
+		{name: "existing destination overwritten by default", existing: true, want: http.StatusNoContent, wantCopied: true, wantFilename: "source.bin"},
+		{name: "existing destination without overwrite", existing: true, overwrite: "F", want: http.StatusPreconditionFailed, wantFilename: "destination.bin"},
+		{name: "depth 0", depth: "0", want: http.StatusCreated, wantCopied: true, wantFilename: ""},
+		{name: "depth infinity", depth: "infinity", want: http.StatusCreated, wantCopied: true, wantFilename: "source.bin"},
+		{name: "absolute destination url", absoluteURL: true, want: http.StatusCreated, wantCopied: true, wantFilename: "source.bin"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			service := newTestService(fs)
+			handler := NewHTTPHandler(service)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			source, err := service.CreateUpload(1000, WithFilename("source.bin"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sourceData := randomBytes(t, 1000)
+			uploadOverHTTP(t, handler, source, sourceData, 500)
+
+			destination := uuid.New().String()
+			var destinationData []byte
+			if tc.existing {
+				destination, err = service.CreateUpload(1000, WithFilename("destination.bin"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				destinationData = randomBytes(t, 1000)
+				uploadOverHTTP(t, handler, destination, destinationData, 500)
+			}
+
+			req, err := http.NewRequest("COPY", server.URL+"/"+source, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Destination", "/uploads/"+destination)
+			if tc.absoluteURL {
+				req.Header.Set("Destination", server.URL+"/uploads/"+destination)
+			}
+			if tc.overwrite != "" {
+				req.Header.Set("Overwrite", tc.overwrite)
+			}
+			if tc.depth != "" {
+				req.Header.Set("Depth", tc.depth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.want {
+				t.Fatalf("got %d, want %d", resp.StatusCode, tc.want)
+			}
+
+			data, err := afero.ReadFile(fs, service.getUploadFilePath(destination))
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantData := destinationData
+			if tc.wantCopied {
+				wantData = sourceData
+			}
+			if !bytes.Equal(data, wantData) {
+				t.Errorf("destination holds the wrong data")
+			}
+
+			meta, err := service.readMetadata(destination)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Filename != tc.wantFilename {
+				t.Errorf("filename %q, want %q", meta.Filename, tc.wantFilename)
+			}
+
+			// the source is left alone
+			data, err = afero.ReadFile(fs, service.getUploadFilePath(source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, sourceData) {
+				t.Errorf("source was changed")
+			}
+		})
+	}
+}
+
+func TestCopyUploadHandlerErrors(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	source, err := service.CreateUpload(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	destination := uuid.New().String()
+
+	for _, tc := range []struct {
+		name     string
+		uploadId string
+		headers  map[string]string
+		want     int
+	}{
+		{"missing destination", source, map[string]string{}, http.StatusBadRequest},
+		{"destination of a directory", source, map[string]string{"Destination": "/uploads/"}, http.StatusBadRequest},
+		{"invalid destination id", source, map[string]string{"Destination": "/uploads/notanid"}, http.StatusBadRequest},
+		{"same upload", source, map[string]string{"Destination": "/uploads/" + source}, http.StatusForbidden},
+		{"invalid overwrite", source, map[string]string{"Destination": "/uploads/" + destination, "Overwrite": "yes"}, http.StatusBadRequest},
+		{"invalid depth", source, map[string]string{"Destination": "/uploads/" + destination, "Depth": "1"}, http.StatusBadRequest},
+		{"unknown source", uuid.New().String(), map[string]string{"Destination": "/uploads/" + destination}, http.StatusNotFound},
+	} {
+		req := httptest.NewRequest("COPY", "/"+tc.uploadId, nil)
+		for key, value := range tc.headers {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body)
+		}
+	}
+}
+
+func TestMoveUploadHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// existing writes a file to the destination before the move
+		existing  bool
+		uploading bool
+		overwrite string
+		want      int
+		wantMoved bool
+	}{
+		{name: "new destination", want: http.StatusCreated, wantMoved: true},
+		{name: "new destination without overwrite", overwrite: "F", want: http.StatusCreated, wantMoved: true},
+		{name: "existing destination with overwrite", existing: true, overwrite: "T", want: http.StatusNoContent, wantMoved: true},
+		{name: "existing destination overwritten by default", existing: true, want: http.StatusNoContent, wantMoved: true},
+		{name: "existing destination without overwrite", existing: true, overwrite: "F", want: http.StatusPreconditionFailed},
+		{name: "uploading upload", uploading: true, want: http.StatusConflict},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			service := newTestService(fs, WithDestinationRoot("/final"))
+			handler := NewHTTPHandler(service)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			var uploadId string
+			var data []byte
+			var path string
+			if tc.uploading {
+				var err error
+				uploadId, err = service.CreateUpload(100)
+				if err != nil {
+					t.Fatal(err)
+				}
+				path = service.getUploadFilePath(uploadId)
+			} else {
+				uploadId, data, path = newFinishedTestUpload(t, service)
+			}
+			existing := []byte("existing")
+			if tc.existing {
+				if err := afero.WriteFile(fs, "/final/dir/file.zip", existing, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req, err := http.NewRequest("MOVE", server.URL+"/"+uploadId, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Destination", "/dir/file.zip")
+			if tc.overwrite != "" {
+				req.Header.Set("Overwrite", tc.overwrite)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.want {
+				t.Fatalf("got %d, want %d", resp.StatusCode, tc.want)
+			}
+
+			meta, err := service.readMetadata(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.wantMoved {
+				if meta.Path == "/final/dir/file.zip" {
+					t.Errorf("upload was moved")
+				}
+				if tc.existing {
+					if got, _ := afero.ReadFile(fs, "/final/dir/file.zip"); !bytes.Equal(got, existing) {
+						t.Errorf("existing destination was changed")
+					}
+				}
+				return
+			}
+
+			if meta.Path != "/final/dir/file.zip" {
+				t.Errorf("path %q, want /final/dir/file.zip", meta.Path)
+			}
+			if got, _ := afero.ReadFile(fs, "/final/dir/file.zip"); !bytes.Equal(got, data) {
+				t.Errorf("destination holds the wrong data")
+			}
+			if ok, _ := afero.Exists(fs, path); ok {
+				t.Errorf("%s still exists", path)
+			}
+		})
+	}
+}
+
+func TestMoveUploadHandlerErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		root        string
+		destination string
+		overwrite   string
+		unknown     bool
+		want        int
+	}{
+		{name: "no destination root", destination: "/file.zip", want: http.StatusForbidden},
+		{name: "destination root itself", root: "/final", destination: "/", want: http.StatusBadRequest},
+		{name: "pending directory", root: "/", destination: pendingDirectory + "/file.zip", want: http.StatusForbidden},
+		{name: "missing destination", root: "/final", want: http.StatusBadRequest},
+		{name: "invalid overwrite", root: "/final", destination: "/file.zip", overwrite: "yes", want: http.StatusBadRequest},
+		{name: "unknown upload", root: "/final", destination: "/file.zip", unknown: true, want: http.StatusNotFound},
+	} {
+		var opts []ChunkedUploaderServiceOption
+		if tc.root != "" {
+			opts = append(opts, WithDestinationRoot(tc.root))
+		}
+		service := newTestService(afero.NewMemMapFs(), opts...)
+		handler := NewHTTPHandler(service)
+		uploadId, _, _ := newFinishedTestUpload(t, service)
+		if tc.unknown {
+			uploadId = uuid.New().String()
+		}
+
+		req := httptest.NewRequest("MOVE", "/"+uploadId, nil)
+		if tc.destination != "" {
+			req.Header.Set("Destination", tc.destination)
+		}
+		if tc.overwrite != "" {
+			req.Header.Set("Overwrite", tc.overwrite)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// WithWriteCoalescing buffers sequential chunks of an upload in memory, up to bufferSize bytes, and writes them to
+// the pending file in one go. The buffer is flushed when it fills up, when a chunk arrives at a non-contiguous
+// offset, when the upload is finished or when it was not written to for flushInterval (the latter requires Run).
+// Buffered bytes are not reported as written until they are flushed, so they are lost on a crash like any other
+// data the server never received. Both the buffer size and the flush interval must be positive.
+func WithWriteCoalescing(bufferSize int, flushInterval time.Duration) ChunkedUploaderServiceOption {
+	if bufferSize <= 0 {
+		panic("chunkeduploader: the write buffer size must be positive")
+	}
+	if flushInterval <= 0 {
+		panic("chunkeduploader: the write buffer flush interval must be positive")
+	}
+
+	return func(c *ChunkedUploaderService) {
+		c.coalescer = &writeCoalescer{
+			bufferSize:    bufferSize,
+			flushInterval: flushInterval,
+			buffers:       make(map[string]*writeBuffer),
+		}
+		c.background.register("write-coalescer", c.runEvery(flushInterval, c.flushStaleWriteBuffers))
+	}
+}
+
+type writeCoalescer struct {
+	bufferSize    int
+	flushInterval time.Duration
+
+	mu      sync.Mutex
+	buffers map[string]*writeBuffer
+}
+
+// writeBuffer holds contiguous bytes of a single upload starting at start.
+type writeBuffer struct {
+	mu        sync.Mutex
+	start     int64
+	data      []byte
+	updatedAt time.Time
+
+	// view is the pending file as the service last saw it, validated at validatedAt and chunks chunks ago, see
+	// WithWriteBufferValidation.
+	view        *pendingFileView
+	validatedAt time.Time
+	chunks      int
+	// removed is set once the buffer is no longer in the coalescer, a chunk which got hold of it before has to look
+	// up the buffer of its upload again.
+	removed bool
+}
+
+func (b *writeBuffer) end() int64 {
+	return b.start + int64(len(b.data))
+}
+
+// lookup returns the buffer of a given upload, if it has one.
+func (w *writeCoalescer) lookup(uploadId string) (*writeBuffer, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	buf, ok := w.buffers[uploadId]
+	return buf, ok
+}
+
+// remove takes a buffer out of the coalescer, the caller must hold the lock of the buffer.
+func (w *writeCoalescer) remove(uploadId string, buf *writeBuffer) {
+	w.mu.Lock()
+	if w.buffers[uploadId] == buf {
+		delete(w.buffers, uploadId)
+	}
+	w.mu.Unlock()
+
+	buf.removed = true
+}
+
+// lockWriteBuffer returns the locked buffer of a given upload. A buffer is only created for an upload whose pending
+// file exists, so chunks of unknown uploads do not leave buffers behind.
+func (c *ChunkedUploaderService) lockWriteBuffer(uploadId string) (*writeBuffer, error) {
+	for {
+		buf, ok := c.coalescer.lookup(uploadId)
+		if !ok {
+			_, err := c.fs.Stat(c.getUploadFilePath(uploadId))
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("ChunkedUploaderService.bufferChunk %w", UploadNotFoundError)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("ChunkedUploaderService.bufferChunk failed to stat pending file %w", err)
+			}
+
+			c.coalescer.mu.Lock()
+			buf, ok = c.coalescer.buffers[uploadId]
+			if !ok {
+				buf = &writeBuffer{data: make([]byte, 0, c.coalescer.bufferSize)}
+				c.coalescer.buffers[uploadId] = buf
+			}
+			c.coalescer.mu.Unlock()
+		}
+
+		buf.mu.Lock()
+		if !buf.removed {
+			return buf, nil
+		}
+		// flushed or dropped while we were waiting for it
+		buf.mu.Unlock()
+	}
+}
+
+// bufferChunk adds a chunk to the write buffer of a given upload, flushing it as often as needed.
+func (c *ChunkedUploaderService) bufferChunk(uploadId string, data io.Reader, offset int64) (string, error) {
+	buf, err := c.lockWriteBuffer(uploadId)
+	if err != nil {
+		return "", err
+	}
+	defer buf.mu.Unlock()
+
+	err = c.validateWriteBuffer(uploadId, buf)
+	if err != nil {
+		return "", err
+	}
+	err = c.checkBufferedSize(uploadId, buf)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := sha256.New()
+	reader := io.TeeReader(data, hasher)
+	chunkStart := offset
+
+	for {
+		if len(buf.data) > 0 && (buf.end() != offset || len(buf.data) == cap(buf.data)) {
+			err := c.flushBuffer(uploadId, buf)
+			if err != nil {
+				return "", err
+			}
+		}
+		if len(buf.data) == 0 {
+			buf.start = offset
+		}
+
+		n, err := reader.Read(buf.data[len(buf.data):cap(buf.data)])
+		buf.data = buf.data[:len(buf.data)+n]
+		buf.updatedAt = time.Now()
+		offset += int64(n)
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if rolledBack(err) {
+				// only the part of the chunk which was not flushed yet can be taken back
+				buf.data = buf.data[:max(chunkStart-buf.start, 0)]
+			}
+			return "", fmt.Errorf("ChunkedUploaderService.bufferChunk failed to read chunk %w", err)
+		}
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// checkBufferedSize fails like writePart once the pending file of a buffered upload, together with the bytes which
+// are not flushed yet, reached the maximum file size.
+func (c *ChunkedUploaderService) checkBufferedSize(uploadId string, buf *writeBuffer) error {
+	if c.maxFileSize == nil {
+		return nil
+	}
+
+	var size int64
+	if buf.view != nil {
+		size = buf.view.Size()
+	} else if info, err := c.fs.Stat(c.getUploadFilePath(uploadId)); err == nil {
+		size = info.Size()
+	}
+	if len(buf.data) > 0 {
+		size = max(size, buf.end())
+	}
+
+	if size >= *c.maxFileSize {
+		return FileSizeExceedsMaximumError
+	}
+	return nil
+}
+
+// flushBuffer writes the buffered bytes to the pending file, the buffer is emptied even if the write fails.
+func (c *ChunkedUploaderService) flushBuffer(uploadId string, buf *writeBuffer) error {
+	if len(buf.data) == 0 {
+		return nil
+	}
+
+	_, written, leaves, err := c.writePart(c.getUploadFilePath(uploadId), bytes.NewReader(buf.data), buf.start, false)
+	buf.data = buf.data[:0]
+	c.observePendingFile(uploadId, buf)
+
+	if written != nil {
+		regionErr := c.addWrittenRegion(uploadId, *written, leaves)
+		if regionErr != nil && err == nil {
+			err = regionErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.flushBuffer failed to flush write buffer %w", err)
+	}
+
+	return nil
+}
+
+// flushWriteBuffer flushes and forgets the write buffer of a given upload.
+func (c *ChunkedUploaderService) flushWriteBuffer(uploadId string) error {
+	if c.coalescer == nil {
+		return nil
+	}
+
+	buf, ok := c.coalescer.lookup(uploadId)
+	if !ok {
+		return nil
+	}
+
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	c.coalescer.remove(uploadId, buf)
+	return c.flushBuffer(uploadId, buf)
+}
+
+// dropWriteBuffer discards the write buffer of a given upload without writing it.
+func (c *ChunkedUploaderService) dropWriteBuffer(uploadId string) {
+	if c.coalescer == nil {
+		return
+	}
+
+	buf, ok := c.coalescer.lookup(uploadId)
+	if !ok {
+		return
+	}
+
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	c.coalescer.remove(uploadId, buf)
+	buf.data = buf.data[:0]
+}
+
+// hasWriteBuffer reports whether a given upload has buffered data which is not written yet.
+func (c *ChunkedUploaderService) hasWriteBuffer(uploadId string) bool {
+	if c.coalescer == nil {
+		return false
+	}
+
+	c.coalescer.mu.Lock()
+	defer c.coalescer.mu.Unlock()
+
+	_, ok := c.coalescer.buffers[uploadId]
+	return ok
+}
+
+// bufferedRegion returns the region of a given upload which is buffered and not written yet.
+func (c *ChunkedUploaderService) bufferedRegion(uploadId string) (ByteRange, bool) {
+	if c.coalescer == nil {
+		return ByteRange{}, false
+	}
+
+	buf, ok := c.coalescer.lookup(uploadId)
+	if !ok {
+		return ByteRange{}, false
+	}
+
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	if len(buf.data) == 0 {
+		return ByteRange{}, false
+	}
+	return ByteRange{Start: buf.start, End: buf.end() - 1}, true
+}
+
+// flushStaleWriteBuffers flushes and forgets the buffers which were not written to for the flush interval.
+func (c *ChunkedUploaderService) flushStaleWriteBuffers() error {
+	c.coalescer.mu.Lock()
+	stale := make(map[string]*writeBuffer)
+	for uploadId, buf := range c.coalescer.buffers {
+		stale[uploadId] = buf
+	}
+	c.coalescer.mu.Unlock()
+
+	var firstErr error
+	for uploadId, buf := range stale {
+		buf.mu.Lock()
+		if !buf.removed && time.Since(buf.updatedAt) >= c.coalescer.flushInterval {
+			c.coalescer.remove(uploadId, buf)
+			err := c.flushBuffer(uploadId, buf)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		buf.mu.Unlock()
+	}
+
+	return firstErr
+}
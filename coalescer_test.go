@@ -0,0 +1,241 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// writeCountingFs counts the writes made to the files it opens, the metadata included.
+type writeCountingFs struct {
+	afero.Fs
+	writes atomic.Int64
+}
+
+func (fs *writeCountingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	file, err := fs.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &writeCountingFile{File: file, writes: &fs.writes}, nil
+}
+
+type writeCountingFile struct {
+	afero.File
+	writes *atomic.Int64
+}
+
+func (f *writeCountingFile) Write(p []byte) (int, error) {
+	f.writes.Add(1)
+	return f.File.Write(p)
+}
+
+// uploadInChunks uploads data sequentially in chunks of a given size.
+func uploadInChunks(tb testing.TB, service *ChunkedUploaderService, uploadId string, data []byte, chunkSize int) {
+	tb.Helper()
+
+	for offset := 0; offset < len(data); offset += chunkSize {
+		end := min(offset+chunkSize, len(data))
+		_, err := service.UploadChunk(uploadId, bytes.NewReader(data[offset:end]), int64(offset))
+		if err != nil {
+			tb.Fatalf("chunk at %d: %v", offset, err)
+		}
+	}
+}
+
+func TestWriteCoalescingMergesChunks(t *testing.T) {
+	fs := &writeCountingFs{Fs: afero.NewMemMapFs()}
+	service := newTestService(fs, WithWriteCoalescing(64<<10, time.Hour))
+
+	data := randomBytes(t, 100<<10)
+	uploadId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadInChunks(t, service, uploadId, data, 4<<10)
+
+	// one full buffer was flushed, the rest waits for the finish
+	if writes := fs.writes.Load(); writes > 5 {
+		t.Errorf("%d writes for 25 chunks", writes)
+	}
+
+	_, err = service.FinishUpload(context.Background(), uploadId, sha256Hex(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.hasWriteBuffer(uploadId) {
+		t.Error("buffer kept after finish")
+	}
+}
+
+func TestWriteCoalescingUnknownUpload(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs(), WithWriteCoalescing(64<<10, time.Hour))
+
+	_, err := service.UploadChunk("missing", bytes.NewReader([]byte("chunk")), 0)
+	if !errors.Is(err, UploadNotFoundError) {
+		t.Errorf("chunk of unknown upload returned %v, want UploadNotFoundError", err)
+	}
+	if service.hasWriteBuffer("missing") {
+		t.Error("buffer created for unknown upload")
+	}
+}
+
+func TestFlushStaleWriteBuffersForgetsBuffers(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs(), WithWriteCoalescing(64<<10, time.Millisecond))
+
+	data := randomBytes(t, 10<<10)
+	uploadId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadInChunks(t, service, uploadId, data, 1<<10)
+
+	time.Sleep(2 * time.Millisecond)
+	if err := service.flushStaleWriteBuffers(); err != nil {
+		t.Fatal(err)
+	}
+	if service.hasWriteBuffer(uploadId) {
+		t.Error("stale buffer kept after flush")
+	}
+
+	written, err := afero.ReadFile(service.fs, service.getUploadFilePath(uploadId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, data) {
+		t.Error("flushed file does not match the chunks")
+	}
+}
+
+func TestWriteCoalescingMaxFileSize(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs(), WithWriteCoalescing(64<<10, time.Hour), WithMaxFileSize(4<<10))
+
+	uploadId, err := service.CreateUpload(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadInChunks(t, service, uploadId, randomBytes(t, 4<<10), 1<<10)
+
+	_, err = service.UploadChunk(uploadId, bytes.NewReader([]byte("more")), 4<<10)
+	if !errors.Is(err, FileSizeExceedsMaximumError) {
+		t.Errorf("chunk past the maximum size returned %v, want FileSizeExceedsMaximumError", err)
+	}
+}
+
+func TestWriteCoalescingConcurrentFlush(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs(), WithWriteCoalescing(8<<10, time.Hour))
+
+	const chunkSize = 1 << 10
+	data := randomBytes(t, 256*chunkSize)
+	uploadId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				if err := service.flushWriteBuffer(uploadId); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+	}()
+
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for offset := worker * chunkSize; offset < len(data); offset += 4 * chunkSize {
+				_, err := service.UploadChunk(uploadId, bytes.NewReader(data[offset:offset+chunkSize]), int64(offset))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(worker)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	if err := service.flushWriteBuffer(uploadId); err != nil {
+		t.Fatal(err)
+	}
+	written, err := afero.ReadFile(service.fs, service.getUploadFilePath(uploadId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, data) {
+		t.Error("chunks buffered while flushing were lost")
+	}
+}
+
+func TestWithWriteCoalescingValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		bufferSize    int
+		flushInterval time.Duration
+	}{
+		{"zero buffer", 0, time.Second},
+		{"negative buffer", -1, time.Second},
+		{"zero interval", 1024, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("invalid option did not panic")
+				}
+			}()
+			WithWriteCoalescing(tc.bufferSize, tc.flushInterval)
+		})
+	}
+}
+
+// BenchmarkWriteCoalescing uploads 4 MiB in 16 KiB chunks and reports the file writes per upload, metadata included.
+func BenchmarkWriteCoalescing(b *testing.B) {
+	data := randomBytes(b, 4<<20)
+
+	for _, bc := range []struct {
+		name string
+		opts []ChunkedUploaderServiceOption
+	}{
+		{"direct", nil},
+		{"coalesced", []ChunkedUploaderServiceOption{WithWriteCoalescing(4<<20, time.Hour)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			fs := &writeCountingFs{Fs: afero.NewBasePathFs(afero.NewOsFs(), b.TempDir())}
+			service := newTestService(fs, bc.opts...)
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				uploadId, err := service.CreateUpload(int64(len(data)))
+				if err != nil {
+					b.Fatal(err)
+				}
+				uploadInChunks(b, service, uploadId, data, 16<<10)
+				if err := service.flushWriteBuffer(uploadId); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(fs.writes.Load())/float64(b.N), "writes/op")
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package chunkeduploader
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ResponseEncoder compresses responses with a content coding, see WithResponseCompression.
+type ResponseEncoder struct {
+	// Encoding is the name of the content coding in Accept-Encoding and Content-Encoding, like "gzip".
+	Encoding string
+	// NewWriter returns a writer compressing into w, it is closed at the end of the response. If it has a Flush
+	// method it is flushed whenever the handler flushes the response.
+	NewWriter func(w io.Writer) io.WriteCloser
+}
+
+// GzipEncoder compresses responses with gzip.
+var GzipEncoder = ResponseEncoder{
+	Encoding: "gzip",
+	NewWriter: func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	},
+}
+
+// WithResponseCompression compresses the JSON responses of the handler with the first of the given encoders, in
+// order of preference, the client accepts with the highest quality. It defaults to GzipEncoder, Brotli is provided
+// by the pkg/compression/brotliadapter module. The routes receiving or serving file data are never compressed.
+func WithResponseCompression(encoders ...ResponseEncoder) ChunkedUploaderHandlerOption {
+	if len(encoders) == 0 {
+		encoders = []ResponseEncoder{GzipEncoder}
+	}
+
+	return func(c *ChunkedUploaderHandler) {
+		c.encoders = encoders
+	}
+}
+
+// negotiateEncoding picks the encoder for an Accept-Encoding header, nil means the response is sent as it is.
+func negotiateEncoding(encoders []ResponseEncoder, acceptEncoding string) *ResponseEncoder {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		qualities[coding] = quality
+	}
+
+	var best *ResponseEncoder
+	bestQuality := 0.0
+	for i := range encoders {
+		quality, ok := qualities[encoders[i].Encoding]
+		if !ok {
+			quality = qualities["*"]
+		}
+		// ties go to the encoder preferred by the server
+		if quality > bestQuality {
+			best, bestQuality = &encoders[i], quality
+		}
+	}
+	return best
+}
+
+// compressionMiddleware compresses the responses of a handler with the encoding negotiated for the request.
+func (c *ChunkedUploaderHandler) compressionMiddleware(next http.Handler) http.Handler {
+	if len(c.encoders) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoder := negotiateEncoding(c.encoders, r.Header.Get("Accept-Encoding"))
+		if encoder == nil || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		writer := &compressingWriter{ResponseWriter: w, encoder: encoder}
+		defer writer.close()
+		next.ServeHTTP(writer, r)
+	})
+}
+
+// compressingWriter compresses a response once its status is known to have a body. Unwrap gives
+// http.ResponseController access to the deadlines of the underlying writer.
+type compressingWriter struct {
+	http.ResponseWriter
+	encoder     *ResponseEncoder
+	writer      io.WriteCloser
+	wroteHeader bool
+}
+
+func (cw *compressingWriter) WriteHeader(status int) {
+	if status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	header := cw.Header()
+	if status != http.StatusNoContent && status != http.StatusNotModified && header.Get("Content-Encoding") == "" {
+		header.Set("Content-Encoding", cw.encoder.Encoding)
+		header.Del("Content-Length")
+		cw.writer = cw.encoder.NewWriter(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressingWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.writer == nil {
+		return cw.ResponseWriter.Write(p)
+	}
+	return cw.writer.Write(p)
+}
+
+func (cw *compressingWriter) Flush() {
+	if flusher, ok := cw.writer.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressingWriter) close() {
+	if cw.writer != nil {
+		cw.writer.Close()
+	}
+}
@@ -0,0 +1,149 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// deflateEncoder stands in for a second encoder preferred by the server, like Brotli.
+var deflateEncoder = ResponseEncoder{
+	Encoding: "deflate",
+	NewWriter: func(w io.Writer) io.WriteCloser {
+		writer, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return writer
+	},
+}
+
+// decodeResponse returns the body of a response decompressed by its Content-Encoding.
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder) []byte {
+	t.Helper()
+
+	var reader io.Reader = rec.Body
+	switch encoding := rec.Header().Get("Content-Encoding"); encoding {
+	case "":
+	case "gzip":
+		gz, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = gz
+	case "deflate":
+		reader = flate.NewReader(rec.Body)
+	default:
+		t.Fatalf("unexpected Content-Encoding %q", encoding)
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestResponseCompression(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	plain := NewHTTPHandler(service)
+	compressed := NewHTTPHandler(service, WithResponseCompression(deflateEncoder, GzipEncoder))
+
+	uploadId, err := service.CreateUpload(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	plain.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+uploadId+"/status", nil))
+	want := rec.Body.Bytes()
+
+	for _, tc := range []struct {
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		// ties go to the order of the server
+		{"gzip, deflate", "deflate"},
+		{"gzip;q=1, deflate;q=0.5", "gzip"},
+		{"*", "deflate"},
+		{"deflate;q=0, *", "gzip"},
+		{"gzip;q=0", ""},
+		{"identity, gzip;q=0, deflate;q=0", ""},
+		{"br", ""},
+		{"GZIP", "gzip"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/"+uploadId+"/status", nil)
+		if tc.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		compressed.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Accept-Encoding %q: %d %s", tc.acceptEncoding, rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("Content-Encoding"); got != tc.wantEncoding {
+			t.Errorf("Accept-Encoding %q: Content-Encoding %q, want %q", tc.acceptEncoding, got, tc.wantEncoding)
+			continue
+		}
+		if vary := rec.Header().Values("Vary"); len(vary) == 0 || vary[len(vary)-1] != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: Vary %v misses Accept-Encoding", tc.acceptEncoding, vary)
+		}
+		if got := decodeResponse(t, rec); !bytes.Equal(got, want) {
+			t.Errorf("Accept-Encoding %q: body %s, want %s", tc.acceptEncoding, got, want)
+		}
+	}
+}
+
+func TestResponseCompressionExclusions(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service, WithResponseCompression(), WithAdminAuthorizer(func(r *http.Request) bool { return true }))
+
+	uploadId, data := newExportTestUpload(t, service, 1000)
+
+	for _, tc := range []struct {
+		name         string
+		method       string
+		path         string
+		wantStatus   int
+		wantEncoding string
+	}{
+		{"default encoder", http.MethodGet, "/" + uploadId + "/status", http.StatusOK, "gzip"},
+		{"listing", http.MethodGet, "/uploads", http.StatusOK, "gzip"},
+		{"file data", http.MethodGet, "/" + uploadId + "/data", http.StatusPartialContent, ""},
+		{"export", http.MethodGet, "/" + uploadId + "/export", http.StatusPartialContent, ""},
+		{"head", http.MethodHead, "/" + uploadId + "/export", http.StatusOK, ""},
+		{"error", http.MethodGet, "/doesnotexist/status", http.StatusNotFound, "gzip"},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("Range", "bytes=0-99")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.wantStatus {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, rec.Code, tc.wantStatus, rec.Body)
+			continue
+		}
+		if got := rec.Header().Get("Content-Encoding"); got != tc.wantEncoding {
+			t.Errorf("%s: Content-Encoding %q, want %q", tc.name, got, tc.wantEncoding)
+		}
+		if tc.name == "export" && !bytes.Equal(rec.Body.Bytes(), data[:100]) {
+			t.Errorf("%s: body differs from the upload", tc.name)
+		}
+	}
+
+	// responses without a body are left alone
+	req := httptest.NewRequest(http.MethodPost, "/"+uploadId+"/tag", strings.NewReader(`{"key": "env", "value": "prod"}`))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() != 0 {
+		t.Errorf("no content: %d with Content-Encoding %q and %d bytes", rec.Code, rec.Header().Get("Content-Encoding"), rec.Body.Len())
+	}
+}
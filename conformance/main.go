@@ -0,0 +1,169 @@
+// Command conformance runs black-box scenarios against a live deployment of the uploader and reports which of them
+// pass, to catch proxies and load balancers breaking the upload protocol before real uploads do.
+//
+//	go run ./conformance -endpoint https://uploads.example.com -header "Authorization: Bearer ..." -json report.json
+//
+// Every upload it creates is removed again: finished uploads are finished with stream=true&delete=true and the others
+// are canceled, so it is safe to run against production.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	client "github.com/Craftserve/chunked-uploader/pkg/client"
+)
+
+// Result is the outcome of a single scenario.
+type Result struct {
+	Name       string `json:"name"`
+	Passed     bool   `json:"passed"`
+	Skipped    bool   `json:"skipped,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Report is the machine-readable result of a run, Leaked are the uploads which could not be removed.
+type Report struct {
+	Endpoint  string    `json:"endpoint"`
+	StartedAt time.Time `json:"started_at"`
+	Passed    bool      `json:"passed"`
+	Results   []Result  `json:"results"`
+	Leaked    []string  `json:"leaked"`
+}
+
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("header must be in the form Name: value")
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+func main() {
+	var headers headerFlags
+	endpoint := flag.String("endpoint", "", "base url of the uploader")
+	flag.Var(&headers, "header", "header sent with every request, like \"Authorization: Bearer token\", may be repeated")
+	chunkSize := flag.Int64("chunk-size", 64<<10, "size of the chunks sent by the scenarios")
+	ttl := flag.Duration("ttl", 0, "upload TTL the deployment is configured with, the expiry scenario is skipped without it")
+	timeout := flag.Duration("timeout", time.Minute, "timeout of a single scenario")
+	run := flag.String("run", "", "regular expression selecting the scenarios to run")
+	jsonPath := flag.String("json", "", "file the JSON report is written to, - for stdout")
+	flag.Parse()
+
+	if *endpoint == "" {
+		fmt.Fprintln(os.Stderr, "-endpoint is required")
+		os.Exit(2)
+	}
+	filter, err := regexp.Compile(*run)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -run:", err)
+		os.Exit(2)
+	}
+	urls, err := client.NewURLTemplates(strings.TrimSuffix(*endpoint, "/")+"/", "init", "{upload_id}/upload", "{upload_id}/finish")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -endpoint:", err)
+		os.Exit(2)
+	}
+
+	h := &harness{
+		endpoint:  strings.TrimSuffix(*endpoint, "/"),
+		urls:      urls,
+		headers:   parseHeaders(headers),
+		http:      &http.Client{},
+		chunkSize: *chunkSize,
+		ttl:       *ttl,
+		created:   map[string]bool{},
+	}
+
+	report := Report{Endpoint: *endpoint, StartedAt: time.Now(), Passed: true, Results: []Result{}, Leaked: []string{}}
+	for _, s := range scenarios {
+		if !filter.MatchString(s.name) {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		started := time.Now()
+		err := s.run(ctx, h)
+		cancel()
+
+		result := Result{Name: s.name, Passed: err == nil, DurationMs: time.Since(started).Milliseconds()}
+		if skip, ok := err.(skipError); ok {
+			result.Passed, result.Skipped, result.Error = true, true, string(skip)
+		} else if err != nil {
+			result.Error = err.Error()
+			report.Passed = false
+		}
+		report.Results = append(report.Results, result)
+		printResult(result)
+	}
+
+	report.Leaked = h.cleanup()
+	if len(report.Leaked) > 0 {
+		fmt.Fprintf(os.Stderr, "could not remove uploads: %s\n", strings.Join(report.Leaked, ", "))
+	}
+
+	if *jsonPath != "" {
+		err := writeReport(*jsonPath, &report)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to write report:", err)
+			os.Exit(2)
+		}
+	}
+	if !report.Passed {
+		os.Exit(1)
+	}
+}
+
+func parseHeaders(values []string) http.Header {
+	header := http.Header{}
+	for _, value := range values {
+		name, v, _ := strings.Cut(value, ":")
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(v))
+	}
+	return header
+}
+
+func printResult(result Result) {
+	status := "PASS"
+	switch {
+	case result.Skipped:
+		status = "SKIP"
+	case !result.Passed:
+		status = "FAIL"
+	}
+	line := fmt.Sprintf("%s %s (%dms)", status, result.Name, result.DurationMs)
+	if result.Error != "" {
+		line += ": " + result.Error
+	}
+	fmt.Fprintln(os.Stderr, line)
+}
+
+func writeReport(path string, report *Report) error {
+	out := os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
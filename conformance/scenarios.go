@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	client "github.com/Craftserve/chunked-uploader/pkg/client"
+)
+
+// skipError marks a scenario which could not run against the deployment, like expiry without -ttl.
+type skipError string
+
+func (s skipError) Error() string {
+	return string(s)
+}
+
+type scenario struct {
+	name string
+	run  func(ctx context.Context, h *harness) error
+}
+
+var scenarios = []scenario{
+	{"capabilities", capabilitiesScenario},
+	{"basic_upload", basicUploadScenario},
+	{"out_of_order_chunks", outOfOrderScenario},
+	{"resume_after_disconnect", resumeScenario},
+	{"oversized_chunk", oversizedChunkScenario},
+	{"checksum_mismatch", checksumMismatchScenario},
+	{"expiry", expiryScenario},
+}
+
+// harness sends the requests of the scenarios with the credentials of the run and remembers the uploads they create.
+type harness struct {
+	endpoint  string
+	urls      *client.URLTemplates
+	headers   http.Header
+	http      *http.Client
+	chunkSize int64
+	ttl       time.Duration
+
+	mu      sync.Mutex
+	created map[string]bool
+}
+
+// do sends a request with the configured headers.
+func (h *harness) do(req *http.Request) (*http.Response, error) {
+	for name, values := range h.headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	return h.http.Do(req)
+}
+
+func (h *harness) request(ctx context.Context, method string, url string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return h.do(req)
+}
+
+// newClient returns a client of the public client package sending its requests through the harness.
+func (h *harness) newClient() *client.Client {
+	return &client.Client{
+		Endpoint:  h.endpoint,
+		URLs:      h.urls,
+		ChunkSize: h.chunkSize,
+		DoRequest: h.do,
+	}
+}
+
+func (h *harness) track(uploadId string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.created[uploadId] = true
+}
+
+func (h *harness) forget(uploadId string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.created, uploadId)
+}
+
+// initUpload creates an upload of a given size and returns its id with the headers of the response.
+func (h *harness) initUpload(ctx context.Context, size int64) (string, http.Header, error) {
+	body, _ := json.Marshal(map[string]interface{}{"file_size": size})
+	initUrl, err := h.urls.InitURL()
+	if err != nil {
+		return "", nil, err
+	}
+
+	resp, err := h.request(ctx, http.MethodPost, initUrl, bytes.NewReader(body), "application/json")
+	if err != nil {
+		return "", nil, fmt.Errorf("init failed %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", nil, unexpectedStatus("init", resp, http.StatusCreated)
+	}
+
+	var init client.InitResponse
+	err = json.NewDecoder(resp.Body).Decode(&init)
+	if err != nil {
+		return "", nil, fmt.Errorf("init returned invalid JSON %w", err)
+	}
+	if init.UploadID == "" {
+		return "", nil, fmt.Errorf("init returned no upload_id")
+	}
+	h.track(init.UploadID)
+
+	return init.UploadID, resp.Header, nil
+}
+
+// sendChunk sends data as the chunk starting at offset and returns the response with its body read.
+func (h *harness) sendChunk(ctx context.Context, uploadId string, offset int64, data []byte) (*http.Response, []byte, error) {
+	chunkUrl, err := h.urls.ChunkURL(uploadId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, chunkUrl, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(len(data))-1))
+
+	resp, err := h.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+// sendChunks sends data in chunks of the configured size, in the order of offsets.
+func (h *harness) sendChunks(ctx context.Context, uploadId string, data []byte, offsets []int64) error {
+	for _, offset := range offsets {
+		end := min(offset+h.chunkSize, int64(len(data)))
+		resp, body, err := h.sendChunk(ctx, uploadId, offset, data[offset:end])
+		if err != nil {
+			return fmt.Errorf("chunk at %d failed %w", offset, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("chunk at %d: expected status 200, got %d: %s", offset, resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+
+		sum := sha256.Sum256(data[offset:end])
+		if checksum := resp.Header.Get("X-Checksum"); checksum != hex.EncodeToString(sum[:]) {
+			return fmt.Errorf("chunk at %d: X-Checksum %q does not match the chunk", offset, checksum)
+		}
+	}
+	return nil
+}
+
+// finishAndVerify finishes an upload streaming its file back and removing it, and compares the file with data.
+func (h *harness) finishAndVerify(ctx context.Context, uploadId string, data []byte) error {
+	resp, err := h.finish(ctx, uploadId, checksumOf(data), true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return unexpectedStatus("finish", resp, http.StatusOK)
+	}
+
+	received, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read finished file %w", err)
+	}
+	if !bytes.Equal(received, data) {
+		return fmt.Errorf("finished file differs from the uploaded data: got %d bytes, expected %d", len(received), len(data))
+	}
+
+	h.forget(uploadId)
+	return nil
+}
+
+// finish finishes an upload with a given checksum, with stream the file is sent back and the upload removed.
+func (h *harness) finish(ctx context.Context, uploadId string, checksum string, stream bool) (*http.Response, error) {
+	finishUrl, err := h.urls.FinishURL(uploadId)
+	if err != nil {
+		return nil, err
+	}
+	if stream {
+		separator := "?"
+		if strings.Contains(finishUrl, "?") {
+			separator = "&"
+		}
+		finishUrl += separator + "stream=true&delete=true"
+	}
+
+	body, _ := json.Marshal(map[string]string{"checksum": checksum})
+	resp, err := h.request(ctx, http.MethodPost, finishUrl, bytes.NewReader(body), "application/json")
+	if err != nil {
+		return nil, fmt.Errorf("finish failed %w", err)
+	}
+	return resp, nil
+}
+
+// cleanup cancels the uploads the scenarios left behind and returns those which could not be removed.
+func (h *harness) cleanup() []string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	h.mu.Lock()
+	ids := make([]string, 0, len(h.created))
+	for id := range h.created {
+		ids = append(ids, id)
+	}
+	h.mu.Unlock()
+	sort.Strings(ids)
+
+	leaked := []string{}
+	for _, id := range ids {
+		resp, err := h.request(ctx, http.MethodDelete, fmt.Sprintf("%s/%s", h.endpoint, id), nil, "")
+		if err != nil {
+			leaked = append(leaked, id)
+			continue
+		}
+		resp.Body.Close()
+		// complete uploads cannot be canceled, the scenarios remove them with delete=true when finishing
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+			leaked = append(leaked, id)
+			continue
+		}
+		h.forget(id)
+	}
+	return leaked
+}
+
+func capabilitiesScenario(ctx context.Context, h *harness) error {
+	capabilities, err := h.newClient().Capabilities(ctx)
+	if err != nil {
+		return err
+	}
+	if len(capabilities.Features) == 0 {
+		return fmt.Errorf("capabilities declare no features")
+	}
+
+	_, header, err := h.initUpload(ctx, 1)
+	if err != nil {
+		return err
+	}
+	advertised := header.Get("X-Uploader-Capabilities")
+	if advertised != strings.Join(capabilities.Features, ",") {
+		return fmt.Errorf("X-Uploader-Capabilities %q does not match the capabilities endpoint %q", advertised, strings.Join(capabilities.Features, ","))
+	}
+	return nil
+}
+
+func basicUploadScenario(ctx context.Context, h *harness) error {
+	data := randomData(3*h.chunkSize + h.chunkSize/2)
+	uploadId, _, err := h.initUpload(ctx, int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	err = h.sendChunks(ctx, uploadId, data, chunkOffsets(int64(len(data)), h.chunkSize))
+	if err != nil {
+		return err
+	}
+	err = h.finishAndVerify(ctx, uploadId, data)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.request(ctx, http.MethodGet, fmt.Sprintf("%s/%s/status", h.endpoint, uploadId), nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("upload finished with delete=true still exists: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func outOfOrderScenario(ctx context.Context, h *harness) error {
+	data := randomData(4 * h.chunkSize)
+	uploadId, _, err := h.initUpload(ctx, int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	offsets := chunkOffsets(int64(len(data)), h.chunkSize)
+	for i, j := 0, len(offsets)-1; i < j; i, j = i+1, j-1 {
+		offsets[i], offsets[j] = offsets[j], offsets[i]
+	}
+
+	err = h.sendChunks(ctx, uploadId, data, offsets)
+	if err != nil {
+		return err
+	}
+	return h.finishAndVerify(ctx, uploadId, data)
+}
+
+// disconnectingReader returns an error after limit bytes, which makes the transport drop the connection in the middle
+// of the body like a client losing its network.
+type disconnectingReader struct {
+	reader io.Reader
+	limit  int64
+}
+
+func (d *disconnectingReader) Read(p []byte) (int, error) {
+	if d.limit <= 0 {
+		return 0, errors.New("simulated disconnect")
+	}
+	if int64(len(p)) > d.limit {
+		p = p[:d.limit]
+	}
+	n, err := d.reader.Read(p)
+	d.limit -= int64(n)
+	return n, err
+}
+
+func resumeScenario(ctx context.Context, h *harness) error {
+	data := randomData(2 * h.chunkSize)
+	uploadId, _, err := h.initUpload(ctx, int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	chunkUrl, err := h.urls.ChunkURL(uploadId)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, chunkUrl, &disconnectingReader{reader: bytes.NewReader(data), limit: int64(len(data)) / 2})
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", len(data)-1))
+	resp, err := h.do(req)
+	if err == nil {
+		resp.Body.Close()
+		return fmt.Errorf("interrupted chunk was answered with status %d", resp.StatusCode)
+	}
+
+	// the server notices the disconnect asynchronously, until then the upload may still be locked by the chunk
+	var missing []client.ByteRange
+	for attempt := 0; ; attempt++ {
+		missing, err = h.newClient().GetMissingRanges(ctx, uploadId)
+		if err == nil || attempt == 10 {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get missing ranges %w", err)
+	}
+	if len(missing) == 0 {
+		return fmt.Errorf("no ranges are missing after an interrupted chunk")
+	}
+
+	for _, r := range missing {
+		for offset := r.Start; offset <= r.End; offset += h.chunkSize {
+			end := min(offset+h.chunkSize-1, r.End)
+			resp, body, err := h.sendChunk(ctx, uploadId, offset, data[offset:end+1])
+			if err != nil {
+				return fmt.Errorf("resumed chunk at %d failed %w", offset, err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("resumed chunk at %d: expected status 200, got %d: %s", offset, resp.StatusCode, strings.TrimSpace(string(body)))
+			}
+		}
+	}
+
+	return h.finishAndVerify(ctx, uploadId, data)
+}
+
+func oversizedChunkScenario(ctx context.Context, h *harness) error {
+	data := randomData(h.chunkSize)
+	uploadId, _, err := h.initUpload(ctx, int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	chunkUrl, err := h.urls.ChunkURL(uploadId)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, chunkUrl, bytes.NewReader(append(data, 0)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", len(data)-1))
+
+	resp, err := h.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		return unexpectedStatus("oversized chunk", resp, http.StatusBadRequest)
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if body.Code != "chunk_length_mismatch" {
+		return fmt.Errorf("oversized chunk: expected code chunk_length_mismatch, got %q", body.Code)
+	}
+
+	// nothing of the rejected chunk may have been kept
+	missing, err := h.newClient().GetMissingRanges(ctx, uploadId)
+	if err != nil {
+		return fmt.Errorf("failed to get missing ranges %w", err)
+	}
+	if len(missing) != 1 || missing[0].Start != 0 || missing[0].End != int64(len(data))-1 {
+		return fmt.Errorf("rejected chunk was partially kept, missing ranges: %v", missing)
+	}
+	return nil
+}
+
+func checksumMismatchScenario(ctx context.Context, h *harness) error {
+	data := randomData(h.chunkSize + 1)
+	uploadId, _, err := h.initUpload(ctx, int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	err = h.sendChunks(ctx, uploadId, data, chunkOffsets(int64(len(data)), h.chunkSize))
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.finish(ctx, uploadId, checksumOf(append([]byte{1}, data...)), false)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		return unexpectedStatus("finish with a wrong checksum", resp, http.StatusBadRequest)
+	}
+	return nil
+}
+
+func expiryScenario(ctx context.Context, h *harness) error {
+	if h.ttl <= 0 {
+		return skipError("-ttl is not set")
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < h.ttl+5*time.Second {
+		return skipError("-timeout is too short for -ttl")
+	}
+
+	data := randomData(2 * h.chunkSize)
+	uploadId, _, err := h.initUpload(ctx, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	err = h.sendChunks(ctx, uploadId, data, []int64{0})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-time.After(h.ttl + 2*time.Second):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	resp, body, err := h.sendChunk(ctx, uploadId, h.chunkSize, data[h.chunkSize:])
+	if err != nil {
+		return err
+	}
+	// the cleanup of the deployment may have removed the expired upload already
+	if resp.StatusCode != http.StatusGone && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("chunk of an expired upload: expected status 410, got %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func unexpectedStatus(operation string, resp *http.Response, expected int) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s: expected status %d, got %d: %s", operation, expected, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func randomData(size int64) []byte {
+	data := make([]byte, size)
+	rand.Read(data)
+	return data
+}
+
+func checksumOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func chunkOffsets(size int64, chunkSize int64) []int64 {
+	offsets := []int64{}
+	for offset := int64(0); offset < size; offset += chunkSize {
+		offsets = append(offsets, offset)
+	}
+	return offsets
+}
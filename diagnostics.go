@@ -0,0 +1,216 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var DiagnosticsNotFoundError = errors.New("upload has no diagnostics")
+
+const (
+	// maxUploadEvents is the number of suspicious events kept in the metadata of an upload, older ones are dropped.
+	maxUploadEvents = 32
+	// stallThreshold is the pause between two writes after which a stall event is recorded.
+	stallThreshold = time.Minute
+	// diagnosticsWindow is the number of bytes from the start and the end of the file included in diagnostics.
+	diagnosticsWindow = 32
+)
+
+const (
+	// UploadEventOverlap is recorded when a chunk rewrites bytes which were already written, usually a retry.
+	UploadEventOverlap = "overlap"
+	// UploadEventStall is recorded when a chunk arrives long after the previous one.
+	UploadEventStall = "stall"
+)
+
+// UploadEvent is a suspicious event in the life of an upload, kept for diagnostics.
+type UploadEvent struct {
+	Kind  string    `json:"kind"`
+	At    time.Time `json:"at"`
+	Start int64     `json:"start"`
+	End   int64     `json:"end"`
+}
+
+// ChunkManifestSummary summarizes the per-chunk hashes known for an upload.
+type ChunkManifestSummary struct {
+	Sequence          int64  `json:"sequence,omitempty"`
+	LastChunkChecksum string `json:"last_chunk_checksum,omitempty"`
+	LeavesRecorded    int    `json:"leaves_recorded"`
+	LeavesTotal       int    `json:"leaves_total"`
+}
+
+// Diagnostics describes the state of an upload when its finish failed. Apart from the hex windows at the start and
+// the end of the file it contains no file contents.
+type Diagnostics struct {
+	CreatedAt        time.Time            `json:"created_at"`
+	Error            string               `json:"error"`
+	Algorithm        string               `json:"algorithm"`
+	DeclaredSize     int64                `json:"declared_size"`
+	ActualSize       int64                `json:"actual_size"`
+	Regions          []ByteRange          `json:"regions"`
+	OverwrittenBytes int64                `json:"overwritten_bytes"`
+	Manifest         ChunkManifestSummary `json:"manifest"`
+	Head             string               `json:"head"`
+	Tail             string               `json:"tail"`
+	Events           []UploadEvent        `json:"events"`
+	ServerVersion    string               `json:"server_version"`
+}
+
+// recordWrite tracks the overwritten bytes, overlaps and stalls of a region about to be added to the metadata.
+func (meta *UploadMetadata) recordWrite(region ByteRange, now time.Time) {
+	overwritten := regionsLength(meta.Regions) + region.Length() - regionsLength(addRegion(meta.Regions, region))
+	if overwritten > 0 {
+		meta.OverwrittenBytes += overwritten
+		meta.addEvent(UploadEvent{Kind: UploadEventOverlap, At: now, Start: region.Start, End: region.End})
+	}
+	if meta.LastWriteAt != nil && now.Sub(*meta.LastWriteAt) > stallThreshold {
+		meta.addEvent(UploadEvent{Kind: UploadEventStall, At: now, Start: region.Start, End: region.End})
+	}
+	meta.LastWriteAt = &now
+}
+
+func (meta *UploadMetadata) addEvent(event UploadEvent) {
+	meta.Events = append(meta.Events, event)
+	if len(meta.Events) > maxUploadEvents {
+		meta.Events = meta.Events[len(meta.Events)-maxUploadEvents:]
+	}
+}
+
+// recordDiagnostics stores the diagnostics of a failed finish in the metadata of a given upload.
+func (c *ChunkedUploaderService) recordDiagnostics(uploadId string, algorithm string, finishErr error) error {
+	file, err := c.fs.Open(c.getUploadFilePath(uploadId))
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.recordDiagnostics failed to open file %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.recordDiagnostics failed to stat file %w", err)
+	}
+
+	head, err := readWindow(file, 0, info.Size())
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.recordDiagnostics failed to read head %w", err)
+	}
+	tail, err := readWindow(file, info.Size()-diagnosticsWindow, info.Size())
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.recordDiagnostics failed to read tail %w", err)
+	}
+
+	err = c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		recorded := 0
+		for _, leaf := range meta.TreeLeaves {
+			if leaf != "" {
+				recorded++
+			}
+		}
+
+		meta.Diagnostics = &Diagnostics{
+			CreatedAt:        time.Now(),
+			Error:            finishErr.Error(),
+			Algorithm:        algorithm,
+			DeclaredSize:     meta.FileSize,
+			ActualSize:       info.Size(),
+			Regions:          meta.Regions,
+			OverwrittenBytes: meta.OverwrittenBytes,
+			Manifest: ChunkManifestSummary{
+				Sequence:          meta.Sequence,
+				LastChunkChecksum: meta.LastChunkChecksum,
+				LeavesRecorded:    recorded,
+				LeavesTotal:       len(meta.TreeLeaves),
+			},
+			Head:          head,
+			Tail:          tail,
+			Events:        meta.Events,
+			ServerVersion: ReadBuildInfo().Version,
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.recordDiagnostics failed to update metadata %w", err)
+	}
+
+	return nil
+}
+
+// readWindow returns up to diagnosticsWindow bytes from a given offset of a file as hex.
+func readWindow(file io.ReaderAt, offset int64, size int64) (string, error) {
+	if offset < 0 {
+		offset = 0
+	}
+	length := size - offset
+	if length > diagnosticsWindow {
+		length = diagnosticsWindow
+	}
+	if length <= 0 {
+		return "", nil
+	}
+
+	buf := make([]byte, length)
+	n, err := file.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf[:n]), nil
+}
+
+// GetDiagnostics returns the diagnostics recorded by the last failed finish of a given upload.
+func (c *ChunkedUploaderService) GetDiagnostics(ctx context.Context, uploadId string) (*Diagnostics, error) {
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetDiagnostics failed to read metadata %w", err)
+	}
+	if meta.Diagnostics == nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetDiagnostics %w", DiagnosticsNotFoundError)
+	}
+
+	return meta.Diagnostics, nil
+}
+
+// DiagnosticsHandler returns the diagnostics of the last failed finish of a given uploadId, it requires admin access.
+func (c *ChunkedUploaderHandler) DiagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.requireAdmin(w, r) {
+		return
+	}
+
+	uploadId := mux.Vars(r)["upload_id"]
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	diagnostics, err := c.service.GetDiagnostics(r.Context(), uploadId)
+	if err != nil {
+		if errors.Is(err, UploadNotFoundError) || errors.Is(err, DiagnosticsNotFoundError) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get diagnostics: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(diagnostics)
+}
+
+// writeFinishDiagnostics writes a failed finish together with the diagnostics it recorded.
+func (c *ChunkedUploaderHandler) writeFinishDiagnostics(w http.ResponseWriter, r *http.Request, uploadId string, finishErr error) {
+	// the diagnostics are left out if they could not be recorded
+	diagnostics, _ := c.service.GetDiagnostics(r.Context(), uploadId)
+
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":       "Failed to verify upload: " + finishErr.Error(),
+		"diagnostics": diagnostics,
+	})
+}
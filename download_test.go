@@ -0,0 +1,179 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Craftserve/chunked-uploader/pkg/client"
+	"github.com/spf13/afero"
+)
+
+// uploadWithClient uploads data with the client and returns the upload id and the path of the finished upload.
+func uploadWithClient(t *testing.T, server *httptest.Server, data []byte) (string, string) {
+	t.Helper()
+
+	localPath := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	c := client.Client{Endpoint: server.URL, ChunkSize: 1000, DoRequest: http.DefaultClient.Do}
+	path, err := c.Upload(context.Background(), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *c.UploadId, path
+}
+
+func TestDownloadRoundTrip(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	server := httptest.NewServer(NewHTTPHandler(service))
+	defer server.Close()
+
+	data := randomBytes(t, 10_000)
+	uploadId, path := uploadWithClient(t, server, data)
+
+	for _, tc := range []struct {
+		name     string
+		argument string
+		opts     client.DownloadOptions
+	}{
+		{name: "by id", argument: uploadId},
+		{name: "by path", argument: path},
+		{name: "by url", argument: server.URL + "/" + uploadId + "/export"},
+		{name: "sequential", argument: uploadId, opts: client.DownloadOptions{Concurrency: 1}},
+		{name: "uneven chunks", argument: uploadId, opts: client.DownloadOptions{Concurrency: 3, ChunkSize: 333}},
+		{name: "single chunk", argument: uploadId, opts: client.DownloadOptions{ChunkSize: 1 << 20}},
+		{name: "expected checksum", argument: uploadId, opts: client.DownloadOptions{ExpectedChecksum: sha256Hex(data)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := os.Create(filepath.Join(t.TempDir(), "download"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer out.Close()
+
+			var mu sync.Mutex
+			var reported []int64
+			c := client.Client{
+				Endpoint:  server.URL,
+				ChunkSize: 1000,
+				DoRequest: http.DefaultClient.Do,
+				Progress: func(transferred int64, total int64) {
+					mu.Lock()
+					defer mu.Unlock()
+					if total != int64(len(data)) {
+						t.Errorf("progress total %d, want %d", total, len(data))
+					}
+					reported = append(reported, transferred)
+				},
+			}
+			if err := c.Download(context.Background(), tc.argument, out, tc.opts); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := os.ReadFile(out.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("downloaded file differs from the upload")
+			}
+			if len(reported) == 0 || reported[len(reported)-1] != int64(len(data)) {
+				t.Errorf("progress %v does not end at %d", reported, len(data))
+			}
+		})
+	}
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	server := httptest.NewServer(NewHTTPHandler(service))
+	defer server.Close()
+
+	uploadId, _ := uploadWithClient(t, server, randomBytes(t, 3000))
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	c := client.Client{Endpoint: server.URL, DoRequest: http.DefaultClient.Do}
+	err = c.Download(context.Background(), uploadId, out, client.DownloadOptions{ExpectedChecksum: sha256Hex(nil)})
+	var mismatch *client.DownloadChecksumMismatch
+	if !errors.As(err, &mismatch) || mismatch.Expected != sha256Hex(nil) {
+		t.Errorf("got %v, want a checksum mismatch", err)
+	}
+}
+
+func TestDownloadResumes(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	server := httptest.NewServer(NewHTTPHandler(service))
+	defer server.Close()
+
+	data := randomBytes(t, 10_000)
+	uploadId, _ := uploadWithClient(t, server, data)
+
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "state")
+	out, err := os.Create(filepath.Join(dir, "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	// the connection breaks after four ranges
+	var ranges atomic.Int64
+	failAfter := int64(4)
+	c := client.Client{
+		Endpoint:  server.URL,
+		ChunkSize: 1000,
+		DoRequest: func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("Range") != "" && ranges.Add(1) > failAfter {
+				return nil, errors.New("connection reset")
+			}
+			return http.DefaultClient.Do(req)
+		},
+	}
+	opts := client.DownloadOptions{Concurrency: 1, StateFile: stateFile}
+	if err := c.Download(context.Background(), uploadId, out, opts); err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Fatalf("got %v, want the broken connection", err)
+	}
+	if _, err := os.Stat(stateFile); err != nil {
+		t.Fatalf("state of the interrupted download: %v", err)
+	}
+
+	ranges.Store(0)
+	failAfter = 1 << 20
+	if err := c.Download(context.Background(), uploadId, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := ranges.Load(); got != 6 {
+		t.Errorf("resumed download requested %d ranges, want the 6 missing ones", got)
+	}
+	got, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("downloaded file differs from the upload")
+	}
+	if _, err := os.Stat(stateFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("state file of a complete download is kept: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package chunkeduploader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// UploadDurationExceededError is returned when writing to an upload created longer than the maximum upload duration
+// ago, see WithMaxUploadDuration.
+type UploadDurationExceededError struct {
+	Deadline time.Time
+}
+
+func (e *UploadDurationExceededError) Error() string {
+	return fmt.Sprintf("upload exceeded its maximum duration at %s", e.Deadline.Format(time.RFC3339))
+}
+
+// WithMaxUploadDuration bounds how long an upload may take from its creation, regardless of how active it is. Unlike
+// the upload ttl the limit cannot be extended. Chunks of uploads past it are rejected with 410 and the upload moves to
+// UploadStateExpired, cleanup removes such uploads on its next run even if they were modified recently. The remaining
+// duration is returned when creating an upload and in its status.
+func WithMaxUploadDuration(d time.Duration) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.maxUploadDuration = d
+	}
+}
+
+// uploadDeadline returns when an upload exceeds the maximum upload duration, false when there is no limit.
+func (c *ChunkedUploaderService) uploadDeadline(meta *UploadMetadata) (time.Time, bool) {
+	if c.maxUploadDuration <= 0 || meta.CreatedAt.IsZero() {
+		return time.Time{}, false
+	}
+	return meta.CreatedAt.Add(c.maxUploadDuration), true
+}
+
+// remainingDuration returns the time left before an upload exceeds the maximum upload duration, it is nil when there
+// is no limit and zero once the limit has passed.
+func (c *ChunkedUploaderService) remainingDuration(meta *UploadMetadata) *time.Duration {
+	deadline, ok := c.uploadDeadline(meta)
+	if !ok {
+		return nil
+	}
+	remaining := max(time.Until(deadline), 0)
+	return &remaining
+}
+
+// exceededDuration returns UploadDurationExceededError if an upload is past the maximum upload duration or was
+// already expired.
+func (c *ChunkedUploaderService) exceededDuration(meta *UploadMetadata) error {
+	deadline, ok := c.uploadDeadline(meta)
+	if meta.State == UploadStateExpired || (ok && time.Now().After(deadline)) {
+		return &UploadDurationExceededError{Deadline: deadline}
+	}
+	return nil
+}
+
+// expireUpload moves an upload which exceeded the maximum upload duration to UploadStateExpired, the caller must not
+// hold the lock of the upload.
+func (c *ChunkedUploaderService) expireUpload(meta *UploadMetadata) {
+	if meta.State == UploadStateExpired {
+		return
+	}
+
+	err := c.setState(meta.UploadId, UploadStateExpired, "")
+	if err != nil {
+		c.log(LogLevelError, "Failed to expire upload", LogField{"upload_id", meta.UploadId}, LogField{"error", err})
+		return
+	}
+	c.log(LogLevelInfo, "Upload exceeded its maximum duration", LogField{"upload_id", meta.UploadId})
+}
+
+// writeDurationExceededError responds to a chunk of an upload past the maximum upload duration with 410.
+func writeDurationExceededError(w http.ResponseWriter, err *UploadDurationExceededError) {
+	w.WriteHeader(http.StatusGone)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":    err.Error(),
+		"code":     "deadline_exceeded",
+		"deadline": err.Deadline.UTC().Format(time.RFC3339),
+	})
+}
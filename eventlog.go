@@ -0,0 +1,132 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// eventLogFile is the file in the snapshot directory of an upload holding its events, one JSON object per line.
+const eventLogFile = "events.json"
+
+// appendEventLog appends an event to the event log of its upload, events of uploads which are gone are dropped.
+func (c *ChunkedUploaderService) appendEventLog(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.fs.Stat(c.getMetadataFilePath(event.UploadId)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	directory := c.getSnapshotDirectory(event.UploadId)
+	err = c.fs.MkdirAll(directory, 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := c.fs.OpenFile(filepath.Join(directory, eventLogFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// a single write keeps concurrent appends from interleaving
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// GetUploadEvents returns the events of a given upload in the order they happened, like its creation and every
+// change of its state.
+func (c *ChunkedUploaderService) GetUploadEvents(ctx context.Context, uploadId string) ([]Event, error) {
+	_, err := c.readMetadata(uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetUploadEvents failed to read metadata %w", err)
+	}
+
+	events := []Event{}
+	file, err := c.fs.Open(filepath.Join(c.getSnapshotDirectory(uploadId), eventLogFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return events, nil
+		}
+		return nil, fmt.Errorf("ChunkedUploaderService.GetUploadEvents failed to open event log %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if json.Unmarshal(scanner.Bytes(), &event) != nil {
+			// a crash while appending may leave a partial last line
+			continue
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetUploadEvents failed to read event log %w", err)
+	}
+
+	return events, nil
+}
+
+// UploadEventsHandler returns the events of a given uploadId as newline-delimited JSON, oldest first. The since query
+// parameter, an RFC 3339 time, leaves out the events which did not happen after it.
+func (c *ChunkedUploaderHandler) UploadEventsHandler(w http.ResponseWriter, r *http.Request) {
+	uploadId := mux.Vars(r)["upload_id"]
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	var since time.Time
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "since must be an RFC 3339 time")
+			return
+		}
+	}
+
+	meta, err := c.service.readMetadata(uploadId)
+	if err == nil && !c.requireOwner(w, r, meta) {
+		return
+	}
+
+	events, err := c.service.GetUploadEvents(r.Context(), uploadId)
+	if err != nil {
+		if errors.Is(err, UploadNotFoundError) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get events: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	for _, event := range events {
+		if !event.At.After(since) {
+			continue
+		}
+		if encoder.Encode(event) != nil {
+			return
+		}
+	}
+}
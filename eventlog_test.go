@@ -0,0 +1,174 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func getEvents(t *testing.T, handler http.Handler, uploadId string, query string) []Event {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+uploadId+"/events"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("events: %d %s", rec.Code, rec.Body)
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("content type %q, want application/x-ndjson", contentType)
+	}
+
+	var events []Event
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestUploadEventLog(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// checksums are sent to finish one after another
+		checksums  func(data []byte) []string
+		wantEvents []EventType
+		wantData   []map[string]string
+	}{
+		{
+			name:       "finished",
+			checksums:  func(data []byte) []string { return []string{sha256Hex(data)} },
+			wantEvents: []EventType{EventUploadCreated, EventUploadStateChanged},
+			wantData:   []map[string]string{{"state": "uploading"}, {"from": "uploading", "to": "complete"}},
+		},
+		{
+			name:       "finished after a mismatch",
+			checksums:  func(data []byte) []string { return []string{sha256Hex(nil), sha256Hex(data)} },
+			wantEvents: []EventType{EventUploadCreated, EventChecksumMismatch, EventUploadStateChanged},
+			wantData:   []map[string]string{{"state": "uploading"}, {"algorithm": "sha256", "policy": "keep"}, {"from": "uploading", "to": "complete"}},
+		},
+		{
+			name:       "unfinished",
+			checksums:  func(data []byte) []string { return nil },
+			wantEvents: []EventType{EventUploadCreated},
+			wantData:   []map[string]string{{"state": "uploading"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs())
+			handler := NewHTTPHandler(service)
+
+			data := randomBytes(t, 1000)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/init", strings.NewReader(`{"file_size": 1000}`)))
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("init: %d %s", rec.Code, rec.Body)
+			}
+			var created struct {
+				UploadId string `json:"upload_id"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+				t.Fatal(err)
+			}
+			uploadId := created.UploadId
+
+			uploadOverHTTP(t, handler, uploadId, data, 400)
+			for _, checksum := range tc.checksums(data) {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/"+uploadId+"/finish", strings.NewReader(fmt.Sprintf(`{"checksum": %q}`, checksum))))
+			}
+
+			events := getEvents(t, handler, uploadId, "")
+			var types []EventType
+			var eventData []map[string]string
+			for i, event := range events {
+				if event.UploadId != uploadId {
+					t.Errorf("event %d of upload %s", i, event.UploadId)
+				}
+				if i > 0 && event.At.Before(events[i-1].At) {
+					t.Errorf("event %d happened before the one preceding it", i)
+				}
+				types = append(types, event.Type)
+				eventData = append(eventData, event.Data)
+			}
+			if !reflect.DeepEqual(types, tc.wantEvents) {
+				t.Fatalf("events %v, want %v", types, tc.wantEvents)
+			}
+			if !reflect.DeepEqual(eventData, tc.wantData) {
+				t.Errorf("event data %v, want %v", eventData, tc.wantData)
+			}
+
+			// since leaves out the events which did not happen after it
+			for i, event := range events {
+				since := getEvents(t, handler, uploadId, "?since="+url.QueryEscape(event.At.Format(time.RFC3339Nano)))
+				if len(since) > len(events)-i-1 {
+					t.Errorf("since event %d: %d events, want at most %d", i, len(since), len(events)-i-1)
+				}
+			}
+			if before := getEvents(t, handler, uploadId, "?since="+url.QueryEscape(events[0].At.Add(-time.Second).Format(time.RFC3339))); len(before) != len(events) {
+				t.Errorf("since before the first event: %d events, want %d", len(before), len(events))
+			}
+		})
+	}
+}
+
+func TestUploadEventLogErrors(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	service := newTestService(fs)
+	handler := NewHTTPHandler(service)
+
+	uploadId, err := service.CreateUpload(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash while appending leaves a partial last line, which is skipped
+	file, err := fs.OpenFile(filepath.Join(service.getSnapshotDirectory(uploadId), eventLogFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(`{"type": "state_ch`))
+	file.Close()
+	if events := getEvents(t, handler, uploadId, ""); len(events) != 1 || events[0].Type != EventUploadCreated {
+		t.Errorf("events with a partial line: %v", events)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		uploadId string
+		query    string
+		want     int
+	}{
+		{"invalid since", uploadId, "?since=yesterday", http.StatusBadRequest},
+		{"unknown upload", "doesnotexist", "", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+tc.uploadId+"/events"+tc.query, nil))
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body)
+		}
+	}
+
+	// the log goes together with the upload
+	if err := service.CancelUpload(context.Background(), uploadId); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := afero.Exists(fs, filepath.Join(service.getSnapshotDirectory(uploadId), eventLogFile)); ok {
+		t.Errorf("event log of a cancelled upload is kept")
+	}
+}
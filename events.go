@@ -0,0 +1,52 @@
+package chunkeduploader
+
+import "time"
+
+type EventType string
+
+const (
+	// EventUploadCreated is emitted when an upload was created, imported or copied.
+	EventUploadCreated EventType = "upload_created"
+	// EventUploadStateChanged is emitted when the state of an upload changed, from and to in Data are the states.
+	EventUploadStateChanged EventType = "state_changed"
+	// EventUploadTransferred is emitted when an upload was handed to another owner.
+	EventUploadTransferred EventType = "upload_transferred"
+)
+
+// Event describes something which happened to an upload, Data carries details depending on the type.
+type Event struct {
+	Type     EventType         `json:"type"`
+	UploadId string            `json:"upload_id"`
+	At       time.Time         `json:"at"`
+	Data     map[string]string `json:"data,omitempty"`
+	// ClientContext is the client context of the upload at the time of the event, see WithClientContext.
+	ClientContext string `json:"client_context,omitempty"`
+}
+
+// EventHook is called synchronously for every event, it must not block. Events are also kept in the event log of
+// their upload, see GetUploadEvents.
+type EventHook func(Event)
+
+// WithEventHook registers a function called for the events of the service.
+func WithEventHook(hook EventHook) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.eventHooks = append(c.eventHooks, hook)
+	}
+}
+
+func (c *ChunkedUploaderService) emit(eventType EventType, uploadId string, data map[string]string) {
+	event := Event{Type: eventType, UploadId: uploadId, At: time.Now(), Data: data}
+	if meta, err := c.readMetadata(uploadId); err == nil {
+		event.ClientContext = meta.ClientContext
+	}
+	c.log(LogLevelDebug, "Upload event", LogField{"upload_id", uploadId}, LogField{"type", eventType}, LogField{"client_context", stripControl(event.ClientContext)})
+	for _, hook := range c.eventHooks {
+		hook(event)
+	}
+	c.queueNotifications(event)
+
+	err := c.appendEventLog(event)
+	if err != nil {
+		c.log(LogLevelWarn, "Failed to record event", LogField{"upload_id", uploadId}, LogField{"type", eventType}, LogField{"error", err})
+	}
+}
@@ -0,0 +1,78 @@
+// Command client is an example uploading a file to the example server, see the flags for its options.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/Craftserve/chunked-uploader/pkg/client"
+)
+
+// config holds the flags of the client.
+type config struct {
+	endpoint    string
+	chunkSize   int64
+	parallelism int
+	file        string
+}
+
+func parseFlags(args []string) (config, error) {
+	var cfg config
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	flags.StringVar(&cfg.endpoint, "endpoint", "http://localhost:8081", "url of the server")
+	flags.Int64Var(&cfg.chunkSize, "chunk-size", 8<<20, "size of the chunks in bytes")
+	flags.IntVar(&cfg.parallelism, "parallelism", 1, "number of chunks sent at once")
+	err := flags.Parse(args)
+	if err != nil {
+		return cfg, err
+	}
+
+	if flags.NArg() != 1 {
+		err = fmt.Errorf("exactly one file is required")
+		fmt.Fprintln(flags.Output(), err)
+		flags.Usage()
+		return cfg, err
+	}
+	cfg.file = flags.Arg(0)
+	return cfg, nil
+}
+
+// upload sends a file to the server of a given config and returns its path on the server.
+func upload(ctx context.Context, cfg config, httpClient *http.Client) (string, error) {
+	file, err := os.Open(cfg.file)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	c := client.Client{
+		Endpoint:    cfg.endpoint,
+		ChunkSize:   cfg.chunkSize,
+		Parallelism: cfg.parallelism,
+		DoRequest:   httpClient.Do,
+	}
+
+	return c.Upload(ctx, file)
+}
+
+func main() {
+	cfg, err := parseFlags(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	path, err := upload(ctx, cfg, &http.Client{})
+	if err != nil {
+		fmt.Println("Error uploading the file:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Uploaded file path:", path)
+}
@@ -4,8 +4,8 @@ const CHUNK_SIZE = 50 * 1024 * 1024; // 50MB
 const uploader = new ChunkedUploaderClient({
   endpoints: {
     init: "http://localhost:8081/init",
-    upload: "http://localhost:8081/upload/{uploadId}",
-    finish: "http://localhost:8081/finish/{uploadId}",
+    upload: "http://localhost:8081/{uploadId}/upload",
+    finish: "http://localhost:8081/{uploadId}/finish",
   },
 });
 
@@ -0,0 +1,142 @@
+// Command server is an example server storing uploads below a root directory, see the flags for its options.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	chunkeduploader "github.com/Craftserve/chunked-uploader"
+	"github.com/Craftserve/chunked-uploader/pkg/logging/slogadapter"
+	"github.com/Craftserve/chunked-uploader/utils"
+	"github.com/spf13/afero"
+)
+
+// Version is set at build time with go build -ldflags "-X main.Version=...".
+var Version string
+
+// cleanupMaxAge is the age of the uploads removed by cleanup.
+const cleanupMaxAge = 24 * time.Hour
+
+// config holds the flags of the server.
+type config struct {
+	addr            string
+	root            string
+	maxFileSize     int64
+	cleanupInterval time.Duration
+	adminToken      string
+}
+
+func parseFlags(args []string) (config, error) {
+	var cfg config
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.StringVar(&cfg.addr, "addr", ":8081", "address to listen on")
+	flags.StringVar(&cfg.root, "root", ".", "directory the uploads are stored below")
+	flags.Int64Var(&cfg.maxFileSize, "max-file-size", 0, "largest accepted upload in bytes, zero means no limit")
+	flags.DurationVar(&cfg.cleanupInterval, "cleanup-interval", time.Hour, "how often uploads older than a day are removed, zero disables cleanup")
+	err := flags.Parse(args)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.adminToken = os.Getenv("ADMIN_TOKEN")
+	return cfg, nil
+}
+
+// newService creates the service storing uploads below the root of a given config.
+func newService(cfg config) (*chunkeduploader.ChunkedUploaderService, error) {
+	err := os.MkdirAll(cfg.root, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create root %w", err)
+	}
+
+	rootFs := afero.NewBasePathFs(afero.NewOsFs(), cfg.root)
+	freeSpace := func() (int64, error) { return utils.FreeSpace(cfg.root) }
+	opts := []chunkeduploader.ChunkedUploaderServiceOption{
+		chunkeduploader.WithDiskReservations(freeSpace, 1<<30),
+		chunkeduploader.WithImportRoot("/imports"),
+		chunkeduploader.WithUploadTTL(24 * time.Hour),
+		chunkeduploader.WithLogger(slogadapter.New(slog.Default())),
+	}
+	if cfg.cleanupInterval > 0 {
+		opts = append(opts, chunkeduploader.WithCleanupInterval(cfg.cleanupInterval, cleanupMaxAge))
+	}
+	if cfg.maxFileSize > 0 {
+		opts = append(opts, chunkeduploader.WithMaxFileSize(cfg.maxFileSize))
+	}
+
+	return chunkeduploader.NewChunkedUploaderService(rootFs, opts...), nil
+}
+
+// newHandler serves the API of a service, the admin endpoints require the admin token of a given config.
+func newHandler(cfg config, service *chunkeduploader.ChunkedUploaderService) http.Handler {
+	buildInfo := chunkeduploader.ReadBuildInfo()
+	if Version != "" {
+		buildInfo.Version = Version
+	}
+
+	return chunkeduploader.NewHTTPHandler(service,
+		chunkeduploader.WithAdminAuthorizer(func(r *http.Request) bool {
+			return cfg.adminToken != "" && r.Header.Get("X-Admin-Token") == cfg.adminToken
+		}),
+		chunkeduploader.WithBuildInfo(buildInfo),
+		chunkeduploader.WithRateLimiter(chunkeduploader.NewRateLimiter(50, 100, true)),
+		chunkeduploader.WithCORS("*"),
+		chunkeduploader.WithRequestLogging(),
+		chunkeduploader.WithMetrics(),
+	)
+}
+
+// run serves the API on a given listener until the context is done, then it shuts the server and the service down.
+func run(ctx context.Context, cfg config, listener net.Listener) error {
+	service, err := newService(cfg)
+	if err != nil {
+		return err
+	}
+	go service.Run(ctx)
+	defer service.Shutdown(context.Background())
+
+	server := chunkeduploader.NewServer(listener.Addr().String(), newHandler(cfg, service))
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	err = server.Serve(listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func main() {
+	cfg, err := parseFlags(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	listener, err := net.Listen("tcp", cfg.addr)
+	if err != nil {
+		fmt.Println("Error starting the server:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Server is running on %s, storing uploads below %s\n", listener.Addr(), cfg.root)
+	err = run(ctx, cfg, listener)
+	if err != nil {
+		fmt.Println("Error running the server:", err)
+		os.Exit(1)
+	}
+}
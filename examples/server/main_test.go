@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Craftserve/chunked-uploader/pkg/client"
+)
+
+func TestUploadThroughExampleServer(t *testing.T) {
+	cfg, err := parseFlags([]string{"--addr", "127.0.0.1:0", "--root", t.TempDir(), "--cleanup-interval", "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", cfg.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- run(ctx, cfg, listener)
+	}()
+	defer func() {
+		cancel()
+		select {
+		case err := <-result:
+			if err != nil {
+				t.Errorf("run returned %v", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Error("server did not shut down")
+		}
+	}()
+
+	data := make([]byte, 5*64<<10+123)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	c := client.Client{
+		Endpoint:  "http://" + listener.Addr().String(),
+		ChunkSize: 64 << 10,
+		DoRequest: http.DefaultClient.Do,
+	}
+	path, checksum, err := c.UploadWithChecksum(ctx, io.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(data)
+	if checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("got checksum %s, want %s", checksum, hex.EncodeToString(sum[:]))
+	}
+	stored, err := os.ReadFile(filepath.Join(cfg.root, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Error("stored file differs from the uploaded one")
+	}
+}
@@ -0,0 +1,290 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var UploadTTLDisabledError = errors.New("upload ttl is not enabled")
+
+// UploadExpiredError is returned when writing to an upload whose ttl has passed.
+type UploadExpiredError struct {
+	ExpiredAt time.Time
+}
+
+func (e *UploadExpiredError) Error() string {
+	return fmt.Sprintf("upload expired at %s", e.ExpiredAt.Format(time.RFC3339))
+}
+
+// WithUploadTTL gives every new upload a deadline ttl after its creation, chunks are rejected once it passes.
+// uploadTTLFor returns the ttl of a given upload, the retention of its source policy or the upload ttl, zero when it
+// has none.
+func (c *ChunkedUploaderService) uploadTTLFor(meta *UploadMetadata) time.Duration {
+	if meta.Policy != nil {
+		return meta.Policy.Retention
+	}
+	return c.uploadTTL
+}
+
+// ExtendUpload moves the deadline ttl from now.
+func WithUploadTTL(ttl time.Duration) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.uploadTTL = ttl
+	}
+}
+
+// expired returns UploadExpiredError if the upload has a deadline which has passed.
+func (m *UploadMetadata) expired() error {
+	if m.ExpiresAt != nil && time.Now().After(*m.ExpiresAt) {
+		return &UploadExpiredError{ExpiredAt: *m.ExpiresAt}
+	}
+	return nil
+}
+
+// ExtendUpload moves the deadline of an unfinished upload to the upload ttl, or the retention of its source policy,
+// from now and returns the new deadline.
+func (c *ChunkedUploaderService) ExtendUpload(ctx context.Context, uploadId string) (time.Time, error) {
+	if c.uploadTTL <= 0 && c.sourcePolicies == nil {
+		return time.Time{}, UploadTTLDisabledError
+	}
+
+	var expiresAt time.Time
+	err := c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		if err := meta.expired(); err != nil {
+			return err
+		}
+
+		ttl := c.uploadTTLFor(meta)
+		if ttl <= 0 {
+			return UploadTTLDisabledError
+		}
+
+		expiresAt = time.Now().Add(ttl)
+		meta.ExpiresAt = &expiresAt
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ChunkedUploaderService.ExtendUpload failed to update metadata %w", err)
+	}
+
+	return expiresAt, nil
+}
+
+// CancelUpload aborts an upload and removes its files. An upload which is being finished or is complete is kept, the
+// cancel fails with UploadFinishingError or UploadAlreadyFinishedError.
+func (c *ChunkedUploaderService) CancelUpload(ctx context.Context, uploadId string) error {
+	drained, release, err := c.writers.cancel(uploadId)
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.CancelUpload %w", err)
+	}
+	defer release()
+
+	// a finish may have completed since the caller looked at the upload, no other can start now
+	unlock := c.locks.lock(uploadId)
+	meta, err := c.readMetadata(uploadId)
+	unlock()
+	if err == nil && (meta.State == UploadStateComplete || meta.State == UploadStateVerifying) {
+		return fmt.Errorf("ChunkedUploaderService.CancelUpload %w", UploadAlreadyFinishedError)
+	}
+
+	// chunks notice the cancellation between the buffers they copy, a chunk stuck reading a stalled client does not
+	// hold up the removal longer than the context allows
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		c.log(LogLevelWarn, "Removing upload with chunks still being written", LogField{"upload_id", uploadId})
+	}
+
+	err = c.RemovePendingFile(uploadId)
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.CancelUpload %w", err)
+	}
+
+	c.log(LogLevelInfo, "Canceled upload", LogField{"upload_id", uploadId})
+	return nil
+}
+
+// setExpiresHeader sets X-Upload-Expires to the deadline of a given upload, if it has one.
+func (c *ChunkedUploaderHandler) setExpiresHeader(w http.ResponseWriter, uploadId string) {
+	if c.service.uploadTTL <= 0 && c.service.sourcePolicies == nil {
+		return
+	}
+
+	meta, err := c.service.readMetadata(uploadId)
+	if err != nil || meta.ExpiresAt == nil {
+		return
+	}
+
+	w.Header().Set("X-Upload-Expires", meta.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
+// writeExpiredError cancels an expired upload and responds with 410.
+func (c *ChunkedUploaderHandler) writeExpiredError(w http.ResponseWriter, r *http.Request, uploadId string, expired *UploadExpiredError) {
+	err := c.service.CancelUpload(r.Context(), uploadId)
+	if err != nil {
+		c.service.log(LogLevelError, "Failed to cancel expired upload", LogField{"upload_id", uploadId}, LogField{"error", err})
+	}
+
+	w.WriteHeader(http.StatusGone)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":      expired.Error(),
+		"code":       "upload_expired",
+		"expired_at": expired.ExpiredAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// ExtendUploadHandler moves the deadline of a given uploadId to the upload ttl from now, with WithUploadTokens it also
+// rotates the token of the upload.
+func (c *ChunkedUploaderHandler) ExtendUploadHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	// with upload tokens the endpoint rotates the token, even without a deadline to move
+	rotate := c.service.uploadTokenTTL > 0
+	expiresAt, err := c.service.ExtendUpload(r.Context(), uploadId)
+	if err != nil && !(rotate && errors.Is(err, UploadTTLDisabledError)) {
+		var expired *UploadExpiredError
+		switch {
+		case errors.As(err, &expired):
+			c.writeExpiredError(w, r, uploadId, expired)
+		case errors.Is(err, UploadTTLDisabledError):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to extend upload: "+err.Error())
+		}
+		return
+	}
+
+	response := map[string]string{}
+	if err == nil {
+		expires := expiresAt.UTC().Format(time.RFC3339)
+		w.Header().Set("X-Upload-Expires", expires)
+		response["expires_at"] = expires
+	}
+	if rotate {
+		token, err := c.service.RotateUploadToken(r.Context(), uploadId)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to rotate upload token: "+err.Error())
+			return
+		}
+		response["token"] = token.Token
+		response["token_expires_at"] = token.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// TouchUpload marks an upload as active without writing any data, so cleanup keeps it for another full period. With
+// an upload ttl or a source policy the deadline is also moved, like ExtendUpload does.
+func (c *ChunkedUploaderService) TouchUpload(ctx context.Context, uploadId string) error {
+	now := time.Now()
+	touch := func() error {
+		err := c.fs.Chtimes(c.getUploadFilePath(uploadId), now, now)
+		if errors.Is(err, fs.ErrNotExist) {
+			return UploadNotFoundError
+		}
+		return err
+	}
+
+	err := c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		if err := meta.expired(); err != nil {
+			return err
+		}
+		if ttl := c.uploadTTLFor(meta); ttl > 0 {
+			expiresAt := now.Add(ttl)
+			meta.ExpiresAt = &expiresAt
+		}
+		return touch()
+	})
+	if errors.Is(err, UploadNotFoundError) {
+		// uploads created before metadata was introduced only have the pending file
+		err = touch()
+	}
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.TouchUpload failed to touch upload %w", err)
+	}
+
+	return nil
+}
+
+// TouchUploadHandler keeps a paused uploadId alive.
+func (c *ChunkedUploaderHandler) TouchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	err := c.service.TouchUpload(r.Context(), uploadId)
+	if err != nil {
+		var expired *UploadExpiredError
+		switch {
+		case errors.As(err, &expired):
+			c.writeExpiredError(w, r, uploadId, expired)
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to touch upload: "+err.Error())
+		}
+		return
+	}
+
+	c.setExpiresHeader(w, uploadId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CancelUploadHandler aborts an unfinished uploadId and removes its files.
+func (c *ChunkedUploaderHandler) CancelUploadHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	meta, err := c.service.readMetadata(uploadId)
+	if err != nil {
+		if errors.Is(err, UploadNotFoundError) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to cancel upload: "+err.Error())
+		return
+	}
+	if !c.requireOwner(w, r, meta) {
+		return
+	}
+	if meta.State == UploadStateComplete || meta.State == UploadStateVerifying {
+		writeJSONError(w, http.StatusConflict, UploadAlreadyFinishedError.Error())
+		return
+	}
+
+	err = c.service.CancelUpload(r.Context(), uploadId)
+	if err != nil {
+		if errors.Is(err, UploadFinishingError) || errors.Is(err, UploadAlreadyFinishedError) {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to cancel upload: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,59 @@
+package chunkeduploader
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestTouchUploadExpiry(t *testing.T) {
+	const ttl = 100 * time.Millisecond
+
+	for _, tc := range []struct {
+		name string
+		opts []ChunkedUploaderServiceOption
+	}{
+		{"upload ttl", []ChunkedUploaderServiceOption{WithUploadTTL(ttl)}},
+		// the retention of the policy wins over the longer upload ttl, as with ExtendUpload
+		{"source policy", []ChunkedUploaderServiceOption{WithUploadTTL(time.Hour), WithSourcePolicies(SourcePolicy{Retention: ttl}, map[string]SourcePolicy{})}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs(), tc.opts...)
+			handler := NewHTTPHandler(service)
+
+			uploadId, err := service.CreateUpload(-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			touched := time.Now()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/"+uploadId+"/touch", nil))
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("touch: %d %s, want 204", rec.Code, rec.Body)
+			}
+			meta, err := service.readMetadata(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.ExpiresAt == nil || meta.ExpiresAt.Before(touched) || meta.ExpiresAt.After(time.Now().Add(ttl)) {
+				t.Fatalf("expires at %v after the touch, want %v from %v", meta.ExpiresAt, ttl, touched)
+			}
+
+			time.Sleep(time.Until(*meta.ExpiresAt) + 10*time.Millisecond)
+
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/"+uploadId+"/upload", strings.NewReader("data")))
+			var body map[string]string
+			json.NewDecoder(rec.Body).Decode(&body)
+			if rec.Code != http.StatusGone || body["code"] != "upload_expired" {
+				t.Errorf("chunk after the expiry: %d %v, want 410 upload_expired", rec.Code, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/afero"
+)
+
+var UploadNotCompleteError = errors.New("upload is not complete")
+
+// openCompleteUpload opens the file of a finished upload at its current location.
+func (c *ChunkedUploaderService) openCompleteUpload(uploadId string) (afero.File, *UploadMetadata, error) {
+	meta, err := c.readMetadata(uploadId)
+	if err != nil && !errors.Is(err, UploadNotFoundError) {
+		return nil, nil, err
+	}
+	if meta != nil && meta.State != UploadStateComplete {
+		return nil, nil, UploadNotCompleteError
+	}
+
+	path, err := c.UploadedFilePath(uploadId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := c.servingFs().Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open uploaded file %w", err)
+	}
+
+	return file, meta, nil
+}
+
+// GetUploadSize returns the size and modification time of the file of a finished upload.
+func (c *ChunkedUploaderService) GetUploadSize(ctx context.Context, uploadId string) (size int64, modTime time.Time, err error) {
+	file, _, err := c.openCompleteUpload(uploadId)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("ChunkedUploaderService.GetUploadSize failed to open upload %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("ChunkedUploaderService.GetUploadSize failed to stat upload %w", err)
+	}
+
+	return info.Size(), info.ModTime(), nil
+}
+
+// ExportUploadHandler serves the file of a finished upload, supporting range and conditional requests. When signed
+// downloads are enabled the request must carry a valid token and expires query parameters.
+func (c *ChunkedUploaderHandler) ExportUploadHandler(w http.ResponseWriter, r *http.Request) {
+	uploadId, ok := c.authorizeExport(w, r)
+	if !ok {
+		return
+	}
+
+	file, meta, err := c.service.openCompleteUpload(uploadId)
+	if err != nil {
+		writeExportError(w, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to stat upload: "+err.Error())
+		return
+	}
+
+	name := uploadId
+	if meta != nil && meta.Filename != "" {
+		name = meta.Filename
+	}
+	setExportHeaders(w, meta)
+
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
+
+// HeadExportHandler returns the headers of ExportUploadHandler without the file content.
+func (c *ChunkedUploaderHandler) HeadExportHandler(w http.ResponseWriter, r *http.Request) {
+	uploadId, ok := c.authorizeExport(w, r)
+	if !ok {
+		return
+	}
+
+	meta, err := c.service.GetMetadata(r.Context(), uploadId)
+	if err != nil && !errors.Is(err, UploadNotFoundError) {
+		writeExportError(w, err)
+		return
+	}
+
+	size, modTime, err := c.service.GetUploadSize(r.Context(), uploadId)
+	if err != nil {
+		writeExportError(w, err)
+		return
+	}
+
+	setExportHeaders(w, meta)
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	if notModified(r, w.Header().Get("ETag"), modTime) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since the way http.ServeContent does, If-Modified-Since is
+// ignored when If-None-Match is present.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modTime.Truncate(time.Second).After(ims)
+}
+
+// authorizeExport checks the signature of an export request when signed downloads are enabled and returns the
+// requested uploadId. It writes the error response itself.
+func (c *ChunkedUploaderHandler) authorizeExport(w http.ResponseWriter, r *http.Request) (string, bool) {
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return "", false
+	}
+
+	if signer := c.service.signer; signer != nil {
+		query := r.URL.Query()
+		err := signer.verify("export", uploadId, query.Get("token"), query.Get("expires"))
+		if err != nil {
+			writeJSONError(w, http.StatusUnauthorized, err.Error())
+			return "", false
+		}
+	}
+
+	return uploadId, true
+}
+
+// setExportHeaders sets the headers describing an upload. The checksum recorded when the upload was verified is used
+// as its strong ETag, so conditional requests never read the file, and its algorithm is sent in X-Checksum-Algorithm.
+func setExportHeaders(w http.ResponseWriter, meta *UploadMetadata) {
+	if meta == nil {
+		return
+	}
+	if meta.ContentType != "" {
+		w.Header().Set("Content-Type", meta.ContentType)
+	}
+	if meta.Checksum != "" {
+		w.Header().Set("ETag", strconv.Quote(meta.Checksum))
+	}
+	if meta.ChecksumAlgorithm != "" {
+		w.Header().Set("X-Checksum-Algorithm", string(meta.ChecksumAlgorithm))
+	}
+}
+
+func writeExportError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, UploadNotFoundError):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, UploadNotCompleteError):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	default:
+		writeJSONError(w, http.StatusInternalServerError, "Failed to open upload: "+err.Error())
+	}
+}
@@ -0,0 +1,171 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// newExportTestUpload creates a finished upload of size bytes and returns its id and data.
+func newExportTestUpload(t *testing.T, service *ChunkedUploaderService, size int, opts ...CreateUploadOption) (string, []byte) {
+	t.Helper()
+
+	data := randomBytes(t, size)
+	uploadId, err := service.CreateUpload(int64(len(data)), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.FinishUpload(context.Background(), uploadId, sha256Hex(data)); err != nil {
+		t.Fatal(err)
+	}
+	return uploadId, data
+}
+
+func exportRequest(handler http.Handler, method string, uploadId string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/"+uploadId+"/export", nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHeadExport(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		size            int
+		opts            []CreateUploadOption
+		wantContentType string
+	}{
+		{name: "content type from metadata", size: 1024, opts: []CreateUploadOption{WithContentType("image/png")}, wantContentType: "image/png"},
+		{name: "default content type", size: 1024, wantContentType: "application/octet-stream"},
+		{name: "single byte", size: 1, wantContentType: "application/octet-stream"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			service := newTestService(fs)
+			handler := NewHTTPHandler(service)
+			uploadId, data := newExportTestUpload(t, service, tc.size, tc.opts...)
+
+			rec := exportRequest(handler, http.MethodHead, uploadId, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("HEAD: %d %s", rec.Code, rec.Body)
+			}
+			if rec.Body.Len() != 0 {
+				t.Errorf("HEAD body: %d bytes, want none", rec.Body.Len())
+			}
+
+			path, err := service.UploadedFilePath(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := fs.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			etag := strconv.Quote(sha256Hex(data))
+			for header, want := range map[string]string{
+				"Content-Length": strconv.Itoa(len(data)),
+				"Content-Type":   tc.wantContentType,
+				"Last-Modified":  info.ModTime().UTC().Format(http.TimeFormat),
+				"ETag":           etag,
+				"Accept-Ranges":  "bytes",
+			} {
+				if got := rec.Header().Get(header); got != want {
+					t.Errorf("%s: got %q, want %q", header, got, want)
+				}
+			}
+			if size, _, err := service.GetUploadSize(context.Background(), uploadId); err != nil || strconv.FormatInt(size, 10) != rec.Header().Get("Content-Length") {
+				t.Errorf("GetUploadSize: %d %v, want Content-Length %s", size, err, rec.Header().Get("Content-Length"))
+			}
+
+			rec = exportRequest(handler, http.MethodGet, uploadId, map[string]string{"If-None-Match": rec.Header().Get("ETag")})
+			if rec.Code != http.StatusNotModified {
+				t.Errorf("GET with If-None-Match: %d, want %d", rec.Code, http.StatusNotModified)
+			}
+			if rec.Body.Len() != 0 {
+				t.Errorf("GET with If-None-Match body: %d bytes, want none", rec.Body.Len())
+			}
+		})
+	}
+}
+
+func TestHeadExportOfUnfinishedUpload(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	uploadId, err := service.CreateUpload(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		uploadId string
+		wantCode int
+	}{
+		{uploadId, http.StatusConflict},
+		{"0123456789abcdef0123456789abcdef", http.StatusNotFound},
+	} {
+		if rec := exportRequest(handler, http.MethodHead, tc.uploadId, nil); rec.Code != tc.wantCode {
+			t.Errorf("HEAD of %s: %d, want %d", tc.uploadId, rec.Code, tc.wantCode)
+		}
+	}
+}
+
+func TestConditionalExport(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+	uploadId, data := newExportTestUpload(t, service, 1024)
+
+	_, modTime, err := service.GetUploadSize(context.Background(), uploadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := strconv.Quote(sha256Hex(data))
+	before := modTime.Add(-time.Hour).UTC().Format(http.TimeFormat)
+	after := modTime.Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	for _, tc := range []struct {
+		name     string
+		headers  map[string]string
+		wantCode int
+	}{
+		{name: "no conditions", wantCode: http.StatusOK},
+		{name: "matching etag", headers: map[string]string{"If-None-Match": etag}, wantCode: http.StatusNotModified},
+		{name: "matching etag in a list", headers: map[string]string{"If-None-Match": `"other", ` + etag}, wantCode: http.StatusNotModified},
+		{name: "weak matching etag", headers: map[string]string{"If-None-Match": "W/" + etag}, wantCode: http.StatusNotModified},
+		{name: "any etag", headers: map[string]string{"If-None-Match": "*"}, wantCode: http.StatusNotModified},
+		{name: "other etag", headers: map[string]string{"If-None-Match": `"other"`}, wantCode: http.StatusOK},
+		{name: "modified since", headers: map[string]string{"If-Modified-Since": before}, wantCode: http.StatusOK},
+		{name: "not modified since", headers: map[string]string{"If-Modified-Since": after}, wantCode: http.StatusNotModified},
+		{name: "etag takes precedence", headers: map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": after}, wantCode: http.StatusOK},
+	} {
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			rec := exportRequest(handler, method, uploadId, tc.headers)
+			if rec.Code != tc.wantCode {
+				t.Errorf("%s %s: %d, want %d", method, tc.name, rec.Code, tc.wantCode)
+				continue
+			}
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Errorf("%s %s: ETag %q, want %q", method, tc.name, got, etag)
+			}
+			wantBody := 0
+			if method == http.MethodGet && tc.wantCode == http.StatusOK {
+				wantBody = len(data)
+			}
+			if rec.Body.Len() != wantBody {
+				t.Errorf("%s %s: %d bytes of body, want %d", method, tc.name, rec.Body.Len(), wantBody)
+			}
+		}
+	}
+}
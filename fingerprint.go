@@ -0,0 +1,76 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+	"github.com/gorilla/mux"
+)
+
+var errFingerprintFound = errors.New("fingerprint found")
+
+type FingerprintResult struct {
+	Exists   bool   `json:"exists"`
+	UploadId string `json:"upload_id,omitempty"`
+	Path     string `json:"path,omitempty"`
+}
+
+// LookupByFingerprint searches the complete uploads for one created with a given fingerprint or verified with it as
+// its SHA-256 checksum.
+func (c *ChunkedUploaderService) LookupByFingerprint(ctx context.Context, fingerprint string) (*FingerprintResult, error) {
+	result := &FingerprintResult{}
+
+	err := c.walkMetadata(func(meta *UploadMetadata) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		verified := meta.ChecksumAlgorithm == utils.ChecksumSHA256 || (meta.ChecksumAlgorithm == "" && c.checksumAlgorithm == utils.ChecksumSHA256)
+		matches := strings.EqualFold(meta.Fingerprint, fingerprint) || (verified && strings.EqualFold(meta.Checksum, fingerprint))
+		if meta.State != UploadStateComplete || !matches {
+			return nil
+		}
+
+		path, err := c.UploadedFilePath(meta.UploadId)
+		if err != nil {
+			// the file is gone, the upload cannot be reused
+			return nil
+		}
+
+		result.Exists = true
+		result.UploadId = meta.UploadId
+		result.Path = path
+		return errFingerprintFound
+	})
+	if err != nil && !errors.Is(err, errFingerprintFound) {
+		return nil, fmt.Errorf("ChunkedUploaderService.LookupByFingerprint failed to search uploads %w", err)
+	}
+
+	return result, nil
+}
+
+// CheckFingerprintHandler reports whether a complete upload with a given fingerprint already exists.
+func (c *ChunkedUploaderHandler) CheckFingerprintHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fingerprint := vars["fingerprint"]
+
+	if _, err := hex.DecodeString(fingerprint); err != nil || fingerprint == "" {
+		writeJSONError(w, http.StatusBadRequest, "fingerprint must be a hex string")
+		return
+	}
+
+	result, err := c.service.LookupByFingerprint(r.Context(), fingerprint)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to lookup fingerprint: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,149 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Craftserve/chunked-uploader/pkg/client"
+	"github.com/spf13/afero"
+)
+
+func TestLookupByFingerprint(t *testing.T) {
+	const fingerprint = "0123456789abcdef"
+
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	// two uploads of the same file, the first one is complete
+	data := randomBytes(t, 1024)
+	var uploadIds []string
+	for i := 0; i < 2; i++ {
+		uploadId, err := service.CreateUpload(int64(len(data)), WithFingerprint(fingerprint))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+			t.Fatal(err)
+		}
+		uploadIds = append(uploadIds, uploadId)
+	}
+	path, err := service.FinishUpload(context.Background(), uploadIds[0], sha256Hex(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		fingerprint string
+		wantStatus  int
+		want        FingerprintResult
+	}{
+		{"fingerprint", fingerprint, http.StatusOK, FingerprintResult{Exists: true, UploadId: uploadIds[0], Path: path}},
+		{"upper case fingerprint", "0123456789ABCDEF", http.StatusOK, FingerprintResult{Exists: true, UploadId: uploadIds[0], Path: path}},
+		{"checksum", sha256Hex(data), http.StatusOK, FingerprintResult{Exists: true, UploadId: uploadIds[0], Path: path}},
+		{"unknown", "fedcba9876543210", http.StatusOK, FingerprintResult{}},
+		{"not hex", "xyz", http.StatusBadRequest, FingerprintResult{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fingerprint/"+tc.fingerprint, nil))
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("got %d %s, want %d", rec.Code, rec.Body, tc.wantStatus)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var got FingerprintResult
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClientSkipsKnownFiles(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// known uploads the same file as the local one before the client runs
+		known      bool
+		size       int
+		wantExists bool
+		// wantUploads is the number of uploads on the server after UploadFile
+		wantUploads int
+	}{
+		{name: "known file", known: true, size: 500, wantExists: true, wantUploads: 1},
+		{name: "new file", size: 500, wantUploads: 1},
+		{name: "known file larger than a chunk", known: true, size: 5000, wantExists: true, wantUploads: 2},
+		{name: "new file larger than a chunk", size: 5000, wantUploads: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs())
+			server := httptest.NewServer(NewHTTPHandler(service))
+			defer server.Close()
+
+			data := randomBytes(t, tc.size)
+			var knownId, knownPath string
+			if tc.known {
+				knownId, data = newExportTestUpload(t, service, tc.size)
+				var err error
+				if knownPath, err = service.UploadedFilePath(knownId); err != nil {
+					t.Fatal(err)
+				}
+			}
+			localPath := filepath.Join(t.TempDir(), "local")
+			if err := os.WriteFile(localPath, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			c := client.Client{
+				Endpoint:  server.URL,
+				ChunkSize: 1000,
+				// slow chunks give the background fingerprint check the time to finish before the upload does
+				DoRequest: func(req *http.Request) (*http.Response, error) {
+					if strings.HasSuffix(req.URL.Path, "/upload") {
+						time.Sleep(20 * time.Millisecond)
+					}
+					return http.DefaultClient.Do(req)
+				},
+			}
+
+			exists, uploadId, err := c.GetFingerprintAndCheck(context.Background(), localPath)
+			if err != nil || exists != tc.wantExists || uploadId != knownId {
+				t.Errorf("GetFingerprintAndCheck: %t %q %v, want %t %q", exists, uploadId, err, tc.wantExists, knownId)
+			}
+
+			path, existingId, err := c.UploadFile(context.Background(), localPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if existingId != knownId || (tc.known && path != knownPath) {
+				t.Errorf("UploadFile: %q %q, want %q %q", path, existingId, knownPath, knownId)
+			}
+			if !tc.known {
+				if got, err := afero.ReadFile(service.fs, path); err != nil || !bytes.Equal(got, data) {
+					t.Errorf("uploaded file: %d bytes %v, want the local file", len(got), err)
+				}
+			}
+
+			uploads, err := service.ListUploads(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(uploads) != tc.wantUploads {
+				t.Errorf("%d uploads on the server, want %d", len(uploads), tc.wantUploads)
+			}
+		})
+	}
+}
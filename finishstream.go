@@ -0,0 +1,100 @@
+package chunkeduploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+)
+
+// FinishUploadAndStream finishes an upload like FinishUpload and then copies the verified file to a given writer, for
+// deployments passing uploads on instead of storing them. Nothing is written unless the upload verified.
+func (c *ChunkedUploaderService) FinishUploadAndStream(ctx context.Context, uploadId string, expectedChecksum string, w io.Writer) error {
+	return c.finishUploadAndStream(ctx, uploadId, expectedChecksum, c.checksumAlgorithm, w, false)
+}
+
+// finishUploadAndStream is FinishUploadAndStream with a given checksum algorithm, with remove the upload is removed
+// once the whole file was written.
+func (c *ChunkedUploaderService) finishUploadAndStream(ctx context.Context, uploadId string, expectedChecksum string, algorithm utils.ChecksumAlgorithm, w io.Writer, remove bool) error {
+	_, err := c.FinishUploadWithAlgorithm(ctx, uploadId, expectedChecksum, algorithm)
+	if err != nil {
+		return err
+	}
+
+	file, _, err := c.openCompleteUpload(uploadId)
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.FinishUploadAndStream failed to open upload %w", err)
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, utils.NewContextReader(ctx, file))
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.FinishUploadAndStream failed to stream upload %w", err)
+	}
+
+	if remove {
+		file.Close()
+		unlock := c.locks.lock(uploadId)
+		defer unlock()
+		err = c.RemovePendingFile(uploadId)
+		if err != nil {
+			return fmt.Errorf("ChunkedUploaderService.FinishUploadAndStream failed to remove upload %w", err)
+		}
+	}
+
+	return nil
+}
+
+// finishStreamWriter sends the headers describing a finished upload right before its first byte, when the upload is
+// known to have verified.
+type finishStreamWriter struct {
+	http.ResponseWriter
+	service     *ChunkedUploaderService
+	uploadId    string
+	wroteHeader bool
+}
+
+func (f *finishStreamWriter) Write(p []byte) (int, error) {
+	f.writeHeader()
+	return f.ResponseWriter.Write(p)
+}
+
+func (f *finishStreamWriter) writeHeader() {
+	if f.wroteHeader {
+		return
+	}
+	f.wroteHeader = true
+
+	meta, err := f.service.readMetadata(f.uploadId)
+	if err == nil {
+		setExportHeaders(f.ResponseWriter, meta)
+		f.Header().Set("X-Checksum", meta.Checksum)
+	}
+	if f.Header().Get("Content-Type") == "" {
+		f.Header().Set("Content-Type", "application/octet-stream")
+	}
+	if size, _, err := f.service.GetUploadSize(context.Background(), f.uploadId); err == nil {
+		f.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	f.WriteHeader(http.StatusOK)
+}
+
+// streamFinish finishes an upload and answers with its file instead of its path, with delete=true in the query the
+// upload is removed once the file was sent.
+func (c *ChunkedUploaderHandler) streamFinish(ctx context.Context, w http.ResponseWriter, r *http.Request, uploadId string, expectedChecksum string, algorithm utils.ChecksumAlgorithm) error {
+	writer := &finishStreamWriter{ResponseWriter: w, service: c.service, uploadId: uploadId}
+	err := c.service.finishUploadAndStream(ctx, uploadId, expectedChecksum, algorithm, writer, r.URL.Query().Get("delete") == "true")
+	if err != nil && writer.wroteHeader {
+		// the status is already sent, the client notices the body is shorter than Content-Length
+		c.service.log(LogLevelWarn, "Failed to stream finished upload", LogField{"upload_id", uploadId}, LogField{"error", err})
+		return nil
+	}
+	if err == nil {
+		// an empty file has no first byte
+		writer.writeHeader()
+	}
+	return err
+}
@@ -0,0 +1,193 @@
+package chunkeduploader
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+)
+
+// defaultMaxRegions is the number of separate regions an upload may have unless WithMaxRegions says otherwise.
+const defaultMaxRegions = 4096
+
+// compactRegionsThreshold is the number of regions from which the metadata stores them delta encoded.
+const compactRegionsThreshold = 16
+
+var TooFragmentedError = errors.New("upload is too fragmented")
+
+// FragmentationPolicy decides what happens to a chunk which would give an upload more regions than allowed.
+type FragmentationPolicy int
+
+const (
+	// FragmentationReject rejects the chunk with TooFragmentedError, it is answered with 422. The chunk is not
+	// recorded, so it has to be sent again once the gaps around it are filled.
+	FragmentationReject FragmentationPolicy = iota
+	// FragmentationCoalesce accepts the chunk and forgets the shortest regions after the committed one instead, they
+	// are reported as missing and have to be sent again.
+	FragmentationCoalesce
+)
+
+// WithMaxRegions bounds the number of separate regions written to an upload, which a client sending tiny chunks at
+// scattered offsets could otherwise grow without limit, together with the metadata recording them. It defaults to
+// 4096 regions with FragmentationReject.
+func WithMaxRegions(limit int, policy FragmentationPolicy) ChunkedUploaderServiceOption {
+	if limit < 1 {
+		panic("chunkeduploader: the region limit must be positive")
+	}
+
+	return func(c *ChunkedUploaderService) {
+		c.maxRegions = limit
+		c.fragmentationPolicy = policy
+	}
+}
+
+// limitRegions applies the fragmentation policy to the regions of an upload which has just been written to.
+func (c *ChunkedUploaderService) limitRegions(meta *UploadMetadata) error {
+	if len(meta.Regions) <= c.maxRegions {
+		return nil
+	}
+	if c.fragmentationPolicy == FragmentationReject {
+		return fmt.Errorf("%w: more than %d regions", TooFragmentedError, c.maxRegions)
+	}
+
+	// the committed region is what resumes continue from, it is always kept
+	candidates := make([]int, 0, len(meta.Regions)-1)
+	for i := 1; i < len(meta.Regions); i++ {
+		candidates = append(candidates, i)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return meta.Regions[candidates[i]].Length() < meta.Regions[candidates[j]].Length()
+	})
+
+	dropped := make(map[int]bool)
+	for _, i := range candidates[:len(meta.Regions)-c.maxRegions] {
+		dropped[i] = true
+		meta.TreeLeaves = clearTreeLeaves(meta.TreeLeaves, meta.Regions[i])
+	}
+
+	kept := make([]ByteRange, 0, c.maxRegions)
+	for i, region := range meta.Regions {
+		if !dropped[i] {
+			kept = append(kept, region)
+		}
+	}
+	meta.Regions = kept
+
+	return nil
+}
+
+// startsRegion reports whether a chunk written at a given offset starts a new region instead of extending one of the
+// sorted regions, appended chunks always extend the last region.
+func startsRegion(regions []ByteRange, offset int64) bool {
+	if offset < 0 {
+		return false
+	}
+	i := sort.Search(len(regions), func(i int) bool { return regions[i].End+1 >= offset })
+	return i == len(regions) || regions[i].Start > offset
+}
+
+// clearTreeLeaves forgets the digests of the tree hash leaves overlapping a given region.
+func clearTreeLeaves(leaves []string, region ByteRange) []string {
+	for i := region.Start / utils.TreeHashLeafSize; i <= region.End/utils.TreeHashLeafSize && i < int64(len(leaves)); i++ {
+		leaves[i] = ""
+	}
+	return leaves
+}
+
+func writeTooFragmentedError(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": err.Error(),
+		"code":  "too_fragmented",
+	})
+}
+
+// encodeRegions encodes sorted, non-overlapping regions as pairs of uvarints, the gap since the end of the previous
+// region and the length of the region minus one, in unpadded base64.
+func encodeRegions(regions []ByteRange) string {
+	buf := make([]byte, 0, len(regions)*4)
+	var next int64
+	for _, r := range regions {
+		buf = binary.AppendUvarint(buf, uint64(r.Start-next))
+		buf = binary.AppendUvarint(buf, uint64(r.Length()-1))
+		next = r.End + 1
+	}
+	return base64.RawStdEncoding.EncodeToString(buf)
+}
+
+// decodeRegions decodes regions encoded by encodeRegions.
+func decodeRegions(encoded string) ([]ByteRange, error) {
+	buf, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var regions []ByteRange
+	var next int64
+	for len(buf) > 0 {
+		gap, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, errors.New("invalid region gap")
+		}
+		buf = buf[n:]
+		length, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, errors.New("invalid region length")
+		}
+		buf = buf[n:]
+
+		start := next + int64(gap)
+		end := start + int64(length)
+		if start < next || end < start {
+			return nil, errors.New("region out of range")
+		}
+		regions = append(regions, ByteRange{Start: start, End: end})
+		next = end + 1
+	}
+
+	return regions, nil
+}
+
+// uploadMetadataJSON is UploadMetadata without its JSON methods.
+type uploadMetadataJSON UploadMetadata
+
+// MarshalJSON stores many regions delta encoded in regions_delta instead of the regions array, see encodeRegions.
+func (m UploadMetadata) MarshalJSON() ([]byte, error) {
+	encoded := struct {
+		uploadMetadataJSON
+		RegionsDelta string `json:"regions_delta,omitempty"`
+	}{uploadMetadataJSON: uploadMetadataJSON(m)}
+
+	if len(m.Regions) >= compactRegionsThreshold {
+		encoded.RegionsDelta = encodeRegions(m.Regions)
+		encoded.Regions = nil
+	}
+
+	return json.Marshal(encoded)
+}
+
+func (m *UploadMetadata) UnmarshalJSON(data []byte) error {
+	decoded := struct {
+		*uploadMetadataJSON
+		RegionsDelta string `json:"regions_delta"`
+	}{uploadMetadataJSON: (*uploadMetadataJSON)(m)}
+
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+
+	if decoded.RegionsDelta != "" {
+		m.Regions, err = decodeRegions(decoded.RegionsDelta)
+		if err != nil {
+			return fmt.Errorf("invalid regions_delta: %w", err)
+		}
+	}
+
+	return nil
+}
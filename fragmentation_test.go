@@ -0,0 +1,143 @@
+package chunkeduploader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestPathologicalFragmentation(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		limit  int
+		policy FragmentationPolicy
+		chunks int
+		// wantRejected is the status of the chunks past the limit
+		wantRejected int
+	}{
+		{name: "reject", limit: 64, policy: FragmentationReject, chunks: 2000, wantRejected: http.StatusUnprocessableEntity},
+		{name: "coalesce", limit: 64, policy: FragmentationCoalesce, chunks: 2000, wantRejected: http.StatusOK},
+		{name: "default limit", chunks: defaultMaxRegions + 500, wantRejected: http.StatusUnprocessableEntity},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []ChunkedUploaderServiceOption
+			limit := defaultMaxRegions
+			if tc.limit != 0 {
+				opts = append(opts, WithMaxRegions(tc.limit, tc.policy))
+				limit = tc.limit
+			}
+			fs := afero.NewMemMapFs()
+			service := newTestService(fs, opts...)
+			handler := NewHTTPHandler(service)
+
+			uploadId, err := service.CreateUpload(int64(tc.chunks) * 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// single bytes at every other offset, each starting a region of its own
+			for i := 0; i < tc.chunks; i++ {
+				offset := i * 2
+				rec := postChunk(handler, uploadId, "application/octet-stream", fmt.Sprintf("bytes=%d-%d", offset, offset), []byte{byte(i)})
+				want := http.StatusOK
+				if i >= limit {
+					want = tc.wantRejected
+				}
+				if rec.Code != want {
+					t.Fatalf("chunk %d: got %d, want %d: %s", i, rec.Code, want, rec.Body)
+				}
+				if want == http.StatusUnprocessableEntity && !strings.Contains(rec.Body.String(), `"too_fragmented"`) {
+					t.Fatalf("chunk %d: body %s misses the too_fragmented code", i, rec.Body)
+				}
+			}
+
+			meta, err := service.readMetadata(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(meta.Regions) > limit {
+				t.Errorf("%d regions, want at most %d", len(meta.Regions), limit)
+			}
+			if meta.Regions[0] != (ByteRange{Start: 0, End: 0}) {
+				t.Errorf("the committed region %v was dropped", meta.Regions[0])
+			}
+
+			// each region takes a few bytes delta encoded, whatever the number of chunks sent
+			info, err := fs.Stat(service.getMetadataFilePath(uploadId))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if maxSize := int64(limit*8 + 2048); info.Size() > maxSize {
+				t.Errorf("metadata of %d bytes, want at most %d", info.Size(), maxSize)
+			}
+
+			// chunks extending a region and chunks filling a gap are accepted past the limit
+			rec := postChunk(handler, uploadId, "application/octet-stream", "bytes=1-1", []byte{0})
+			if rec.Code != http.StatusOK {
+				t.Errorf("filling a gap: got %d: %s", rec.Code, rec.Body)
+			}
+			end := meta.Regions[len(meta.Regions)-1].End
+			rec = postChunk(handler, uploadId, "application/octet-stream", fmt.Sprintf("bytes=%d-%d", end+1, end+1), []byte{0})
+			if rec.Code != http.StatusOK {
+				t.Errorf("extending a region: got %d: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestRegionsEncoding(t *testing.T) {
+	many := make([]ByteRange, 0, 1000)
+	for i := int64(0); i < 1000; i++ {
+		many = append(many, ByteRange{Start: i * 3 * (i + 1), End: i*3*(i+1) + i})
+	}
+
+	for _, tc := range []struct {
+		name      string
+		regions   []ByteRange
+		wantDelta bool
+	}{
+		{name: "none"},
+		{name: "one", regions: []ByteRange{{Start: 0, End: 99}}},
+		{name: "below the threshold", regions: many[:compactRegionsThreshold-1]},
+		{name: "at the threshold", regions: many[:compactRegionsThreshold], wantDelta: true},
+		{name: "many", regions: many, wantDelta: true},
+		{name: "large offsets", regions: append(append([]ByteRange{}, many[:compactRegionsThreshold]...), ByteRange{Start: 1 << 40, End: 1<<41 - 1}), wantDelta: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(UploadMetadata{UploadId: "id", Regions: tc.regions})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var raw map[string]json.RawMessage
+			if err := json.Unmarshal(data, &raw); err != nil {
+				t.Fatal(err)
+			}
+			_, hasDelta := raw["regions_delta"]
+			_, hasArray := raw["regions"]
+			if hasDelta != tc.wantDelta || (hasArray && tc.wantDelta) {
+				t.Errorf("regions_delta %t and regions %t, want regions_delta %t", hasDelta, hasArray, tc.wantDelta)
+			}
+
+			var meta UploadMetadata
+			if err := json.Unmarshal(data, &meta); err != nil {
+				t.Fatal(err)
+			}
+			if meta.UploadId != "id" || !reflect.DeepEqual(meta.Regions, tc.regions) {
+				t.Errorf("got %s %v, want regions %v", meta.UploadId, meta.Regions, tc.regions)
+			}
+		})
+	}
+
+	for _, encoded := range []string{"!", "gA", "AA"} {
+		var meta UploadMetadata
+		if err := json.Unmarshal([]byte(`{"regions_delta": "`+encoded+`"}`), &meta); err == nil {
+			t.Errorf("regions_delta %q decoded to %v", encoded, meta.Regions)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package chunkeduploader
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// generationETag returns the ETag of the status of an upload at a given generation.
+func generationETag(generation int64) string {
+	return strconv.Quote("g" + strconv.FormatInt(generation, 10))
+}
+
+// setGenerationHeader sets X-Upload-Generation to the current generation of a given upload and returns it, it is
+// zero when the upload has no metadata.
+func (c *ChunkedUploaderHandler) setGenerationHeader(w http.ResponseWriter, uploadId string) int64 {
+	meta, err := c.service.readMetadata(uploadId)
+	if err != nil {
+		return 0
+	}
+
+	w.Header().Set("X-Upload-Generation", strconv.FormatInt(meta.Generation, 10))
+	return meta.Generation
+}
@@ -0,0 +1,188 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// getStatus returns the status of an upload together with its ETag.
+func getStatus(t *testing.T, handler http.Handler, uploadId string) (UploadStatus, string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+uploadId+"/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: %d %s", rec.Code, rec.Body)
+	}
+	var status UploadStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status, rec.Header().Get("ETag")
+}
+
+func TestUploadGeneration(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	service := newTestService(fs, WithDestinationRoot("/final"))
+	handler := NewHTTPHandler(service)
+
+	data := randomBytes(t, 1000)
+	uploadId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func(t *testing.T) *httptest.ResponseRecorder
+	}{
+		{"first chunk", func(t *testing.T) *httptest.ResponseRecorder {
+			return postChunk(handler, uploadId, "application/octet-stream", "bytes=0-499", data[:500])
+		}},
+		{"second chunk", func(t *testing.T) *httptest.ResponseRecorder {
+			return postChunk(handler, uploadId, "application/octet-stream", "bytes=500-999", data[500:])
+		}},
+		{"tag", func(t *testing.T) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/"+uploadId+"/tag", strings.NewReader(`{"key": "env", "value": "prod"}`)))
+			return rec
+		}},
+		{"finish", func(t *testing.T) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/"+uploadId+"/finish", strings.NewReader(fmt.Sprintf(`{"checksum": %q}`, sha256Hex(data)))))
+			return rec
+		}},
+		{"move", func(t *testing.T) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("MOVE", "/"+uploadId, nil)
+			req.Header.Set("Destination", "/file")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}},
+	} {
+		before, beforeETag := getStatus(t, handler, uploadId)
+
+		rec := tc.change(t)
+		if rec.Code >= 300 {
+			t.Fatalf("%s: %d %s", tc.name, rec.Code, rec.Body)
+		}
+
+		after, afterETag := getStatus(t, handler, uploadId)
+		if after.Generation <= before.Generation {
+			t.Errorf("%s: generation %d after %d", tc.name, after.Generation, before.Generation)
+		}
+		if want := fmt.Sprintf(`"g%d"`, after.Generation); afterETag != want {
+			t.Errorf("%s: ETag %s, want %s", tc.name, afterETag, want)
+		}
+		if afterETag == beforeETag {
+			t.Errorf("%s: ETag %s did not change", tc.name, afterETag)
+		}
+		// chunks tell pollers the generation to expect
+		if header := rec.Header().Get("X-Upload-Generation"); strings.HasSuffix(tc.name, "chunk") && header != strconv.FormatInt(after.Generation, 10) {
+			t.Errorf("%s: X-Upload-Generation %q, want %d", tc.name, header, after.Generation)
+		}
+	}
+
+	// the generation is kept in the metadata, so it does not go backwards across restarts
+	status, _ := getStatus(t, handler, uploadId)
+	restartedService := newTestService(fs, WithDestinationRoot("/final"))
+	restarted := NewHTTPHandler(restartedService)
+	if got, _ := getStatus(t, restarted, uploadId); got.Generation != status.Generation {
+		t.Errorf("generation %d after the restart, want %d", got.Generation, status.Generation)
+	}
+	if err := restartedService.AddTag(context.Background(), uploadId, "env", "dev"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := getStatus(t, restarted, uploadId); got.Generation <= status.Generation {
+		t.Errorf("generation %d after a change following the restart, want more than %d", got.Generation, status.Generation)
+	}
+}
+
+func TestStatusNotModified(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	uploadId, err := service.CreateUpload(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, stale := getStatus(t, handler, uploadId)
+	uploadOverHTTP(t, handler, uploadId, randomBytes(t, 500), 500)
+	_, current := getStatus(t, handler, uploadId)
+
+	for _, tc := range []struct {
+		ifNoneMatch string
+		want        int
+	}{
+		{current, http.StatusNotModified},
+		{"W/" + current, http.StatusNotModified},
+		{stale + ", " + current, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{stale, http.StatusOK},
+		{"", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/"+uploadId+"/status", nil)
+		if tc.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tc.ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("If-None-Match %s: got %d, want %d", tc.ifNoneMatch, rec.Code, tc.want)
+		}
+		if etag := rec.Header().Get("ETag"); etag != current {
+			t.Errorf("If-None-Match %s: ETag %s, want %s", tc.ifNoneMatch, etag, current)
+		}
+		if rec.Code == http.StatusNotModified && rec.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: 304 with a body", tc.ifNoneMatch)
+		}
+	}
+}
+
+func TestChunkAckGeneration(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	uploadId, err := service.CreateUpload(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var previous int64
+	for offset := 0; offset < 1000; offset += 250 {
+		req := httptest.NewRequest(http.MethodPost, "/"+uploadId+"/upload", strings.NewReader(strings.Repeat("x", 250)))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+249))
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("chunk at %d: %d %s", offset, rec.Code, rec.Body)
+		}
+
+		var ack ChunkAck
+		if err := json.NewDecoder(rec.Body).Decode(&ack); err != nil {
+			t.Fatal(err)
+		}
+		if ack.Generation <= previous || strconv.FormatInt(ack.Generation, 10) != rec.Header().Get("X-Upload-Generation") {
+			t.Errorf("chunk at %d: generation %d with header %q after %d", offset, ack.Generation, rec.Header().Get("X-Upload-Generation"), previous)
+		}
+		previous = ack.Generation
+	}
+
+	status, err := service.GetUploadStatus(context.Background(), uploadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Generation != previous {
+		t.Errorf("status generation %d, want %d of the last chunk", status.Generation, previous)
+	}
+}
@@ -1,12 +1,17 @@
 module github.com/Craftserve/chunked-uploader
 
-go 1.20
+go 1.21
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/robfig/cron/v3 v3.0.1 // indirect
-	github.com/spf13/afero v1.11.0 // indirect
-	golang.org/x/sync v0.6.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/mux v1.8.1
+	github.com/gorilla/websocket v1.5.1
+	github.com/spf13/afero v1.11.0
+	go.uber.org/goleak v1.3.0
+	golang.org/x/image v0.18.0
+)
+
+require (
+	golang.org/x/net v0.19.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 )
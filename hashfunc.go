@@ -0,0 +1,54 @@
+package chunkeduploader
+
+import (
+	"context"
+	"hash"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+	"github.com/spf13/afero"
+)
+
+// WithHashFunc makes the service verify finished uploads with a hash created by newHash, like SHA-1, SHA-512 or a
+// BLAKE3 implementation, instead of one of the built-in algorithms. The hash is known by a given name, which becomes
+// the default algorithm of the service and is what finish requests pass as their algorithm to use it, a request
+// naming any other unknown algorithm is rejected. The checksums are hex encoded. The chunk checksums returned in
+// X-Checksum and compared by VerifyResume stay SHA-256, so clients need no knowledge of the hash to resume. It cannot
+// be combined with WithChecksumAlgorithm.
+func WithHashFunc(name utils.ChecksumAlgorithm, newHash func() hash.Hash) ChunkedUploaderServiceOption {
+	if name == "" || name.Valid() {
+		panic("chunkeduploader: hash function needs a name other than the built-in algorithms, got " + string(name))
+	}
+	if newHash == nil {
+		panic("chunkeduploader: hash function must not be nil")
+	}
+
+	return func(c *ChunkedUploaderService) {
+		if c.checksumAlgorithm != utils.ChecksumSHA256 && c.hashFunc == nil {
+			panic("chunkeduploader: WithHashFunc cannot be combined with WithChecksumAlgorithm")
+		}
+		c.checksumAlgorithm = name
+		c.hashFunc = newHash
+	}
+}
+
+// supportsAlgorithm reports whether checksums of a given algorithm can be verified, which are the built-in
+// algorithms and the one of WithHashFunc.
+func (c *ChunkedUploaderService) supportsAlgorithm(algorithm utils.ChecksumAlgorithm) bool {
+	return algorithm.Valid() || c.customAlgorithm(algorithm)
+}
+
+// customAlgorithm reports whether a given algorithm is the one of WithHashFunc.
+func (c *ChunkedUploaderService) customAlgorithm(algorithm utils.ChecksumAlgorithm) bool {
+	return c.hashFunc != nil && algorithm == c.checksumAlgorithm
+}
+
+// computeCustomChecksum computes the checksum of a file on a given filesystem with the hash of WithHashFunc.
+func (c *ChunkedUploaderService) computeCustomChecksum(ctx context.Context, fs afero.Fs, path string) (string, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return utils.ComputeReaderHash(ctx, utils.NewThrottledReader(ctx, file, c.backgroundReadRate()), c.hashFunc)
+}
@@ -0,0 +1,154 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Craftserve/chunked-uploader/pkg/client"
+	"github.com/Craftserve/chunked-uploader/utils"
+	"github.com/spf13/afero"
+)
+
+const testHashName utils.ChecksumAlgorithm = "sha512"
+
+func sha512Hex(data []byte) string {
+	sum := sha512.Sum512(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// uploadOverHTTP sends data in chunks of a given size and returns the X-Checksum of every chunk.
+func uploadOverHTTP(t *testing.T, handler http.Handler, uploadId string, data []byte, chunkSize int) []string {
+	t.Helper()
+
+	var checksums []string
+	for offset := 0; offset < len(data); offset += chunkSize {
+		chunk := data[offset:min(offset+chunkSize, len(data))]
+		rec := postChunk(handler, uploadId, "application/octet-stream", fmt.Sprintf("bytes=%d-%d", offset, offset+len(chunk)-1), chunk)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("chunk at %d: %d %s", offset, rec.Code, rec.Body)
+		}
+		checksums = append(checksums, rec.Header().Get("X-Checksum"))
+	}
+	return checksums
+}
+
+func TestHashFunc(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		opts      []ChunkedUploaderServiceOption
+		algorithm utils.ChecksumAlgorithm
+		checksum  func([]byte) string
+	}{
+		{name: "default", checksum: sha256Hex},
+		{name: "custom", opts: []ChunkedUploaderServiceOption{WithHashFunc(testHashName, sha512.New)}, checksum: sha512Hex},
+		{name: "built-in next to custom", opts: []ChunkedUploaderServiceOption{WithHashFunc(testHashName, sha512.New)}, algorithm: utils.ChecksumSHA256, checksum: sha256Hex},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs(), tc.opts...)
+			data := randomBytes(t, 3000)
+
+			uploadId, err := service.CreateUpload(int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checksums := uploadOverHTTP(t, NewHTTPHandler(service), uploadId, data, 1000)
+			for i, checksum := range checksums {
+				if want := sha256Hex(data[i*1000 : (i+1)*1000]); checksum != want {
+					t.Errorf("X-Checksum of chunk %d: got %s, want its SHA-256 %s", i, checksum, want)
+				}
+			}
+
+			algorithm := tc.algorithm
+			if algorithm == "" {
+				algorithm = service.checksumAlgorithm
+			}
+			if _, err := service.FinishUploadWithAlgorithm(context.Background(), uploadId, tc.checksum(data), algorithm); err != nil {
+				t.Errorf("finish with %s: %v", algorithm, err)
+			}
+		})
+	}
+}
+
+func TestHashFuncRejectsOtherChecksum(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs(), WithHashFunc(testHashName, sha512.New))
+	data := randomBytes(t, 1000)
+
+	uploadId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the SHA-256 of the file is not its checksum under the custom hash
+	if _, err := service.FinishUpload(context.Background(), uploadId, sha256Hex(data)); err == nil {
+		t.Error("finish with the SHA-256 under a custom hash succeeded")
+	}
+	if _, err := service.FinishUploadWithAlgorithm(context.Background(), uploadId, sha512Hex(data), "blake3"); err == nil {
+		t.Error("finish with an unknown algorithm succeeded")
+	}
+}
+
+func TestVerifyResumeUnderHashFunc(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs(), WithHashFunc(testHashName, sha512.New))
+	handler := NewHTTPHandler(service)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	data := randomBytes(t, 4000)
+
+	uploadId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadOverHTTP(t, handler, uploadId, data, 1000)
+
+	c := client.Client{Endpoint: server.URL, ChunkSize: 1000, UploadId: &uploadId, DoRequest: http.DefaultClient.Do}
+	chunks, err := c.LastChunkHashes(bytes.NewReader(data), int64(len(data)), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.VerifyResume(context.Background(), chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range resp.Chunks {
+		if !chunk.Match {
+			t.Errorf("chunk %d-%d does not match", chunk.Start, chunk.End)
+		}
+	}
+	if resp.Committed != int64(len(data)) {
+		t.Errorf("committed %d after resume, want %d", resp.Committed, len(data))
+	}
+}
+
+func TestHashFuncValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func()
+	}{
+		{"built-in name", func() { WithHashFunc(utils.ChecksumSHA256, sha512.New) }},
+		{"nil hash", func() { WithHashFunc(testHashName, nil) }},
+		{"checksum algorithm after hash func", func() {
+			newTestService(afero.NewMemMapFs(), WithHashFunc(testHashName, sha512.New), WithChecksumAlgorithm(utils.ChecksumCRC32C))
+		}},
+		{"hash func after checksum algorithm", func() {
+			newTestService(afero.NewMemMapFs(), WithChecksumAlgorithm(utils.ChecksumCRC32C), WithHashFunc(testHashName, sha512.New))
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("invalid option did not panic")
+				}
+			}()
+			tc.setup()
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package chunkeduploader
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var IdempotencyKeyConflictError = errors.New("idempotency key was used with different parameters")
+var InvalidIdempotencyKeyError = errors.New("invalid idempotency key")
+
+const (
+	defaultIdempotencyWindow = 24 * time.Hour
+	// maxIdempotencyKeys is the number of keys remembered per owner, the oldest ones are forgotten first.
+	maxIdempotencyKeys   = 1000
+	maxIdempotencyKeyLen = 255
+)
+
+// WithIdempotencyWindow sets how long the idempotency keys of created uploads are remembered, 24 hours by default.
+func WithIdempotencyWindow(window time.Duration) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.idempotency.window = window
+	}
+}
+
+// withIdempotencyKey records the idempotency key an upload was created with, see CreateUploadIdempotent.
+func withIdempotencyKey(key string) CreateUploadOption {
+	return func(m *UploadMetadata) {
+		m.IdempotencyKey = key
+	}
+}
+
+// idempotencyKeys maps the idempotency keys of each owner to the uploads created with them. The keys are persisted
+// in the metadata of the uploads, so they survive a restart and are forgotten together with the uploads.
+type idempotencyKeys struct {
+	window time.Duration
+
+	mu     sync.Mutex
+	loaded bool
+	owners map[string]map[string]idempotencyEntry
+}
+
+type idempotencyEntry struct {
+	uploadId  string
+	createdAt time.Time
+}
+
+// CreateUploadIdempotent creates an upload like CreateUpload, unless the owner already created one with the same
+// idempotency key within the idempotency window. In that case the existing upload id is returned with created set to
+// false, or IdempotencyKeyConflictError if it was created with a different file size.
+func (c *ChunkedUploaderService) CreateUploadIdempotent(fileSize int64, key string, opts ...CreateUploadOption) (uploadId string, created bool, err error) {
+	if key == "" || len(key) > maxIdempotencyKeyLen {
+		return "", false, fmt.Errorf("ChunkedUploaderService.CreateUploadIdempotent %w", InvalidIdempotencyKeyError)
+	}
+
+	options := &UploadMetadata{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	owner := options.Owner
+
+	// concurrent requests with the same key wait for the first one
+	unlock := c.locks.lock("idempotency:" + owner + ":" + key)
+	defer unlock()
+
+	uploadId, err = c.lookupIdempotencyKey(owner, key)
+	if err != nil {
+		return "", false, fmt.Errorf("ChunkedUploaderService.CreateUploadIdempotent failed to load idempotency keys %w", err)
+	}
+	if uploadId != "" {
+		meta, err := c.readMetadata(uploadId)
+		if err == nil {
+			if meta.FileSize != fileSize {
+				return "", false, fmt.Errorf("ChunkedUploaderService.CreateUploadIdempotent %w", IdempotencyKeyConflictError)
+			}
+			return uploadId, false, nil
+		}
+		if !errors.Is(err, UploadNotFoundError) {
+			return "", false, fmt.Errorf("ChunkedUploaderService.CreateUploadIdempotent failed to read metadata %w", err)
+		}
+		// the upload is gone and its key with it
+	}
+
+	uploadId, err = c.CreateUpload(fileSize, append(opts, withIdempotencyKey(key))...)
+	if err != nil {
+		return "", false, err
+	}
+	c.rememberIdempotencyKey(owner, key, idempotencyEntry{uploadId: uploadId, createdAt: time.Now()})
+
+	return uploadId, true, nil
+}
+
+// lookupIdempotencyKey returns the upload created by an owner with a given key within the window, or an empty string.
+func (c *ChunkedUploaderService) lookupIdempotencyKey(owner string, key string) (string, error) {
+	k := &c.idempotency
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	err := c.loadIdempotencyKeys()
+	if err != nil {
+		return "", err
+	}
+
+	entry, ok := k.owners[owner][key]
+	if !ok {
+		return "", nil
+	}
+	if time.Since(entry.createdAt) > c.idempotencyWindow() {
+		delete(k.owners[owner], key)
+		return "", nil
+	}
+
+	return entry.uploadId, nil
+}
+
+func (c *ChunkedUploaderService) rememberIdempotencyKey(owner string, key string, entry idempotencyEntry) {
+	k := &c.idempotency
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys := k.owners[owner]
+	if keys == nil {
+		keys = make(map[string]idempotencyEntry)
+		k.owners[owner] = keys
+	}
+	keys[key] = entry
+
+	if len(keys) > maxIdempotencyKeys {
+		oldest := make([]string, 0, len(keys))
+		for key := range keys {
+			oldest = append(oldest, key)
+		}
+		sort.Slice(oldest, func(i, j int) bool {
+			return keys[oldest[i]].createdAt.Before(keys[oldest[j]].createdAt)
+		})
+		for _, key := range oldest[:len(keys)-maxIdempotencyKeys] {
+			delete(keys, key)
+		}
+	}
+}
+
+// loadIdempotencyKeys reconstructs the keys from the metadata of the uploads once before the first use. It must be
+// called with the keys locked.
+func (c *ChunkedUploaderService) loadIdempotencyKeys() error {
+	k := &c.idempotency
+	if k.loaded {
+		return nil
+	}
+
+	owners := make(map[string]map[string]idempotencyEntry)
+	err := c.walkMetadata(func(meta *UploadMetadata) error {
+		if meta.IdempotencyKey == "" || time.Since(meta.CreatedAt) > c.idempotencyWindow() {
+			return nil
+		}
+		if owners[meta.Owner] == nil {
+			owners[meta.Owner] = make(map[string]idempotencyEntry)
+		}
+		owners[meta.Owner][meta.IdempotencyKey] = idempotencyEntry{uploadId: meta.UploadId, createdAt: meta.CreatedAt}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	k.owners = owners
+	k.loaded = true
+	return nil
+}
+
+func (c *ChunkedUploaderService) idempotencyWindow() time.Duration {
+	if c.idempotency.window > 0 {
+		return c.idempotency.window
+	}
+	return defaultIdempotencyWindow
+}
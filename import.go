@@ -0,0 +1,276 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var ImportsDisabledError = errors.New("imports are not enabled")
+var ImportPathNotAllowedError = errors.New("import path is outside of the import root")
+
+// maxImportSymlinks is the number of symlinks followed when resolving an import path, like the limit of the kernel.
+const maxImportSymlinks = 40
+
+// WithImportRoot allows ImportFile to take over files below a given directory of the service filesystem.
+func WithImportRoot(root string) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.importRoot = filepath.Clean(root)
+	}
+}
+
+type ImportOptions struct {
+	Filename    string
+	ContentType string
+	Tags        map[string]string
+	// Copy leaves the source file in place, by default it is moved.
+	Copy bool
+	// Checksum is the expected checksum of the file, the import fails if it does not match.
+	Checksum string
+}
+
+// ImportFile registers an existing file as a finished upload. The file must be a regular file below the import
+// root, also when symlinks on its path are followed, it is moved or copied into the pending directory and verified
+// like a regular upload.
+func (c *ChunkedUploaderService) ImportFile(ctx context.Context, srcPath string, opts ImportOptions) (uploadId string, err error) {
+	srcPath, err = c.confineImportPath(srcPath)
+	if err == nil {
+		srcPath, err = c.resolveImportPath(srcPath)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("ChunkedUploaderService.ImportFile %w", UploadNotFoundError)
+	}
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.ImportFile %w", err)
+	}
+
+	info, err := lstat(c.fs, srcPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("ChunkedUploaderService.ImportFile %w", UploadNotFoundError)
+		}
+		return "", fmt.Errorf("ChunkedUploaderService.ImportFile failed to stat source %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("ChunkedUploaderService.ImportFile %s is not a regular file", srcPath)
+	}
+
+	fields := &UploadMetadata{Filename: opts.Filename, ContentType: opts.ContentType, Tags: opts.Tags}
+	if fields.Filename == "" {
+		fields.Filename = filepath.Base(srcPath)
+	}
+	err = fields.sanitize()
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.ImportFile %w", err)
+	}
+
+	checksum, err := c.computeChecksum(ctx, srcPath)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.ImportFile failed to compute checksum %w", err)
+	}
+	if opts.Checksum != "" && opts.Checksum != checksum {
+		return "", fmt.Errorf("ChunkedUploaderService.ImportFile %w - expected: %s, got: %s", FileChecksumMismatchError, opts.Checksum, checksum)
+	}
+
+	uploadId = c.generateUploadId()
+	dstPath := c.getUploadFilePath(uploadId)
+
+	if opts.Copy {
+		err = copyFile(c.fs, srcPath, dstPath)
+	} else {
+		err = c.fs.MkdirAll(filepath.Dir(dstPath), StandardAccess)
+		if err == nil {
+			err = c.fs.Rename(srcPath, dstPath)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.ImportFile failed to take over file %w", err)
+	}
+
+	meta := &UploadMetadata{
+		UploadId:    uploadId,
+		CreatedAt:   time.Now(),
+		State:       UploadStateComplete,
+		FileSize:    info.Size(),
+		Filename:    fields.Filename,
+		ContentType: fields.ContentType,
+		Tags:        fields.Tags,
+		Checksum:    checksum,
+	}
+	if info.Size() > 0 {
+		meta.Regions = []ByteRange{{Start: 0, End: info.Size() - 1}}
+	}
+
+	err = c.saveMetadata(meta)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.ImportFile failed to write metadata %w", err)
+	}
+	c.emit(EventUploadCreated, uploadId, map[string]string{"state": string(meta.State)})
+
+	return uploadId, nil
+}
+
+// confineImportPath cleans a given path and checks that it is below the import root and outside of the pending
+// directory and the root of the path builder.
+func (c *ChunkedUploaderService) confineImportPath(path string) (string, error) {
+	if c.importRoot == "" {
+		return "", ImportsDisabledError
+	}
+
+	path = filepath.Clean(path)
+	rel, err := filepath.Rel(c.importRoot, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ImportPathNotAllowedError
+	}
+
+	for _, root := range []string{pendingDirectory, c.paths.Root()} {
+		rel, err = filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", ImportPathNotAllowedError
+		}
+	}
+
+	return path, nil
+}
+
+// resolveImportPath follows the symlinks in every component of a path confined by confineImportPath, checking that
+// each target is confined as well, and returns the path of the file without any symlinks on it. Filesystems which
+// report symlinks but cannot read them do not allow importing through them.
+func (c *ChunkedUploaderService) resolveImportPath(path string) (string, error) {
+	rel, err := filepath.Rel(c.importRoot, path)
+	if err != nil {
+		return "", ImportPathNotAllowedError
+	}
+
+	resolved := c.importRoot
+	remaining := strings.Split(rel, string(filepath.Separator))
+	links := 0
+	for len(remaining) > 0 {
+		next := filepath.Join(resolved, remaining[0])
+		remaining = remaining[1:]
+
+		info, err := lstat(c.fs, next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		reader, ok := c.fs.(afero.LinkReader)
+		if !ok || links > maxImportSymlinks {
+			return "", ImportPathNotAllowedError
+		}
+		target, err := reader.ReadlinkIfPossible(next)
+		if err != nil {
+			return "", fmt.Errorf("failed to read symlink %w", err)
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(resolved, target)
+		}
+		target, err = c.confineImportPath(target)
+		if err != nil {
+			return "", err
+		}
+
+		// the target may contain symlinks itself, so it is walked from the import root again
+		rel, _ = filepath.Rel(c.importRoot, target)
+		remaining = append(strings.Split(rel, string(filepath.Separator)), remaining...)
+		resolved = c.importRoot
+	}
+
+	return resolved, nil
+}
+
+// lstat does not follow symlinks where the filesystem supports it, so a link cannot point an import outside of the
+// import root.
+func lstat(fs afero.Fs, path string) (os.FileInfo, error) {
+	if lstater, ok := fs.(afero.Lstater); ok {
+		info, _, err := lstater.LstatIfPossible(path)
+		return info, err
+	}
+	return fs.Stat(path)
+}
+
+func copyFile(fs afero.Fs, srcPath string, dstPath string) error {
+	src, err := fs.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := createFile(fs, dstPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		dst.Close()
+		fs.Remove(dstPath)
+		return err
+	}
+
+	return dst.Close()
+}
+
+type ImportRequest struct {
+	Path        string            `json:"path"`
+	Filename    string            `json:"filename"`
+	ContentType string            `json:"content_type"`
+	Tags        map[string]string `json:"tags"`
+	Copy        bool              `json:"copy"`
+	Checksum    string            `json:"checksum"`
+}
+
+// ImportHandler registers an existing file on the server as a finished upload, it requires admin access.
+func (c *ChunkedUploaderHandler) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.requireAdmin(w, r) {
+		return
+	}
+
+	var req ImportRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Path == "" {
+		writeJSONError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+
+	uploadId, err := c.service.ImportFile(r.Context(), req.Path, ImportOptions{
+		Filename:    req.Filename,
+		ContentType: req.ContentType,
+		Tags:        req.Tags,
+		Copy:        req.Copy,
+		Checksum:    req.Checksum,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ImportsDisabledError), errors.Is(err, ImportPathNotAllowedError):
+			writeJSONError(w, http.StatusForbidden, err.Error())
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, FileChecksumMismatchError):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, InvalidMetadataError), errors.Is(err, ProtectedMetadataKeyError):
+			writeMetadataError(w, err)
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to import file: "+err.Error())
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"upload_id": uploadId})
+}
@@ -0,0 +1,72 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// newImportTestService creates a service on a real directory, so symlinks can be created below its import root.
+func newImportTestService(t *testing.T) (*ChunkedUploaderService, string) {
+	t.Helper()
+
+	base := t.TempDir()
+	for _, dir := range []string{"import/inside", "outside"} {
+		if err := os.MkdirAll(filepath.Join(base, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"import/inside/file", "outside/secret"} {
+		if err := os.WriteFile(filepath.Join(base, file), []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	service := newTestService(afero.NewBasePathFs(afero.NewOsFs(), base), WithImportRoot("/import"))
+	return service, base
+}
+
+func TestImportFileFollowsConfinedSymlinks(t *testing.T) {
+	service, base := newImportTestService(t)
+
+	if err := os.Symlink("inside", filepath.Join(base, "import/dir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../dir/file", filepath.Join(base, "import/inside/link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/import/dir/file", "/import/inside/link"} {
+		_, err := service.ImportFile(context.Background(), path, ImportOptions{Copy: true})
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+func TestImportFileRejectsEscapingSymlinks(t *testing.T) {
+	service, base := newImportTestService(t)
+
+	links := map[string]string{
+		"import/escape":        "../outside",
+		"import/inside/secret": "../../outside/secret",
+		"import/absolute":      filepath.Join(base, "outside"),
+		"import/loop":          "loop",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(base, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, path := range []string{"/import/escape/secret", "/import/inside/secret", "/import/absolute/secret", "/import/loop/file"} {
+		_, err := service.ImportFile(context.Background(), path, ImportOptions{Copy: true})
+		if !errors.Is(err, ImportPathNotAllowedError) {
+			t.Errorf("%s: got %v, want ImportPathNotAllowedError", path, err)
+		}
+	}
+}
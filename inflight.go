@@ -0,0 +1,127 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+var InFlightBytesExceededError = errors.New("in-flight chunk bytes limit reached")
+
+// WithInFlightBytesLimit bounds the total length of the chunks being written at once across all uploads. A chunk
+// reserves its length before it is written and releases it afterwards. When the budget is exhausted the chunk waits
+// for other chunks to finish if wait is set, otherwise it is rejected with 429. A chunk larger than the whole budget
+// waits for all other chunks instead of being rejected forever. Chunks must state their length, in the Range header,
+// the offset and length query parameters of WithQueryParameters or with Content-Length, chunks without it are rejected
+// with 411.
+func WithInFlightBytesLimit(limit int64, wait bool) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.inFlightBytes = &inFlightBytes{limit: limit, wait: wait, released: make(chan struct{})}
+	}
+}
+
+type inFlightBytes struct {
+	limit int64
+	wait  bool
+
+	mu       sync.Mutex
+	reserved int64
+	// released is closed and replaced whenever bytes are released.
+	released chan struct{}
+}
+
+// reserveInFlightBytes reserves n bytes of the in-flight budget, the returned function releases them.
+func (c *ChunkedUploaderService) reserveInFlightBytes(ctx context.Context, n int64) (release func(), err error) {
+	b := c.inFlightBytes
+	if b == nil {
+		return func() {}, nil
+	}
+	if n > b.limit {
+		n = b.limit
+	}
+
+	for {
+		b.mu.Lock()
+		if b.reserved+n <= b.limit {
+			b.reserved += n
+			b.mu.Unlock()
+			return func() { b.release(n) }, nil
+		}
+		released := b.released
+		b.mu.Unlock()
+
+		if !b.wait {
+			return nil, InFlightBytesExceededError
+		}
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (b *inFlightBytes) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.reserved -= n
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// InFlightBytes returns the bytes reserved by chunks being written and the limit, both are zero without a limit.
+func (c *ChunkedUploaderService) InFlightBytes() (reserved int64, limit int64) {
+	b := c.inFlightBytes
+	if b == nil {
+		return 0, 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reserved, b.limit
+}
+
+// chunkLength returns the length of a chunk request from its range, given by the Range header or the query
+// parameters, or its Content-Length, -1 when it is unknown.
+func (c *ChunkedUploaderHandler) chunkLength(r *http.Request) int64 {
+	if !isJSONRequest(r) {
+		params, err := c.parseChunkParameters(r)
+		if err == nil && params.rangeHeader != "" {
+			start, end, err := parseRangeHeader(params.rangeHeader)
+			if err == nil && end != -1 {
+				return end - start + 1
+			}
+		}
+	}
+	return r.ContentLength
+}
+
+// admitChunk reserves the length of a chunk request against the in-flight budget, it writes the error response and
+// returns false when the chunk is not admitted.
+func (c *ChunkedUploaderHandler) admitChunk(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	if c.service.inFlightBytes == nil {
+		return func() {}, true
+	}
+
+	length := c.chunkLength(r)
+	if length < 0 {
+		writeJSONError(w, http.StatusLengthRequired, "chunk length is required, send a Range header with an end, a length or Content-Length")
+		return nil, false
+	}
+
+	release, err := c.service.reserveInFlightBytes(r.Context(), length)
+	if err != nil {
+		if timedOut(r) {
+			writeTimeoutError(w, HandlerUploadChunk)
+			return nil, false
+		}
+		w.Header().Set("Retry-After", "1")
+		writeJSONError(w, http.StatusTooManyRequests, InFlightBytesExceededError.Error())
+		return nil, false
+	}
+
+	return release, true
+}
@@ -0,0 +1,70 @@
+package chunkeduploader
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestChunkLength(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		queryParameters bool
+		query           string
+		headers         map[string]string
+		contentLength   int64
+		want            int64
+	}{
+		{name: "range header", headers: map[string]string{"Range": "bytes=10-19"}, contentLength: -1, want: 10},
+		{name: "content length", contentLength: 7, want: 7},
+		{name: "unknown", contentLength: -1, want: -1},
+		{name: "open range", headers: map[string]string{"Range": "bytes=10-"}, contentLength: 7, want: 7},
+		{name: "query", queryParameters: true, query: "offset=10&length=5", contentLength: -1, want: 5},
+		{name: "query open range", queryParameters: true, query: "offset=10", contentLength: 7, want: 7},
+		{name: "query without the option", query: "offset=10&length=5", contentLength: -1, want: -1},
+		{name: "header takes precedence", queryParameters: true, query: "offset=10&length=5", headers: map[string]string{"Range": "bytes=0-1"}, contentLength: -1, want: 2},
+		{name: "invalid query", queryParameters: true, query: "length=5", contentLength: 7, want: 7},
+		{name: "json chunk", headers: map[string]string{"Range": "bytes=10-19", "Content-Type": "application/json"}, contentLength: 30, want: 30},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []ChunkedUploaderHandlerOption
+			if tc.queryParameters {
+				opts = append(opts, WithQueryParameters())
+			}
+			handler := NewChunkedUploaderHandler(newTestService(afero.NewMemMapFs()), opts...)
+
+			r := httptest.NewRequest(http.MethodPost, "/id/upload?"+tc.query, nil)
+			for key, value := range tc.headers {
+				r.Header.Set(key, value)
+			}
+			r.ContentLength = tc.contentLength
+
+			if got := handler.chunkLength(r); got != tc.want {
+				t.Errorf("got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInFlightBytesLimitWithQueryParameters(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs(), WithInFlightBytesLimit(1<<20, false))
+	handler := NewHTTPHandler(service, WithQueryParameters())
+
+	uploadId, err := service.CreateUpload(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a body of unknown length, so only the query parameters tell the length of the chunk
+	body := io.MultiReader(strings.NewReader("data"))
+	req := httptest.NewRequest(http.MethodPost, "/"+uploadId+"/upload?offset=0&length=4", body)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("chunk: %d %s, want 200", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,211 @@
+package chunkeduploader
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// infoFormats are the media types GetUploadInfoHandler serves, the first one is the default.
+var infoFormats = []struct {
+	mediaType string
+	write     func(w io.Writer, status *UploadStatus, params map[string]string) error
+}{
+	{"application/json", writeInfoJSON},
+	{"text/plain", writeInfoText},
+	{"text/csv", writeInfoCSV},
+	{"application/xml", writeInfoXML},
+}
+
+// infoField is a single field of the upload information, every format carries the same fields in the same order.
+type infoField struct {
+	name  string
+	value string
+}
+
+// uploadInfoFields flattens the status of an upload for the formats without nesting, the fields of an absent policy
+// and remaining duration are empty.
+func uploadInfoFields(status *UploadStatus) []infoField {
+	fields := []infoField{
+		{"upload_id", status.UploadId},
+		{"state", string(status.State)},
+		{"mode", string(status.Mode)},
+		{"file_size", strconv.FormatInt(status.FileSize, 10)},
+		{"length", strconv.FormatInt(status.Length, 10)},
+		{"sequence", strconv.FormatInt(status.Sequence, 10)},
+		{"generation", strconv.FormatInt(status.Generation, 10)},
+		{"failure_reason", status.FailureReason},
+		{"source", status.Source},
+		{"policy_retention", ""},
+		{"policy_max_file_size", ""},
+		{"policy_chunk_size", ""},
+		{"remaining_seconds", ""},
+	}
+	if status.Policy != nil {
+		fields[9].value = status.Policy.Retention.String()
+		fields[10].value = strconv.FormatInt(status.Policy.MaxFileSize, 10)
+		fields[11].value = strconv.FormatInt(status.Policy.ChunkSize, 10)
+	}
+	if status.RemainingSeconds != nil {
+		fields[12].value = strconv.FormatInt(*status.RemainingSeconds, 10)
+	}
+	return fields
+}
+
+// writeInfoJSON writes the same body as StatusHandler.
+func writeInfoJSON(w io.Writer, status *UploadStatus, params map[string]string) error {
+	return json.NewEncoder(w).Encode(status)
+}
+
+// writeInfoText writes one "name: value" line per field, for reading the information in a terminal.
+func writeInfoText(w io.Writer, status *UploadStatus, params map[string]string) error {
+	fields := uploadInfoFields(status)
+	width := 0
+	for _, field := range fields {
+		width = max(width, len(field.name))
+	}
+
+	for _, field := range fields {
+		_, err := fmt.Fprintf(w, "%-*s %s\n", width+1, field.name+":", field.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeInfoCSV writes the fields as a single CSV row, preceded by a row of their names with the header=present
+// parameter of RFC 4180.
+func writeInfoCSV(w io.Writer, status *UploadStatus, params map[string]string) error {
+	fields := uploadInfoFields(status)
+	names := make([]string, len(fields))
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		names[i], values[i] = field.name, field.value
+	}
+
+	writer := csv.NewWriter(w)
+	if params["header"] == "present" {
+		writer.Write(names)
+	}
+	writer.Write(values)
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeInfoXML writes the fields as the child elements of an upload element.
+func writeInfoXML(w io.Writer, status *UploadStatus, params map[string]string) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	upload := xml.StartElement{Name: xml.Name{Local: "upload"}}
+	err = encoder.EncodeToken(upload)
+	if err != nil {
+		return err
+	}
+	for _, field := range uploadInfoFields(status) {
+		err = encoder.EncodeElement(field.value, xml.StartElement{Name: xml.Name{Local: field.name}})
+		if err != nil {
+			return err
+		}
+	}
+	err = encoder.EncodeToken(upload.End())
+	if err != nil {
+		return err
+	}
+	return encoder.Flush()
+}
+
+// negotiateInfoFormat picks the index of the format in infoFormats preferred by an Accept header together with the
+// parameters of the accepted media type. It returns -1 when none is acceptable, an empty header accepts JSON.
+func negotiateInfoFormat(accept string) (int, map[string]string) {
+	if strings.TrimSpace(accept) == "" {
+		return 0, nil
+	}
+
+	best, bestQuality, bestExact := -1, 0.0, false
+	var bestParams map[string]string
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		for i, format := range infoFormats {
+			if !mediaTypeMatches(mediaType, format.mediaType) {
+				continue
+			}
+			// an exact type wins over a wildcard of the same quality
+			exact := mediaType == format.mediaType
+			if quality > bestQuality || (quality > 0 && quality == bestQuality && exact && !bestExact) {
+				best, bestQuality, bestExact, bestParams = i, quality, exact, params
+			}
+			break
+		}
+	}
+	return best, bestParams
+}
+
+// mediaTypeMatches reports whether an accepted media type, which may be a wildcard like */* or text/*, matches a
+// given media type.
+func mediaTypeMatches(accepted string, mediaType string) bool {
+	if accepted == "*/*" || accepted == mediaType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(accepted, "/*")
+	return ok && strings.HasPrefix(mediaType, prefix+"/")
+}
+
+// GetUploadInfoHandler returns the status of a given uploadId like StatusHandler in the format chosen by the Accept
+// header: JSON, plain text, a CSV row or XML. Wildcards and requests without Accept get JSON.
+func (c *ChunkedUploaderHandler) GetUploadInfoHandler(w http.ResponseWriter, r *http.Request) {
+	uploadId := mux.Vars(r)["upload_id"]
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	index, params := negotiateInfoFormat(r.Header.Get("Accept"))
+	if index == -1 {
+		writeJSONError(w, http.StatusNotAcceptable, "supported formats are application/json, text/plain, text/csv and application/xml")
+		return
+	}
+	format := infoFormats[index]
+
+	status, err := c.service.GetUploadStatus(r.Context(), uploadId)
+	if err != nil {
+		if errors.Is(err, UploadNotFoundError) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get status: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", format.mediaType+"; charset=utf-8")
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+	err = format.write(w, status, params)
+	if err != nil {
+		c.service.log(LogLevelWarn, "Failed to write upload info", LogField{"upload_id", uploadId}, LogField{"error", err})
+	}
+}
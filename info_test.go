@@ -0,0 +1,181 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// parseInfo reads the fields of the upload information in a given media type.
+func parseInfo(t *testing.T, mediaType string, body string) map[string]string {
+	t.Helper()
+
+	fields := map[string]string{}
+	switch mediaType {
+	case "application/json":
+		var status UploadStatus
+		if err := json.Unmarshal([]byte(body), &status); err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range uploadInfoFields(&status) {
+			fields[field.name] = field.value
+		}
+	case "text/plain":
+		scanner := bufio.NewScanner(strings.NewReader(body))
+		for scanner.Scan() {
+			name, value, ok := strings.Cut(scanner.Text(), ":")
+			if !ok {
+				t.Fatalf("line %q", scanner.Text())
+			}
+			fields[name] = strings.TrimSpace(value)
+		}
+	case "text/csv":
+		rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 2 {
+			t.Fatalf("%d rows, want a header and a row", len(rows))
+		}
+		for i, name := range rows[0] {
+			fields[name] = rows[1][i]
+		}
+	case "application/xml":
+		decoder := xml.NewDecoder(strings.NewReader(body))
+		var name string
+		for {
+			token, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch token := token.(type) {
+			case xml.StartElement:
+				name = token.Name.Local
+				if name != "upload" {
+					fields[name] = ""
+				}
+			case xml.CharData:
+				if name != "" && name != "upload" {
+					fields[name] = string(token)
+				}
+			case xml.EndElement:
+				name = ""
+			}
+		}
+	default:
+		t.Fatalf("unknown media type %s", mediaType)
+	}
+	return fields
+}
+
+func TestGetUploadInfoHandler(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	data := randomBytes(t, 1000)
+	uploadId, err := service.CreateUpload(int64(len(data)), WithSource("web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadOverHTTP(t, handler, uploadId, data[:600], 300)
+
+	status, err := service.GetUploadStatus(context.Background(), uploadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{}
+	for _, field := range uploadInfoFields(status) {
+		want[field.name] = field.value
+	}
+
+	for _, tc := range []struct {
+		accept string
+		// wantType is the served media type, csv is requested with header=present to read the names of the fields
+		wantType string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/json", "application/json"},
+		{"text/plain", "text/plain"},
+		{"text/csv; header=present", "text/csv"},
+		{"application/xml", "application/xml"},
+		{"text/*", "text/plain"},
+		{"text/plain; q=0.5, text/csv; header=present", "text/csv"},
+		{"*/*; q=0.1, application/xml", "application/xml"},
+		{"*/*, text/plain", "text/plain"},
+		{"application/xml; q=0, application/json", "application/json"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/"+uploadId+"/info", nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("Accept %q: %d %s", tc.accept, rec.Code, rec.Body)
+			continue
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != tc.wantType+"; charset=utf-8" {
+			t.Errorf("Accept %q: content type %q, want %s", tc.accept, contentType, tc.wantType)
+			continue
+		}
+		if vary := rec.Header().Get("Vary"); vary != "Accept" {
+			t.Errorf("Accept %q: Vary %q, want Accept", tc.accept, vary)
+		}
+		if got := parseInfo(t, tc.wantType, rec.Body.String()); !reflect.DeepEqual(got, want) {
+			t.Errorf("Accept %q: fields %v, want %v", tc.accept, got, want)
+		}
+	}
+
+	// without header=present the CSV is a single row of values
+	req := httptest.NewRequest(http.MethodGet, "/"+uploadId+"/info", nil)
+	req.Header.Set("Accept", "text/csv")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil || len(rows) != 1 || rows[0][0] != uploadId {
+		t.Errorf("csv without header: %v %v", rows, err)
+	}
+}
+
+func TestGetUploadInfoHandlerErrors(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	uploadId, err := service.CreateUpload(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		uploadId string
+		accept   string
+		want     int
+	}{
+		{"unsupported format", uploadId, "image/png", http.StatusNotAcceptable},
+		{"every format refused", uploadId, "*/*; q=0", http.StatusNotAcceptable},
+		{"unknown upload", "doesnotexist", "application/json", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/"+tc.uploadId+"/info", nil)
+		req.Header.Set("Accept", tc.accept)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body)
+		}
+	}
+}
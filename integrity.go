@@ -0,0 +1,134 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// IntegrityReport compares the file of a finished upload with the checksum it was verified with.
+type IntegrityReport struct {
+	UploadId         string      `json:"upload_id"`
+	State            UploadState `json:"state"`
+	ComputedChecksum string      `json:"computed_checksum"`
+	StoredChecksum   string      `json:"stored_checksum"`
+	Match            bool        `json:"match"`
+	FileSize         int64       `json:"file_size"`
+	WrittenBytes     int64       `json:"written_bytes"`
+	CoveragePct      float64     `json:"coverage_pct"`
+	// SparseRegions are the parts of the file no chunk was written to.
+	SparseRegions      []ByteRange `json:"sparse_regions"`
+	LastWriteAt        *time.Time  `json:"last_write_at,omitempty"`
+	ChecksumComputedAt time.Time   `json:"checksum_computed_at"`
+}
+
+// SparseFileInfo returns the parts of the file of a finished upload which no chunk was written to.
+func (c *ChunkedUploaderService) SparseFileInfo(ctx context.Context, uploadId string) ([]ByteRange, error) {
+	size, _, err := c.GetUploadSize(ctx, uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.SparseFileInfo %w", err)
+	}
+
+	regions, err := c.GetWrittenRegions(ctx, uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.SparseFileInfo %w", err)
+	}
+
+	return missingRegions(regions, size), nil
+}
+
+// GenerateIntegrityReport recomputes the checksum of a finished upload and reports it together with the regions
+// written to it. It reads the whole file unless the checksum is cached, see ComputeAndCacheChecksum.
+func (c *ChunkedUploaderService) GenerateIntegrityReport(ctx context.Context, uploadId string) (*IntegrityReport, error) {
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GenerateIntegrityReport failed to read metadata %w", err)
+	}
+	if meta.State != UploadStateComplete {
+		return nil, fmt.Errorf("ChunkedUploaderService.GenerateIntegrityReport %w", UploadNotCompleteError)
+	}
+
+	regions, err := c.GetWrittenRegions(ctx, uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GenerateIntegrityReport %w", err)
+	}
+
+	computedAt := time.Now()
+	checksum, err := c.ComputeAndCacheChecksum(ctx, uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GenerateIntegrityReport %w", err)
+	}
+	if cached, err := c.readMetadata(uploadId); err == nil && cached.ChecksumComputedAt != nil {
+		computedAt = *cached.ChecksumComputedAt
+	}
+
+	size, _, err := c.GetUploadSize(ctx, uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GenerateIntegrityReport %w", err)
+	}
+
+	sparse, err := c.SparseFileInfo(ctx, uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GenerateIntegrityReport %w", err)
+	}
+
+	written := regionsLength(regions)
+	coverage := 100.0
+	if size > 0 {
+		coverage = float64(size-regionsLength(sparse)) / float64(size) * 100
+	}
+
+	return &IntegrityReport{
+		UploadId:           uploadId,
+		State:              meta.State,
+		ComputedChecksum:   checksum,
+		StoredChecksum:     meta.Checksum,
+		Match:              meta.Checksum != "" && checksum == meta.Checksum,
+		FileSize:           size,
+		WrittenBytes:       written,
+		CoveragePct:        coverage,
+		SparseRegions:      sparse,
+		LastWriteAt:        meta.LastWriteAt,
+		ChecksumComputedAt: computedAt,
+	}, nil
+}
+
+// IntegrityReportHandler returns the integrity report of a finished upload, it requires admin access. Any part of the
+// report failing or running over the handler timeout is answered with 503.
+func (c *ChunkedUploaderHandler) IntegrityReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.requireAdmin(w, r) {
+		return
+	}
+
+	r, cancel := c.withHandlerTimeout(w, r, HandlerIntegrityReport)
+	defer cancel()
+
+	uploadId := mux.Vars(r)["upload_id"]
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	report, err := c.service.GenerateIntegrityReport(r.Context(), uploadId)
+	if err != nil {
+		switch {
+		case timedOut(r):
+			writeTimeoutError(w, HandlerIntegrityReport)
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, UploadNotCompleteError):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		default:
+			writeJSONError(w, http.StatusServiceUnavailable, "Failed to generate integrity report: "+err.Error())
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
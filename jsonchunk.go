@@ -0,0 +1,88 @@
+package chunkeduploader
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// defaultJSONChunkLimit bounds the decoded size of a JSON chunk which has neither a closed range nor a maximum part
+// size to bound it.
+const defaultJSONChunkLimit = 16 << 20
+
+// jsonChunkOverhead is the room left in the body of a JSON chunk for everything but its data.
+const jsonChunkOverhead = 4 << 10
+
+var JSONChunkTooLargeError = errors.New("JSON chunk is too large")
+
+// JSONChunkRequest is the body of a chunk sent as JSON, for clients behind gateways which cannot pass binary bodies.
+type JSONChunkRequest struct {
+	// Data holds the chunk bytes encoded with standard base64.
+	Data     string `json:"data"`
+	Offset   *int64 `json:"offset"`
+	Checksum string `json:"checksum"`
+}
+
+// isJSONRequest reports whether the request body is declared as JSON.
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// jsonChunkBodyLimit returns the size the body of a JSON chunk may have: the encoded length of its range when it is
+// closed, else of the maximum part size or defaultJSONChunkLimit.
+func (c *ChunkedUploaderService) jsonChunkBodyLimit(rangeStart int64, rangeEnd int64) int64 {
+	length := int64(defaultJSONChunkLimit)
+	switch {
+	case rangeEnd != -1:
+		length = rangeEnd - rangeStart + 1
+	case c.maxPartSize != nil:
+		length = *c.maxPartSize
+	}
+	return int64(base64.StdEncoding.EncodedLen(int(length))) + jsonChunkOverhead
+}
+
+// decodeJSONChunk decodes a JSON encoded chunk and returns its bytes and offset. The offset from the body takes
+// precedence over the one from the Range header. When the Range header is closed the decoded length must match it
+// and when a checksum is given it must match the decoded bytes.
+func decodeJSONChunk(body io.Reader, rangeStart int64, rangeEnd int64) ([]byte, int64, error) {
+	var req JSONChunkRequest
+	err := json.NewDecoder(body).Decode(&req)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, 0, fmt.Errorf("%w: the body exceeds %d bytes", JSONChunkTooLargeError, tooLarge.Limit)
+		}
+		return nil, 0, fmt.Errorf("invalid JSON")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(req.Data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("data must be base64 encoded")
+	}
+
+	offset := rangeStart
+	if req.Offset != nil {
+		if *req.Offset < 0 {
+			return nil, 0, fmt.Errorf("offset must not be negative")
+		}
+		offset = *req.Offset
+	}
+
+	if rangeEnd != -1 && int64(len(data)) != rangeEnd-rangeStart+1 {
+		return nil, 0, fmt.Errorf("decoded data length %d does not match range length %d", len(data), rangeEnd-rangeStart+1)
+	}
+
+	if req.Checksum != "" {
+		err = verifyChunkChecksum(data, req.Checksum)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return data, offset, nil
+}
@@ -0,0 +1,117 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func postChunk(handler http.Handler, uploadId string, contentType string, rangeHeader string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/"+uploadId+"/upload", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func jsonChunk(t *testing.T, data []byte, offset int64) []byte {
+	t.Helper()
+
+	body, err := json.Marshal(JSONChunkRequest{Data: base64.StdEncoding.EncodeToString(data), Offset: &offset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestJSONChunksWriteSameBytesAsBinary(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+	data := randomBytes(t, 3000)
+
+	binaryId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for offset := 0; offset < len(data); offset += 1000 {
+		chunk := data[offset : offset+1000]
+		rangeHeader := fmt.Sprintf("bytes=%d-%d", offset, offset+len(chunk)-1)
+
+		rec := postChunk(handler, binaryId, "application/octet-stream", rangeHeader, chunk)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("binary chunk: %d %s", rec.Code, rec.Body)
+		}
+		rec = postChunk(handler, jsonId, "application/json", "", jsonChunk(t, chunk, int64(offset)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("JSON chunk: %d %s", rec.Code, rec.Body)
+		}
+	}
+
+	binary, err := afero.ReadFile(service.fs, service.getUploadFilePath(binaryId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := afero.ReadFile(service.fs, service.getUploadFilePath(jsonId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(binary, data) || !bytes.Equal(decoded, data) {
+		t.Error("binary and JSON chunks did not write the same bytes")
+	}
+}
+
+func TestJSONChunkLengthMismatch(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	uploadId, err := service.CreateUpload(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := postChunk(handler, uploadId, "application/json", "bytes=0-9", jsonChunk(t, []byte("short"), 0))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("chunk shorter than its range: %d, want 400", rec.Code)
+	}
+}
+
+func TestJSONChunkBodyLimit(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs(), WithMaxPartSize(1024))
+	handler := NewHTTPHandler(service)
+
+	uploadId, err := service.CreateUpload(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := postChunk(handler, uploadId, "application/json", "", jsonChunk(t, randomBytes(t, 1024), 0))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("chunk of the maximum part size: %d %s", rec.Code, rec.Body)
+	}
+
+	padded := `{"data": "` + base64.StdEncoding.EncodeToString(randomBytes(t, 10)) + `", "checksum": "` + strings.Repeat(" ", 8<<10) + `"}`
+	rec = postChunk(handler, uploadId, "application/json", "", []byte(padded))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: %d, want 413", rec.Code)
+	}
+
+	rec = postChunk(handler, uploadId, "application/json", "bytes=1024-1033", jsonChunk(t, randomBytes(t, 8<<10), 1024))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("body larger than its range: %d, want 413", rec.Code)
+	}
+}
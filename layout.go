@@ -0,0 +1,217 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// LayoutStrategy decides where in the pending directory the files of an upload are stored.
+type LayoutStrategy interface {
+	// RelativePath returns the path of the data file of an upload relative to the pending directory, the metadata
+	// is stored next to it.
+	RelativePath(uploadId string) string
+	// IsShard reports whether a directory at a given path relative to the pending directory holds uploads.
+	IsShard(relativeDir string) bool
+}
+
+// FlatLayout stores all uploads directly in the pending directory, it is the default.
+type FlatLayout struct{}
+
+func (FlatLayout) RelativePath(uploadId string) string {
+	return uploadId
+}
+
+func (FlatLayout) IsShard(relativeDir string) bool {
+	return false
+}
+
+// ShardedLayout spreads uploads over nested directories named after the leading characters of the upload id, so no
+// single directory grows huge. With Levels 2 and Width 2 an upload "af5d9cba-..." is stored in "af/5d/".
+type ShardedLayout struct {
+	Levels int
+	Width  int
+}
+
+func (l ShardedLayout) RelativePath(uploadId string) string {
+	parts := make([]string, 0, l.Levels+1)
+	for i := 0; i < l.Levels && (i+1)*l.Width <= len(uploadId); i++ {
+		parts = append(parts, uploadId[i*l.Width:(i+1)*l.Width])
+	}
+	return filepath.Join(append(parts, uploadId)...)
+}
+
+func (l ShardedLayout) IsShard(relativeDir string) bool {
+	parts := strings.Split(filepath.ToSlash(relativeDir), "/")
+	if len(parts) > l.Levels {
+		return false
+	}
+	for _, part := range parts {
+		if len(part) != l.Width || strings.Trim(part, "0123456789abcdef") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// WithLayout sets the layout of the pending directory. Existing uploads stored with another layout are not found
+// until they are moved with MigrateLayout. When sharded services share a storage root, give each one a namespace.
+func WithLayout(layout LayoutStrategy) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.layout = layout
+	}
+}
+
+// walkPending calls fn for every file stored below the root of given paths. Snapshot directories and, with a layout,
+// directories which are not shards of it, like the directories of other namespaces, are skipped.
+func (c *ChunkedUploaderService) walkPending(paths PathBuilder, fn func(path string, info fs.FileInfo) error) error {
+	pendingDir := paths.Root()
+	return afero.Walk(c.fs, pendingDir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() {
+			if path == pendingDir {
+				return nil
+			}
+			if strings.HasSuffix(path, ".snapshots") {
+				return filepath.SkipDir
+			}
+			if layout, ok := paths.(layoutPaths); ok {
+				rel, err := filepath.Rel(pendingDir, path)
+				if err != nil || !layout.layout.IsShard(rel) {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		return fn(path, info)
+	})
+}
+
+// MigrateLayout moves the uploads stored with one layout to the locations of another one and returns how many were
+// moved. Uploads which are being written to are skipped, so running it again later picks them up. With dryRun
+// nothing is moved and the uploads which would be moved are only counted and logged. It does not apply to services
+// with a custom PathBuilder.
+func (c *ChunkedUploaderService) MigrateLayout(ctx context.Context, from LayoutStrategy, to LayoutStrategy, dryRun bool) (migrated int, err error) {
+	if _, ok := c.paths.(layoutPaths); !ok {
+		return 0, fmt.Errorf("ChunkedUploaderService.MigrateLayout the service uses a custom path builder")
+	}
+
+	var uploadIds []string
+	err = c.walkPending(layoutPaths{root: c.pendingDirectory(), layout: from}, func(path string, info fs.FileInfo) error {
+		uploadId, ok := c.paths.ParseID(path)
+		if !ok {
+			return nil
+		}
+		if filepath.Join(c.pendingDirectory(), from.RelativePath(uploadId)) != path {
+			// already stored with another layout
+			return nil
+		}
+		uploadIds = append(uploadIds, uploadId)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("ChunkedUploaderService.MigrateLayout failed to list uploads %w", err)
+	}
+
+	for _, uploadId := range uploadIds {
+		if err := ctx.Err(); err != nil {
+			return migrated, err
+		}
+
+		srcPath := filepath.Join(c.pendingDirectory(), from.RelativePath(uploadId))
+		dstPath := filepath.Join(c.pendingDirectory(), to.RelativePath(uploadId))
+		if srcPath == dstPath {
+			continue
+		}
+
+		if dryRun {
+			c.log(LogLevelInfo, "Would migrate upload", LogField{"upload_id", uploadId}, LogField{"from", srcPath}, LogField{"to", dstPath})
+			migrated++
+			continue
+		}
+
+		moved, err := c.migrateUpload(uploadId, srcPath, dstPath)
+		if err != nil {
+			return migrated, fmt.Errorf("ChunkedUploaderService.MigrateLayout failed to migrate upload %s %w", uploadId, err)
+		}
+		if moved {
+			migrated++
+		}
+	}
+
+	return migrated, nil
+}
+
+// migrateUpload moves the data and metadata of a single upload, it returns false if the upload is busy.
+func (c *ChunkedUploaderService) migrateUpload(uploadId string, srcPath string, dstPath string) (bool, error) {
+	unlock, ok := c.locks.tryLock(uploadId)
+	if !ok {
+		c.log(LogLevelInfo, "Skipping migration of locked upload", LogField{"upload_id", uploadId})
+		return false, nil
+	}
+	defer unlock()
+
+	if c.hasWriteBuffer(uploadId) {
+		c.log(LogLevelInfo, "Skipping migration of buffered upload", LogField{"upload_id", uploadId})
+		return false, nil
+	}
+
+	// new chunks are rejected until the files are moved, chunks in flight would still write to the old path
+	idle, release, err := c.writers.finish(uploadId)
+	if err != nil {
+		c.log(LogLevelInfo, "Skipping migration of busy upload", LogField{"upload_id", uploadId}, LogField{"error", err})
+		return false, nil
+	}
+	defer release()
+	select {
+	case <-idle:
+	default:
+		c.log(LogLevelInfo, "Skipping migration of upload with chunks in flight", LogField{"upload_id", uploadId})
+		return false, nil
+	}
+
+	if _, err := c.fs.Stat(dstPath); err == nil {
+		return false, fmt.Errorf("destination %s already exists", dstPath)
+	}
+
+	err = c.fs.MkdirAll(filepath.Dir(dstPath), StandardAccess)
+	if err != nil {
+		return false, err
+	}
+
+	// the metadata goes first, so a chunk racing the migration fails instead of being written without being recorded
+	err = c.fs.Rename(srcPath+".json", dstPath+".json")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	err = c.fs.Rename(srcPath+".json.bak", dstPath+".json.bak")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	err = c.fs.Rename(srcPath, dstPath)
+	if err != nil {
+		return false, err
+	}
+
+	err = c.fs.Rename(srcPath+".snapshots", dstPath+".snapshots")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	c.log(LogLevelInfo, "Migrated upload", LogField{"upload_id", uploadId}, LogField{"from", srcPath}, LogField{"to", dstPath})
+	return true, nil
+}
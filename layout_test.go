@@ -0,0 +1,90 @@
+package chunkeduploader
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestMigrateLayout(t *testing.T) {
+	to := ShardedLayout{Levels: 2, Width: 2}
+
+	for _, tc := range []struct {
+		name string
+		// hold keeps the upload busy while it is migrated and returns a function releasing it
+		hold   func(t *testing.T, service *ChunkedUploaderService, uploadId string) func()
+		dryRun bool
+		want   int
+		moved  bool
+	}{
+		{"idle", nil, false, 1, true},
+		{"dry run", nil, true, 1, false},
+		{"locked", func(t *testing.T, service *ChunkedUploaderService, uploadId string) func() {
+			return service.locks.lock(uploadId)
+		}, false, 0, false},
+		{"chunk in flight", func(t *testing.T, service *ChunkedUploaderService, uploadId string) func() {
+			_, done, err := service.writers.start(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return done
+		}, false, 0, false},
+		{"finishing", func(t *testing.T, service *ChunkedUploaderService, uploadId string) func() {
+			_, release, err := service.writers.finish(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return release
+		}, false, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs())
+			uploadId, err := service.CreateUpload(4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := service.UploadChunk(uploadId, strings.NewReader("data"), 0); err != nil {
+				t.Fatal(err)
+			}
+			srcPath := service.getUploadFilePath(uploadId)
+			dstPath := filepath.Join(service.pendingDirectory(), to.RelativePath(uploadId))
+
+			release := func() {}
+			if tc.hold != nil {
+				release = tc.hold(t, service, uploadId)
+			}
+			migrated, err := service.MigrateLayout(context.Background(), FlatLayout{}, to, tc.dryRun)
+			release()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if migrated != tc.want {
+				t.Errorf("migrated %d uploads, want %d", migrated, tc.want)
+			}
+			for _, suffix := range []string{"", ".json"} {
+				atSrc, atDst := exists(t, service.fs, srcPath+suffix), exists(t, service.fs, dstPath+suffix)
+				if atSrc == tc.moved || atDst != tc.moved {
+					t.Errorf("%s at the old path %v and the new one %v, want moved %v", filepath.Base(srcPath+suffix), atSrc, atDst, tc.moved)
+				}
+			}
+
+			// a skipped upload is picked up by the next run, a moved one is not moved again
+			want := 1
+			if tc.moved {
+				want = 0
+			}
+			if migrated, err := service.MigrateLayout(context.Background(), FlatLayout{}, to, false); err != nil || migrated != want {
+				t.Errorf("next run: %d %v, want %d", migrated, err, want)
+			}
+			if !exists(t, service.fs, dstPath) {
+				t.Error("upload was not moved by the next run")
+			}
+			if data, err := afero.ReadFile(service.fs, dstPath); err != nil || string(data) != "data" {
+				t.Errorf("moved data: %q %v, want data", data, err)
+			}
+		})
+	}
+}
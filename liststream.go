@@ -0,0 +1,108 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// UploadFilter selects the uploads returned by StreamUploads, empty fields match every upload.
+type UploadFilter struct {
+	State  UploadState
+	Owner  string
+	Source string
+}
+
+func (f UploadFilter) matches(meta *UploadMetadata) bool {
+	return (f.State == "" || meta.State == f.State) &&
+		(f.Owner == "" || meta.Owner == f.Owner) &&
+		(f.Source == "" || meta.Source == f.Source)
+}
+
+// UploadSummary is the part of the metadata of an upload returned by StreamUploads.
+type UploadSummary struct {
+	UploadId  string      `json:"upload_id"`
+	State     UploadState `json:"state"`
+	FileSize  int64       `json:"file_size"`
+	Length    int64       `json:"length"`
+	Filename  string      `json:"filename,omitempty"`
+	Owner     string      `json:"owner,omitempty"`
+	Source    string      `json:"source,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// StreamUploads sends a summary of every upload matching a given filter to the returned channel while walking the
+// pending directory, so listing many uploads needs constant memory. A non-empty cursor skips the uploads whose id
+// sorts at or before it, it is the id of the last upload a previous listing returned. The channel is closed when the
+// walk ends or the context is done.
+func (c *ChunkedUploaderService) StreamUploads(ctx context.Context, cursor string, filter UploadFilter) (<-chan UploadSummary, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	summaries := make(chan UploadSummary)
+	go func() {
+		defer close(summaries)
+
+		err := c.walkMetadata(func(meta *UploadMetadata) error {
+			if cursor != "" && strings.Compare(meta.UploadId, cursor) <= 0 || !filter.matches(meta) {
+				return ctx.Err()
+			}
+
+			select {
+			case summaries <- UploadSummary{
+				UploadId:  meta.UploadId,
+				State:     meta.State,
+				FileSize:  meta.FileSize,
+				Length:    meta.length(),
+				Filename:  meta.Filename,
+				Owner:     meta.Owner,
+				Source:    meta.Source,
+				CreatedAt: meta.CreatedAt,
+			}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			c.log(LogLevelError, "Failed to stream uploads", LogField{"error", err})
+		}
+	}()
+
+	return summaries, nil
+}
+
+// streamUploads writes the uploads as newline-delimited JSON, flushing every line.
+func (c *ChunkedUploaderHandler) streamUploads(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := UploadFilter{
+		State:  UploadState(query.Get("state")),
+		Owner:  query.Get("owner"),
+		Source: query.Get("source"),
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	summaries, err := c.service.StreamUploads(ctx, query.Get("cursor"), filter)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to list uploads: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	flusher := http.NewResponseController(w)
+	for summary := range summaries {
+		if encoder.Encode(summary) != nil {
+			// the client is gone, cancel stops the walk
+			return
+		}
+		flusher.Flush()
+	}
+}
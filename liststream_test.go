@@ -0,0 +1,151 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func streamUploadsRequest(query string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/uploads?"+query, nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	return req
+}
+
+func TestStreamUploads(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service, WithAdminAuthorizer(func(r *http.Request) bool { return true }))
+
+	created := map[string][]string{}
+	for _, upload := range []struct {
+		key  string
+		opts []CreateUploadOption
+	}{
+		{"alice web", []CreateUploadOption{WithUploadOwner("alice"), WithSource("web")}},
+		{"alice api", []CreateUploadOption{WithUploadOwner("alice"), WithSource("api")}},
+		{"bob web", []CreateUploadOption{WithUploadOwner("bob"), WithSource("web")}},
+	} {
+		uploadId, err := service.CreateUpload(10, upload.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		created[upload.key] = []string{uploadId}
+	}
+	completeId, _ := newExportTestUpload(t, service, 10, WithUploadOwner("bob"), WithSource("api"))
+	created["bob api"] = []string{completeId}
+
+	all := []string{created["alice web"][0], created["alice api"][0], created["bob web"][0], completeId}
+	sort.Strings(all)
+
+	for _, tc := range []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"everything", "", all},
+		{"owner", "owner=alice", append(created["alice web"], created["alice api"]...)},
+		{"source", "source=web", append(created["alice web"], created["bob web"]...)},
+		{"owner and source", "owner=bob&source=web", created["bob web"]},
+		{"state", "state=complete", created["bob api"]},
+		{"cursor", "cursor=" + all[1], all[2:]},
+		{"cursor past the end", "cursor=" + all[3], nil},
+		{"no match", "owner=carol", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, streamUploadsRequest(tc.query))
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+				t.Fatalf("list: %d %s %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+			}
+
+			var got []string
+			scanner := bufio.NewScanner(rec.Body)
+			for scanner.Scan() {
+				var summary UploadSummary
+				if err := json.Unmarshal(scanner.Bytes(), &summary); err != nil {
+					t.Fatalf("line %q: %v", scanner.Text(), err)
+				}
+				got = append(got, summary.UploadId)
+			}
+			sort.Strings(got)
+			want := append([]string(nil), tc.want...)
+			sort.Strings(want)
+			if len(got) != len(want) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Errorf("got %v, want %v", got, want)
+					break
+				}
+			}
+		})
+	}
+}
+
+// heapSamplingWriter discards the response and samples the live heap every given number of lines.
+type heapSamplingWriter struct {
+	header http.Header
+	every  int
+	lines  int
+	peak   uint64
+}
+
+func (w *heapSamplingWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *heapSamplingWriter) WriteHeader(statusCode int) {}
+
+func (w *heapSamplingWriter) Write(p []byte) (int, error) {
+	w.lines += bytes.Count(p, []byte("\n"))
+	if w.lines%w.every == 0 {
+		runtime.GC()
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		w.peak = max(w.peak, stats.HeapAlloc)
+	}
+	return len(p), nil
+}
+
+func (w *heapSamplingWriter) Flush() {}
+
+func TestStreamUploadsMemory(t *testing.T) {
+	const uploads = 10000
+
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service, WithAdminAuthorizer(func(r *http.Request) bool { return true }))
+	// only the metadata is listed, so the uploads are mocked with their metadata files
+	for i := 0; i < uploads; i++ {
+		meta := UploadMetadata{UploadId: service.generateUploadId(), CreatedAt: time.Now(), State: UploadStateUploading, Filename: "file.bin", Source: "web"}
+		data, err := json.Marshal(meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := afero.WriteFile(service.fs, service.getMetadataFilePath(meta.UploadId), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	w := &heapSamplingWriter{header: http.Header{}, every: 500}
+	handler.ServeHTTP(w, streamUploadsRequest("").WithContext(context.Background()))
+	if w.lines != uploads {
+		t.Fatalf("%d uploads streamed, want %d", w.lines, uploads)
+	}
+	if w.peak > before.HeapAlloc && w.peak-before.HeapAlloc > 10<<20 {
+		t.Errorf("heap grew by %d bytes while streaming, want at most 10 MB", w.peak-before.HeapAlloc)
+	}
+}
@@ -0,0 +1,71 @@
+package chunkeduploader
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// LogField is a structured value attached to a log message, the service uses keys like upload_id, offset and bytes.
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger receives the log messages of the service. Implementations should return without any work when the level
+// is disabled.
+type Logger interface {
+	Log(level LogLevel, msg string, fields ...LogField)
+}
+
+// WithLogger sends the log messages of the service to a given logger instead of the standard log package.
+func WithLogger(logger Logger) ChunkedUploaderServiceOption {
+	if logger == nil {
+		panic("chunkeduploader: logger must not be nil")
+	}
+
+	return func(c *ChunkedUploaderService) {
+		c.logger = logger
+	}
+}
+
+// stdLogger writes to the standard log package in the format the service always used.
+type stdLogger struct{}
+
+func (stdLogger) Log(level LogLevel, msg string, fields ...LogField) {
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, field := range fields {
+		fmt.Fprintf(&b, " %s=%v", field.Key, field.Value)
+	}
+
+	log.Printf("[ChunkedUploaderService] %s", b.String())
+}
+
+func (c *ChunkedUploaderService) log(level LogLevel, msg string, fields ...LogField) {
+	c.logger.Log(level, msg, fields...)
+}
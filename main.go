@@ -1,6 +1,8 @@
 package chunkeduploader
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -9,10 +11,12 @@ import (
 	"hash"
 	"io"
 	"io/fs"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Craftserve/chunked-uploader/utils"
@@ -21,6 +25,9 @@ import (
 	"github.com/spf13/afero"
 )
 
+// pendingDirectory holds the files of unfinished uploads.
+const pendingDirectory = "/.pending"
+
 type StandardUmask = fs.FileMode
 
 const (
@@ -30,6 +37,8 @@ const (
 
 var FileSizeExceedsMaximumError = errors.New("file size exceeds maximum")
 var FileChecksumMismatchError = errors.New("file checksum mismatch")
+var ChunkLengthMismatchError = errors.New("chunk length does not match range")
+var UploadAlreadyFinishedError = errors.New("upload is already finished")
 
 type ChunkedUploaderServiceOption func(*ChunkedUploaderService)
 
@@ -45,21 +54,134 @@ func WithMaxPartSize(maxPartSize int64) ChunkedUploaderServiceOption {
 	}
 }
 
+// WithMmapChecksum makes upload verification memory map the pending file instead of copying it through a buffer.
+// It only takes effect for files on the real OS filesystem, other backends keep using the buffered copy.
+func WithMmapChecksum() ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.mmapChecksum = true
+	}
+}
+
+// WithChecksumAlgorithm sets the algorithm of the checksums used to verify finished uploads, it defaults to
+// utils.ChecksumSHA256. The chunk checksums returned in X-Checksum stay SHA-256, see WithHashFunc for other hashes.
+// It cannot be combined with WithHashFunc.
+func WithChecksumAlgorithm(algorithm utils.ChecksumAlgorithm) ChunkedUploaderServiceOption {
+	if !algorithm.Valid() {
+		panic("chunkeduploader: invalid checksum algorithm " + string(algorithm))
+	}
+
+	return func(c *ChunkedUploaderService) {
+		if c.hashFunc != nil {
+			panic("chunkeduploader: WithChecksumAlgorithm cannot be combined with WithHashFunc")
+		}
+		c.checksumAlgorithm = algorithm
+	}
+}
+
+// WithNamespace keeps the uploads of the service in their own subdirectory of the pending directory, so several
+// services can share one storage root. Listing and cleanup only ever see the uploads of their own namespace.
+func WithNamespace(namespace string) ChunkedUploaderServiceOption {
+	if namespace != "" && (namespace != filepath.Base(namespace) || namespace == "." || namespace == "..") {
+		panic("chunkeduploader: invalid namespace " + namespace)
+	}
+
+	return func(c *ChunkedUploaderService) {
+		c.namespace = namespace
+	}
+}
+
+// WithCleanupInterval makes Run remove uploads older than maxAge every interval.
+func WithCleanupInterval(interval time.Duration, maxAge time.Duration) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.cleanupMaxAge = maxAge
+		c.background.register("cleanup", c.runEvery(interval, func() error {
+			return c.Cleanup(maxAge)
+		}))
+	}
+}
+
 type ChunkedUploaderService struct {
 	fs          afero.Fs
+	storage     *rebindableFs
+	memory      *memoryFs
 	maxFileSize *int64
 	maxPartSize *int64
+	locks       uploadLocks
+	writers     chunkWriters
+
+	mmapChecksum bool
+	background   background
+	coalescer    *writeCoalescer
+	namespace    string
+	signer       *urlSigner
+	reservations *diskReservations
+	importRoot   string
+	uploadTTL    time.Duration
+
+	strictCleanup     bool
+	checksumAlgorithm utils.ChecksumAlgorithm
+	parallelChunks    *parallelChunks
+	inFlightBytes     *inFlightBytes
+
+	mutableCompletedMetadata bool
+	logger                   Logger
+	scanners                 []ScannerFunc
+	layout                   LayoutStrategy
+	paths                    PathBuilder
+	mismatchPolicy           MismatchPolicy
+	defaultSourcePolicy      SourcePolicy
+	sourcePolicies           map[string]SourcePolicy
+	readFs                   afero.Fs
+	maxRangeRead             int64
+	maxBatchConcurrency      int
+	destinationRoot          string
+	treeLeaves               bool
+	maxChunkCount            int64
+	eventHooks               []EventHook
+	idempotency              idempotencyKeys
+	backgroundIO             *backgroundIO
+	signatureVerifier        SignatureVerifier
+	chunkTuner               *chunkTuner
+	finalizeCommand          *finalizeCommand
+	maxRegions               int
+	fragmentationPolicy      FragmentationPolicy
+	maxUploadDuration        time.Duration
+	notifications            *notificationQueue
+	uploadTokenTTL           time.Duration
+	cleanupMaxAge            time.Duration
+	bufferValidation         *bufferValidation
+	outOfBandModifications   atomic.Int64
+	hashFunc                 func() hash.Hash
 }
 
 func NewChunkedUploaderService(fs afero.Fs, opts ...ChunkedUploaderServiceOption) *ChunkedUploaderService {
+	storage := &rebindableFs{fs: fs}
 	service := &ChunkedUploaderService{
-		fs: fs,
+		fs:                  storage,
+		storage:             storage,
+		checksumAlgorithm:   utils.ChecksumSHA256,
+		logger:              stdLogger{},
+		layout:              FlatLayout{},
+		maxRangeRead:        defaultMaxRangeRead,
+		maxBatchConcurrency: defaultMaxBatchConcurrency,
+		maxRegions:          defaultMaxRegions,
 	}
 
 	for _, opt := range opts {
 		opt(service)
 	}
 
+	if service.memory != nil {
+		service.memory.base = storage
+		service.fs = service.memory
+	}
+	if service.paths == nil {
+		service.paths = layoutPaths{root: service.pendingDirectory(), layout: service.layout}
+	}
+	if err := validatePathBuilder(service.paths); err != nil {
+		panic("chunkeduploader: invalid path builder: " + err.Error())
+	}
+
 	return service
 }
 
@@ -69,7 +191,16 @@ func (c *ChunkedUploaderService) generateUploadId() string {
 
 // createUpload creates a new upload with a given uploadId and maxSize, it allocates the file with the given size.
 func (c *ChunkedUploaderService) createUpload(uploadId string, maxSize int64) (err error) {
-	tempPath := getUploadFilePath(uploadId)
+	tempPath := c.getUploadFilePath(uploadId)
+	if c.memory != nil {
+		inMemory, err := c.memory.reserve(tempPath, maxSize)
+		if err != nil {
+			return fmt.Errorf("ChunkedUploaderService.createUpload failed to make room in memory %w", err)
+		}
+		if !inMemory && maxSize > 0 && maxSize <= c.memory.threshold {
+			c.log(LogLevelDebug, "In-memory budget exhausted, storing upload on disk", LogField{"upload_id", uploadId})
+		}
+	}
 	file, err := createFile(c.fs, tempPath)
 
 	if err != nil {
@@ -88,95 +219,139 @@ func (c *ChunkedUploaderService) createUpload(uploadId string, maxSize int64) (e
 	return err
 }
 
-// writePart writes a part of a file to a given path.
-func (c *ChunkedUploaderService) writePart(path string, reader io.Reader, offset int64) (h string, err error) {
+// writePart writes a part of a file to a given path, it returns the region which was actually written, also when
+// the copy fails midway, and the tree hash leaves it fully wrote when leaves are tracked. With sync the written data
+// is flushed to stable storage before returning.
+func (c *ChunkedUploaderService) writePart(path string, reader io.Reader, offset int64, sync bool) (h string, written *ByteRange, leaves map[int64]string, err error) {
 	var writer io.Writer
 	var hasher hash.Hash = sha256.New()
 
 	file, err := c.fs.OpenFile(path, os.O_WRONLY, 0644)
 	if err != nil {
-		return h, err
+		if errors.Is(err, fs.ErrNotExist) {
+			// the pending file was removed behind the back of the service
+			return h, nil, nil, fmt.Errorf("%w: %s", UploadNotFoundError, err)
+		}
+		return h, nil, nil, err
 	}
 	defer file.Close()
 
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return h, err
+		return h, nil, nil, err
 	}
 
 	if c.maxFileSize != nil {
 		if fileInfo.Size() >= *c.maxFileSize {
-			return h, FileSizeExceedsMaximumError
+			return h, nil, nil, FileSizeExceedsMaximumError
 		}
 	}
 
-	writer = io.MultiWriter(file, hasher)
-
 	if offset != -1 {
 		_, err = file.Seek(offset, io.SeekStart)
 		if err != nil {
-			return h, fmt.Errorf("ChunkedUploaderService.writePart failed to seek %w", err)
+			return h, nil, nil, fmt.Errorf("ChunkedUploaderService.writePart failed to seek %w", err)
 		}
 	}
 
 	if offset == -1 {
-		_, err = file.Seek(0, io.SeekEnd)
+		offset, err = file.Seek(0, io.SeekEnd)
 		if err != nil {
-			return h, fmt.Errorf("ChunkedUploaderService.writePart failed to seek %w", err)
+			return h, nil, nil, fmt.Errorf("ChunkedUploaderService.writePart failed to seek %w", err)
 		}
 	}
 
-	_, err = io.Copy(writer, reader)
+	writer = io.MultiWriter(file, hasher)
+	var leafWriter *leafHasher
+	if c.treeLeaves {
+		leafWriter = newLeafHasher(offset)
+		writer = io.MultiWriter(file, hasher, leafWriter)
+	}
+
+	n, err := io.Copy(writer, reader)
+	if n > 0 {
+		written = &ByteRange{Start: offset, End: offset + n - 1}
+	}
+	if leafWriter != nil {
+		leaves = leafWriter.leaves
+	}
+	if sync && written != nil {
+		syncErr := file.Sync()
+		if syncErr != nil {
+			// nothing is acknowledged unless it reached the disk
+			return h, nil, nil, fmt.Errorf("ChunkedUploaderService.writePart failed to sync %w", syncErr)
+		}
+	}
 	if err != nil {
-		return h, fmt.Errorf("ChunkedUploaderService.writePart failed to copy %w", err)
+		return h, written, leaves, fmt.Errorf("ChunkedUploaderService.writePart failed to copy %w", err)
 	}
 
 	h = hex.EncodeToString(hasher.Sum(nil))
 
-	return h, nil
+	return h, written, leaves, nil
 }
 
-// Cleanup removes old uploads that were created before a given timeLimit.
-func (c *ChunkedUploaderService) Cleanup(duration time.Duration) error {
-	timeLimit := time.Now().Add(-duration)
+// Remove pending temporary file
+func (c *ChunkedUploaderService) RemovePendingFile(uploadId string) error {
+	c.dropWriteBuffer(uploadId)
+	c.releaseSpace(uploadId)
 
-	afero.Walk(c.fs, ".pending", func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	path := c.getUploadFilePath(uploadId)
+	err := c.fs.Remove(path)
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.RemovePendingFile failed to remove pending file %w", err)
+	}
 
-		if info.ModTime().Before(timeLimit) {
-			log.Printf("[ChunkedUploaderService] Removing old upload: %s, modified at: %s, now is: %s", path, info.ModTime(), time.Now())
-			err = c.fs.Remove(path)
-			if err != nil {
-				return fmt.Errorf("ChunkedUploaderService.Cleanup failed to remove old upload %w", err)
-			}
+	// the backup goes first, so it cannot bring back an upload whose metadata is already removed
+	err = c.fs.Remove(c.getMetadataFilePath(uploadId) + ".bak")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("ChunkedUploaderService.RemovePendingFile failed to remove metadata backup %w", err)
+	}
 
-			return nil
-		}
+	err = c.fs.Remove(c.getMetadataFilePath(uploadId))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("ChunkedUploaderService.RemovePendingFile failed to remove metadata %w", err)
+	}
 
-		return nil
-	})
+	err = c.fs.RemoveAll(c.getSnapshotDirectory(uploadId))
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.RemovePendingFile failed to remove snapshots %w", err)
+	}
 
 	return nil
 }
 
-// Remove pending temporary file
-func (c *ChunkedUploaderService) RemovePendingFile(uploadId string) error {
-	path := getUploadFilePath(uploadId)
-	err := c.fs.Remove(path)
-	if err != nil {
-		return fmt.Errorf("ChunkedUploaderService.RemovePendingFile failed to remove pending file %w", err)
-	}
+// computeChecksum computes the checksum of a given file with the algorithm of the service.
+func (c *ChunkedUploaderService) computeChecksum(ctx context.Context, path string) (string, error) {
+	return c.computeChecksumWith(ctx, path, c.checksumAlgorithm)
+}
 
-	return nil
+func (c *ChunkedUploaderService) computeChecksumWith(ctx context.Context, path string, algorithm utils.ChecksumAlgorithm) (checksum string, err error) {
+	err = c.backgroundRead(func() error {
+		if c.customAlgorithm(algorithm) {
+			checksum, err = c.computeCustomChecksum(ctx, c.fs, path)
+		} else if c.mmapChecksum {
+			checksum, err = utils.ComputeChecksumMmapWith(ctx, c.fs, path, algorithm)
+		} else {
+			checksum, err = utils.ComputeChecksumThrottled(ctx, c.fs, path, algorithm, c.backgroundReadRate())
+		}
+		return err
+	})
+	return checksum, err
 }
 
 // VerifyUpload verifies an upload by comparing the checksum of the uploaded file with an expected checksum.
-func (c *ChunkedUploaderService) verifyUpload(uploadId string, expectedChecksum string) error {
-	pendingPath := getUploadFilePath(uploadId)
-
-	checksum, err := utils.ComputeChecksum(c.fs, pendingPath)
+// The computation is aborted as soon as the context is done. A checksum cached for the file as it is now is used
+// instead of reading the file, see ComputeAndCacheChecksum.
+func (c *ChunkedUploaderService) verifyUpload(ctx context.Context, uploadId string, expectedChecksum string, algorithm utils.ChecksumAlgorithm) error {
+	pendingPath := c.getUploadFilePath(uploadId)
+
+	checksum, err := c.checksumWithCache(uploadId, pendingPath, algorithm, func() (string, error) {
+		if algorithm == utils.ChecksumSHA256Tree && c.treeLeaves {
+			return c.computeTreeHash(ctx, uploadId)
+		}
+		return c.computeChecksumWith(ctx, pendingPath, algorithm)
+	})
 	if err != nil {
 		return fmt.Errorf("ChunkedUploaderService.verifyUpload failed to compute checksum %w path %s", err, pendingPath)
 	}
@@ -188,34 +363,296 @@ func (c *ChunkedUploaderService) verifyUpload(uploadId string, expectedChecksum
 	return nil
 }
 
-func (c *ChunkedUploaderService) CreateUpload(fileSize int64) (string, error) {
+func (c *ChunkedUploaderService) CreateUpload(fileSize int64, opts ...CreateUploadOption) (string, error) {
 	uploadId := c.generateUploadId()
-	err := c.createUpload(uploadId, fileSize)
+	meta := &UploadMetadata{
+		UploadId:  uploadId,
+		CreatedAt: time.Now(),
+		State:     UploadStateUploading,
+		FileSize:  fileSize,
+	}
+	if c.uploadTTL > 0 {
+		expiresAt := meta.CreatedAt.Add(c.uploadTTL)
+		meta.ExpiresAt = &expiresAt
+	}
+	for _, opt := range opts {
+		opt(meta)
+	}
+
+	err := meta.sanitize()
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.CreateUpload %w", err)
+	}
+
+	err = c.applySourcePolicy(meta)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.CreateUpload %w", err)
+	}
+
+	err = c.checkChunkCount(meta)
 	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.CreateUpload %w", err)
+	}
+
+	if c.uploadTokenTTL > 0 {
+		token, err := c.issueUploadToken(meta)
+		if err != nil {
+			return "", fmt.Errorf("ChunkedUploaderService.CreateUpload failed to issue token %w", err)
+		}
+		if meta.issuedToken != nil {
+			*meta.issuedToken = *token
+		}
+	}
+
+	err = c.reserveSpace(uploadId, fileSize)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.CreateUpload failed to reserve space %w", err)
+	}
+
+	err = c.createUpload(uploadId, fileSize)
+	if err != nil {
+		c.discardUpload(uploadId)
 		return "", fmt.Errorf("ChunkedUploaderService.CreateUpload failed to create upload %w", err)
 	}
+
+	err = c.saveMetadata(meta)
+	if err != nil {
+		c.discardUpload(uploadId)
+		return "", fmt.Errorf("ChunkedUploaderService.CreateUpload failed to write metadata %w", err)
+	}
+	c.emit(EventUploadCreated, uploadId, map[string]string{"state": string(meta.State)})
+
 	return uploadId, nil
 }
 
+// discardUpload removes what a failed CreateUpload left behind, nothing refers to a pending file without metadata.
+func (c *ChunkedUploaderService) discardUpload(uploadId string) {
+	c.releaseSpace(uploadId)
+
+	err := c.fs.Remove(c.getUploadFilePath(uploadId))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		c.log(LogLevelWarn, "Failed to remove pending file of a failed upload", LogField{"upload_id", uploadId}, LogField{"error", err})
+	}
+}
+
 func (c *ChunkedUploaderService) UploadChunk(uploadId string, data io.Reader, offset int64) (string, error) {
-	tempPath := getUploadFilePath(uploadId)
-	return c.writePart(tempPath, data, offset)
+	h, _, err := c.uploadChunk(uploadId, data, offset)
+	return h, err
 }
 
-func (c *ChunkedUploaderService) FinishUpload(uploadId string, expectedChecksum string) (path string, err error) {
-	err = c.verifyUpload(uploadId, expectedChecksum)
+// uploadChunk is UploadChunk which also returns the offset the chunk was written at, an empty chunk appended to the
+// end of the file is reported at offset -1.
+func (c *ChunkedUploaderService) uploadChunk(uploadId string, data io.Reader, offset int64) (string, int64, error) {
+	ctx, done, err := c.writers.start(uploadId)
 	if err != nil {
+		return "", offset, err
+	}
+	defer done()
+	data = cancellableReader(ctx, data)
+
+	meta, err := c.readMetadata(uploadId)
+	if err != nil && !errors.Is(err, UploadNotFoundError) {
+		return "", offset, fmt.Errorf("ChunkedUploaderService.UploadChunk failed to read metadata %w", err)
+	}
+	if meta != nil && meta.mode() == UploadModeAppend {
+		return "", offset, AppendSequenceRequiredError
+	}
+	if meta != nil {
+		if meta.finished() {
+			// a late chunk must not change the verified file
+			return "", offset, UploadAlreadyFinishedError
+		}
+		if err := meta.expired(); err != nil {
+			return "", offset, err
+		}
+		if err := c.exceededDuration(meta); err != nil {
+			c.expireUpload(meta)
+			return "", offset, err
+		}
+		if c.fragmentationPolicy == FragmentationReject && len(meta.Regions) >= c.maxRegions && startsRegion(meta.Regions, offset) {
+			return "", offset, fmt.Errorf("ChunkedUploaderService.UploadChunk %w: more than %d regions", TooFragmentedError, c.maxRegions)
+		}
+	}
+
+	durable := meta != nil && meta.Durable
+
+	// durable chunks are acknowledged only once written, so they are never buffered
+	if c.coalescer != nil && !durable {
+		if offset != -1 {
+			h, err := c.bufferChunk(uploadId, data, offset)
+			return h, offset, err
+		}
+
+		// appending needs the real end of the file
+		err = c.flushWriteBuffer(uploadId)
+		if err != nil {
+			return "", offset, err
+		}
+	}
+
+	tempPath := c.getUploadFilePath(uploadId)
+	h, written, leaves, err := c.writePart(tempPath, data, offset, durable)
+
+	if written != nil && !rolledBack(err) {
+		offset = written.Start
+		regionErr := c.addWrittenRegion(uploadId, *written, leaves)
+		if regionErr != nil && err == nil {
+			err = regionErr
+		}
+	}
+
+	return h, offset, err
+}
+
+// FinishUpload verifies an upload, if the context is done before the verification completes the upload is left
+// unfinished so it can be finished again later.
+func (c *ChunkedUploaderService) FinishUpload(ctx context.Context, uploadId string, expectedChecksum string) (path string, err error) {
+	return c.FinishUploadWithAlgorithm(ctx, uploadId, expectedChecksum, c.checksumAlgorithm)
+}
+
+// FinishUploadWithAlgorithm is like FinishUpload, but the expected checksum was computed with a given algorithm
+// instead of the one of the service.
+func (c *ChunkedUploaderService) FinishUploadWithAlgorithm(ctx context.Context, uploadId string, expectedChecksum string, algorithm utils.ChecksumAlgorithm) (path string, err error) {
+	if !c.supportsAlgorithm(algorithm) {
+		return "", fmt.Errorf("ChunkedUploaderService.FinishUpload unsupported checksum algorithm %q", algorithm)
+	}
+
+	// chunks already being written land before the file is verified, new ones are rejected until it is finished
+	idle, release, err := c.writers.finish(uploadId)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.FinishUpload %w", err)
+	}
+	defer release()
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		return "", fmt.Errorf("ChunkedUploaderService.FinishUpload %w", ctx.Err())
+	}
+
+	unlock := c.locks.lock(uploadId)
+	meta, err := c.readMetadata(uploadId)
+	unlock()
+	if err != nil && !errors.Is(err, UploadNotFoundError) {
+		return "", fmt.Errorf("ChunkedUploaderService.FinishUpload failed to read metadata %w", err)
+	}
+	if meta != nil && meta.finished() {
+		// a retried finish of a complete upload succeeds without verifying it again, a failed upload is only
+		// verified again by ReprocessUpload
+		if meta.State == UploadStateComplete && meta.finishedWith(expectedChecksum, algorithm, c.checksumAlgorithm) {
+			if meta.Path != "" {
+				return meta.Path, nil
+			}
+			return c.getUploadFilePath(uploadId), nil
+		}
+		return "", fmt.Errorf("ChunkedUploaderService.FinishUpload %w", UploadAlreadyFinishedError)
+	}
+
+	err = c.flushWriteBuffer(uploadId)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.FinishUpload failed to flush write buffer %w", err)
+	}
+
+	verifyCtx, cancel := verificationContext(ctx)
+	err = c.verifyUpload(verifyCtx, uploadId, expectedChecksum, algorithm)
+	cancel()
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(verifyCtx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("ChunkedUploaderService.FinishUpload %w", VerificationDeadlineExceededError)
+		}
+		if ctx.Err() != nil {
+			c.log(LogLevelWarn, "Aborted finish of upload", LogField{"upload_id", uploadId}, LogField{"error", ctx.Err()})
+		}
+		if errors.Is(err, FileChecksumMismatchError) {
+			diagErr := c.recordDiagnostics(uploadId, string(algorithm), err)
+			if diagErr != nil {
+				c.log(LogLevelError, "Failed to record diagnostics", LogField{"upload_id", uploadId}, LogField{"error", diagErr})
+			}
+			policyErr := c.handleMismatch(uploadId, string(algorithm), err)
+			if policyErr != nil {
+				c.log(LogLevelError, "Failed to apply mismatch policy", LogField{"upload_id", uploadId}, LogField{"error", policyErr})
+			}
+		}
 		return "", fmt.Errorf("ChunkedUploaderService.UploadChunk failed to verify upload %w", err)
 	}
 
-	path = getUploadFilePath(uploadId)
+	signature, err := c.verifySignature(ctx, uploadId)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.FinishUpload failed to verify signature %w", err)
+	}
+
+	checksum := expectedChecksum
+	var finalized *finalizeResult
+	if c.finalizeCommand != nil {
+		finalized, err = c.runFinalizeCommand(ctx, uploadId)
+		if err != nil {
+			return "", fmt.Errorf("ChunkedUploaderService.FinishUpload %w", err)
+		}
+		checksum, err = c.computeChecksumWith(ctx, c.getUploadFilePath(uploadId), algorithm)
+		if err != nil {
+			return "", fmt.Errorf("ChunkedUploaderService.FinishUpload failed to compute checksum of finalized file %w", err)
+		}
+	}
+
+	err = c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		meta.State = UploadStateComplete
+		if len(c.scanners) > 0 {
+			meta.State = UploadStateVerifying
+		}
+		meta.Checksum = checksum
+		meta.ChecksumAlgorithm = algorithm
+		meta.Signature = signature
+		if finalized != nil {
+			meta.OriginalChecksum = expectedChecksum
+			meta.OriginalPath = finalized.originalPath
+			meta.FileSize = finalized.size
+			meta.Regions = nil
+			if finalized.size > 0 {
+				meta.Regions = []ByteRange{{Start: 0, End: finalized.size - 1}}
+			}
+			meta.TreeLeaves = nil
+			meta.invalidateChecksum()
+			if c.finalizeCommand.ContentType != "" {
+				meta.ContentType = c.finalizeCommand.ContentType
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, UploadNotFoundError) {
+		// an upload without metadata is finished by its file alone, but one removed while it was verified is gone
+		_, statErr := c.fs.Stat(c.getUploadFilePath(uploadId))
+		if meta == nil && statErr == nil {
+			err = nil
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.FinishUpload failed to update metadata %w", err)
+	}
+	c.releaseSpace(uploadId)
+
+	if len(c.scanners) > 0 {
+		err = c.scanUpload(ctx, uploadId)
+		if err != nil {
+			return "", fmt.Errorf("ChunkedUploaderService.FinishUpload %w", err)
+		}
+	}
+
+	path = c.getUploadFilePath(uploadId)
 
 	return path, nil
 }
 
+// OpenUploadedFile opens the file of an upload for serving it, see WithReadOnlyFs.
 func (c *ChunkedUploaderService) OpenUploadedFile(uploadId string) (io.ReadCloser, error) {
-	path := getUploadFilePath(uploadId)
-	file, err := c.fs.Open(path)
+	return c.openUploadedFile(c.servingFs(), uploadId)
+}
+
+func (c *ChunkedUploaderService) openUploadedFile(fs afero.Fs, uploadId string) (io.ReadCloser, error) {
+	path, err := c.UploadedFilePath(uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.OpenUploadedFile failed to resolve uploaded file %w", err)
+	}
+
+	file, err := fs.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("ChunkedUploaderService.OpenUploadedFile failed to open uploaded file  %w", err)
 	}
@@ -223,20 +660,130 @@ func (c *ChunkedUploaderService) OpenUploadedFile(uploadId string) (io.ReadClose
 	return file, nil
 }
 
+// UploadedFilePath returns the current location of an upload, following renames recorded in its metadata and
+// falling back to the pending path for unfinished uploads.
+func (c *ChunkedUploaderService) UploadedFilePath(uploadId string) (string, error) {
+	meta, err := c.readMetadata(uploadId)
+	if err != nil && !errors.Is(err, UploadNotFoundError) {
+		return "", fmt.Errorf("ChunkedUploaderService.UploadedFilePath failed to read metadata %w", err)
+	}
+
+	if meta != nil && meta.Path != "" {
+		return meta.Path, nil
+	}
+
+	path := c.getUploadFilePath(uploadId)
+	_, err = c.fs.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", UploadNotFoundError
+		}
+		return "", fmt.Errorf("ChunkedUploaderService.UploadedFilePath failed to stat pending file %w", err)
+	}
+
+	return path, nil
+}
+
+// RenameUploadedFile moves an upload to a given path and records the new location in its metadata.
+func (c *ChunkedUploaderService) RenameUploadedFile(uploadId string, path string) error {
+	return c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		err := c.moveUploadedFile(meta, path)
+		if err != nil {
+			return fmt.Errorf("ChunkedUploaderService.RenameUploadedFile %w", err)
+		}
+		return nil
+	})
+}
+
+// moveUploadedFile moves the file of an upload to a given path and records it in the metadata, the caller saves it.
+func (c *ChunkedUploaderService) moveUploadedFile(meta *UploadMetadata, path string) error {
+	currentPath := meta.Path
+	if currentPath == "" {
+		currentPath = c.getUploadFilePath(meta.UploadId)
+	}
+
+	err := c.fs.MkdirAll(filepath.Dir(path), StandardAccess)
+	if err != nil {
+		return fmt.Errorf("failed to create directory %w", err)
+	}
+
+	err = c.fs.Rename(currentPath, path)
+	if err != nil {
+		return fmt.Errorf("failed to rename uploaded file %w", err)
+	}
+
+	meta.Path = path
+	return nil
+}
+
+// ChecksumUploadedFile computes the checksum of an upload at its current location.
+func (c *ChunkedUploaderService) ChecksumUploadedFile(uploadId string) (string, error) {
+	path, err := c.UploadedFilePath(uploadId)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.ChecksumUploadedFile failed to resolve uploaded file %w", err)
+	}
+
+	checksum, err := utils.ComputeChecksum(c.fs, path)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.ChecksumUploadedFile failed to compute checksum %w", err)
+	}
+
+	return checksum, nil
+}
+
 type ChunkedUploaderHandler struct {
-	service *ChunkedUploaderService
+	service         *ChunkedUploaderService
+	authorizeAdmin  func(r *http.Request) bool
+	ownerOf         func(r *http.Request) string
+	chunkAckFormat  ChunkAckFormat
+	timeouts        map[string]time.Duration
+	resolveSource   func(r *http.Request) string
+	corsOrigins     []string
+	logRequests     bool
+	metrics         *httpMetrics
+	authenticate    func(r *http.Request) bool
+	rateLimiter     *RateLimiter
+	buildInfo       *BuildInfo
+	encoders        []ResponseEncoder
+	queryParameters bool
 }
 
-func NewChunkedUploaderHandler(service *ChunkedUploaderService) *ChunkedUploaderHandler {
-	return &ChunkedUploaderHandler{service: service}
+func NewChunkedUploaderHandler(service *ChunkedUploaderService, opts ...ChunkedUploaderHandlerOption) *ChunkedUploaderHandler {
+	handler := &ChunkedUploaderHandler{service: service, timeouts: make(map[string]time.Duration)}
+	for name, timeout := range defaultHandlerTimeouts {
+		handler.timeouts[name] = timeout
+	}
+
+	for _, opt := range opts {
+		opt(handler)
+	}
+
+	return handler
 }
 
 type CreateUploadRequest struct {
-	FileSize *int64 `json:"file_size"`
+	FileSize    *int64            `json:"file_size"`
+	Filename    string            `json:"filename"`
+	ContentType string            `json:"content_type"`
+	Tags        map[string]string `json:"tags"`
+	Fingerprint string            `json:"fingerprint"`
+	Mode        UploadMode        `json:"mode"`
+	Durable     bool              `json:"durable"`
+	// ClientContext is kept with the upload and returned in its events, see WithClientContext.
+	ClientContext string `json:"client_context"`
+	// ChunkSize is the chunk size the client is going to use, see WithMaxChunkCount.
+	ChunkSize int64 `json:"chunk_size"`
+	// Source selects the policy of the upload unless the handler resolves it itself, see WithSourcePolicies.
+	Source string `json:"source"`
+	// StrictResume requires every chunk to state the committed offset it expects, see WithStrictResume.
+	StrictResume bool `json:"strict_resume"`
 }
 
 // CreateUploadHandler creates a new upload with a given file size and returns an uploadId.
 func (c *ChunkedUploaderHandler) CreateUploadHandler(w http.ResponseWriter, r *http.Request) {
+	r, cancel := c.withHandlerTimeout(w, r, HandlerCreateUpload)
+	defer cancel()
+
 	var req CreateUploadRequest
 
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -250,18 +797,116 @@ func (c *ChunkedUploaderHandler) CreateUploadHandler(w http.ResponseWriter, r *h
 		fileSize = *req.FileSize
 	}
 
-	uploadId, err := c.service.CreateUpload(fileSize)
+	if req.Mode != "" && req.Mode != UploadModeRandom && req.Mode != UploadModeAppend {
+		writeJSONError(w, http.StatusBadRequest, "mode must be one of: random, append")
+		return
+	}
+
+	opts := []CreateUploadOption{WithFilename(req.Filename), WithContentType(req.ContentType), WithTags(req.Tags), WithFingerprint(req.Fingerprint), WithUploadMode(req.Mode), WithClientContext(req.ClientContext)}
+	if req.Durable {
+		opts = append(opts, WithDurable())
+	}
+	if req.ChunkSize > 0 {
+		opts = append(opts, WithChunkSize(req.ChunkSize))
+	}
+	if req.StrictResume {
+		opts = append(opts, WithStrictResume())
+	}
+	if source := c.sourceOf(r, req.Source); source != "" {
+		opts = append(opts, WithSource(source))
+	}
+	opts = append(opts, c.ownerOptions(r)...)
+	var token UploadToken
+	opts = append(opts, WithIssuedUploadToken(&token))
+
+	created := true
+	var uploadId string
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		uploadId, created, err = c.service.CreateUploadIdempotent(fileSize, key, opts...)
+	} else {
+		uploadId, err = c.service.CreateUpload(fileSize, opts...)
+	}
+	if err == nil && created && timedOut(r) {
+		// the client gives up on the response, so nobody would use the upload
+		err = c.service.CancelUpload(context.Background(), uploadId)
+		if err != nil {
+			c.service.log(LogLevelError, "Failed to cancel timed out upload", LogField{"upload_id", uploadId}, LogField{"error", err})
+		}
+		writeTimeoutError(w, HandlerCreateUpload)
+		return
+	}
 	if err != nil {
+		if timedOut(r) {
+			writeTimeoutError(w, HandlerCreateUpload)
+			return
+		}
+		if errors.Is(err, InvalidIdempotencyKeyError) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, InvalidMetadataError) || errors.Is(err, ProtectedMetadataKeyError) {
+			writeMetadataError(w, err)
+			return
+		}
+		if errors.Is(err, IdempotencyKeyConflictError) {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		var chunkCount *ChunkCountExceededError
+		if errors.As(err, &chunkCount) {
+			writeChunkCountError(w, chunkCount)
+			return
+		}
+		if errors.Is(err, InsufficientStorageError) {
+			writeJSONError(w, http.StatusInsufficientStorage, err.Error())
+			return
+		}
+		if errors.Is(err, FileSizeExceedsMaximumError) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
 		writeJSONError(w, http.StatusInternalServerError, "failed to create upload: "+err.Error())
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"upload_id": uploadId})
+	if !created && c.service.uploadTokenTTL > 0 {
+		// the token of the first response cannot be recovered, the retrying client gets a new one
+		rotated, err := c.service.RotateUploadToken(r.Context(), uploadId)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "failed to issue upload token: "+err.Error())
+			return
+		}
+		token = *rotated
+	}
+
+	c.setExpiresHeader(w, uploadId)
+	c.setMaxParallelHeader(w)
+	c.setRecommendedChunkSizeHeader(w)
+	c.setCapabilitiesHeader(w)
+	c.setStrictResumeHeader(w, uploadId)
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	response := map[string]interface{}{"upload_id": uploadId}
+	if c.service.maxUploadDuration > 0 {
+		if meta, err := c.service.readMetadata(uploadId); err == nil {
+			response["remaining_seconds"] = int64(c.service.remainingDuration(meta).Seconds())
+		}
+	}
+	if token.Token != "" {
+		response["token"] = token.Token
+		response["token_expires_at"] = token.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	json.NewEncoder(w).Encode(response)
 }
 
 // UploadChunkHandler uploads a chunk of a file to a given uploadId.
 func (c *ChunkedUploaderHandler) UploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	r, cancel := c.withHandlerTimeout(w, r, HandlerUploadChunk)
+	defer cancel()
+
 	vars := mux.Vars(r)
 	uploadId := vars["upload_id"]
 
@@ -270,38 +915,204 @@ func (c *ChunkedUploaderHandler) UploadChunkHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	rangeHeader := r.Header.Get("Range")
+	release, ok := c.service.acquireChunkSlot(uploadId)
+	if !ok {
+		c.setMaxParallelHeader(w)
+		writeJSONError(w, http.StatusTooManyRequests, TooManyParallelChunksError.Error())
+		return
+	}
+	defer release()
+
+	releaseBytes, ok := c.admitChunk(w, r)
+	if !ok {
+		return
+	}
+	defer releaseBytes()
+
+	if sequence := r.Header.Get("X-Append-Sequence"); sequence != "" {
+		c.appendChunk(w, r, uploadId, sequence)
+		return
+	}
+
+	params, err := c.parseChunkParameters(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	var rangeStart int64 = -1
+	var rangeEnd int64 = -1
 
-	if rangeHeader != "" {
-		var err error
-		rangeStart, err = parseRangeHeader(rangeHeader)
+	if params.rangeHeader != "" {
+		rangeStart, rangeEnd, err = parseRangeHeader(params.rangeHeader)
 		if err != nil {
 			writeJSONError(w, http.StatusBadRequest, "Invalid Range header")
 			return
 		}
 	}
 
+	expected, err := parseExpectedCommitted(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	err = c.service.checkCommittedOffset(uploadId, expected)
+	if err != nil {
+		var mismatch *CommittedOffsetMismatchError
+		switch {
+		case errors.As(err, &mismatch):
+			writeCommittedOffsetError(w, mismatch)
+		case errors.Is(err, ExpectedCommittedRequiredError):
+			writeJSONError(w, http.StatusPreconditionRequired, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
 	// it will be io.Reader sent wit application/octet-stream
-	fileReader := r.Body
+	var fileReader io.Reader = r.Body
+	if rangeEnd != -1 && !isJSONRequest(r) {
+		length := rangeEnd - rangeStart + 1
+		if r.ContentLength != -1 && r.ContentLength != length {
+			writeChunkLengthError(w, &ChunkLengthError{Expected: length, Received: r.ContentLength})
+			return
+		}
+		// without a Content-Length the bytes received before a mismatch are kept, like those of an interrupted chunk
+		fileReader = &exactLengthReader{reader: r.Body, length: length, declared: r.ContentLength != -1}
+	}
+
+	if isJSONRequest(r) {
+		body := http.MaxBytesReader(w, r.Body, c.service.jsonChunkBodyLimit(rangeStart, rangeEnd))
+		data, offset, err := decodeJSONChunk(body, rangeStart, rangeEnd)
+		if err == nil && params.checksum != "" {
+			err = verifyChunkChecksum(data, params.checksum)
+		}
+		if errors.Is(err, JSONChunkTooLargeError) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		fileReader = bytes.NewReader(data)
+		rangeStart = offset
+		if offset != -1 {
+			rangeEnd = offset + int64(len(data)) - 1
+		}
+	} else if params.checksum != "" {
+		if rangeEnd == -1 {
+			writeJSONError(w, http.StatusBadRequest, "a chunk checksum requires the length of the chunk")
+			return
+		}
+		// the chunk is only written once it is known to be intact
+		data, err := readCheckedChunk(r.Body, rangeEnd-rangeStart+1, params.checksum)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		fileReader = bytes.NewReader(data)
+	}
 
-	h, err := c.service.UploadChunk(uploadId, fileReader, rangeStart)
+	if params.last {
+		if rangeEnd == -1 {
+			writeJSONError(w, http.StatusBadRequest, "the last chunk flag requires the length of the chunk")
+			return
+		}
+		err = c.service.checkLastChunk(uploadId, rangeEnd)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	counter := &countingReader{reader: fileReader}
+	started := time.Now()
+	h, offset, err := c.service.uploadChunk(uploadId, counter, rangeStart)
 	if err != nil {
+		if timedOut(r) {
+			writeTimeoutError(w, HandlerUploadChunk)
+			return
+		}
+		var expired *UploadExpiredError
+		if errors.As(err, &expired) {
+			c.writeExpiredError(w, r, uploadId, expired)
+			return
+		}
+		var exceeded *UploadDurationExceededError
+		if errors.As(err, &exceeded) {
+			writeDurationExceededError(w, exceeded)
+			return
+		}
+		if errors.Is(err, UploadCancelledError) {
+			writeCancelledError(w, err)
+			return
+		}
+		if errors.Is(err, UploadAlreadyFinishedError) || errors.Is(err, UploadFinishingError) {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if errors.Is(err, UploadNotFoundError) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		var lengthErr *ChunkLengthError
+		if errors.As(err, &lengthErr) {
+			writeChunkLengthError(w, lengthErr)
+			return
+		}
+		if errors.Is(err, AppendSequenceRequiredError) || errors.Is(err, ChunkLengthMismatchError) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, TooFragmentedError) {
+			writeTooFragmentedError(w, err)
+			return
+		}
 		writeJSONError(w, http.StatusInternalServerError, "Failed to upload chunk: "+err.Error())
 		return
 	}
 
+	if params.last {
+		err = c.service.declareFileSize(uploadId, rangeEnd+1)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to record file size: "+err.Error())
+			return
+		}
+	}
+
+	c.service.recordChunk(counter.count, time.Since(started))
+
 	w.Header().Set("X-Checksum", h)
-	w.WriteHeader(http.StatusOK)
+	c.setExpiresHeader(w, uploadId)
+	generation := c.setGenerationHeader(w, uploadId)
+	c.writeChunkAck(w, r, ChunkAck{Received: counter.count, Offset: offset, ChunkChecksum: h, Generation: generation})
 }
 
 type FinishUploadRequest struct {
 	Checksum string `json:"checksum"`
+	// Algorithm is the algorithm the checksum was computed with, like "sha256-tree". It defaults to the algorithm of
+	// the service.
+	Algorithm utils.ChecksumAlgorithm `json:"algorithm"`
+	// Signature is a base64 encoded detached signature of the file made with the key KeyId, see
+	// WithSignatureVerifier.
+	Signature []byte `json:"signature,omitempty"`
+	KeyId     string `json:"key_id,omitempty"`
+	// Destination moves the finished file below the destination root, together with a FinishManifest written to
+	// Manifest when it is set, see FinishUploadTo.
+	Destination string `json:"destination,omitempty"`
+	Manifest    string `json:"manifest,omitempty"`
 }
 
-// FinishUploadHandler finishes an upload by verifying the checksum of the uploaded file.
+// FinishUploadHandler finishes an upload by verifying the checksum of the uploaded file. With stream=true in the query
+// the response is the verified file itself, see FinishUploadAndStream, and delete=true removes the upload after it.
+// With dry_run=true it only reports whether the finish would succeed, see PreflightFinish. A destination in the body
+// moves the finished file below the destination root, see FinishUploadTo.
 func (c *ChunkedUploaderHandler) FinishUploadHandler(w http.ResponseWriter, r *http.Request) {
+	r, cancel := c.withHandlerTimeout(w, r, HandlerFinishUpload)
+	defer cancel()
+
 	vars := mux.Vars(r)
 	uploadId := vars["upload_id"]
 
@@ -310,6 +1121,11 @@ func (c *ChunkedUploaderHandler) FinishUploadHandler(w http.ResponseWriter, r *h
 		return
 	}
 
+	if r.URL.Query().Get("dry_run") == "true" {
+		c.preflightFinish(w, r)
+		return
+	}
+
 	var req FinishUploadRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
@@ -324,14 +1140,103 @@ func (c *ChunkedUploaderHandler) FinishUploadHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	path, err := c.service.FinishUpload(uploadId, expectedChecksum)
+	algorithm := req.Algorithm
+	if algorithm == "" {
+		algorithm = c.service.checksumAlgorithm
+	}
+	if !c.service.supportsAlgorithm(algorithm) {
+		writeJSONError(w, http.StatusBadRequest, "unsupported checksum algorithm")
+		return
+	}
+
+	ctx := r.Context()
+	deadline, ok, err := parseFinalizeDeadline(r)
 	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if ok {
+		ctx = WithVerificationDeadline(ctx, deadline)
+	}
+	if len(req.Signature) > 0 || req.KeyId != "" {
+		ctx = WithSignature(ctx, UploadSignature{KeyId: req.KeyId, Signature: req.Signature})
+	}
+
+	stream := r.URL.Query().Get("stream") == "true"
+	if req.Manifest != "" && req.Destination == "" {
+		writeJSONError(w, http.StatusBadRequest, "manifest requires a destination")
+		return
+	}
+	if stream && req.Destination != "" {
+		writeJSONError(w, http.StatusBadRequest, "a streamed finish cannot have a destination")
+		return
+	}
+
+	var path string
+	switch {
+	case stream:
+		err = c.streamFinish(ctx, w, r, uploadId, expectedChecksum, algorithm)
+	case req.Destination != "":
+		var opts []FinishToOption
+		if req.Manifest != "" {
+			opts = append(opts, WithManifest(req.Manifest))
+		}
+		path, err = c.service.finishUploadTo(ctx, uploadId, expectedChecksum, algorithm, req.Destination, opts...)
+	default:
+		path, err = c.service.FinishUploadWithAlgorithm(ctx, uploadId, expectedChecksum, algorithm)
+	}
+	if err != nil {
+		if errors.Is(err, DestinationNotAllowedError) {
+			writeJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, DestinationExistsError) {
+			writeJSONError(w, http.StatusPreconditionFailed, err.Error())
+			return
+		}
+		if errors.Is(err, UploadAlreadyFinishedError) || errors.Is(err, UploadFinishingError) || errors.Is(err, UploadCancelledError) {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if errors.Is(err, VerificationDeadlineExceededError) {
+			c.writeVerificationDeadlineError(w, r, uploadId)
+			return
+		}
+		if timedOut(r) {
+			writeTimeoutError(w, HandlerFinishUpload)
+			return
+		}
+		if r.Context().Err() != nil {
+			// the client is gone, there is no one to report the failure to
+			return
+		}
+		if errors.Is(err, ScanFailedError) || errors.Is(err, InvalidSignatureError) || errors.Is(err, FinalizeCommandFailedError) {
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		if r.URL.Query().Get("debug") == "true" && c.authorizeAdmin != nil && c.authorizeAdmin(r) {
+			c.writeFinishDiagnostics(w, r, uploadId, err)
+			return
+		}
 		writeJSONError(w, http.StatusBadRequest, "Failed to verify upload: "+err.Error())
 		return
 	}
+	if stream {
+		return
+	}
+
+	response := map[string]string{"path": path}
+	if c.service.signer != nil {
+		url, err := c.service.SignedDownloadURL(uploadId)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to sign download url: "+err.Error())
+			return
+		}
+		response["url"] = url
+	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"path": path})
+	json.NewEncoder(w).Encode(response)
 }
 
 // openFile opens a file with a given path and returns a file handle, it creates the directory if it does not exist.
@@ -354,14 +1259,115 @@ func createFile(fs afero.Fs, path string) (file afero.File, err error) {
 	return openFile(fs, path, os.O_RDWR|os.O_CREATE, StandardAccess)
 }
 
-// parseRangeHeader parses a range header and returns range start
-func parseRangeHeader(rangeHeader string) (int64, error) {
-	var start int64
-	_, err := fmt.Sscanf(rangeHeader, "offset=%d-", &start)
-	if err != nil {
+// parseRangeHeader parses a range header in either the "offset=start-" or the "bytes=start-end" form and returns
+// range start and end. Like in HTTP the end is inclusive, so "bytes=0-9" covers 10 bytes, and it is -1 if the range
+// is open.
+func parseRangeHeader(rangeHeader string) (start int64, end int64, err error) {
+	unit, spec, ok := strings.Cut(rangeHeader, "=")
+	if !ok || (unit != "offset" && unit != "bytes") {
+		return 0, 0, fmt.Errorf("invalid range unit")
+	}
+
+	startSpec, endSpec, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range")
+	}
+
+	start, err = strconv.ParseInt(startSpec, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range start")
+	}
+
+	if endSpec == "" {
+		return start, -1, nil
+	}
+
+	end, err = strconv.ParseInt(endSpec, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, fmt.Errorf("invalid range end")
+	}
+
+	return start, end, nil
+}
+
+// ChunkLengthError is returned when the body of a chunk is shorter or longer than its range, Received is the number
+// of bytes read until the mismatch was noticed.
+type ChunkLengthError struct {
+	Expected int64
+	Received int64
+	// declared is set when the client declared the length of the body, a mismatch is then a bug of the client and
+	// nothing of the chunk is recorded
+	declared bool
+}
+
+func (e *ChunkLengthError) Error() string {
+	if e.Received > e.Expected {
+		return fmt.Sprintf("%s: body is longer than the range length %d", ChunkLengthMismatchError, e.Expected)
+	}
+	return fmt.Sprintf("%s: body length %d is shorter than the range length %d", ChunkLengthMismatchError, e.Received, e.Expected)
+}
+
+func (e *ChunkLengthError) Unwrap() error {
+	return ChunkLengthMismatchError
+}
+
+// rolledBack reports whether err aborted a chunk whose written bytes must not be recorded, a length mismatch or the
+// cancellation of the upload.
+func rolledBack(err error) bool {
+	var lengthErr *ChunkLengthError
+	return (errors.As(err, &lengthErr) && lengthErr.declared) || errors.Is(err, UploadCancelledError)
+}
+
+// exactLengthReader reads exactly length bytes from a reader, it fails with a *ChunkLengthError if the reader ends
+// early or has more data.
+type exactLengthReader struct {
+	reader   io.Reader
+	length   int64
+	read     int64
+	declared bool
+}
+
+func (e *exactLengthReader) Read(p []byte) (int, error) {
+	remaining := e.length - e.read
+	if remaining == 0 {
+		var extra [1]byte
+		n, err := e.reader.Read(extra[:])
+		if n > 0 {
+			return 0, e.mismatch(e.read + int64(n))
+		}
+		if err == nil {
+			// the reader made no progress, let the caller retry
+			return 0, nil
+		}
 		return 0, err
 	}
-	return start, nil
+
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := e.reader.Read(p)
+	e.read += int64(n)
+	if err != nil && e.read < e.length && (err == io.EOF || e.declared) {
+		// a body ending before its declared Content-Length fails with io.ErrUnexpectedEOF
+		return n, e.mismatch(e.read)
+	}
+
+	return n, err
+}
+
+func (e *exactLengthReader) mismatch(received int64) error {
+	return &ChunkLengthError{Expected: e.length, Received: received, declared: e.declared}
+}
+
+func writeChunkLengthError(w http.ResponseWriter, err *ChunkLengthError) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":    err.Error(),
+		"code":     "chunk_length_mismatch",
+		"expected": err.Expected,
+		"received": err.Received,
+	})
 }
 
 // writeJSONError writes a JSON error response with a given status code and message.
@@ -373,6 +1379,11 @@ func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
 	}
 }
 
-func getUploadFilePath(uploadId string) string {
-	return filepath.Join("/.pending", uploadId)
+// pendingDirectory returns the directory holding the pending files of the service namespace.
+func (c *ChunkedUploaderService) pendingDirectory() string {
+	return filepath.Join(pendingDirectory, c.namespace)
+}
+
+func (c *ChunkedUploaderService) getUploadFilePath(uploadId string) string {
+	return c.paths.DataPath(uploadId)
 }
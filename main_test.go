@@ -0,0 +1,445 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// discardLogger drops every message, so tests do not flood the output with upload events.
+type discardLogger struct{}
+
+func (discardLogger) Log(level LogLevel, msg string, fields ...LogField) {}
+
+// newTestService creates a service which does not log.
+func newTestService(fs afero.Fs, opts ...ChunkedUploaderServiceOption) *ChunkedUploaderService {
+	return NewChunkedUploaderService(fs, append([]ChunkedUploaderServiceOption{WithLogger(discardLogger{})}, opts...)...)
+}
+
+func randomBytes(tb testing.TB, n int) []byte {
+	tb.Helper()
+
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		tb.Fatal(err)
+	}
+	return data
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFinishWaitsForChunksInFlight(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	data := randomBytes(t, 8192)
+
+	uploadId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the chunk is being written once the first bytes were read
+	pr, pw := io.Pipe()
+	chunkResult := make(chan error, 1)
+	go func() {
+		_, err := service.UploadChunk(uploadId, pr, 0)
+		chunkResult <- err
+	}()
+	if _, err := pw.Write(data[:4096]); err != nil {
+		t.Fatal(err)
+	}
+
+	finishResult := make(chan error, 1)
+	go func() {
+		_, err := service.FinishUpload(context.Background(), uploadId, sha256Hex(data))
+		finishResult <- err
+	}()
+
+	// chunks arriving while the upload is being finished are rejected
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := service.UploadChunk(uploadId, bytes.NewReader(data[:1]), 0)
+		if errors.Is(err, UploadFinishingError) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("chunk during finish: got %v, want UploadFinishingError", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := pw.Write(data[4096:]); err != nil {
+		t.Fatal(err)
+	}
+	pw.Close()
+
+	if err := <-chunkResult; err != nil {
+		t.Fatalf("chunk in flight: %v", err)
+	}
+	if err := <-finishResult; err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+
+	_, err = service.UploadChunk(uploadId, bytes.NewReader(data[:1]), 0)
+	if !errors.Is(err, UploadAlreadyFinishedError) {
+		t.Errorf("chunk after finish: got %v, want UploadAlreadyFinishedError", err)
+	}
+}
+
+// removingHash is SHA-256 which removes the files of an upload once the checksum is taken, like a cleanup running
+// while the upload is verified.
+type removingHash struct {
+	hash.Hash
+	remove func()
+}
+
+func (h removingHash) Sum(b []byte) []byte {
+	h.remove()
+	return h.Hash.Sum(b)
+}
+
+func TestFinishOfRemovedUploadFails(t *testing.T) {
+	var service *ChunkedUploaderService
+	var uploadId string
+	service = newTestService(afero.NewMemMapFs(), WithHashFunc("removing-sha256", func() hash.Hash {
+		return removingHash{Hash: sha256.New(), remove: func() {
+			service.fs.Remove(service.getUploadFilePath(uploadId))
+			service.fs.Remove(service.getMetadataFilePath(uploadId) + ".bak")
+			service.fs.Remove(service.getMetadataFilePath(uploadId))
+		}}
+	}))
+	data := randomBytes(t, 1024)
+
+	uploadId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := service.FinishUpload(context.Background(), uploadId, sha256Hex(data))
+	if !errors.Is(err, UploadNotFoundError) {
+		t.Errorf("got %q, %v, want UploadNotFoundError", path, err)
+	}
+}
+
+// failingFs fails opening and renaming to the files matched by fail.
+type failingFs struct {
+	afero.Fs
+	fail func(name string) bool
+}
+
+func (fs *failingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if fs.fail(name) {
+		return nil, errors.New("injected failure")
+	}
+	return fs.Fs.OpenFile(name, flag, perm)
+}
+
+func (fs *failingFs) Rename(oldname string, newname string) error {
+	if fs.fail(newname) {
+		return errors.New("injected failure")
+	}
+	return fs.Fs.Rename(oldname, newname)
+}
+
+func TestCreateUploadFailureLeavesNoFiles(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		fail func(name string) bool
+	}{
+		{"metadata write", func(name string) bool { return strings.HasSuffix(name, ".json.tmp") }},
+		{"metadata rename", func(name string) bool { return strings.HasSuffix(name, ".json") }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := &failingFs{Fs: afero.NewMemMapFs(), fail: tc.fail}
+			service := newTestService(fs)
+
+			if _, err := service.CreateUpload(1024); err == nil {
+				t.Fatal("CreateUpload succeeded")
+			}
+
+			err := afero.Walk(fs, service.pendingDirectory(), func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					t.Errorf("%s left behind", path)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// BenchmarkChunkLatencyDuringVerification writes 64 KiB chunks while a 64 MiB upload is verified over and over and
+// reports the 95th percentile of the chunk latency, with and without the background I/O priority.
+func BenchmarkChunkLatencyDuringVerification(b *testing.B) {
+	large := randomBytes(b, 64<<20)
+	chunk := randomBytes(b, 64<<10)
+
+	for _, bc := range []struct {
+		name string
+		opts []ChunkedUploaderServiceOption
+	}{
+		{"default", nil},
+		{"background priority", []ChunkedUploaderServiceOption{WithBackgroundIOPriority(256 << 20)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			service := newTestService(afero.NewBasePathFs(afero.NewOsFs(), b.TempDir()), bc.opts...)
+
+			verifiedId, err := service.CreateUpload(int64(len(large)))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := service.UploadChunk(verifiedId, bytes.NewReader(large), 0); err != nil {
+				b.Fatal(err)
+			}
+			uploadId, err := service.CreateUpload(-1)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			verifying := make(chan struct{})
+			go func() {
+				defer close(verifying)
+				for ctx.Err() == nil {
+					service.computeChecksum(ctx, service.getUploadFilePath(verifiedId))
+				}
+			}()
+
+			latencies := make([]time.Duration, 0, b.N)
+			b.SetBytes(int64(len(chunk)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				if _, err := service.UploadChunk(uploadId, bytes.NewReader(chunk), int64(i*len(chunk))); err != nil {
+					b.Fatal(err)
+				}
+				latencies = append(latencies, time.Since(start))
+			}
+			b.StopTimer()
+			cancel()
+			<-verifying
+
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			p95 := latencies[len(latencies)*95/100]
+			b.ReportMetric(float64(p95.Nanoseconds()), "p95-ns/op")
+		})
+	}
+}
+
+func TestOpenUploadedFile(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// prepare changes the upload after its data is written and returns where it is expected, empty if nowhere
+		prepare func(t *testing.T, service *ChunkedUploaderService, uploadId string) string
+	}{
+		{"before rename", func(t *testing.T, service *ChunkedUploaderService, uploadId string) string {
+			return service.getUploadFilePath(uploadId)
+		}},
+		{"after rename", func(t *testing.T, service *ChunkedUploaderService, uploadId string) string {
+			if err := service.RenameUploadedFile(uploadId, "/files/renamed"); err != nil {
+				t.Fatal(err)
+			}
+			return "/files/renamed"
+		}},
+		{"after cancel", func(t *testing.T, service *ChunkedUploaderService, uploadId string) string {
+			if err := service.CancelUpload(context.Background(), uploadId); err != nil {
+				t.Fatal(err)
+			}
+			return ""
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs())
+			data := randomBytes(t, 1024)
+			uploadId, err := service.CreateUpload(int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+				t.Fatal(err)
+			}
+			want := tc.prepare(t, service, uploadId)
+
+			path, err := service.UploadedFilePath(uploadId)
+			if want == "" {
+				if !errors.Is(err, UploadNotFoundError) {
+					t.Errorf("UploadedFilePath: %q %v, want UploadNotFoundError", path, err)
+				}
+				if _, err := service.OpenUploadedFile(uploadId); !errors.Is(err, UploadNotFoundError) {
+					t.Errorf("OpenUploadedFile: %v, want UploadNotFoundError", err)
+				}
+				return
+			}
+			if err != nil || path != want {
+				t.Fatalf("UploadedFilePath: %q %v, want %q", path, err, want)
+			}
+
+			file, err := service.OpenUploadedFile(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			if got, err := io.ReadAll(file); err != nil || !bytes.Equal(got, data) {
+				t.Errorf("OpenUploadedFile read %d bytes %v, want the uploaded data", len(got), err)
+			}
+			if checksum, err := service.ChecksumUploadedFile(uploadId); err != nil || checksum != sha256Hex(data) {
+				t.Errorf("ChecksumUploadedFile: %s %v, want %s", checksum, err, sha256Hex(data))
+			}
+		})
+	}
+}
+
+func TestChunkRangeEndIsInclusive(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		rangeHeader string
+		bodyLength  int
+		// unknownLength sends the body without a Content-Length, so the length is only checked while reading
+		unknownLength bool
+		wantCode      int
+	}{
+		{name: "exact length", rangeHeader: "bytes=0-9", bodyLength: 10, wantCode: http.StatusOK},
+		{name: "single byte", rangeHeader: "bytes=3-3", bodyLength: 1, wantCode: http.StatusOK},
+		{name: "last byte", rangeHeader: "bytes=9-9", bodyLength: 1, wantCode: http.StatusOK},
+		{name: "one byte short", rangeHeader: "bytes=0-4", bodyLength: 4, wantCode: http.StatusBadRequest},
+		{name: "one byte long", rangeHeader: "bytes=0-4", bodyLength: 6, wantCode: http.StatusBadRequest},
+		{name: "empty body for single byte", rangeHeader: "bytes=3-3", bodyLength: 0, wantCode: http.StatusBadRequest},
+		{name: "unknown length exact", rangeHeader: "bytes=2-6", bodyLength: 5, unknownLength: true, wantCode: http.StatusOK},
+		{name: "unknown length one byte short", rangeHeader: "bytes=2-6", bodyLength: 4, unknownLength: true, wantCode: http.StatusBadRequest},
+		{name: "unknown length one byte long", rangeHeader: "bytes=2-6", bodyLength: 6, unknownLength: true, wantCode: http.StatusBadRequest},
+		{name: "open range", rangeHeader: "bytes=4-", bodyLength: 6, wantCode: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			service := newTestService(fs)
+			handler := NewHTTPHandler(service)
+			uploadId, err := service.CreateUpload(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body := randomBytes(t, tc.bodyLength)
+			req := httptest.NewRequest(http.MethodPost, "/"+uploadId+"/upload", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/octet-stream")
+			req.Header.Set("Range", tc.rangeHeader)
+			if tc.unknownLength {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tc.wantCode {
+				t.Fatalf("%s with %d bytes: %d %s, want %d", tc.rangeHeader, tc.bodyLength, rec.Code, rec.Body, tc.wantCode)
+			}
+			if tc.wantCode != http.StatusOK {
+				return
+			}
+
+			start, _, err := parseRangeHeader(tc.rangeHeader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := afero.ReadFile(fs, service.getUploadFilePath(uploadId))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := data[start : start+int64(len(body))]; !bytes.Equal(got, body) {
+				t.Errorf("bytes at %d: %x, want %x", start, got, body)
+			}
+		})
+	}
+}
+
+func TestChunkLengthAccounting(t *testing.T) {
+	for _, coalescing := range []bool{false, true} {
+		for _, tc := range []struct {
+			name       string
+			bodyLength int
+			// contentLength is the declared length of the body, -1 sends the body without one
+			contentLength int64
+			wantCode      int
+			wantReceived  int64
+			wantRegions   []ByteRange
+		}{
+			{name: "matching", bodyLength: 500, contentLength: 500, wantCode: http.StatusOK, wantRegions: []ByteRange{{Start: 0, End: 499}}},
+			{name: "declared short", bodyLength: 300, contentLength: 300, wantCode: http.StatusBadRequest, wantReceived: 300},
+			{name: "declared long", bodyLength: 600, contentLength: 600, wantCode: http.StatusBadRequest, wantReceived: 600},
+			// the body ends before its Content-Length, like a client whose connection broke
+			{name: "body shorter than declared", bodyLength: 300, contentLength: 500, wantCode: http.StatusBadRequest, wantReceived: 300},
+			{name: "unknown length short", bodyLength: 300, contentLength: -1, wantCode: http.StatusBadRequest, wantReceived: 300, wantRegions: []ByteRange{{Start: 0, End: 299}}},
+			{name: "unknown length long", bodyLength: 600, contentLength: -1, wantCode: http.StatusBadRequest, wantReceived: 501, wantRegions: []ByteRange{{Start: 0, End: 499}}},
+			{name: "unknown length matching", bodyLength: 500, contentLength: -1, wantCode: http.StatusOK, wantRegions: []ByteRange{{Start: 0, End: 499}}},
+		} {
+			t.Run(fmt.Sprintf("%s coalescing %t", tc.name, coalescing), func(t *testing.T) {
+				var opts []ChunkedUploaderServiceOption
+				if coalescing {
+					opts = append(opts, WithWriteCoalescing(4096, time.Hour))
+				}
+				service := newTestService(afero.NewMemMapFs(), opts...)
+				handler := NewHTTPHandler(service)
+				uploadId, err := service.CreateUpload(1000)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				req := httptest.NewRequest(http.MethodPost, "/"+uploadId+"/upload", bytes.NewReader(randomBytes(t, tc.bodyLength)))
+				req.Header.Set("Content-Type", "application/octet-stream")
+				req.Header.Set("Range", "bytes=0-499")
+				req.ContentLength = tc.contentLength
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				if rec.Code != tc.wantCode {
+					t.Fatalf("got %d %s, want %d", rec.Code, rec.Body, tc.wantCode)
+				}
+
+				if tc.wantCode != http.StatusOK {
+					var resp struct {
+						Code     string `json:"code"`
+						Expected int64  `json:"expected"`
+						Received int64  `json:"received"`
+					}
+					if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+						t.Fatal(err)
+					}
+					if resp.Code != "chunk_length_mismatch" || resp.Expected != 500 || resp.Received != tc.wantReceived {
+						t.Errorf("got %+v, want chunk_length_mismatch with 500 expected and %d received", resp, tc.wantReceived)
+					}
+				}
+
+				if err := service.flushWriteBuffer(uploadId); err != nil {
+					t.Fatal(err)
+				}
+				meta, err := service.readMetadata(uploadId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(meta.Regions, tc.wantRegions) {
+					t.Errorf("regions %v, want %v", meta.Regions, tc.wantRegions)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package chunkeduploader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// WithInMemoryUploads keeps the files of uploads declaring a size of at most threshold bytes in memory instead of on
+// disk, which saves the latency of the disk for small files like avatars. At most budget bytes are held at once, when
+// a new upload does not fit the least recently used files which are not open are written to disk, and if that does not
+// free enough the new upload is stored on disk right away. Files are also written to disk when they are renamed.
+// Everything else works the same for both, only the metadata is always on disk.
+//
+// The files held in memory are lost when the process stops, their uploads then have metadata without a file and fail
+// until cleanup removes them. Uploads of unknown size are always stored on disk. Files held in memory are not visible
+// through the filesystem of WithReadOnlyFs.
+func WithInMemoryUploads(threshold int64, budget int64) ChunkedUploaderServiceOption {
+	if threshold <= 0 || budget < threshold {
+		panic("chunkeduploader: the in-memory budget must hold at least one upload of the threshold")
+	}
+
+	return func(c *ChunkedUploaderService) {
+		c.memory = &memoryFs{
+			mem:       afero.NewMemMapFs(),
+			threshold: threshold,
+			budget:    budget,
+			files:     make(map[string]*memoryFile),
+		}
+	}
+}
+
+// memoryFs holds the files it reserved in memory and forwards everything else to the base filesystem.
+type memoryFs struct {
+	base      afero.Fs
+	mem       afero.Fs
+	threshold int64
+	budget    int64
+
+	mu    sync.Mutex
+	used  int64
+	files map[string]*memoryFile
+}
+
+// memoryFile is the bookkeeping of a file held in memory, size is what it counts against the budget.
+type memoryFile struct {
+	size     int64
+	open     int
+	lastUsed time.Time
+}
+
+// reserve makes a file of a given size, which is about to be created, be held in memory. It reports false when the
+// file does not fit and has to be stored on disk.
+func (m *memoryFs) reserve(name string, size int64) (bool, error) {
+	if size <= 0 || size > m.threshold {
+		return false, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for m.used+size > m.budget {
+		spilled, err := m.spillLeastRecentlyUsed()
+		if err != nil {
+			return false, err
+		}
+		if !spilled {
+			return false, nil
+		}
+	}
+
+	m.files[filepath.Clean(name)] = &memoryFile{size: size, lastUsed: time.Now()}
+	m.used += size
+	return true, nil
+}
+
+// spillLeastRecentlyUsed writes the least recently used file which is not open to the base filesystem, it reports
+// false when every file is open. The caller holds mu.
+func (m *memoryFs) spillLeastRecentlyUsed() (bool, error) {
+	var name string
+	var oldest *memoryFile
+	for candidate, file := range m.files {
+		if file.open == 0 && (oldest == nil || file.lastUsed.Before(oldest.lastUsed)) {
+			name, oldest = candidate, file
+		}
+	}
+	if oldest == nil {
+		return false, nil
+	}
+
+	err := m.spill(name, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to write %s to disk %w", name, err)
+	}
+	return true, nil
+}
+
+// spill moves a file held in memory to a given path of the base filesystem, keeping its modification time for
+// cleanup. The caller holds mu.
+func (m *memoryFs) spill(name string, target string) error {
+	src, err := m.mem.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := m.base.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, StandardAccess)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		m.base.Remove(target)
+		return err
+	}
+	m.base.Chtimes(target, info.ModTime(), info.ModTime())
+
+	m.drop(name)
+	return nil
+}
+
+// drop forgets a file held in memory. The caller holds mu.
+func (m *memoryFs) drop(name string) {
+	m.mem.Remove(name)
+	m.used -= m.files[name].size
+	delete(m.files, name)
+}
+
+// held returns the bookkeeping of a file if it is held in memory. The caller holds mu.
+func (m *memoryFs) held(name string) (string, *memoryFile) {
+	name = filepath.Clean(name)
+	return name, m.files[name]
+}
+
+func (m *memoryFs) Create(name string) (afero.File, error) {
+	return m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (m *memoryFs) Open(name string) (afero.File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (m *memoryFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, file := m.held(name)
+	if file == nil {
+		f, err := m.base.OpenFile(name, flag, perm)
+		if err != nil || flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return f, err
+		}
+		// directories list the files held in memory too, so walking the pending directory finds them
+		return &memoryDir{File: f, fs: m, dir: name}, nil
+	}
+
+	err := m.mem.MkdirAll(filepath.Dir(name), StandardAccess)
+	if err != nil {
+		return nil, err
+	}
+	f, err := m.mem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	file.open++
+	file.lastUsed = time.Now()
+	return &memoryHandle{File: f, fs: m, name: name}, nil
+}
+
+func (m *memoryFs) Mkdir(name string, perm os.FileMode) error {
+	return m.base.Mkdir(name, perm)
+}
+
+func (m *memoryFs) MkdirAll(path string, perm os.FileMode) error {
+	return m.base.MkdirAll(path, perm)
+}
+
+func (m *memoryFs) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, file := m.held(name)
+	if file == nil {
+		return m.base.Remove(name)
+	}
+	m.drop(name)
+	return nil
+}
+
+func (m *memoryFs) RemoveAll(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	path = filepath.Clean(path)
+	for name := range m.files {
+		if name == path || strings.HasPrefix(name, path+string(filepath.Separator)) {
+			m.drop(name)
+		}
+	}
+	return m.base.RemoveAll(path)
+}
+
+// Rename writes a file held in memory to disk at the new name, files replaced by a rename are always on disk.
+func (m *memoryFs) Rename(oldname string, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	newname, replaced := m.held(newname)
+	if replaced != nil {
+		if replaced.open > 0 {
+			return fmt.Errorf("rename %s: %w", newname, os.ErrExist)
+		}
+		m.drop(newname)
+	}
+
+	oldname, file := m.held(oldname)
+	if file == nil {
+		return m.base.Rename(oldname, newname)
+	}
+	if file.open > 0 {
+		return fmt.Errorf("rename %s: file is open", oldname)
+	}
+	return m.spill(oldname, newname)
+}
+
+func (m *memoryFs) Stat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	name, file := m.held(name)
+	m.mu.Unlock()
+
+	if file == nil {
+		return m.base.Stat(name)
+	}
+	return m.mem.Stat(name)
+}
+
+func (m *memoryFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	m.mu.Lock()
+	name, file := m.held(name)
+	m.mu.Unlock()
+
+	if file == nil {
+		if lstater, ok := m.base.(afero.Lstater); ok {
+			return lstater.LstatIfPossible(name)
+		}
+	}
+	info, err := m.Stat(name)
+	return info, false, err
+}
+
+func (m *memoryFs) Name() string {
+	return "memoryFs"
+}
+
+func (m *memoryFs) Chmod(name string, mode os.FileMode) error {
+	return m.route(name).Chmod(name, mode)
+}
+
+func (m *memoryFs) Chown(name string, uid int, gid int) error {
+	return m.route(name).Chown(name, uid, gid)
+}
+
+func (m *memoryFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return m.route(name).Chtimes(name, atime, mtime)
+}
+
+// route returns the filesystem holding a given file.
+func (m *memoryFs) route(name string) afero.Fs {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, file := m.held(name); file != nil {
+		return m.mem
+	}
+	return m.base
+}
+
+// memoryHandle is a file held in memory, closing it makes it a candidate for spilling again.
+type memoryHandle struct {
+	afero.File
+	fs     *memoryFs
+	name   string
+	closed bool
+}
+
+func (h *memoryHandle) Close() error {
+	err := h.File.Close()
+
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+	if h.closed {
+		return err
+	}
+	h.closed = true
+
+	file := h.fs.files[h.name]
+	if file == nil {
+		return err
+	}
+	file.open--
+	// writes past the declared size count against the budget too
+	if info, statErr := h.fs.mem.Stat(h.name); statErr == nil && info.Size() > file.size {
+		h.fs.used += info.Size() - file.size
+		file.size = info.Size()
+	}
+	return err
+}
+
+// memoryDir is a directory of the base filesystem whose listing includes the files held in memory in it.
+type memoryDir struct {
+	afero.File
+	fs   *memoryFs
+	dir  string
+	sent bool
+}
+
+func (d *memoryDir) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := d.File.Readdir(count)
+	if d.sent || (count > 0 && !errors.Is(err, io.EOF)) {
+		return infos, err
+	}
+	d.sent = true
+
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+	for name := range d.fs.files {
+		if filepath.Dir(name) != d.dir {
+			continue
+		}
+		if info, statErr := d.fs.mem.Stat(name); statErr == nil {
+			infos = append(infos, info)
+		}
+	}
+	if len(infos) > 0 && errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return infos, err
+}
+
+// Unwrap returns the file of the base filesystem.
+func (d *memoryDir) Unwrap() afero.File {
+	return d.File
+}
+
+func (d *memoryDir) Readdirnames(count int) ([]string, error) {
+	infos, err := d.Readdir(count)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names, err
+}
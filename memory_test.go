@@ -0,0 +1,210 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// onDisk reports whether the file of an upload was written to the filesystem below the in-memory layer.
+func onDisk(t *testing.T, service *ChunkedUploaderService, uploadId string) bool {
+	t.Helper()
+
+	ok, err := afero.Exists(service.memory.base, service.getUploadFilePath(uploadId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func TestInMemoryUploads(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		size         int64
+		declaredSize int64
+		wantInMemory bool
+	}{
+		{name: "small", size: 1000, declaredSize: 1000, wantInMemory: true},
+		{name: "at the threshold", size: 4096, declaredSize: 4096, wantInMemory: true},
+		{name: "over the threshold", size: 4097, declaredSize: 4097},
+		{name: "unknown size", size: 1000, declaredSize: -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			base := afero.NewMemMapFs()
+			service := newTestService(base, WithInMemoryUploads(4096, 16384), WithDestinationRoot("/final"))
+			handler := NewHTTPHandler(service)
+
+			data := randomBytes(t, int(tc.size))
+			uploadId, err := service.CreateUpload(tc.declaredSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			uploadOverHTTP(t, handler, uploadId, data, 400)
+			if got := onDisk(t, service, uploadId); got == tc.wantInMemory {
+				t.Errorf("file on disk: %t, want %t", got, !tc.wantInMemory)
+			}
+
+			read, err := service.ReadRange(context.Background(), uploadId, 100, 200)
+			if err != nil || !bytes.Equal(read, data[100:300]) {
+				t.Errorf("ReadRange: %v", err)
+			}
+
+			// the pending directory lists the file wherever it is
+			names, err := afero.ReadDir(service.fs, filepath.Dir(service.getUploadFilePath(uploadId)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			listed := false
+			for _, info := range names {
+				listed = listed || info.Name() == filepath.Base(service.getUploadFilePath(uploadId))
+			}
+			if !listed {
+				t.Errorf("pending directory does not list the file")
+			}
+
+			if _, err := service.FinishUpload(context.Background(), uploadId, sha256Hex(data)); err != nil {
+				t.Fatal(err)
+			}
+			file, err := service.OpenUploadedFile(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(file)
+			file.Close()
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("OpenUploadedFile: %v", err)
+			}
+
+			// moving a finished file renames it, which writes it to disk
+			if _, err := service.MoveUpload(context.Background(), uploadId, "/file", false); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := afero.ReadFile(base, "/final/file"); err != nil || !bytes.Equal(got, data) {
+				t.Errorf("moved file on disk: %v", err)
+			}
+			if service.memory.used != 0 {
+				t.Errorf("%d bytes of the budget still in use", service.memory.used)
+			}
+		})
+	}
+}
+
+func TestInMemoryBudget(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// open keeps the files of the first uploads open while the last one is created
+		open         int
+		wantInMemory []bool
+	}{
+		{name: "least recently used spilled", wantInMemory: []bool{false, true, true, true}},
+		{name: "open files kept", open: 1, wantInMemory: []bool{true, false, true, true}},
+		{name: "every file open", open: 3, wantInMemory: []bool{true, true, true, false}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs(), WithInMemoryUploads(1000, 3000))
+			handler := NewHTTPHandler(service)
+
+			var uploadIds []string
+			var data [][]byte
+			for i := 0; i < 3; i++ {
+				uploadId, err := service.CreateUpload(1000)
+				if err != nil {
+					t.Fatal(err)
+				}
+				uploadIds = append(uploadIds, uploadId)
+				data = append(data, randomBytes(t, 1000))
+				uploadOverHTTP(t, handler, uploadId, data[i], 1000)
+			}
+			for _, uploadId := range uploadIds[:tc.open] {
+				file, err := service.fs.Open(service.getUploadFilePath(uploadId))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer file.Close()
+			}
+
+			uploadId, err := service.CreateUpload(1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			uploadIds = append(uploadIds, uploadId)
+			data = append(data, randomBytes(t, 1000))
+			uploadOverHTTP(t, handler, uploadId, data[3], 1000)
+
+			for i, uploadId := range uploadIds {
+				if got := onDisk(t, service, uploadId); got == tc.wantInMemory[i] {
+					t.Errorf("upload %d on disk: %t, want %t", i, got, !tc.wantInMemory[i])
+				}
+				// spilled files keep their data
+				if _, err := service.FinishUpload(context.Background(), uploadId, sha256Hex(data[i])); err != nil {
+					t.Errorf("finish upload %d: %v", i, err)
+				}
+			}
+			if service.memory.used > service.memory.budget {
+				t.Errorf("%d bytes in use, over the budget of %d", service.memory.used, service.memory.budget)
+			}
+		})
+	}
+}
+
+func TestInMemoryUploadsLostOnRestart(t *testing.T) {
+	base := afero.NewMemMapFs()
+	service := newTestService(base, WithInMemoryUploads(4096, 16384))
+
+	data := randomBytes(t, 1000)
+	uploadId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newTestService(base, WithInMemoryUploads(4096, 16384))
+	if _, err := restarted.FinishUpload(context.Background(), uploadId, sha256Hex(data)); err == nil {
+		t.Errorf("finished an upload whose file was held in memory before the restart")
+	}
+}
+
+// BenchmarkSmallUploads creates, uploads and finishes uploads of 100 KB on disk and in memory.
+func BenchmarkSmallUploads(b *testing.B) {
+	data := randomBytes(b, 100_000)
+	checksum := sha256Hex(data)
+
+	for _, bc := range []struct {
+		name string
+		opts []ChunkedUploaderServiceOption
+	}{
+		{"disk", nil},
+		{"in memory", []ChunkedUploaderServiceOption{WithInMemoryUploads(1<<20, 64<<20)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			service := newTestService(afero.NewBasePathFs(afero.NewOsFs(), b.TempDir()), bc.opts...)
+			handler := NewHTTPHandler(service)
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				uploadId, err := service.CreateUpload(int64(len(data)))
+				if err != nil {
+					b.Fatal(err)
+				}
+				for offset := 0; offset < len(data); offset += 25_000 {
+					rec := postChunk(handler, uploadId, "application/octet-stream", fmt.Sprintf("bytes=%d-%d", offset, offset+24_999), data[offset:offset+25_000])
+					if rec.Code != http.StatusOK {
+						b.Fatalf("chunk at %d: %d %s", offset, rec.Code, rec.Body)
+					}
+				}
+				if _, err := service.FinishUpload(context.Background(), uploadId, checksum); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,591 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+	"github.com/gorilla/mux"
+	"github.com/spf13/afero"
+)
+
+var UploadNotFoundError = errors.New("upload not found")
+var MetadataKeyNotFoundError = errors.New("metadata key not found")
+var ProtectedMetadataKeyError = errors.New("metadata key is protected")
+var InvalidMetadataError = errors.New("invalid metadata")
+var MetadataLockedError = errors.New("metadata of a finished upload cannot be changed")
+
+const (
+	maxFilenameLength = 255
+	maxTags           = 64
+	maxTagLength      = 256
+)
+
+// protectedMetadataKeys are the metadata fields which cannot be managed as tags.
+var protectedMetadataKeys = map[string]bool{
+	"upload_id":    true,
+	"created_at":   true,
+	"filename":     true,
+	"content_type": true,
+	"owner":        true,
+}
+
+type UploadState string
+
+const (
+	UploadStateUploading UploadState = "uploading"
+	UploadStateComplete  UploadState = "complete"
+	// UploadStateVerifying is the state of a verified upload while the scanners run.
+	UploadStateVerifying UploadState = "verifying"
+	// UploadStateFailed is the state of an upload rejected by a scanner, see FailureReason.
+	UploadStateFailed UploadState = "failed"
+	// UploadStateExpired is the state of an upload which exceeded the maximum upload duration, see
+	// WithMaxUploadDuration.
+	UploadStateExpired UploadState = "expired"
+)
+
+// UploadMetadata describes an upload, it is stored next to the pending file.
+type UploadMetadata struct {
+	UploadId    string            `json:"upload_id"`
+	CreatedAt   time.Time         `json:"created_at"`
+	State       UploadState       `json:"state"`
+	FileSize    int64             `json:"file_size"`
+	Filename    string            `json:"filename,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	Mode        UploadMode        `json:"mode,omitempty"`
+	// ClientContext is an opaque string of the application, see WithClientContext.
+	ClientContext string `json:"client_context,omitempty"`
+	// IdempotencyKey is the key the upload was created with, see CreateUploadIdempotent.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// ChunkSize is the chunk size declared by the client when creating the upload.
+	ChunkSize int64 `json:"chunk_size,omitempty"`
+	// Owner identifies the caller which created the upload, see WithOwner.
+	Owner string `json:"owner,omitempty"`
+	// Source is where the upload comes from and Policy the policy applied to it, see WithSourcePolicies.
+	Source string        `json:"source,omitempty"`
+	Policy *SourcePolicy `json:"policy,omitempty"`
+	// Durable uploads sync every chunk and its metadata before acknowledging it, see WithDurable.
+	Durable bool `json:"durable,omitempty"`
+	// StrictResume uploads require the expected committed offset with every chunk, see WithStrictResume.
+	StrictResume bool `json:"strict_resume,omitempty"`
+	// ExpiresAt is the deadline for the upload to be finished, it is only set with an upload ttl or a source policy.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Sequence is the sequence number of the last applied append and LastChunkChecksum its checksum.
+	Sequence          int64  `json:"sequence,omitempty"`
+	LastChunkChecksum string `json:"last_chunk_checksum,omitempty"`
+	// Generation is incremented whenever the metadata is saved, so it tells apart every state of the upload and never
+	// goes backwards.
+	Generation int64 `json:"generation,omitempty"`
+	// Checksum is the verified checksum of a complete upload and ChecksumAlgorithm the algorithm it was computed with.
+	Checksum          string                  `json:"checksum,omitempty"`
+	ChecksumAlgorithm utils.ChecksumAlgorithm `json:"checksum_algorithm,omitempty"`
+	FailureReason     string                  `json:"failure_reason,omitempty"`
+	// OriginalChecksum is the verified checksum of an upload replaced by the output of a finalize command, and
+	// OriginalPath the location the uploaded file is kept at, see WithFinalizeCommand.
+	OriginalChecksum string `json:"original_checksum,omitempty"`
+	OriginalPath     string `json:"original_path,omitempty"`
+	// Signature is the verified detached signature of a complete upload, see WithSignatureVerifier.
+	Signature *UploadSignature `json:"signature,omitempty"`
+	// ComputedChecksum caches the checksum of the file computed at ChecksumComputedAt with
+	// ComputedChecksumAlgorithm, it is valid while the file keeps ChecksumModTime. See ComputeAndCacheChecksum.
+	ComputedChecksum          string                  `json:"computed_checksum,omitempty"`
+	ComputedChecksumAlgorithm utils.ChecksumAlgorithm `json:"computed_checksum_algorithm,omitempty"`
+	ChecksumComputedAt        *time.Time              `json:"checksum_computed_at,omitempty"`
+	ChecksumModTime           *time.Time              `json:"checksum_mod_time,omitempty"`
+	// Path is the location the upload was renamed to, it is empty while the upload stays in the pending directory.
+	Path string `json:"path,omitempty"`
+	// Regions are the sorted, non-overlapping regions written to the pending file.
+	Regions []ByteRange `json:"regions,omitempty"`
+	// TreeLeaves are the hex digests of the tree hash leaves written so far, empty for leaves which have to be read
+	// back from the file, see WithTreeHashLeaves.
+	TreeLeaves []string `json:"tree_leaves,omitempty"`
+	// OverwrittenBytes, LastWriteAt and Events are kept for the diagnostics of a failed finish.
+	OverwrittenBytes int64         `json:"overwritten_bytes,omitempty"`
+	LastWriteAt      *time.Time    `json:"last_write_at,omitempty"`
+	Events           []UploadEvent `json:"events,omitempty"`
+	// Diagnostics describes the last failed finish.
+	Diagnostics *Diagnostics `json:"diagnostics,omitempty"`
+	// TokenHash is the SHA-256 digest of the upload token valid until TokenExpiresAt, see WithUploadTokens.
+	TokenHash      string     `json:"token_hash,omitempty"`
+	TokenExpiresAt *time.Time `json:"token_expires_at,omitempty"`
+	// Placement is the journal of an unfinished FinishUploadTo.
+	Placement *PlacementJournal `json:"placement,omitempty"`
+
+	// issuedToken receives the token issued when creating the upload, see WithIssuedUploadToken.
+	issuedToken *UploadToken
+}
+
+type CreateUploadOption func(*UploadMetadata)
+
+func WithFilename(filename string) CreateUploadOption {
+	return func(m *UploadMetadata) {
+		m.Filename = filename
+	}
+}
+
+func WithContentType(contentType string) CreateUploadOption {
+	return func(m *UploadMetadata) {
+		m.ContentType = contentType
+	}
+}
+
+func WithTags(tags map[string]string) CreateUploadOption {
+	return func(m *UploadMetadata) {
+		m.Tags = tags
+	}
+}
+
+func WithUploadOwner(owner string) CreateUploadOption {
+	return func(m *UploadMetadata) {
+		m.Owner = owner
+	}
+}
+
+func WithFingerprint(fingerprint string) CreateUploadOption {
+	return func(m *UploadMetadata) {
+		m.Fingerprint = fingerprint
+	}
+}
+
+// WithDurable makes every acknowledged chunk of the upload survive a crash. The chunk data is synced to disk first and
+// only then the metadata recording its region is written, synced and renamed into place, so the regions found in the
+// metadata after a restart were all fully written. Each chunk costs two or three fsyncs, which typically limits the
+// upload to a few hundred chunks per second on SSDs and far fewer on spinning disks, so use large chunks.
+func WithDurable() CreateUploadOption {
+	return func(m *UploadMetadata) {
+		m.Durable = true
+	}
+}
+
+// lastModified returns when data was last written to the upload.
+func (m *UploadMetadata) lastModified() time.Time {
+	if m.LastWriteAt != nil {
+		return *m.LastWriteAt
+	}
+	return m.CreatedAt
+}
+
+// readMetadata reads the metadata of a given upload, it returns UploadNotFoundError if the upload has no metadata.
+// When the metadata file is missing or corrupt, the previous generation kept next to it is read instead.
+func (c *ChunkedUploaderService) readMetadata(uploadId string) (*UploadMetadata, error) {
+	path := c.getMetadataFilePath(uploadId)
+
+	meta, err := decodeMetadataFile(c.fs, path)
+	if err == nil {
+		return meta, nil
+	}
+
+	backup, backupErr := decodeMetadataFile(c.fs, path+".bak")
+	if backupErr != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			if errors.Is(backupErr, fs.ErrNotExist) {
+				return nil, UploadNotFoundError
+			}
+			return nil, backupErr
+		}
+		return nil, err
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		c.log(LogLevelWarn, "Metadata is corrupt, using the previous generation", LogField{"upload_id", uploadId}, LogField{"error", err})
+	}
+
+	return backup, nil
+}
+
+func decodeMetadataFile(fs afero.Fs, path string) (*UploadMetadata, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var meta UploadMetadata
+	err = json.NewDecoder(file).Decode(&meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+
+	return &meta, nil
+}
+
+// saveMetadata atomically replaces the metadata of a given upload, readers never observe a partially written file.
+// The new metadata is written to a temporary file which is renamed into place, the replaced file is kept as a backup
+// for readMetadata to fall back to. The files and the directory are synced for durable uploads.
+func (c *ChunkedUploaderService) saveMetadata(meta *UploadMetadata) error {
+	path := c.getMetadataFilePath(meta.UploadId)
+	tempPath := path + ".tmp"
+	meta.Generation++
+
+	file, err := openFile(c.fs, tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, StandardAccess)
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(file).Encode(meta)
+	if err != nil {
+		file.Close()
+		c.fs.Remove(tempPath)
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+
+	if meta.Durable {
+		err = file.Sync()
+		if err != nil {
+			file.Close()
+			c.fs.Remove(tempPath)
+			return fmt.Errorf("failed to sync metadata: %w", err)
+		}
+	}
+
+	err = file.Close()
+	if err != nil {
+		c.fs.Remove(tempPath)
+		return fmt.Errorf("failed to close metadata: %w", err)
+	}
+
+	// between the two renames only the backup exists, readers fall back to it
+	err = c.fs.Rename(path, path+".bak")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		c.fs.Remove(tempPath)
+		return fmt.Errorf("failed to keep previous metadata: %w", err)
+	}
+
+	err = c.fs.Rename(tempPath, path)
+	if err != nil {
+		c.fs.Remove(tempPath)
+		return fmt.Errorf("failed to rename metadata: %w", err)
+	}
+
+	if meta.Durable {
+		// the rename itself is only durable once the directory is synced
+		err = syncDir(c.fs, filepath.Dir(path))
+		if err != nil {
+			return fmt.Errorf("failed to sync metadata directory: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// updateMetadata applies fn to the metadata of a given upload and writes it back, holding the upload lock.
+func (c *ChunkedUploaderService) updateMetadata(uploadId string, fn func(*UploadMetadata) error) error {
+	unlock := c.locks.lock(uploadId)
+	defer unlock()
+
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return err
+	}
+
+	previous := meta.State
+	err = fn(meta)
+	if err != nil {
+		return err
+	}
+
+	err = c.saveMetadata(meta)
+	if err != nil {
+		return err
+	}
+
+	if meta.State != previous {
+		data := map[string]string{"from": string(previous), "to": string(meta.State)}
+		if meta.FailureReason != "" {
+			data["reason"] = meta.FailureReason
+		}
+		c.emit(EventUploadStateChanged, uploadId, data)
+	}
+	return nil
+}
+
+// walkMetadata calls fn with the metadata of every upload, unreadable metadata files are skipped.
+func (c *ChunkedUploaderService) walkMetadata(fn func(meta *UploadMetadata) error) error {
+	return c.walkPending(c.paths, func(path string, info fs.FileInfo) error {
+		kind, uploadId := c.classifyPendingFile(path)
+		switch kind {
+		case pendingFileMetadata:
+		case pendingFileMetadataBackup:
+			// a crash while saving may leave only the backup behind
+			if _, err := c.fs.Stat(c.getMetadataFilePath(uploadId)); err == nil {
+				return nil
+			}
+		default:
+			return nil
+		}
+
+		meta, err := c.readMetadata(uploadId)
+		if err != nil {
+			return nil
+		}
+
+		return fn(meta)
+	})
+}
+
+// GetMetadata returns the metadata of a given upload.
+func (c *ChunkedUploaderService) GetMetadata(ctx context.Context, uploadId string) (*UploadMetadata, error) {
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetMetadata failed to read metadata %w", err)
+	}
+
+	return meta, nil
+}
+
+// ListUploads returns the metadata of all uploads in the service namespace.
+func (c *ChunkedUploaderService) ListUploads(ctx context.Context) ([]UploadMetadata, error) {
+	uploads := []UploadMetadata{}
+
+	err := c.walkMetadata(func(meta *UploadMetadata) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		uploads = append(uploads, *meta)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.ListUploads failed to list uploads %w", err)
+	}
+
+	return uploads, nil
+}
+
+// ListUploadsHandler returns the metadata of all uploads, it requires admin access. With Accept:
+// application/x-ndjson the uploads are streamed as one summary per line instead, filtered by the state, owner and
+// source query parameters and starting after the cursor one, see StreamUploads.
+func (c *ChunkedUploaderHandler) ListUploadsHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.requireAdmin(w, r) {
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		c.streamUploads(w, r)
+		return
+	}
+
+	uploads, err := c.service.ListUploads(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to list uploads: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"uploads": uploads})
+}
+
+// WithMutableCompletedMetadata allows ReplaceMetadata and PatchMetadata to change the metadata of finished uploads,
+// which are the complete, verifying and failed ones.
+func WithMutableCompletedMetadata(mutable bool) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.mutableCompletedMetadata = mutable
+	}
+}
+
+// ReplaceMetadata replaces the user provided metadata of a given upload, which are the filename, content type, tags,
+// fingerprint and client context. Fields which are not given are cleared. The identity, state and the bookkeeping of
+// written data are kept.
+func (c *ChunkedUploaderService) ReplaceMetadata(ctx context.Context, uploadId string, meta UploadMetadata) error {
+	err := meta.sanitize()
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.ReplaceMetadata %w", err)
+	}
+
+	err = c.updateMetadata(uploadId, func(current *UploadMetadata) error {
+		if current.finished() && !c.mutableCompletedMetadata {
+			return MetadataLockedError
+		}
+
+		current.Filename = meta.Filename
+		current.ContentType = meta.ContentType
+		current.Tags = meta.Tags
+		current.Fingerprint = meta.Fingerprint
+		current.ClientContext = meta.ClientContext
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.ReplaceMetadata failed to update metadata %w", err)
+	}
+
+	return nil
+}
+
+// PutMetadataHandler replaces the metadata of a given uploadId with the metadata from the request body.
+func (c *ChunkedUploaderHandler) PutMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	var meta UploadMetadata
+	err := json.NewDecoder(r.Body).Decode(&meta)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	err = c.service.ReplaceMetadata(r.Context(), uploadId, meta)
+	if err != nil {
+		switch {
+		case errors.Is(err, InvalidMetadataError), errors.Is(err, ProtectedMetadataKeyError):
+			writeMetadataError(w, err)
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, MetadataLockedError):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to replace metadata: "+err.Error())
+		}
+		return
+	}
+
+	updated, err := c.service.GetMetadata(r.Context(), uploadId)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to read metadata: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteMetadataKey removes a single tag from the metadata of a given upload.
+func (c *ChunkedUploaderService) DeleteMetadataKey(ctx context.Context, uploadId string, key string) error {
+	if protectedMetadataKeys[key] {
+		return fmt.Errorf("ChunkedUploaderService.DeleteMetadataKey %w: %s", ProtectedMetadataKeyError, key)
+	}
+
+	err := c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		if _, ok := meta.Tags[key]; !ok {
+			return MetadataKeyNotFoundError
+		}
+		delete(meta.Tags, key)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.DeleteMetadataKey failed to update metadata %w", err)
+	}
+
+	return nil
+}
+
+// DeleteMetadataKeyHandler removes a single tag from the metadata of a given uploadId.
+func (c *ChunkedUploaderHandler) DeleteMetadataKeyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+	key := vars["key"]
+
+	if uploadId == "" || key == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id and key are required")
+		return
+	}
+
+	err := c.service.DeleteMetadataKey(r.Context(), uploadId, key)
+	if err != nil {
+		switch {
+		case errors.Is(err, ProtectedMetadataKeyError):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, UploadNotFoundError), errors.Is(err, MetadataKeyNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete metadata key: "+err.Error())
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadLocks serializes read-modify-write operations on a single upload.
+type uploadLocks struct {
+	mu    sync.Mutex
+	locks map[string]*uploadLock
+}
+
+type uploadLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// tryLock locks a given upload if it is not locked already, without waiting.
+func (l *uploadLocks) tryLock(uploadId string) (unlock func(), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, locked := l.locks[uploadId]; locked {
+		return nil, false
+	}
+	lock := l.add(uploadId)
+	// nobody else has a reference to a new lock, so this does not wait
+	lock.mu.Lock()
+
+	return l.unlocker(uploadId, lock), true
+}
+
+// lock locks a given upload and returns a function releasing it.
+func (l *uploadLocks) lock(uploadId string) func() {
+	l.mu.Lock()
+	lock := l.add(uploadId)
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+
+	return l.unlocker(uploadId, lock)
+}
+
+// add returns the lock of a given upload with a reference taken, creating it if there is none. The caller holds mu.
+func (l *uploadLocks) add(uploadId string) *uploadLock {
+	if l.locks == nil {
+		l.locks = make(map[string]*uploadLock)
+	}
+	lock, ok := l.locks[uploadId]
+	if !ok {
+		lock = &uploadLock{}
+		l.locks[uploadId] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+// unlocker returns a function unlocking a given lock and dropping its reference.
+func (l *uploadLocks) unlocker(uploadId string, lock *uploadLock) func() {
+	return func() {
+		lock.mu.Unlock()
+
+		l.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, uploadId)
+		}
+		l.mu.Unlock()
+	}
+}
+
+func syncDir(fs afero.Fs, path string) error {
+	dir, err := fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+func (c *ChunkedUploaderService) getMetadataFilePath(uploadId string) string {
+	return c.paths.MetaPath(uploadId)
+}
@@ -0,0 +1,113 @@
+package chunkeduploader
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestMetadataTruncatedAtEveryOffset(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+
+	uploadId, err := service.CreateUpload(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, strings.NewReader("aaaa"), 0); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := service.readMetadata(uploadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, strings.NewReader("bbbb"), 4); err != nil {
+		t.Fatal(err)
+	}
+	current, err := service.readMetadata(uploadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := service.getMetadataFilePath(uploadId)
+	files := map[string][]byte{}
+	for _, suffix := range []string{"", ".bak"} {
+		if files[suffix], err = afero.ReadFile(service.fs, path+suffix); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a crash while saving leaves a partial temporary file
+	files[".tmp"] = files[""]
+
+	for _, tc := range []struct {
+		name   string
+		suffix string
+		// want is the generation read back from a file truncated before its last byte, the encoder ends it with a
+		// newline which can be cut off without losing anything
+		want *UploadMetadata
+	}{
+		{"metadata", "", previous},
+		{"backup", ".bak", current},
+		{"temporary", ".tmp", current},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := files[tc.suffix]
+			for offset := 0; offset < len(data); offset++ {
+				for suffix, original := range files {
+					if suffix == ".tmp" {
+						service.fs.Remove(path + suffix)
+						continue
+					}
+					if err := afero.WriteFile(service.fs, path+suffix, original, 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := afero.WriteFile(service.fs, path+tc.suffix, data[:offset], 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				want := tc.want
+				if offset == len(data)-1 && tc.suffix == "" {
+					want = current
+				}
+				meta, err := service.readMetadata(uploadId)
+				if err != nil {
+					t.Fatalf("truncated at %d: %v", offset, err)
+				}
+				if meta.Generation != want.Generation || regionsLength(meta.Regions) != regionsLength(want.Regions) {
+					t.Fatalf("truncated at %d: generation %d with %d bytes, want generation %d with %d bytes", offset, meta.Generation, regionsLength(meta.Regions), want.Generation, regionsLength(want.Regions))
+				}
+
+				// the next save starts from the state which was read and leaves readable metadata behind
+				if err := service.updateMetadata(uploadId, func(meta *UploadMetadata) error { return nil }); err != nil {
+					t.Fatalf("update after truncating at %d: %v", offset, err)
+				}
+				if _, err := decodeMetadataFile(service.fs, path); err != nil {
+					t.Fatalf("metadata after truncating at %d: %v", offset, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMetadataWithoutReadableGeneration(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+
+	uploadId, err := service.CreateUpload(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, strings.NewReader("aaaa"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	path := service.getMetadataFilePath(uploadId)
+	for _, suffix := range []string{"", ".bak"} {
+		if err := afero.WriteFile(service.fs, path+suffix, []byte(`{"upload_id":`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := service.readMetadata(uploadId); err == nil {
+		t.Error("corrupt metadata and backup were read")
+	}
+}
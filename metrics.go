@@ -0,0 +1,43 @@
+package chunkeduploader
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// MetricsHandler serves the gauges of the service in the Prometheus text format.
+func (c *ChunkedUploaderHandler) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	reserved, limit := c.service.InFlightBytes()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "# HELP chunkeduploader_inflight_bytes Bytes reserved by chunks being written.")
+	fmt.Fprintln(w, "# TYPE chunkeduploader_inflight_bytes gauge")
+	fmt.Fprintf(w, "chunkeduploader_inflight_bytes %d\n", reserved)
+	fmt.Fprintln(w, "# HELP chunkeduploader_inflight_bytes_limit Limit of the bytes reserved by chunks being written, 0 without a limit.")
+	fmt.Fprintln(w, "# TYPE chunkeduploader_inflight_bytes_limit gauge")
+	fmt.Fprintf(w, "chunkeduploader_inflight_bytes_limit %d\n", limit)
+	c.service.writeChunkTuningMetrics(w)
+	c.service.writeNotificationMetrics(w)
+	c.service.writeOutOfBandMetrics(w)
+
+	if c.metrics == nil {
+		return
+	}
+
+	c.metrics.mu.Lock()
+	defer c.metrics.mu.Unlock()
+
+	codes := make([]int, 0, len(c.metrics.requests))
+	for code := range c.metrics.requests {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	fmt.Fprintln(w, "# HELP chunkeduploader_http_requests_total Requests served by status code, see WithMetrics.")
+	fmt.Fprintln(w, "# TYPE chunkeduploader_http_requests_total counter")
+	for _, code := range codes {
+		fmt.Fprintf(w, "chunkeduploader_http_requests_total{code=\"%d\"} %d\n", code, c.metrics.requests[code])
+	}
+}
@@ -0,0 +1,122 @@
+package chunkeduploader
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// quarantineDirectory holds the files of uploads quarantined after a checksum mismatch.
+const quarantineDirectory = "/.quarantine"
+
+const failureReasonFinishChecksumMismatch = "checksum_mismatch"
+
+// EventChecksumMismatch is emitted when a finish fails because the checksum does not match.
+const EventChecksumMismatch EventType = "checksum_mismatch"
+
+// MismatchPolicy decides what happens to an upload whose checksum did not match on finish.
+type MismatchPolicy string
+
+const (
+	// MismatchKeep leaves the upload as it is, so the client can re-send the corrupted chunks and finish again. It is
+	// the default, abandoned uploads are removed by cleanup.
+	MismatchKeep MismatchPolicy = "keep"
+	// MismatchDelete removes the upload right away to reclaim its space.
+	MismatchDelete MismatchPolicy = "delete"
+	// MismatchQuarantine marks the upload as failed and moves its files to the quarantine directory for inspection.
+	MismatchQuarantine MismatchPolicy = "quarantine"
+)
+
+// WithMismatchPolicy sets what happens to an upload whose checksum did not match on finish.
+func WithMismatchPolicy(policy MismatchPolicy) ChunkedUploaderServiceOption {
+	if policy != MismatchKeep && policy != MismatchDelete && policy != MismatchQuarantine {
+		panic("chunkeduploader: invalid mismatch policy " + string(policy))
+	}
+	return func(c *ChunkedUploaderService) {
+		c.mismatchPolicy = policy
+	}
+}
+
+// handleMismatch applies the mismatch policy to an upload which failed to finish, the mismatch is logged and emitted
+// as an event whatever the policy. The policy only applies to uploads which are still being uploaded, a finished
+// upload is left as it is.
+func (c *ChunkedUploaderService) handleMismatch(uploadId string, algorithm string, mismatch error) error {
+	policy := c.mismatchPolicy
+	if policy == "" {
+		policy = MismatchKeep
+	}
+
+	c.log(LogLevelWarn, "Upload checksum mismatch", LogField{"upload_id", uploadId}, LogField{"policy", policy}, LogField{"error", mismatch})
+	c.emit(EventChecksumMismatch, uploadId, map[string]string{"algorithm": algorithm, "policy": string(policy)})
+
+	switch policy {
+	case MismatchDelete:
+		unlock := c.locks.lock(uploadId)
+		defer unlock()
+
+		meta, err := c.readMetadata(uploadId)
+		if err != nil && !errors.Is(err, UploadNotFoundError) {
+			return fmt.Errorf("failed to read metadata %w", err)
+		}
+		if meta != nil && meta.finished() {
+			return nil
+		}
+		return c.RemovePendingFile(uploadId)
+	case MismatchQuarantine:
+		return c.quarantineUpload(uploadId)
+	}
+
+	return nil
+}
+
+// quarantineUpload marks an upload as failed and moves its data and metadata out of the pending directory, a
+// finished upload is left as it is.
+func (c *ChunkedUploaderService) quarantineUpload(uploadId string) error {
+	err := c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		if meta.finished() {
+			return UploadAlreadyFinishedError
+		}
+		meta.State = UploadStateFailed
+		meta.FailureReason = failureReasonFinishChecksumMismatch
+		return nil
+	})
+	if errors.Is(err, UploadAlreadyFinishedError) {
+		return nil
+	}
+	if err != nil && !errors.Is(err, UploadNotFoundError) {
+		return fmt.Errorf("failed to mark upload as failed %w", err)
+	}
+
+	unlock := c.locks.lock(uploadId)
+	defer unlock()
+
+	c.dropWriteBuffer(uploadId)
+	c.releaseSpace(uploadId)
+
+	dstPath := filepath.Join(quarantineDirectory, c.namespace, uploadId)
+	err = c.fs.MkdirAll(filepath.Dir(dstPath), StandardAccess)
+	if err != nil {
+		return fmt.Errorf("failed to create quarantine directory %w", err)
+	}
+
+	err = c.fs.Rename(c.getUploadFilePath(uploadId), dstPath)
+	if err != nil {
+		return fmt.Errorf("failed to quarantine upload %w", err)
+	}
+
+	err = c.fs.Rename(c.getMetadataFilePath(uploadId), dstPath+".json")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to quarantine metadata %w", err)
+	}
+
+	for _, path := range []string{c.getMetadataFilePath(uploadId) + ".bak", c.getSnapshotDirectory(uploadId)} {
+		err = c.fs.RemoveAll(path)
+		if err != nil {
+			return fmt.Errorf("failed to remove %s %w", path, err)
+		}
+	}
+
+	c.log(LogLevelInfo, "Quarantined upload", LogField{"upload_id", uploadId}, LogField{"path", dstPath})
+	return nil
+}
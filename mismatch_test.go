@@ -0,0 +1,102 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// newFinishedTestUpload creates an upload, finishes it and returns its data and the path finish returned.
+func newFinishedTestUpload(t *testing.T, service *ChunkedUploaderService) (string, []byte, string) {
+	t.Helper()
+
+	data := randomBytes(t, 1024)
+	uploadId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+	path, err := service.FinishUpload(context.Background(), uploadId, sha256Hex(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uploadId, data, path
+}
+
+func TestFinishRetryOfCompleteUpload(t *testing.T) {
+	for _, policy := range []MismatchPolicy{MismatchKeep, MismatchDelete, MismatchQuarantine} {
+		t.Run(string(policy), func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs(), WithMismatchPolicy(policy))
+			uploadId, data, path := newFinishedTestUpload(t, service)
+
+			retried, err := service.FinishUpload(context.Background(), uploadId, sha256Hex(data))
+			if err != nil || retried != path {
+				t.Errorf("retry with the same checksum: got %q, %v, want %q", retried, err, path)
+			}
+
+			_, err = service.FinishUpload(context.Background(), uploadId, sha256Hex([]byte("other")))
+			if !errors.Is(err, UploadAlreadyFinishedError) {
+				t.Errorf("retry with another checksum: got %v, want UploadAlreadyFinishedError", err)
+			}
+
+			meta, err := service.readMetadata(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.State != UploadStateComplete {
+				t.Errorf("got state %s, want complete", meta.State)
+			}
+			stored, err := afero.ReadFile(service.fs, path)
+			if err != nil || !bytes.Equal(stored, data) {
+				t.Errorf("file of the complete upload changed: %v", err)
+			}
+		})
+	}
+}
+
+func TestFinishRetryAfterFinalizeCommand(t *testing.T) {
+	if _, err := exec.LookPath("head"); err != nil {
+		t.Skip("head is not available")
+	}
+
+	service := newTestService(afero.NewMemMapFs(), WithMismatchPolicy(MismatchDelete), WithFinalizeCommand(FinalizeCommand{Name: "head", Args: []string{"-c", "16"}}))
+	uploadId, data, path := newFinishedTestUpload(t, service)
+
+	// the file was replaced by the output of the command, the client still knows only its own checksum
+	retried, err := service.FinishUpload(context.Background(), uploadId, sha256Hex(data))
+	if err != nil || retried != path {
+		t.Errorf("retry with the original checksum: got %q, %v, want %q", retried, err, path)
+	}
+
+	stored, err := afero.ReadFile(service.fs, path)
+	if err != nil || !bytes.Equal(stored, data[:16]) {
+		t.Errorf("output of the finalize command changed: %v", err)
+	}
+}
+
+func TestMismatchPolicyDeletesUploadingUpload(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs(), WithMismatchPolicy(MismatchDelete))
+	data := randomBytes(t, 1024)
+
+	uploadId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = service.FinishUpload(context.Background(), uploadId, sha256Hex([]byte("other")))
+	if !errors.Is(err, FileChecksumMismatchError) {
+		t.Fatalf("got %v, want FileChecksumMismatchError", err)
+	}
+	if ok, _ := afero.Exists(service.fs, service.getUploadFilePath(uploadId)); ok {
+		t.Error("upload with a mismatching checksum kept")
+	}
+}
@@ -0,0 +1,94 @@
+package chunkeduploader
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+)
+
+type MultipartInitResult struct {
+	FieldName string `json:"field_name"`
+	Filename  string `json:"filename,omitempty"`
+	UploadId  string `json:"upload_id,omitempty"`
+	UploadUrl string `json:"upload_url,omitempty"`
+	Checksum  string `json:"checksum,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// MultipartInitHandler creates an upload for every file part of a multipart/form-data request. The size of each
+// upload is taken from the part's Content-Length header and the part body, if any, is written as its first chunk.
+// Every part gets its own result, so the response is 207 Multi-Status even if some of them failed.
+func (c *ChunkedUploaderHandler) MultipartInitHandler(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid multipart request: "+err.Error())
+		return
+	}
+
+	results := []MultipartInitResult{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			results = append(results, MultipartInitResult{Error: "Invalid multipart part: " + err.Error()})
+			break
+		}
+
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		result := MultipartInitResult{
+			FieldName: part.FormName(),
+			Filename:  part.FileName(),
+		}
+
+		var fileSize int64 = -1
+		if contentLength := part.Header.Get("Content-Length"); contentLength != "" {
+			fileSize, err = strconv.ParseInt(contentLength, 10, 64)
+			if err != nil || fileSize < 0 {
+				result.Error = "Invalid Content-Length: " + contentLength
+				results = append(results, result)
+				part.Close()
+				continue
+			}
+		}
+
+		if fileSize > 0 && c.service.maxFileSize != nil && fileSize > *c.service.maxFileSize {
+			result.Error = FileSizeExceedsMaximumError.Error()
+			results = append(results, result)
+			part.Close()
+			continue
+		}
+
+		opts := append([]CreateUploadOption{WithFilename(part.FileName()), WithContentType(part.Header.Get("Content-Type"))}, c.ownerOptions(r)...)
+		uploadId, err := c.service.CreateUpload(fileSize, opts...)
+		if err != nil {
+			result.Error = "failed to create upload: " + err.Error()
+			results = append(results, result)
+			part.Close()
+			continue
+		}
+
+		result.UploadId = uploadId
+		result.UploadUrl = path.Join(path.Dir(r.URL.Path), uploadId, "upload")
+
+		h, err := c.service.UploadChunk(uploadId, part, 0)
+		if err != nil {
+			result.Error = "Failed to upload chunk: " + err.Error()
+		} else {
+			result.Checksum = h
+		}
+
+		results = append(results, result)
+		part.Close()
+	}
+
+	w.WriteHeader(http.StatusMultiStatus)
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,104 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestMultipartInit(t *testing.T) {
+	type part struct {
+		filename string
+		// contentLength is sent as the Content-Length header of the part, the length of data when -1 and none when
+		// empty
+		contentLength string
+		data          string
+	}
+
+	for _, tc := range []struct {
+		name  string
+		parts []part
+		// wantErrors tells which results are errors, by part
+		wantErrors []bool
+	}{
+		{"all parts succeed", []part{{"world.zip", "-1", "world"}, {"nether.zip", "-1", "nether"}}, []bool{false, false}},
+		{"some parts fail", []part{{"world.zip", "-1", "world"}, {"invalid.zip", "abc", "data"}, {"huge.zip", "4096", ""}}, []bool{false, true, true}},
+		{"unknown size", []part{{"world.zip", "", "world"}}, []bool{false}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs(), WithMaxFileSize(1024))
+			handler := NewHTTPHandler(service)
+
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			for _, p := range tc.parts {
+				header := textproto.MIMEHeader{}
+				header.Set("Content-Disposition", `form-data; name="file"; filename="`+p.filename+`"`)
+				switch p.contentLength {
+				case "":
+				case "-1":
+					header.Set("Content-Length", strconv.Itoa(len(p.data)))
+				default:
+					header.Set("Content-Length", p.contentLength)
+				}
+				w, err := writer.CreatePart(header)
+				if err != nil {
+					t.Fatal(err)
+				}
+				w.Write([]byte(p.data))
+			}
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/multi-init", &body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusMultiStatus {
+				t.Fatalf("got %d %s, want 207", rec.Code, rec.Body)
+			}
+
+			var results []MultipartInitResult
+			if err := json.NewDecoder(rec.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != len(tc.parts) {
+				t.Fatalf("got %d results, want %d", len(results), len(tc.parts))
+			}
+			for i, result := range results {
+				p := tc.parts[i]
+				if result.FieldName != "file" || result.Filename != p.filename {
+					t.Errorf("result %d is for %s %s, want file %s", i, result.FieldName, result.Filename, p.filename)
+				}
+				if (result.Error != "") != tc.wantErrors[i] {
+					t.Errorf("result %d error %q, want an error %v", i, result.Error, tc.wantErrors[i])
+				}
+				if result.Error != "" {
+					continue
+				}
+
+				meta, err := service.readMetadata(result.UploadId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				wantSize := int64(len(p.data))
+				if p.contentLength == "" {
+					wantSize = -1
+				}
+				if meta.FileSize != wantSize || meta.Filename != p.filename {
+					t.Errorf("upload %d: size %d filename %s, want %d %s", i, meta.FileSize, meta.Filename, wantSize, p.filename)
+				}
+				data, err := afero.ReadFile(service.fs, service.getUploadFilePath(result.UploadId))
+				if err != nil || !bytes.HasPrefix(data, []byte(p.data)) {
+					t.Errorf("upload %d: %q %v, want %q written", i, data, err, p.data)
+				}
+			}
+		})
+	}
+}
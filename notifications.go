@@ -0,0 +1,403 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/afero"
+)
+
+// notificationsDirectory holds the queue of undelivered notifications, one file per delivery.
+const notificationsDirectory = "/.notifications"
+
+const (
+	defaultNotificationQueueLimit = 10000
+	notificationRetryInterval     = 10 * time.Second
+	maxNotificationBackoff        = time.Hour
+	notificationDeliveryTimeout   = 30 * time.Second
+)
+
+// Notifier delivers the events of the service to a downstream system at least once, see WithNotifier. Unlike an
+// EventHook it may block and fail, a failed delivery is retried with the same delivery id, so receivers can drop
+// the deliveries they have already seen.
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// Notification is a delivery of an event to a notifier, it stays queued on disk until the notifier accepts it.
+type Notification struct {
+	DeliveryId string    `json:"delivery_id"`
+	Notifier   string    `json:"notifier"`
+	Event      Event     `json:"event"`
+	QueuedAt   time.Time `json:"queued_at"`
+	Attempts   int       `json:"attempts"`
+	// NextAttemptAt is when a failed delivery is retried, LastError tells why it failed.
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// WebhookNotifier posts the events as JSON to a URL, the delivery id is sent in the X-Delivery-Id header. A delivery
+// is only complete once the URL responds with a 2xx status.
+type WebhookNotifier struct {
+	URL string
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	body, err := json.Marshal(notification.Event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Delivery-Id", notification.DeliveryId)
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", res.Status)
+	}
+	return nil
+}
+
+// WithNotifier delivers every event of the service to a notifier under a given name. The deliveries are queued on
+// disk below the storage root before the change is acknowledged and sent by Run, so they survive a restart. Queued
+// deliveries of a notifier which is no longer configured are kept until it is configured again.
+func WithNotifier(name string, notifier Notifier) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		q := c.notificationQueue()
+		if _, ok := q.notifiers[name]; !ok {
+			q.names = append(q.names, name)
+		}
+		q.notifiers[name] = notifier
+	}
+}
+
+// WithWebhook posts every event of the service to a given URL, see WithNotifier and WebhookNotifier.
+func WithWebhook(url string) ChunkedUploaderServiceOption {
+	return WithNotifier("webhook:"+url, &WebhookNotifier{URL: url})
+}
+
+// WithNotificationQueueLimit bounds the number of queued deliveries, it defaults to 10000. Once it is reached the
+// oldest deliveries are dropped to make room, which is logged and counted in the metrics.
+func WithNotificationQueueLimit(limit int) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.notificationQueue().limit = limit
+	}
+}
+
+// notificationQueue keeps the undelivered notifications in the order they were queued. The queue is loaded from
+// disk on first use, so deliveries interrupted by a restart are resumed.
+type notificationQueue struct {
+	mu        sync.Mutex
+	notifiers map[string]Notifier
+	names     []string
+	limit     int
+	loaded    bool
+	pending   []*Notification
+	dropped   int64
+	wake      chan struct{}
+}
+
+// notificationQueue returns the queue of the service, creating it and its background component on first use.
+func (c *ChunkedUploaderService) notificationQueue() *notificationQueue {
+	if c.notifications == nil {
+		c.notifications = &notificationQueue{
+			notifiers: make(map[string]Notifier),
+			limit:     defaultNotificationQueueLimit,
+			wake:      make(chan struct{}, 1),
+		}
+		c.background.register("notifications", c.runNotifications)
+	}
+	return c.notifications
+}
+
+func (c *ChunkedUploaderService) notificationsDirectory() string {
+	return filepath.Join(notificationsDirectory, c.namespace)
+}
+
+func (c *ChunkedUploaderService) notificationPath(deliveryId string) string {
+	return filepath.Join(c.notificationsDirectory(), deliveryId+".json")
+}
+
+// loadNotifications reads the queue from disk unless it is loaded already, the caller must hold the lock.
+func (c *ChunkedUploaderService) loadNotifications() error {
+	q := c.notifications
+	if q.loaded {
+		return nil
+	}
+
+	entries, err := afero.ReadDir(c.fs, c.notificationsDirectory())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	pending := make([]*Notification, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(c.notificationsDirectory(), entry.Name())
+		data, err := afero.ReadFile(c.fs, path)
+		if err != nil {
+			return err
+		}
+		var notification Notification
+		if json.Unmarshal(data, &notification) != nil || notification.DeliveryId == "" {
+			c.log(LogLevelWarn, "Dropping corrupt notification", LogField{"path", path})
+			c.fs.Remove(path)
+			continue
+		}
+		pending = append(pending, &notification)
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].QueuedAt.Before(pending[j].QueuedAt)
+	})
+
+	q.pending = pending
+	q.loaded = true
+	return nil
+}
+
+// saveNotification writes a notification to its file in the queue, replacing the previous one at once.
+func (c *ChunkedUploaderService) saveNotification(notification *Notification) error {
+	path := c.notificationPath(notification.DeliveryId)
+	tempPath := path + ".tmp"
+
+	file, err := openFile(c.fs, tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, StandardAccess)
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(file).Encode(notification)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		c.fs.Remove(tempPath)
+		return fmt.Errorf("failed to write notification: %w", err)
+	}
+
+	return c.fs.Rename(tempPath, path)
+}
+
+// queueNotifications queues an event for every configured notifier, dropping the oldest deliveries over the limit.
+func (c *ChunkedUploaderService) queueNotifications(event Event) {
+	q := c.notifications
+	if q == nil || len(q.names) == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	err := c.loadNotifications()
+	if err != nil {
+		c.log(LogLevelError, "Failed to load notifications", LogField{"error", err})
+		return
+	}
+
+	for _, name := range q.names {
+		notification := &Notification{
+			DeliveryId:    uuid.New().String(),
+			Notifier:      name,
+			Event:         event,
+			QueuedAt:      time.Now(),
+			NextAttemptAt: time.Now(),
+		}
+		err := c.saveNotification(notification)
+		if err != nil {
+			c.log(LogLevelError, "Failed to queue notification", LogField{"upload_id", event.UploadId}, LogField{"notifier", name}, LogField{"error", err})
+			continue
+		}
+		q.pending = append(q.pending, notification)
+	}
+
+	for q.limit > 0 && len(q.pending) > q.limit {
+		oldest := q.pending[0]
+		q.pending = q.pending[1:]
+		q.dropped++
+		c.fs.Remove(c.notificationPath(oldest.DeliveryId))
+		c.log(LogLevelWarn, "Notification queue is full, dropped the oldest delivery", LogField{"delivery_id", oldest.DeliveryId}, LogField{"notifier", oldest.Notifier}, LogField{"upload_id", oldest.Event.UploadId})
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// PendingNotifications returns the deliveries which were not accepted by their notifier yet, oldest first.
+func (c *ChunkedUploaderService) PendingNotifications() ([]Notification, error) {
+	q := c.notifications
+	if q == nil {
+		return []Notification{}, nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	err := c.loadNotifications()
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.PendingNotifications failed to load notifications %w", err)
+	}
+
+	notifications := make([]Notification, 0, len(q.pending))
+	for _, notification := range q.pending {
+		notifications = append(notifications, *notification)
+	}
+	return notifications, nil
+}
+
+// runNotifications delivers the queued notifications whenever one is queued and retries the failed ones.
+func (c *ChunkedUploaderService) runNotifications(ctx context.Context, heartbeat func()) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.notifications.wake:
+		case <-timer.C:
+		}
+
+		err := c.deliverNotifications(ctx)
+		if err != nil {
+			c.log(LogLevelError, "Failed to deliver notifications", LogField{"error", err})
+		}
+		heartbeat()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(notificationRetryInterval)
+	}
+}
+
+// deliverNotifications sends every due notification once, in the order they were queued. A notification is
+// removed from the queue only after its notifier accepted it, so a crash in between delivers it again.
+func (c *ChunkedUploaderService) deliverNotifications(ctx context.Context) error {
+	q := c.notifications
+
+	q.mu.Lock()
+	err := c.loadNotifications()
+	now := time.Now()
+	due := make([]Notification, 0, len(q.pending))
+	for _, notification := range q.pending {
+		if q.notifiers[notification.Notifier] != nil && !notification.NextAttemptAt.After(now) {
+			due = append(due, *notification)
+		}
+	}
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, notification := range due {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		deliveryCtx, cancel := context.WithTimeout(ctx, notificationDeliveryTimeout)
+		err := q.notifiers[notification.Notifier].Notify(deliveryCtx, notification)
+		cancel()
+
+		c.completeNotification(notification.DeliveryId, err)
+	}
+
+	return nil
+}
+
+// completeNotification removes a delivered notification from the queue or schedules the retry of a failed one.
+func (c *ChunkedUploaderService) completeNotification(deliveryId string, deliveryErr error) {
+	q := c.notifications
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	index := -1
+	for i, notification := range q.pending {
+		if notification.DeliveryId == deliveryId {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		// dropped from a full queue in the meantime
+		return
+	}
+	notification := q.pending[index]
+
+	if deliveryErr == nil {
+		err := c.fs.Remove(c.notificationPath(deliveryId))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			c.log(LogLevelError, "Failed to remove delivered notification", LogField{"delivery_id", deliveryId}, LogField{"error", err})
+			return
+		}
+		q.pending = append(q.pending[:index], q.pending[index+1:]...)
+		return
+	}
+
+	notification.Attempts++
+	notification.LastError = deliveryErr.Error()
+	backoff := min(notificationRetryInterval<<min(notification.Attempts-1, 16), maxNotificationBackoff)
+	notification.NextAttemptAt = time.Now().Add(backoff)
+	c.log(LogLevelWarn, "Notification delivery failed", LogField{"delivery_id", deliveryId}, LogField{"notifier", notification.Notifier}, LogField{"attempts", notification.Attempts}, LogField{"error", deliveryErr})
+
+	err := c.saveNotification(notification)
+	if err != nil {
+		c.log(LogLevelError, "Failed to save notification", LogField{"delivery_id", deliveryId}, LogField{"error", err})
+	}
+}
+
+// writeNotificationMetrics writes the size of the notification queue and the dropped deliveries in the Prometheus
+// text format.
+func (c *ChunkedUploaderService) writeNotificationMetrics(w io.Writer) {
+	q := c.notifications
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP chunkeduploader_notifications_pending Deliveries waiting for their notifier.")
+	fmt.Fprintln(w, "# TYPE chunkeduploader_notifications_pending gauge")
+	fmt.Fprintf(w, "chunkeduploader_notifications_pending %d\n", len(q.pending))
+	fmt.Fprintln(w, "# HELP chunkeduploader_notifications_dropped_total Deliveries dropped because the queue was full.")
+	fmt.Fprintln(w, "# TYPE chunkeduploader_notifications_dropped_total counter")
+	fmt.Fprintf(w, "chunkeduploader_notifications_dropped_total %d\n", q.dropped)
+}
@@ -0,0 +1,189 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// recordingNotifier records the deliveries it receives and fails them with err.
+type recordingNotifier struct {
+	mu        sync.Mutex
+	delivered []Notification
+	err       error
+	// crash stops the delivering goroutine once a delivery was received, like a process dying before the
+	// delivery was marked complete.
+	crash bool
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, notification Notification) error {
+	n.mu.Lock()
+	n.delivered = append(n.delivered, notification)
+	n.mu.Unlock()
+
+	if n.crash {
+		runtime.Goexit()
+	}
+	return n.err
+}
+
+func (n *recordingNotifier) deliveryIds() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ids := make([]string, len(n.delivered))
+	for i, notification := range n.delivered {
+		ids[i] = notification.DeliveryId
+	}
+	return ids
+}
+
+// deliverInGoroutine runs deliverNotifications in its own goroutine, which a crashing notifier may stop.
+func deliverInGoroutine(service *ChunkedUploaderService) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.deliverNotifications(context.Background())
+	}()
+	<-done
+}
+
+func pendingNotifications(t *testing.T, service *ChunkedUploaderService) []Notification {
+	t.Helper()
+
+	pending, err := service.PendingNotifications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pending
+}
+
+func TestNotificationRedeliveredAfterCrash(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	crashing := &recordingNotifier{crash: true}
+	service := newTestService(fs, WithNotifier("test", crashing))
+
+	uploadId, err := service.CreateUpload(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliverInGoroutine(service)
+
+	delivered := crashing.deliveryIds()
+	if len(delivered) != 1 {
+		t.Fatalf("got %d deliveries before the crash, want 1", len(delivered))
+	}
+
+	// the restarted service finds the delivery on disk and sends it again with the same id
+	notifier := &recordingNotifier{}
+	restarted := newTestService(fs, WithNotifier("test", notifier))
+	if err := restarted.deliverNotifications(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	redelivered := notifier.deliveryIds()
+	if len(redelivered) != 1 || redelivered[0] != delivered[0] {
+		t.Fatalf("redelivered %v, want %v", redelivered, delivered)
+	}
+	if notifier.delivered[0].Event.UploadId != uploadId || notifier.delivered[0].Event.Type != EventUploadCreated {
+		t.Errorf("redelivered event %+v", notifier.delivered[0].Event)
+	}
+
+	if pending := pendingNotifications(t, restarted); len(pending) != 0 {
+		t.Errorf("%d deliveries pending after a successful delivery", len(pending))
+	}
+	if pending := pendingNotifications(t, newTestService(fs, WithNotifier("test", notifier))); len(pending) != 0 {
+		t.Errorf("%d deliveries pending on disk after a successful delivery", len(pending))
+	}
+}
+
+func TestFailedNotificationSurvivesRestart(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	failing := &recordingNotifier{err: errors.New("receiver is down")}
+	service := newTestService(fs, WithNotifier("test", failing))
+
+	if _, err := service.CreateUpload(16); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.deliverNotifications(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := &recordingNotifier{}
+	restarted := newTestService(fs, WithNotifier("test", notifier))
+	pending := pendingNotifications(t, restarted)
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError != "receiver is down" {
+		t.Fatalf("got pending %+v, want one delivery failed once", pending)
+	}
+
+	// the retry is not due yet
+	if err := restarted.deliverNotifications(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if delivered := notifier.deliveryIds(); len(delivered) != 0 {
+		t.Errorf("delivered %v before the retry was due", delivered)
+	}
+}
+
+func TestNotificationsOfRemovedNotifierAreKept(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	crashing := &recordingNotifier{crash: true}
+	service := newTestService(fs, WithNotifier("old", crashing))
+
+	if _, err := service.CreateUpload(16); err != nil {
+		t.Fatal(err)
+	}
+	deliverInGoroutine(service)
+
+	notifier := &recordingNotifier{}
+	restarted := newTestService(fs, WithNotifier("new", notifier))
+	if err := restarted.deliverNotifications(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if delivered := notifier.deliveryIds(); len(delivered) != 0 {
+		t.Errorf("delivery of another notifier sent: %v", delivered)
+	}
+	if pending := pendingNotifications(t, restarted); len(pending) != 1 || pending[0].Notifier != "old" {
+		t.Errorf("got pending %+v, want the delivery of the old notifier", pending)
+	}
+}
+
+func TestNotificationQueueLimitDropsOldest(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	service := newTestService(fs, WithNotifier("test", &recordingNotifier{}), WithNotificationQueueLimit(2))
+
+	var uploadIds []string
+	for i := 0; i < 3; i++ {
+		uploadId, err := service.CreateUpload(16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uploadIds = append(uploadIds, uploadId)
+	}
+
+	pending := pendingNotifications(t, newTestService(fs, WithNotifier("test", &recordingNotifier{})))
+	if len(pending) != 2 || pending[0].Event.UploadId != uploadIds[1] || pending[1].Event.UploadId != uploadIds[2] {
+		t.Errorf("got pending %+v, want the two newest deliveries", pending)
+	}
+}
+
+func TestCorruptNotificationIsDropped(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	service := newTestService(fs, WithNotifier("test", &recordingNotifier{}))
+
+	path := service.notificationPath("corrupt")
+	if err := afero.WriteFile(fs, path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending := pendingNotifications(t, service); len(pending) != 0 {
+		t.Errorf("got pending %+v, want none", pending)
+	}
+	if exists(t, fs, path) {
+		t.Error("corrupt notification kept")
+	}
+}
@@ -0,0 +1,134 @@
+package chunkeduploader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+const (
+	defaultBufferValidationInterval = 5 * time.Second
+	defaultBufferValidationChunks   = 64
+)
+
+// bufferValidation is how often a write buffer checks the pending file it was created for, see
+// WithWriteBufferValidation.
+type bufferValidation struct {
+	interval time.Duration
+	chunks   int
+}
+
+// WithWriteBufferValidation sets how often an upload buffered by WithWriteCoalescing checks that its pending file was
+// not changed behind its back, like removed by an operator or renamed by another replica: once interval has passed or
+// chunks chunks were buffered since the last check, whichever comes first. Zero turns the respective trigger off and
+// zero for both checks with every chunk. The defaults are 5 seconds and 64 chunks.
+//
+// The check is a single stat compared with what the service saw after its own last write. When the file is gone the
+// buffered bytes are dropped and the chunk fails with UploadNotFoundError instead of being acknowledged for a file
+// which no longer exists, when it was changed the buffer is written to the file now at the path before continuing.
+// Either way a warning is logged and chunkeduploader_out_of_band_modifications_total is incremented.
+func WithWriteBufferValidation(interval time.Duration, chunks int) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.bufferValidation = &bufferValidation{interval: interval, chunks: chunks}
+	}
+}
+
+// validationDue reports whether a buffer which was validated at a given time and buffered a number of chunks since is
+// to be validated again.
+func (c *ChunkedUploaderService) validationDue(validatedAt time.Time, chunks int) bool {
+	v := bufferValidation{interval: defaultBufferValidationInterval, chunks: defaultBufferValidationChunks}
+	if c.bufferValidation != nil {
+		v = *c.bufferValidation
+	}
+	if v.interval <= 0 && v.chunks <= 0 {
+		return true
+	}
+	return (v.interval > 0 && time.Since(validatedAt) >= v.interval) || (v.chunks > 0 && chunks >= v.chunks)
+}
+
+// observePendingFile records the pending file of a buffered upload as the service left it.
+func (c *ChunkedUploaderService) observePendingFile(uploadId string, buf *writeBuffer) {
+	info, err := c.fs.Stat(c.getUploadFilePath(uploadId))
+	if err != nil {
+		buf.view = nil
+		return
+	}
+	buf.view = newPendingFileView(info)
+	buf.validatedAt = time.Now()
+	buf.chunks = 0
+}
+
+// validateWriteBuffer compares the pending file of a buffered upload with the recorded view when a validation is due,
+// the caller must hold the lock of the buffer. It fails with UploadNotFoundError once the file is gone.
+func (c *ChunkedUploaderService) validateWriteBuffer(uploadId string, buf *writeBuffer) error {
+	buf.chunks++
+	if buf.view != nil && !c.validationDue(buf.validatedAt, buf.chunks) {
+		return nil
+	}
+
+	info, err := c.fs.Stat(c.getUploadFilePath(uploadId))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if buf.view != nil {
+			c.outOfBandModifications.Add(1)
+			c.log(LogLevelWarn, "Pending file removed out of band, dropping buffered bytes", LogField{"upload_id", uploadId}, LogField{"bytes", len(buf.data)})
+		}
+		buf.data = buf.data[:0]
+		c.coalescer.remove(uploadId, buf)
+		return fmt.Errorf("ChunkedUploaderService.bufferChunk %w: pending file was removed", UploadNotFoundError)
+	case err != nil:
+		return fmt.Errorf("ChunkedUploaderService.bufferChunk failed to stat pending file %w", err)
+	case buf.view != nil && !samePendingFile(buf.view, info):
+		c.outOfBandModifications.Add(1)
+		c.log(LogLevelWarn, "Pending file modified out of band, reloading it", LogField{"upload_id", uploadId}, LogField{"size", info.Size()}, LogField{"modified_at", info.ModTime()})
+		// the buffered bytes were acknowledged, so they go to the file now at the path, which records it again
+		return c.flushBuffer(uploadId, buf)
+	}
+
+	buf.view = newPendingFileView(info)
+	buf.validatedAt = time.Now()
+	buf.chunks = 0
+	return nil
+}
+
+// pendingFileView is the stat of a pending file at the time it was taken. The size and modification time are copied,
+// as the stats of some file systems, like the in-memory one of afero, keep following the file.
+type pendingFileView struct {
+	info    fs.FileInfo
+	size    int64
+	modTime time.Time
+}
+
+func newPendingFileView(info fs.FileInfo) *pendingFileView {
+	return &pendingFileView{info: info, size: info.Size(), modTime: info.ModTime()}
+}
+
+func (v *pendingFileView) Size() int64 {
+	return v.size
+}
+
+// samePendingFile reports whether a stat describes the same unchanged file as a view, the identity of the file is
+// only compared on file systems reporting it.
+func samePendingFile(view *pendingFileView, info fs.FileInfo) bool {
+	if view.size != info.Size() || !view.modTime.Equal(info.ModTime()) {
+		return false
+	}
+	if view.info.Sys() != nil && info.Sys() != nil {
+		return os.SameFile(view.info, info)
+	}
+	return true
+}
+
+// writeOutOfBandMetrics writes the number of detected out of band modifications in the Prometheus text format.
+func (c *ChunkedUploaderService) writeOutOfBandMetrics(w io.Writer) {
+	if c.coalescer == nil {
+		return
+	}
+
+	fmt.Fprintln(w, "# HELP chunkeduploader_out_of_band_modifications_total Pending files of buffered uploads found removed or changed by someone else.")
+	fmt.Fprintln(w, "# TYPE chunkeduploader_out_of_band_modifications_total counter")
+	fmt.Fprintf(w, "chunkeduploader_out_of_band_modifications_total %d\n", c.outOfBandModifications.Load())
+}
@@ -0,0 +1,142 @@
+package chunkeduploader
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestOutOfBandModification(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []ChunkedUploaderServiceOption
+		// change modifies the pending file behind the back of the service after the first chunk
+		change func(fs afero.Fs, path string) error
+		// wait is the time between the change and the next chunks
+		wait time.Duration
+		// wantCodes are the answers to the chunks after the change
+		wantCodes     []int
+		wantDetected  int64
+		wantUntouched bool
+	}{
+		{
+			name:         "removed, checked with every chunk",
+			opts:         []ChunkedUploaderServiceOption{WithWriteBufferValidation(0, 0)},
+			change:       func(fs afero.Fs, path string) error { return fs.Remove(path) },
+			wantCodes:    []int{http.StatusNotFound, http.StatusNotFound},
+			wantDetected: 1,
+		},
+		{
+			name:         "removed, checked every third chunk",
+			opts:         []ChunkedUploaderServiceOption{WithWriteBufferValidation(0, 3)},
+			change:       func(fs afero.Fs, path string) error { return fs.Remove(path) },
+			wantCodes:    []int{http.StatusOK, http.StatusOK, http.StatusNotFound},
+			wantDetected: 1,
+		},
+		{
+			name:         "removed, checked after an interval",
+			opts:         []ChunkedUploaderServiceOption{WithWriteBufferValidation(10*time.Millisecond, 0)},
+			change:       func(fs afero.Fs, path string) error { return fs.Remove(path) },
+			wait:         20 * time.Millisecond,
+			wantCodes:    []int{http.StatusNotFound},
+			wantDetected: 1,
+		},
+		{
+			name:         "replaced",
+			opts:         []ChunkedUploaderServiceOption{WithWriteBufferValidation(0, 0)},
+			change:       func(fs afero.Fs, path string) error { return afero.WriteFile(fs, path, make([]byte, 10), 0644) },
+			wantCodes:    []int{http.StatusOK, http.StatusOK},
+			wantDetected: 1,
+		},
+		{
+			name:          "unchanged",
+			opts:          []ChunkedUploaderServiceOption{WithWriteBufferValidation(0, 0)},
+			change:        func(fs afero.Fs, path string) error { return nil },
+			wantCodes:     []int{http.StatusOK, http.StatusOK, http.StatusOK},
+			wantUntouched: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			service := newTestService(fs, append([]ChunkedUploaderServiceOption{WithWriteCoalescing(1<<20, time.Hour)}, tc.opts...)...)
+			handler := NewHTTPHandler(service)
+
+			uploadId, err := service.CreateUpload(1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec := postChunk(handler, uploadId, "application/octet-stream", "bytes=0-99", randomBytes(t, 100)); rec.Code != http.StatusOK {
+				t.Fatalf("first chunk: %d %s", rec.Code, rec.Body)
+			}
+
+			if err := tc.change(fs, service.getUploadFilePath(uploadId)); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(tc.wait)
+
+			for i, want := range tc.wantCodes {
+				offset := (i + 1) * 100
+				rec := postChunk(handler, uploadId, "application/octet-stream", fmt.Sprintf("bytes=%d-%d", offset, offset+99), randomBytes(t, 100))
+				if rec.Code != want {
+					t.Errorf("chunk %d after the change: got %d, want %d: %s", i, rec.Code, want, rec.Body)
+				}
+			}
+
+			if got := service.outOfBandModifications.Load(); got != tc.wantDetected {
+				t.Errorf("%d modifications detected, want %d", got, tc.wantDetected)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if line := fmt.Sprintf("chunkeduploader_out_of_band_modifications_total %d\n", tc.wantDetected); !strings.Contains(rec.Body.String(), line) {
+				t.Errorf("metrics miss %q", line)
+			}
+
+			// nothing is written into the void, a removed file stays removed
+			exists, err := afero.Exists(fs, service.getUploadFilePath(uploadId))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wantExists := tc.wantCodes[len(tc.wantCodes)-1] == http.StatusOK; exists != wantExists {
+				t.Errorf("pending file exists: %t, want %t", exists, wantExists)
+			}
+			if tc.wantUntouched {
+				if err := service.flushWriteBuffer(uploadId); err != nil {
+					t.Fatal(err)
+				}
+				meta, err := service.readMetadata(uploadId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if meta.length() != 400 {
+					t.Errorf("%d bytes written, want 400", meta.length())
+				}
+			}
+		})
+	}
+}
+
+func TestChunkToRemovedPendingFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	service := newTestService(fs)
+	handler := NewHTTPHandler(service)
+
+	uploadId, err := service.CreateUpload(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove(service.getUploadFilePath(uploadId)); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := postChunk(handler, uploadId, "application/octet-stream", "bytes=0-99", randomBytes(t, 100)); rec.Code != http.StatusNotFound {
+		t.Errorf("got %d, want 404: %s", rec.Code, rec.Body)
+	}
+	if exists, _ := afero.Exists(fs, service.getUploadFilePath(uploadId)); exists {
+		t.Errorf("chunk recreated the removed pending file")
+	}
+}
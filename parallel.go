@@ -0,0 +1,64 @@
+package chunkeduploader
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+var TooManyParallelChunksError = errors.New("too many parallel chunks")
+
+// WithMaxParallelChunks limits the number of chunk requests a single upload may have in flight at once, requests
+// over the limit are rejected with 429. The limit is advertised in the X-Max-Parallel-Chunks header.
+func WithMaxParallelChunks(limit int) ChunkedUploaderServiceOption {
+	if limit < 1 {
+		panic("chunkeduploader: the parallel chunk limit must be positive")
+	}
+
+	return func(c *ChunkedUploaderService) {
+		c.parallelChunks = &parallelChunks{limit: limit, inFlight: make(map[string]int)}
+	}
+}
+
+// parallelChunks counts the chunk requests in flight per upload.
+type parallelChunks struct {
+	limit int
+
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+// acquireChunkSlot takes one of the parallel chunk slots of a given upload, it returns false when all are taken.
+// The returned function releases the slot.
+func (c *ChunkedUploaderService) acquireChunkSlot(uploadId string) (release func(), ok bool) {
+	p := c.parallelChunks
+	if p == nil {
+		return func() {}, true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.inFlight[uploadId] >= p.limit {
+		return nil, false
+	}
+	p.inFlight[uploadId]++
+
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		p.inFlight[uploadId]--
+		if p.inFlight[uploadId] <= 0 {
+			delete(p.inFlight, uploadId)
+		}
+	}, true
+}
+
+// setMaxParallelHeader advertises the parallel chunk limit, if there is one.
+func (c *ChunkedUploaderHandler) setMaxParallelHeader(w http.ResponseWriter) {
+	if p := c.service.parallelChunks; p != nil {
+		w.Header().Set("X-Max-Parallel-Chunks", strconv.Itoa(p.limit))
+	}
+}
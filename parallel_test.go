@@ -0,0 +1,43 @@
+package chunkeduploader
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestAcquireChunkSlot(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs(), WithMaxParallelChunks(2))
+
+	first, ok := service.acquireChunkSlot("a")
+	if !ok {
+		t.Fatal("first slot not acquired")
+	}
+	if _, ok := service.acquireChunkSlot("a"); !ok {
+		t.Fatal("second slot not acquired")
+	}
+	if _, ok := service.acquireChunkSlot("a"); ok {
+		t.Error("slot acquired over the limit")
+	}
+	if _, ok := service.acquireChunkSlot("b"); !ok {
+		t.Error("limit shared between uploads")
+	}
+
+	first()
+	if _, ok := service.acquireChunkSlot("a"); !ok {
+		t.Error("released slot not acquired")
+	}
+}
+
+func TestWithMaxParallelChunksValidation(t *testing.T) {
+	for _, limit := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("limit %d did not panic", limit)
+				}
+			}()
+			WithMaxParallelChunks(limit)
+		}()
+	}
+}
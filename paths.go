@@ -0,0 +1,86 @@
+package chunkeduploader
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// PathBuilder decides where the files of an upload are stored. The metadata backups, temporary metadata files and
+// snapshots are stored next to the paths it returns.
+type PathBuilder interface {
+	// DataPath returns the path of the data file of an upload.
+	DataPath(uploadId string) string
+	// MetaPath returns the path of the metadata file of an upload, it should be the data path with an extension so
+	// cleanup can tell which upload the file belongs to.
+	MetaPath(uploadId string) string
+	// Root returns the directory holding all paths of the builder, no other files should be stored below it.
+	Root() string
+	// ParseID returns the upload id of a given data path, it returns false for any other path.
+	ParseID(path string) (string, bool)
+}
+
+// WithPathBuilder replaces the layout of the pending directory with a custom naming scheme, for example one which
+// prefixes the files with a customer id. The builder is validated when the service is created, an invalid one panics.
+// Existing uploads stored under another scheme are not found.
+func WithPathBuilder(builder PathBuilder) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.paths = builder
+	}
+}
+
+// layoutPaths stores uploads in the pending directory of the namespace using a LayoutStrategy, it is the default.
+type layoutPaths struct {
+	root   string
+	layout LayoutStrategy
+}
+
+func (p layoutPaths) DataPath(uploadId string) string {
+	return filepath.Join(p.root, p.layout.RelativePath(uploadId))
+}
+
+func (p layoutPaths) MetaPath(uploadId string) string {
+	return p.DataPath(uploadId) + ".json"
+}
+
+func (p layoutPaths) Root() string {
+	return p.root
+}
+
+// ParseID only looks at the file name, so uploads left in the locations of another layout are still recognized.
+func (p layoutPaths) ParseID(path string) (string, bool) {
+	uploadId := filepath.Base(path)
+	if _, err := uuid.Parse(uploadId); err != nil {
+		return "", false
+	}
+	return uploadId, true
+}
+
+// validatePathBuilder checks that the paths of a builder stay below its root and that upload ids round-trip.
+func validatePathBuilder(builder PathBuilder) error {
+	root := filepath.Clean(builder.Root())
+	uploadId := uuid.New().String()
+
+	dataPath := builder.DataPath(uploadId)
+	metaPath := builder.MetaPath(uploadId)
+	for _, path := range []string{dataPath, metaPath} {
+		rel, err := filepath.Rel(root, filepath.Clean(path))
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("path %s is outside of root %s", path, root)
+		}
+	}
+
+	if dataPath == metaPath {
+		return fmt.Errorf("data and metadata paths are both %s", dataPath)
+	}
+	if parsed, ok := builder.ParseID(dataPath); !ok || parsed != uploadId {
+		return fmt.Errorf("upload id %s does not round-trip through data path %s", uploadId, dataPath)
+	}
+	if _, ok := builder.ParseID(metaPath); ok {
+		return fmt.Errorf("metadata path %s is parsed as a data path", metaPath)
+	}
+
+	return nil
+}
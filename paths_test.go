@@ -0,0 +1,179 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/afero"
+)
+
+// prefixPaths names the files of every upload after a customer, like "cust123__<upload id>".
+type prefixPaths struct {
+	root   string
+	prefix string
+}
+
+func (p prefixPaths) DataPath(uploadId string) string {
+	return filepath.Join(p.root, p.prefix+"__"+uploadId)
+}
+
+func (p prefixPaths) MetaPath(uploadId string) string {
+	return p.DataPath(uploadId) + ".json"
+}
+
+func (p prefixPaths) Root() string {
+	return p.root
+}
+
+func (p prefixPaths) ParseID(path string) (string, bool) {
+	uploadId, ok := strings.CutPrefix(filepath.Base(path), p.prefix+"__")
+	if !ok {
+		return "", false
+	}
+	if _, err := uuid.Parse(uploadId); err != nil {
+		return "", false
+	}
+	return uploadId, true
+}
+
+// brokenPaths overrides single methods of prefixPaths to break the builder.
+type brokenPaths struct {
+	prefixPaths
+	dataPath func(uploadId string) string
+	metaPath func(uploadId string) string
+	parseID  func(path string) (string, bool)
+}
+
+func (p brokenPaths) DataPath(uploadId string) string {
+	if p.dataPath != nil {
+		return p.dataPath(uploadId)
+	}
+	return p.prefixPaths.DataPath(uploadId)
+}
+
+func (p brokenPaths) MetaPath(uploadId string) string {
+	if p.metaPath != nil {
+		return p.metaPath(uploadId)
+	}
+	return p.prefixPaths.MetaPath(uploadId)
+}
+
+func (p brokenPaths) ParseID(path string) (string, bool) {
+	if p.parseID != nil {
+		return p.parseID(path)
+	}
+	return p.prefixPaths.ParseID(path)
+}
+
+func TestValidatePathBuilder(t *testing.T) {
+	valid := prefixPaths{root: "/uploads", prefix: "cust123"}
+
+	for _, tc := range []struct {
+		name    string
+		builder PathBuilder
+		wantErr bool
+	}{
+		{name: "default", builder: layoutPaths{root: "/uploads", layout: FlatLayout{}}},
+		{name: "sharded", builder: layoutPaths{root: "/uploads", layout: ShardedLayout{}}},
+		{name: "prefixed", builder: valid},
+		{name: "data outside of root", builder: brokenPaths{prefixPaths: valid, dataPath: func(uploadId string) string { return "/elsewhere/" + uploadId }}, wantErr: true},
+		{name: "metadata escaping root", builder: brokenPaths{prefixPaths: valid, metaPath: func(uploadId string) string { return "/uploads/../" + uploadId + ".json" }}, wantErr: true},
+		{name: "data at root", builder: brokenPaths{prefixPaths: valid, dataPath: func(uploadId string) string { return "/uploads" }}, wantErr: true},
+		{name: "same data and metadata", builder: brokenPaths{prefixPaths: valid, metaPath: valid.DataPath}, wantErr: true},
+		{name: "id does not round-trip", builder: brokenPaths{prefixPaths: valid, parseID: func(path string) (string, bool) { return "other", true }}, wantErr: true},
+		{name: "metadata parsed as data", builder: brokenPaths{prefixPaths: valid, parseID: func(path string) (string, bool) {
+			return strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "cust123__"), ".json"), true
+		}}, wantErr: true},
+	} {
+		err := validatePathBuilder(tc.builder)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: %v, want error %t", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestCustomPathBuilder(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	paths := prefixPaths{root: "/pending", prefix: "cust123"}
+	service := newTestService(fs, WithPathBuilder(paths))
+
+	data := randomBytes(t, 1024)
+	uploadId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/pending/cust123__" + uploadId, "/pending/cust123__" + uploadId + ".json"} {
+		if !exists(t, fs, path) {
+			t.Errorf("%s not created", path)
+		}
+	}
+
+	if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+	uploads, err := service.ListUploads(context.Background())
+	if err != nil || len(uploads) != 1 || uploads[0].UploadId != uploadId {
+		t.Errorf("ListUploads: %v %v, want %s", uploads, err, uploadId)
+	}
+
+	path, err := service.FinishUpload(context.Background(), uploadId, sha256Hex(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/pending/cust123__"+uploadId {
+		t.Errorf("finished at %s, want the data path of the builder", path)
+	}
+	file, err := service.OpenUploadedFile(uploadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	// cleanup derives the upload from its data path and keeps files the builder does not recognize
+	oldId, err := service.CreateUpload(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldFiles := []string{paths.DataPath(oldId), paths.MetaPath(oldId)}
+	foreign := "/pending/notes.txt"
+	if err := afero.WriteFile(fs, foreign, []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ageFiles(t, fs, 48*time.Hour, append(oldFiles, foreign)...)
+
+	summary, err := service.CleanupUploads(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.RemovedUploads != 1 {
+		t.Errorf("removed %d uploads, want 1", summary.RemovedUploads)
+	}
+	for _, path := range oldFiles {
+		if exists(t, fs, path) {
+			t.Errorf("%s of the old upload kept", path)
+		}
+	}
+	for _, path := range []string{foreign, paths.DataPath(uploadId), paths.MetaPath(uploadId)} {
+		if !exists(t, fs, path) {
+			t.Errorf("%s removed", path)
+		}
+	}
+}
+
+func TestInvalidPathBuilderPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("service with an invalid path builder created")
+		}
+	}()
+
+	newTestService(afero.NewMemMapFs(), WithPathBuilder(brokenPaths{
+		prefixPaths: prefixPaths{root: "/pending", prefix: "cust123"},
+		dataPath:    func(uploadId string) string { return "/elsewhere/" + uploadId },
+	}))
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+)
+
+// Capabilities describes what the server supports, see Client.Capabilities.
+type Capabilities struct {
+	Version                  string                    `json:"version"`
+	APIVersion               string                    `json:"api_version"`
+	Features                 []string                  `json:"features"`
+	ChecksumAlgorithms       []utils.ChecksumAlgorithm `json:"checksum_algorithms"`
+	DefaultChecksumAlgorithm utils.ChecksumAlgorithm   `json:"default_checksum_algorithm"`
+	ChunkEncodings           []string                  `json:"chunk_encodings"`
+	MaxParallelChunks        int                       `json:"max_parallel_chunks,omitempty"`
+	MaxFileSize              int64                     `json:"max_file_size,omitempty"`
+	MaxChunkCount            int64                     `json:"max_chunk_count,omitempty"`
+	RecommendedChunkSize     int64                     `json:"recommended_chunk_size,omitempty"`
+	SignedURLs               bool                      `json:"signed_urls"`
+}
+
+// HasFeature reports whether the server declares a given feature, like "tree_hash".
+func (c *Capabilities) HasFeature(feature string) bool {
+	for _, f := range c.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsAlgorithm reports whether the server verifies checksums of a given algorithm.
+func (c *Capabilities) SupportsAlgorithm(algorithm utils.ChecksumAlgorithm) bool {
+	for _, a := range c.ChecksumAlgorithms {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// Capabilities fetches what the server supports from its capabilities endpoint, the result is cached by the client.
+func (c *Client) Capabilities(ctx context.Context) (*Capabilities, error) {
+	if c.capabilities != nil {
+		return c.capabilities, nil
+	}
+
+	var resp Capabilities
+	err := c.doJsonRequest(ctx, http.MethodGet, fmt.Sprintf("%s/capabilities", c.Endpoint), nil, http.StatusOK, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch capabilities %w", err)
+	}
+
+	c.capabilities = &resp
+	return c.capabilities, nil
+}
+
+// negotiate turns off the optional behaviors the server does not declare, servers which cannot tell what they
+// support are trusted to support everything.
+func (c *Client) negotiate(ctx context.Context) {
+	if !c.NegotiateCapabilities {
+		return
+	}
+
+	capabilities, err := c.Capabilities(ctx)
+	if err != nil {
+		c.warn("could not negotiate capabilities: %s", err)
+		return
+	}
+
+	if c.TreeHash && !capabilities.SupportsAlgorithm(utils.ChecksumSHA256Tree) {
+		c.warn("server does not support tree hashes, using sha256")
+		c.TreeHash = false
+	}
+	if c.StrictResume && !capabilities.HasFeature("strict_resume") {
+		c.warn("server does not support strict resume")
+		c.StrictResume = false
+	}
+	if c.AutoChunkSize && capabilities.RecommendedChunkSize > 0 {
+		c.ChunkSize = capabilities.RecommendedChunkSize
+	}
+	if capabilities.MaxParallelChunks > 0 {
+		c.maxParallelChunks = capabilities.MaxParallelChunks
+	}
+}
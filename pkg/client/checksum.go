@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+	"github.com/spf13/afero"
+)
+
+// defaultVerifyBlockSize is the size of the blocks VerifyLocalFile compares large files in.
+const defaultVerifyBlockSize = 64 << 20
+
+type ChecksumResponse struct {
+	Algorithm string `json:"algorithm"`
+	Checksum  string `json:"checksum"`
+	Start     int64  `json:"start"`
+	End       int64  `json:"end"`
+	Size      int64  `json:"size"`
+}
+
+// VerificationMismatch is returned by VerifyLocalFile when a block of a large file differs, both Start and End are
+// inclusive.
+type VerificationMismatch struct {
+	Start int64
+	End   int64
+}
+
+func (e *VerificationMismatch) Error() string {
+	return fmt.Sprintf("range %d-%d differs from the server", e.Start, e.End)
+}
+
+// VerifyLocalFile compares the SHA-256 of a local file with the one of an upload on the server without downloading
+// it. It returns false without an error when the files differ, errors are only returned for I/O and network
+// failures. Files larger than VerifyBlockSize are compared block by block, stopping at the first block which differs,
+// which is returned as a *VerificationMismatch error.
+func (c *Client) VerifyLocalFile(ctx context.Context, uploadId string, localPath string) (bool, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	blockSize := c.VerifyBlockSize
+	if blockSize <= 0 {
+		blockSize = defaultVerifyBlockSize
+	}
+
+	if info.Size() <= blockSize {
+		local, err := checksumSection(file, 0, info.Size())
+		if err != nil {
+			return false, err
+		}
+
+		remote, err := c.remoteChecksum(ctx, uploadId, nil)
+		if err != nil {
+			return false, err
+		}
+
+		return remote.Size == info.Size() && remote.Checksum == local, nil
+	}
+
+	for start := int64(0); start < info.Size(); start += blockSize {
+		end := min(start+blockSize, info.Size()) - 1
+
+		local, err := checksumSection(file, start, end-start+1)
+		if err != nil {
+			return false, err
+		}
+
+		remote, err := c.remoteChecksum(ctx, uploadId, &ByteRange{Start: start, End: end})
+		if err != nil {
+			return false, err
+		}
+
+		if remote.Size != info.Size() {
+			// the bytes past the end of the shorter file differ
+			return false, &VerificationMismatch{Start: min(remote.Size, info.Size()), End: max(remote.Size, info.Size()) - 1}
+		}
+		if remote.Checksum != local {
+			return false, &VerificationMismatch{Start: start, End: end}
+		}
+	}
+
+	return true, nil
+}
+
+// ComputeLocalChecksum computes the checksum of a local file with a given algorithm, like "sha256", "crc32c" or
+// "sha256-tree", in the encoding the server uses for it.
+func (c *Client) ComputeLocalChecksum(ctx context.Context, filePath string, algo string) (string, error) {
+	algorithm := utils.ChecksumAlgorithm(algo)
+	if !algorithm.Valid() {
+		return "", fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+
+	return utils.ComputeChecksumWith(ctx, afero.NewOsFs(), filePath, algorithm)
+}
+
+func checksumSection(r io.ReaderAt, start int64, length int64) (string, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, io.NewSectionReader(r, start, length))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// remoteChecksum asks the server for the SHA-256 of an upload, or of a range of it when one is given.
+func (c *Client) remoteChecksum(ctx context.Context, uploadId string, r *ByteRange) (*ChecksumResponse, error) {
+	checksumUrl := fmt.Sprintf("%s/%s/checksum", c.Endpoint, url.PathEscape(uploadId))
+	if r != nil {
+		checksumUrl += fmt.Sprintf("?start=%d&end=%d", r.Start, r.End)
+	}
+
+	var resp ChecksumResponse
+	err := c.doJsonRequest(ctx, http.MethodGet, checksumUrl, nil, http.StatusOK, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checksum %w", err)
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestComputeLocalChecksum(t *testing.T) {
+	for _, tc := range []struct {
+		algo string
+		want string
+	}{
+		{"sha256", "c03905fcdab297513a620ec81ed46ca44ddb62d41cbbd83eb4a5a3592be26a69"},
+		{"crc32c", "/ieOcg=="},
+		// a file within a single leaf hashes to the digest of that leaf
+		{"sha256-tree", "c03905fcdab297513a620ec81ed46ca44ddb62d41cbbd83eb4a5a3592be26a69"},
+	} {
+		c := Client{}
+		got, err := c.ComputeLocalChecksum(context.Background(), filepath.Join("testdata", "golden.txt"), tc.algo)
+		if err != nil {
+			t.Errorf("%s: %v", tc.algo, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.algo, got, tc.want)
+		}
+	}
+}
+
+func TestComputeLocalChecksumErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		path string
+		algo string
+	}{
+		{"unsupported algorithm", filepath.Join("testdata", "golden.txt"), "md5"},
+		{"missing file", filepath.Join("testdata", "missing.txt"), "sha256"},
+	} {
+		c := Client{}
+		if got, err := c.ComputeLocalChecksum(context.Background(), tc.path, tc.algo); err == nil {
+			t.Errorf("%s: got %s, want an error", tc.name, got)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Logger receives the warnings of the client, *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// ChunkAck is the JSON acknowledgment of a chunk, Offset is where the chunk was written.
+type ChunkAck struct {
+	Received      int64  `json:"received"`
+	Offset        int64  `json:"offset"`
+	ChunkChecksum string `json:"chunk_checksum"`
+}
+
+// rangeRejectedError is returned when the server does not accept the Range header of a chunk.
+type rangeRejectedError struct {
+	err error
+}
+
+func (e *rangeRejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rangeRejectedError) Unwrap() error {
+	return e.err
+}
+
+func (c *Client) warn(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+	}
+}
+
+// probeChunk sends the first chunk of the source and detects whether the server accepts chunks at given offsets.
+// Older servers only append chunks, the rest of the upload is then sent sequentially without a Range header. At
+// offset 0 both kinds of servers write the chunk to the same place, so the probe cannot corrupt the upload, and the
+// committed offset is checked before any other chunk is sent. It returns the size of the chunk.
+func (c *Client) probeChunk(ctx context.Context, chunkUrl string, source io.Reader) (int64, error) {
+	if c.ChunkSize <= 0 {
+		return 0, errors.New("chunk size must be positive")
+	}
+	c.sequential = false
+
+	chunk, err := io.ReadAll(io.LimitReader(source, c.ChunkSize))
+	if err != nil {
+		return 0, err
+	}
+	size := int64(len(chunk))
+
+	ack, err := c.postChunk(ctx, chunkUrl, 0, bytes.NewReader(chunk), true)
+	var rejected *rangeRejectedError
+	if errors.As(err, &rejected) {
+		c.warn("chunked-uploader: server rejected the Range header (%s), falling back to sequential chunks", err)
+		c.sequential = true
+		ack, err = c.postChunk(ctx, chunkUrl, -1, bytes.NewReader(chunk), true)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	committed := int64(-1)
+	if ack != nil {
+		committed = ack.Offset + ack.Received
+	} else if !c.sequential {
+		received, err := c.receivedOffset(ctx, 0)
+		if err == nil {
+			committed = received
+		}
+	}
+
+	if committed == -1 {
+		if !c.sequential {
+			c.warn("chunked-uploader: server does not report committed offsets, falling back to sequential chunks")
+			c.sequential = true
+		}
+		return size, nil
+	}
+	if committed != size {
+		return 0, fmt.Errorf("server committed %d bytes of the first chunk of %d bytes", committed, size)
+	}
+
+	return size, nil
+}
+
+// postChunk sends a single chunk at a given offset, with offset -1 the chunk is sent without a Range header and the
+// server appends it. With ack a JSON acknowledgment is asked for, nil is returned when the server sends none.
+func (c *Client) postChunk(ctx context.Context, chunkUrl string, offset int64, body io.Reader, ack bool) (*ChunkAck, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, chunkUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if offset >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("offset=%d-", offset))
+		if c.strictResume {
+			req.Header.Set("X-Expected-Committed", strconv.FormatInt(offset, 10))
+		}
+	}
+	if ack {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	res, err := c.DoRequest(req)
+	if err != nil {
+		return nil, &retryableError{fmt.Errorf("failed to upload chunk %w", err)}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusTooManyRequests {
+		return nil, &retryableError{fmt.Errorf("failed to upload chunk %s", getJsonError(res.Body))}
+	}
+
+	if offset >= 0 && (res.StatusCode == http.StatusBadRequest || res.StatusCode == http.StatusRequestedRangeNotSatisfiable) {
+		return nil, &rangeRejectedError{fmt.Errorf("failed to upload chunk %s", getJsonError(res.Body))}
+	}
+
+	if res.StatusCode == http.StatusConflict && c.strictResume {
+		return nil, decodeConflict(res.Body)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to upload chunk %s", getJsonError(res.Body))
+	}
+
+	var chunkAck *ChunkAck
+	if ack {
+		var decoded ChunkAck
+		if json.NewDecoder(res.Body).Decode(&decoded) == nil {
+			chunkAck = &decoded
+		}
+	}
+
+	// the chunk is written whatever happens to the extension, which the next chunk tries again while the deadline
+	// stays close
+	err = c.extendIfExpiring(ctx, res.Header.Get("X-Upload-Expires"))
+	if err != nil {
+		c.warn("chunked-uploader: %s", err)
+	}
+
+	return chunkAck, nil
+}
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func TestPostChunkSurvivesFailedExtend(t *testing.T) {
+	extends := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/extend"):
+			extends++
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error": "unavailable"}`))
+		case strings.HasSuffix(r.URL.Path, "/upload"):
+			w.Header().Set("X-Upload-Expires", time.Now().Add(time.Minute).Format(time.RFC3339))
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	uploadId := "upload"
+	logger := &recordingLogger{}
+	c := &Client{
+		DoRequest:          http.DefaultClient.Do,
+		Endpoint:           server.URL,
+		UploadId:           &uploadId,
+		MinTTLBeforeExtend: time.Hour,
+		Logger:             logger,
+	}
+
+	_, err := c.postChunk(context.Background(), server.URL+"/upload/upload", 0, strings.NewReader("chunk"), false)
+	if err != nil {
+		t.Fatalf("accepted chunk failed because of the extension: %v", err)
+	}
+	if extends != 1 {
+		t.Errorf("%d extensions, want 1", extends)
+	}
+	if len(logger.messages) != 1 {
+		t.Errorf("failed extension logged %d times, want once", len(logger.messages))
+	}
+}
+
+// compatServer imitates the chunk handling of different server versions.
+type compatServer struct {
+	// rejectRange answers chunks with a Range header with 400, like servers which do not know the header
+	rejectRange bool
+	// appendOnly appends every chunk whatever its Range header says and sends neither acks nor regions
+	appendOnly bool
+	// shortCommit acknowledges one byte less than was sent
+	shortCommit bool
+
+	data   []byte
+	chunks int
+	ranged int
+	finish string
+}
+
+func (s *compatServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/init"):
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"upload_id": "upload"}`))
+	case strings.HasSuffix(r.URL.Path, "/upload"):
+		s.chunks++
+		rangeHeader := r.Header.Get("Range")
+		if rangeHeader != "" {
+			s.ranged++
+		}
+		if rangeHeader != "" && s.rejectRange {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid range"}`))
+			return
+		}
+
+		chunk, _ := io.ReadAll(r.Body)
+		offset := int64(len(s.data))
+		if rangeHeader != "" && !s.appendOnly {
+			fmt.Sscanf(rangeHeader, "offset=%d-", &offset)
+		}
+		if end := offset + int64(len(chunk)); end > int64(len(s.data)) {
+			s.data = append(s.data, make([]byte, end-int64(len(s.data)))...)
+		}
+		copy(s.data[offset:], chunk)
+
+		w.WriteHeader(http.StatusOK)
+		if !s.appendOnly && r.Header.Get("Accept") == "application/json" {
+			received := int64(len(chunk))
+			if s.shortCommit {
+				received--
+			}
+			json.NewEncoder(w).Encode(ChunkAck{Received: received, Offset: offset})
+		}
+	case strings.HasSuffix(r.URL.Path, "/finish"):
+		var req struct {
+			Checksum string `json:"checksum"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		s.finish = req.Checksum
+		w.Write([]byte(`{"path": "/uploads/upload"}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestUploadFallsBackToSequential(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdefghij"), 26)
+
+	for _, tc := range []struct {
+		name   string
+		server *compatServer
+		// wantRanged is the number of chunks sent with a Range header
+		wantRanged   int
+		wantWarnings int
+		wantErr      bool
+	}{
+		{name: "ranged server", server: &compatServer{}, wantRanged: 6},
+		{name: "range rejected", server: &compatServer{rejectRange: true}, wantRanged: 1, wantWarnings: 1},
+		{name: "append only", server: &compatServer{appendOnly: true}, wantRanged: 1, wantWarnings: 1},
+		{name: "first chunk not committed", server: &compatServer{shortCommit: true}, wantRanged: 1, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(tc.server)
+			defer server.Close()
+
+			logger := &recordingLogger{}
+			c := &Client{DoRequest: http.DefaultClient.Do, Endpoint: server.URL, ChunkSize: 100, Parallelism: 1, Logger: logger}
+			path, err := c.Upload(context.Background(), io.NopCloser(bytes.NewReader(data)))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("upload to %s succeeded", tc.name)
+				}
+				if tc.server.chunks != 1 {
+					t.Errorf("%d chunks sent, want only the first one", tc.server.chunks)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if path != "/uploads/upload" {
+				t.Errorf("path: got %q", path)
+			}
+			if !bytes.Equal(tc.server.data, data) {
+				t.Errorf("server received %d bytes which differ from the source", len(tc.server.data))
+			}
+			sum := sha256.Sum256(data)
+			if tc.server.finish != hex.EncodeToString(sum[:]) {
+				t.Errorf("finished with %s, want the checksum of the source", tc.server.finish)
+			}
+			if tc.server.ranged != tc.wantRanged {
+				t.Errorf("%d chunks with a Range header, want %d", tc.server.ranged, tc.wantRanged)
+			}
+			if len(logger.messages) != tc.wantWarnings {
+				t.Errorf("warnings %q, want %d", logger.messages, tc.wantWarnings)
+			}
+		})
+	}
+}
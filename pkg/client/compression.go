@@ -0,0 +1,60 @@
+package client
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ContentDecoder decodes responses compressed with a content coding, see Client.Decoders.
+type ContentDecoder struct {
+	// Encoding is the name of the content coding in Accept-Encoding and Content-Encoding, like "br".
+	Encoding  string
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// gzipDecoder is always accepted after the decoders of the client.
+var gzipDecoder = ContentDecoder{
+	Encoding: "gzip",
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+}
+
+// decoders returns the decoders of the client followed by gzip, in order of preference.
+func (c *Client) decoders() []ContentDecoder {
+	decoders := append([]ContentDecoder{}, c.Decoders...)
+	for _, decoder := range decoders {
+		if decoder.Encoding == gzipDecoder.Encoding {
+			return decoders
+		}
+	}
+	return append(decoders, gzipDecoder)
+}
+
+// acceptEncoding returns the Accept-Encoding header of the JSON requests.
+func (c *Client) acceptEncoding() string {
+	decoders := c.decoders()
+	encodings := make([]string, len(decoders))
+	for i, decoder := range decoders {
+		encodings[i] = decoder.Encoding
+	}
+	return strings.Join(encodings, ", ")
+}
+
+// decodeBody returns the body of a response to a request sent with acceptEncoding, decompressed.
+func (c *Client) decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return resp.Body, nil
+	}
+
+	for _, decoder := range c.decoders() {
+		if decoder.Encoding == encoding {
+			return decoder.NewReader(resp.Body)
+		}
+	}
+	return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var deflateDecoder = ContentDecoder{
+	Encoding: "deflate",
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		return flate.NewReader(r), nil
+	},
+}
+
+func TestClientDecodesResponses(t *testing.T) {
+	body := `{"version": "1.2.3", "features": ["metadata", "regions"]}`
+
+	for _, tc := range []struct {
+		name     string
+		decoders []ContentDecoder
+		// encoding is the Content-Encoding the server answers with
+		encoding           string
+		wantAcceptEncoding string
+		wantErr            bool
+	}{
+		{name: "identity", wantAcceptEncoding: "gzip"},
+		{name: "gzip", encoding: "gzip", wantAcceptEncoding: "gzip"},
+		{name: "additional decoder", decoders: []ContentDecoder{deflateDecoder}, encoding: "deflate", wantAcceptEncoding: "deflate, gzip"},
+		{name: "gzip next to an additional decoder", decoders: []ContentDecoder{deflateDecoder}, encoding: "gzip", wantAcceptEncoding: "deflate, gzip"},
+		{name: "gzip preferred", decoders: []ContentDecoder{gzipDecoder, deflateDecoder}, encoding: "deflate", wantAcceptEncoding: "gzip, deflate"},
+		{name: "unsupported encoding", encoding: "deflate", wantAcceptEncoding: "gzip", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var acceptEncoding string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				acceptEncoding = r.Header.Get("Accept-Encoding")
+
+				var writer io.WriteCloser
+				switch tc.encoding {
+				case "gzip":
+					writer = gzip.NewWriter(w)
+				case "deflate":
+					writer, _ = flate.NewWriter(w, flate.DefaultCompression)
+				}
+				if writer != nil {
+					w.Header().Set("Content-Encoding", tc.encoding)
+				}
+				w.WriteHeader(http.StatusOK)
+				if writer == nil {
+					io.WriteString(w, body)
+					return
+				}
+				io.WriteString(writer, body)
+				writer.Close()
+			}))
+			defer server.Close()
+
+			c := Client{Endpoint: server.URL, Decoders: tc.decoders, DoRequest: http.DefaultClient.Do}
+			capabilities, err := c.Capabilities(context.Background())
+			if acceptEncoding != tc.wantAcceptEncoding {
+				t.Errorf("Accept-Encoding %q, want %q", acceptEncoding, tc.wantAcceptEncoding)
+			}
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("decoded a response in an unsupported encoding")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if capabilities.Version != "1.2.3" || !reflect.DeepEqual(capabilities.Features, []string{"metadata", "regions"}) {
+				t.Errorf("got %+v", capabilities)
+			}
+		})
+	}
+}
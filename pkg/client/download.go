@@ -0,0 +1,354 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+)
+
+const (
+	defaultDownloadConcurrency = 4
+	defaultDownloadChunkSize   = 8 << 20
+)
+
+// DownloadOptions configure Client.Download.
+type DownloadOptions struct {
+	// Concurrency is the number of ranges requested at once, it defaults to 4 when zero.
+	Concurrency int
+	// ChunkSize is the size of the requested ranges, it defaults to ChunkSize of the client or 8 MiB when zero.
+	ChunkSize int64
+	// ExpectedChecksum is compared with the checksum of the downloaded file instead of the ETag of the server, it
+	// requires a writer implementing io.ReaderAt.
+	ExpectedChecksum string
+	// ChecksumAlgorithm is the algorithm of ExpectedChecksum, it defaults to the one the server reports in
+	// X-Checksum-Algorithm and then to sha256.
+	ChecksumAlgorithm utils.ChecksumAlgorithm
+	// StateFile records the ranges already written to the writer, so a download interrupted midway continues with the
+	// missing ranges when it is started again with the same writer. It is removed once the download is complete and
+	// ignored when the file on the server has changed since.
+	StateFile string
+}
+
+// DownloadChecksumMismatch is returned by Download when the checksum of the downloaded file differs from the expected
+// one.
+type DownloadChecksumMismatch struct {
+	Expected string
+	Actual   string
+}
+
+func (e *DownloadChecksumMismatch) Error() string {
+	return fmt.Sprintf("downloaded file checksum %s differs from the expected %s", e.Actual, e.Expected)
+}
+
+// downloadState is the content of DownloadOptions.StateFile.
+type downloadState struct {
+	Size      int64       `json:"size"`
+	Validator string      `json:"validator"`
+	ChunkSize int64       `json:"chunk_size"`
+	Done      []ByteRange `json:"done"`
+}
+
+// downloadInfo describes the file to download, the validator is the ETag or else the Last-Modified date of the file,
+// sent in If-Range so ranges of a replaced file are never mixed.
+type downloadInfo struct {
+	url       string
+	size      int64
+	validator string
+	etag      string
+	algorithm utils.ChecksumAlgorithm
+}
+
+// Download fetches a finished upload into w with parallel ranged GETs of its export endpoint. The upload is given by
+// its id, by the path returned when it was finished, whose base name is the upload id, or by a full URL like a signed
+// download URL. The whole file is verified against opts.ExpectedChecksum or else the strong ETag of the server when w
+// implements io.ReaderAt, Progress of the client receives the downloaded bytes.
+func (c *Client) Download(ctx context.Context, uploadIdOrPath string, w io.WriterAt, opts DownloadOptions) error {
+	downloadUrl := c.downloadURL(uploadIdOrPath)
+
+	info, err := c.headDownload(ctx, downloadUrl)
+	if err != nil {
+		return err
+	}
+
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = c.ChunkSize
+	}
+	if chunkSize <= 0 {
+		chunkSize = defaultDownloadChunkSize
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultDownloadConcurrency
+	}
+
+	state := c.loadDownloadState(opts.StateFile, info, chunkSize)
+	done := make(map[int64]bool, len(state.Done))
+	transferred := int64(0)
+	for _, r := range state.Done {
+		done[r.Start] = true
+		transferred += r.End - r.Start + 1
+	}
+	progress := c.newProgress(info.size, transferred)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ranges := make(chan ByteRange)
+	go func() {
+		defer close(ranges)
+		for start := int64(0); start < info.size; start += chunkSize {
+			if done[start] {
+				continue
+			}
+			end := start + chunkSize - 1
+			if end >= info.size {
+				end = info.size - 1
+			}
+			select {
+			case ranges <- ByteRange{Start: start, End: end}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var downloadErr error
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range ranges {
+				err := c.downloadRange(ctx, info, w, r)
+				if err == nil && opts.StateFile != "" {
+					mu.Lock()
+					state.Done = append(state.Done, r)
+					err = saveDownloadState(opts.StateFile, state)
+					mu.Unlock()
+				}
+				if err != nil {
+					errOnce.Do(func() {
+						downloadErr = err
+						cancel()
+					})
+					return
+				}
+				progress.add(r.End - r.Start + 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if downloadErr != nil {
+		return downloadErr
+	}
+
+	err = c.verifyDownload(ctx, info, w, opts)
+	if err != nil {
+		var mismatch *DownloadChecksumMismatch
+		if errors.As(err, &mismatch) && opts.StateFile != "" {
+			// the written ranges cannot be trusted, the next attempt starts over
+			os.Remove(opts.StateFile)
+		}
+		return err
+	}
+
+	if opts.StateFile != "" {
+		err = os.Remove(opts.StateFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// downloadURL resolves the argument of Download to the URL of the file.
+func (c *Client) downloadURL(uploadIdOrPath string) string {
+	if u, err := url.Parse(uploadIdOrPath); err == nil && u.IsAbs() {
+		return uploadIdOrPath
+	}
+
+	uploadId := uploadIdOrPath
+	if strings.ContainsAny(uploadId, `/\`) {
+		uploadId = path.Base(strings.ReplaceAll(uploadId, `\`, "/"))
+	}
+	return fmt.Sprintf("%s/%s/export", strings.TrimSuffix(c.Endpoint, "/"), url.PathEscape(uploadId))
+}
+
+// headDownload reads the size, the validator and the checksum of the file to download.
+func (c *Client) headDownload(ctx context.Context, downloadUrl string) (*downloadInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, downloadUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.DoRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to read download headers: %s", res.Status)
+	}
+
+	size, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("download has no valid Content-Length")
+	}
+
+	info := &downloadInfo{
+		url:       downloadUrl,
+		size:      size,
+		validator: res.Header.Get("ETag"),
+		algorithm: utils.ChecksumAlgorithm(res.Header.Get("X-Checksum-Algorithm")),
+	}
+	if strings.HasPrefix(info.validator, `"`) {
+		// weak ETags do not identify the content, they are neither compared nor sent in If-Range
+		info.etag, _ = strconv.Unquote(info.validator)
+	} else {
+		info.validator = res.Header.Get("Last-Modified")
+	}
+
+	return info, nil
+}
+
+// downloadRange writes a single range of the file at its offset of w.
+func (c *Client) downloadRange(ctx context.Context, info *downloadInfo, w io.WriterAt, r ByteRange) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, info.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.Start, r.End))
+	if info.validator != "" {
+		req.Header.Set("If-Range", info.validator)
+	}
+
+	res, err := c.DoRequest(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return fmt.Errorf("file changed on the server during the download")
+	default:
+		return fmt.Errorf("failed to download range %d-%d %s", r.Start, r.End, getJsonError(res.Body))
+	}
+
+	length := r.End - r.Start + 1
+	n, err := io.Copy(io.NewOffsetWriter(w, r.Start), io.LimitReader(res.Body, length))
+	if err != nil {
+		return err
+	}
+	if n != length {
+		return fmt.Errorf("range %d-%d ended after %d bytes", r.Start, r.End, n)
+	}
+
+	return nil
+}
+
+// verifyDownload compares the checksum of the downloaded file with the expected one, it is skipped with a warning
+// when neither the options nor the server provide one or w cannot be read back.
+func (c *Client) verifyDownload(ctx context.Context, info *downloadInfo, w io.WriterAt, opts DownloadOptions) error {
+	expected := opts.ExpectedChecksum
+	if expected == "" {
+		expected = info.etag
+	}
+	if expected == "" {
+		c.warn("chunked-uploader: server did not send a checksum, skipping download verification")
+		return nil
+	}
+
+	src, ok := w.(io.ReaderAt)
+	if !ok {
+		if opts.ExpectedChecksum != "" {
+			return fmt.Errorf("verifying a download requires a writer implementing io.ReaderAt")
+		}
+		c.warn("chunked-uploader: writer cannot be read back, skipping download verification")
+		return nil
+	}
+
+	algorithm := opts.ChecksumAlgorithm
+	if algorithm == "" {
+		algorithm = info.algorithm
+	}
+	if algorithm == "" {
+		algorithm = utils.ChecksumSHA256
+	}
+	if !algorithm.Valid() {
+		return fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+
+	actual, err := utils.ComputeReaderChecksum(ctx, io.NewSectionReader(src, 0, info.size), algorithm)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return &DownloadChecksumMismatch{Expected: expected, Actual: actual}
+	}
+
+	return nil
+}
+
+// loadDownloadState reads the state of an earlier attempt of the same download. A missing or unreadable state file,
+// one of a different file or chunk size, or a file the server sends no validator for starts the download over.
+func (c *Client) loadDownloadState(stateFile string, info *downloadInfo, chunkSize int64) *downloadState {
+	fresh := &downloadState{Size: info.size, Validator: info.validator, ChunkSize: chunkSize}
+	if stateFile == "" {
+		return fresh
+	}
+
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			c.warn("chunked-uploader: could not read download state, starting over: %s", err)
+		}
+		return fresh
+	}
+
+	var state downloadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		c.warn("chunked-uploader: could not parse download state, starting over: %s", err)
+		return fresh
+	}
+	if state.Size != info.size || state.Validator != info.validator || state.Validator == "" || state.ChunkSize != chunkSize {
+		return fresh
+	}
+
+	return &state
+}
+
+// saveDownloadState replaces the state file, it is written to a temporary file first so an interrupted write keeps
+// the previous state.
+func saveDownloadState(stateFile string, state *downloadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp := stateFile + ".tmp"
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, stateFile)
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type ExtendResponse struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ExtendUpload moves the deadline of a given upload and returns the new one.
+func (c *Client) ExtendUpload(ctx context.Context, uploadId string) (time.Time, error) {
+	extendUrl := fmt.Sprintf("%s/%s/extend", c.Endpoint, uploadId)
+
+	var resp ExtendResponse
+	err := c.doJsonRequest(ctx, http.MethodPost, extendUrl, nil, http.StatusOK, &resp)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return resp.ExpiresAt, nil
+}
+
+// extendIfExpiring extends the upload when the deadline from a X-Upload-Expires header is closer than
+// MinTTLBeforeExtend.
+func (c *Client) extendIfExpiring(ctx context.Context, expiresHeader string) error {
+	if c.MinTTLBeforeExtend <= 0 || expiresHeader == "" {
+		return nil
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, expiresHeader)
+	if err != nil {
+		return fmt.Errorf("invalid X-Upload-Expires header %w", err)
+	}
+
+	if time.Until(expiresAt) >= c.MinTTLBeforeExtend {
+		return nil
+	}
+
+	_, err = c.ExtendUpload(ctx, *c.UploadId)
+	if err != nil {
+		return fmt.Errorf("failed to extend upload %w", err)
+	}
+
+	return nil
+}
+
+// TouchUpload keeps a paused upload alive, call it periodically while no chunks are sent.
+func (c *Client) TouchUpload(ctx context.Context, uploadId string) error {
+	touchUrl := fmt.Sprintf("%s/%s/touch", c.Endpoint, uploadId)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, touchUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.DoRequest(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to touch upload %s", getJsonError(res.Body))
+	}
+
+	return nil
+}
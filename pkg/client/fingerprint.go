@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+)
+
+type FingerprintResponse struct {
+	Exists   bool   `json:"exists"`
+	UploadId string `json:"upload_id,omitempty"`
+	Path     string `json:"path,omitempty"`
+}
+
+// GetFingerprintAndCheck computes the SHA-256 of a local file and asks the server whether it already has a complete
+// upload of an identical file. When exists is true the file does not have to be uploaded again.
+func (c *Client) GetFingerprintAndCheck(ctx context.Context, localPath string) (exists bool, uploadId string, err error) {
+	resp, err := c.fingerprintAndCheck(ctx, localPath)
+	if err != nil {
+		return false, "", err
+	}
+
+	return resp.Exists, resp.UploadId, nil
+}
+
+func (c *Client) fingerprintAndCheck(ctx context.Context, localPath string) (*FingerprintResponse, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, utils.NewContextReader(ctx, file))
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprintUrl := fmt.Sprintf("%s/fingerprint/%s", c.Endpoint, hex.EncodeToString(hash.Sum(nil)))
+
+	var resp FingerprintResponse
+	err = c.doJsonRequest(ctx, http.MethodGet, fingerprintUrl, nil, http.StatusOK, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check fingerprint %w", err)
+	}
+
+	return &resp, nil
+}
+
+// UploadFile uploads a local file unless the server already has an identical one. Files larger than a chunk are
+// fingerprinted in the background while the upload runs, which is canceled once the server reports the file exists.
+// The canceled upload is left for the server to clean up. It returns the path of the uploaded file, or of the
+// existing one along with its upload id.
+func (c *Client) UploadFile(ctx context.Context, localPath string) (path string, existingUploadId string, err error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	if info.Size() <= c.ChunkSize {
+		resp, err := c.fingerprintAndCheck(ctx, localPath)
+		if err != nil {
+			return "", "", err
+		}
+		if resp.Exists {
+			return resp.Path, resp.UploadId, nil
+		}
+		path, err := c.uploadLocalFile(ctx, localPath)
+		return path, "", err
+	}
+
+	uploadCtx, cancelUpload := context.WithCancel(ctx)
+	defer cancelUpload()
+
+	type checkResult struct {
+		resp *FingerprintResponse
+		err  error
+	}
+	checked := make(chan checkResult, 1)
+	go func() {
+		resp, err := c.fingerprintAndCheck(uploadCtx, localPath)
+		if err == nil && resp.Exists {
+			cancelUpload()
+		}
+		checked <- checkResult{resp, err}
+	}()
+
+	path, err = c.uploadLocalFile(uploadCtx, localPath)
+	if err == nil {
+		return path, "", nil
+	}
+
+	// a failed upload is canceled by the check finding the file, otherwise the check is not needed anymore
+	cancelUpload()
+	result := <-checked
+	if ctx.Err() == nil && result.err == nil && result.resp.Exists {
+		return result.resp.Path, result.resp.UploadId, nil
+	}
+
+	return "", "", err
+}
+
+func (c *Client) uploadLocalFile(ctx context.Context, localPath string) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return c.Upload(ctx, file)
+}
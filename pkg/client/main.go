@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Craftserve/chunked-uploader/utils"
 )
 
 type InitResponse struct {
@@ -17,125 +21,313 @@ type InitResponse struct {
 
 type FinishResponse struct {
 	Path string `json:"path"`
+	// URL is a signed download url, it is only set when the server has signed downloads enabled.
+	URL string `json:"url,omitempty"`
+	// LocalChecksum is the checksum the client computed from the source and the server verified the upload with.
+	LocalChecksum string `json:"-"`
+}
+
+// ByteRange is a range of bytes, both Start and End are inclusive.
+type ByteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+type RegionsResponse struct {
+	Regions    []ByteRange `json:"regions"`
+	Missing    []ByteRange `json:"missing"`
+	TotalBytes int64       `json:"total_bytes"`
 }
 
 type Client struct {
 	DoRequest func(req *http.Request) (*http.Response, error)
 	Endpoint  string
+	// URLs are the templates of the init, chunk and finish URLs, see NewURLTemplates. When nil the routes of the
+	// NewHTTPHandler below Endpoint are used.
+	URLs      *URLTemplates
 	ChunkSize int64
 	UploadId  *string
+	// ChunkRetries is the number of times a chunk which failed midway is resumed, it defaults to 3 when zero and
+	// a negative value disables resuming. Only seekable sources can be resumed.
+	ChunkRetries int
+	// MinTTLBeforeExtend makes the client extend the upload once its deadline is closer than this, zero disables it.
+	MinTTLBeforeExtend time.Duration
+	// Parallelism is the number of chunks sent at once, it is capped by the limit the server advertises in
+	// X-Max-Parallel-Chunks. Only sources implementing io.ReaderAt and io.Seeker are sent in parallel.
+	Parallelism int
+	// ConcurrentHash makes parallel uploads compute the checksum in a pass running alongside the chunks instead of
+	// after all of them were sent. Either way the pass reads the whole source once more through its own
+	// io.SectionReader with a constant small buffer, so memory use does not grow with the file. Running it
+	// concurrently hides the hashing time behind the upload at the cost of reading the source twice at once, which
+	// helps with cached files and fast disks and may slow the upload down on spinning disks. Sources which are not
+	// seekable are always sent sequentially and hashed as they are read.
+	ConcurrentHash bool
+	// VerifySamples is the number of ranges compared with VerifyRanges before finishing an upload from an
+	// io.ReaderAt source, zero disables it.
+	VerifySamples int
+	// TreeHash makes the client verify the upload with a SHA-256 tree hash over 1 MiB leaves instead of a flat
+	// SHA-256, which the server can check from the leaves it recorded while receiving the chunks.
+	TreeHash bool
+	// VerifyBlockSize is the size of the blocks VerifyLocalFile compares files larger than it in, it defaults to
+	// 64 MiB when zero.
+	VerifyBlockSize int64
+	// Logger receives warnings, like the fallback to sequential chunks for servers which only append them.
+	Logger Logger
+	// StrictResume asks the server to reject chunks sent for a different committed offset than the client expects,
+	// the client then continues from the committed offset. Strict resume uploads are always sent sequentially.
+	StrictResume bool
+	// AutoChunkSize makes the client use the chunk size the server recommends in X-Recommended-Chunk-Size instead of
+	// ChunkSize, which stays in use for servers without a recommendation.
+	AutoChunkSize bool
+	// NegotiateCapabilities makes the client fetch the capabilities of the server before uploading and turn off the
+	// optional behaviors it does not support, like TreeHash or StrictResume, instead of failing.
+	NegotiateCapabilities bool
+	// Decoders are the content codings the client accepts for the JSON responses besides gzip, which is always
+	// accepted, in order of preference. Brotli is provided by the pkg/compression/brotliadapter module.
+	Decoders []ContentDecoder
+	// Progress is called with the bytes transferred by uploads and downloads, see ProgressFunc.
+	Progress ProgressFunc
+	// Finished is the response of the server to the last finished upload.
+	Finished *FinishResponse
+
+	maxParallelChunks int
+	// sequential is set when the server only appends chunks, see probeChunk.
+	sequential bool
+	// strictResume is set when the server accepted StrictResume for the upload.
+	strictResume bool
+	// capabilities are cached by Capabilities.
+	capabilities *Capabilities
 }
 
 func (c *Client) Upload(ctx context.Context, fileReader io.ReadCloser) (path string, err error) {
+	path, _, err = c.UploadWithChecksum(ctx, fileReader)
+	return path, err
+}
+
+// UploadWithChecksum is Upload which also returns the checksum the client computed from the source, so it can be
+// stored or logged without reading the source again.
+func (c *Client) UploadWithChecksum(ctx context.Context, fileReader io.ReadCloser) (path string, checksum string, err error) {
+	c.negotiate(ctx)
+
 	err = c.initUpload(ctx)
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+	urls, err := c.urls()
+	if err != nil {
+		return "", "", err
+	}
+	chunkUrl, err := urls.ChunkURL(*c.UploadId)
+	if err != nil {
+		return "", "", err
 	}
-	chunkUrl := fmt.Sprintf("%s/%s/upload", c.Endpoint, *c.UploadId)
 
-	hash := sha256.New()
-	hashingReader := io.TeeReader(fileReader, hash)
+	progress := c.newProgress(sourceSize(fileReader), 0)
+	source, err := newHashingReader(fileReader, c.newHash())
+	if err != nil {
+		return "", "", err
+	}
 
-	for {
-		chunkReader := io.LimitedReader{R: hashingReader, N: c.ChunkSize}
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, chunkUrl, &chunkReader)
+	offset, err := c.probeChunk(ctx, chunkUrl, source)
+	if err != nil {
+		return "", "", err
+	}
+	progress.set(offset)
+
+	if src, ok := fileReader.(readerAtSeeker); ok && offset == c.ChunkSize && c.workers() > 1 && !c.sequential && !c.strictResume {
+		checksum, err = c.uploadParallel(ctx, chunkUrl, src, source.base, offset, progress)
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return "", err
+			return "", "", err
 		}
-		req.Header.Set("Content-Type", "application/octet-stream")
-
-		res, err := c.DoRequest(req)
+		err = c.verifyBeforeFinish(ctx, fileReader)
 		if err != nil {
-			return "", fmt.Errorf("failed to upload chunk %w", err)
+			return "", "", err
 		}
-		defer res.Body.Close()
-
-		if res.StatusCode != http.StatusOK {
-			return "", fmt.Errorf("failed to upload chunk %s", getJsonError(res.Body))
+		path, err = c.finishUpload(ctx, checksum)
+		if err != nil {
+			return "", "", err
 		}
+		return path, checksum, nil
+	}
 
-		if chunkReader.N == c.ChunkSize {
-			break
+	reconciled := 0
+	for n := offset; n == c.ChunkSize; {
+		n, err = c.uploadChunk(ctx, chunkUrl, source, offset, c.ChunkSize)
+		if err != nil && reconciled < defaultChunkRetries {
+			if committed, ok := c.reconcileCommitted(source, err); ok {
+				reconciled++
+				offset, n = committed, c.ChunkSize
+				continue
+			}
+		}
+		if err != nil {
+			return "", "", err
 		}
+		offset += n
+		progress.set(offset)
+	}
 
+	err = c.verifyBeforeFinish(ctx, fileReader)
+	if err != nil {
+		return "", "", err
 	}
 
-	path, err = c.finishUpload(ctx, hex.EncodeToString(hash.Sum(nil)))
+	checksum = source.Sum()
+	path, err = c.finishUpload(ctx, checksum)
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+
+	return path, checksum, nil
+}
+
+// verifyBeforeFinish spot-checks the upload when VerifySamples is set and the source supports it.
+func (c *Client) verifyBeforeFinish(ctx context.Context, fileReader io.Reader) error {
+	source, ok := fileReader.(io.ReaderAt)
+	if !ok || c.VerifySamples <= 0 {
+		return nil
 	}
 
-	return path, nil
+	return c.VerifyRanges(ctx, source, c.VerifySamples)
 }
 
 func (c *Client) initUpload(ctx context.Context) error {
 	var args = struct {
-		FileSize *int64 `json:"file_size"`
+		FileSize     *int64 `json:"file_size"`
+		StrictResume bool   `json:"strict_resume,omitempty"`
 	}{
-		FileSize: nil,
+		FileSize:     nil,
+		StrictResume: c.StrictResume,
 	}
 
 	var resp InitResponse
-	err := c.sendJsonRequest(ctx, c.Endpoint+"/init", args, http.StatusCreated, &resp)
+	urls, err := c.urls()
+	if err != nil {
+		return err
+	}
+	initUrl, err := urls.InitURL()
+	if err != nil {
+		return err
+	}
+
+	header, err := c.doJsonRequestHeader(ctx, http.MethodPost, initUrl, args, http.StatusCreated, &resp)
 	if err != nil {
 		return err
 	}
 	c.UploadId = &resp.UploadID
+
+	c.maxParallelChunks = 0
+	if limit, err := strconv.Atoi(header.Get("X-Max-Parallel-Chunks")); err == nil && limit > 0 {
+		c.maxParallelChunks = limit
+	}
+	c.strictResume = header.Get("X-Strict-Resume") == "true"
+	if size, err := strconv.ParseInt(header.Get("X-Recommended-Chunk-Size"), 10, 64); err == nil && size > 0 && c.AutoChunkSize {
+		c.ChunkSize = size
+	}
 	return nil
 }
 
+// newHash returns the hash the upload is verified with.
+func (c *Client) newHash() hash.Hash {
+	if c.TreeHash {
+		return utils.NewTreeHash()
+	}
+	return sha256.New()
+}
+
 func (c *Client) finishUpload(ctx context.Context, hash string) (string, error) {
 	var args = struct {
-		Checksum string `json:"checksum"`
+		Checksum  string `json:"checksum"`
+		Algorithm string `json:"algorithm,omitempty"`
 	}{
 		Checksum: hash,
 	}
+	if c.TreeHash {
+		args.Algorithm = string(utils.ChecksumSHA256Tree)
+	}
 
-	finishUrl := fmt.Sprintf("%s/%s/finish", c.Endpoint, *c.UploadId)
+	urls, err := c.urls()
+	if err != nil {
+		return "", err
+	}
+	finishUrl, err := urls.FinishURL(*c.UploadId)
+	if err != nil {
+		return "", err
+	}
 
 	var resp FinishResponse
-	err := c.sendJsonRequest(ctx, finishUrl, &args, http.StatusOK, &resp)
+	err = c.sendJsonRequest(ctx, finishUrl, &args, http.StatusOK, &resp)
 	if err != nil {
 		return "", err
 	}
+	resp.LocalChecksum = hash
+	c.Finished = &resp
 
 	return resp.Path, nil
 }
 
+// GetMissingRanges returns the ranges of a given upload which the server has not received yet.
+func (c *Client) GetMissingRanges(ctx context.Context, uploadId string) ([]ByteRange, error) {
+	regionsUrl := fmt.Sprintf("%s/%s/regions", c.Endpoint, uploadId)
+
+	var resp RegionsResponse
+	err := c.doJsonRequest(ctx, http.MethodGet, regionsUrl, nil, http.StatusOK, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Missing, nil
+}
+
 func (c *Client) sendJsonRequest(ctx context.Context, url string, args interface{}, expectedStatus int, response interface{}) error {
-	var reqBody *bytes.Buffer
+	return c.doJsonRequest(ctx, http.MethodPost, url, args, expectedStatus, response)
+}
+
+func (c *Client) doJsonRequest(ctx context.Context, method string, url string, args interface{}, expectedStatus int, response interface{}) error {
+	_, err := c.doJsonRequestHeader(ctx, method, url, args, expectedStatus, response)
+	return err
+}
+
+// doJsonRequestHeader is doJsonRequest which also returns the response headers.
+func (c *Client) doJsonRequestHeader(ctx context.Context, method string, url string, args interface{}, expectedStatus int, response interface{}) (http.Header, error) {
+	var reqBody io.Reader
 	if args != nil {
-		reqBody = &bytes.Buffer{}
-		err := json.NewEncoder(reqBody).Encode(args)
+		buf := &bytes.Buffer{}
+		err := json.NewEncoder(buf).Encode(args)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		reqBody = buf
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Encoding", c.acceptEncoding())
 
 	resp, err := c.DoRequest(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != expectedStatus {
-		return fmt.Errorf("server error: %s", resp.Status)
+		return nil, fmt.Errorf("server error: %s", resp.Status)
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(response)
+	body, err := c.decodeBody(resp)
 	if err != nil {
-		return fmt.Errorf("could not decode response %w", err)
+		return nil, fmt.Errorf("could not decode response %w", err)
 	}
-	return nil
+	defer body.Close()
+
+	err = json.NewDecoder(body).Decode(response)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode response %w", err)
+	}
+	return resp.Header, nil
 }
 
 func getJsonError(body io.Reader) string {
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+)
+
+type readerAtSeeker interface {
+	io.ReaderAt
+	io.Seeker
+}
+
+// workers returns the number of chunks to send at once.
+func (c *Client) workers() int {
+	workers := c.Parallelism
+	if c.maxParallelChunks > 0 && workers > c.maxParallelChunks {
+		workers = c.maxParallelChunks
+	}
+	return workers
+}
+
+// uploadParallel sends the chunks of a source starting at base from several workers, the chunks before offset from
+// are already sent and counted in progress. It returns the checksum of the source, which is computed in a separate
+// pass reading the source from the start, see Client.ConcurrentHash.
+func (c *Client) uploadParallel(ctx context.Context, chunkUrl string, src readerAtSeeker, base int64, from int64, progress *progress) (string, error) {
+	if c.ChunkSize <= 0 {
+		return "", errors.New("chunk size must be positive")
+	}
+
+	end, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+	size := end - base
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	offsets := make(chan int64)
+	go func() {
+		defer close(offsets)
+		for offset := from; offset < size; offset += c.ChunkSize {
+			select {
+			case offsets <- offset:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	type hashResult struct {
+		checksum string
+		err      error
+	}
+	hashed := make(chan hashResult, 1)
+	hashPass := func() {
+		checksum, err := c.hashSection(ctx, src, base, size)
+		hashed <- hashResult{checksum, err}
+	}
+	if c.ConcurrentHash {
+		go hashPass()
+	}
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var uploadErr error
+	for i := 0; i < c.workers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for offset := range offsets {
+				length := c.ChunkSize
+				if offset+length > size {
+					length = size - offset
+				}
+
+				err := c.sendChunkAt(ctx, chunkUrl, src, base, offset, length)
+				if err != nil {
+					errOnce.Do(func() {
+						uploadErr = err
+						cancel()
+					})
+					return
+				}
+				progress.add(length)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if uploadErr != nil {
+		return "", uploadErr
+	}
+
+	if !c.ConcurrentHash {
+		hashPass()
+	}
+	result := <-hashed
+
+	return result.checksum, result.err
+}
+
+// hashSection computes the checksum of a section of a source, it stops once the context is done.
+func (c *Client) hashSection(ctx context.Context, src io.ReaderAt, base int64, size int64) (string, error) {
+	hash := c.newHash()
+	_, err := io.Copy(hash, utils.NewContextReader(ctx, io.NewSectionReader(src, base, size)))
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sendChunkAt sends a single chunk of a source, it is sent again as a whole when it fails midway.
+func (c *Client) sendChunkAt(ctx context.Context, chunkUrl string, src io.ReaderAt, base int64, offset int64, length int64) error {
+	retries := c.ChunkRetries
+	if retries == 0 {
+		retries = defaultChunkRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := c.sendChunk(ctx, chunkUrl, offset, io.NewSectionReader(src, base+offset, length))
+		if err == nil {
+			return nil
+		}
+
+		var retryable *retryableError
+		if attempt >= retries || !errors.As(err, &retryable) || ctx.Err() != nil {
+			return err
+		}
+	}
+}
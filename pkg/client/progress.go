@@ -0,0 +1,69 @@
+package client
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// ProgressFunc receives the number of bytes an upload or a download has transferred so far and the size of the file,
+// which is -1 when it is not known up front. It is called after every chunk, concurrently from the workers of
+// parallel transfers, and the reported bytes never decrease.
+type ProgressFunc func(transferred int64, total int64)
+
+// progress reports the bytes of a transfer to the Progress callback of the client.
+type progress struct {
+	fn          ProgressFunc
+	total       int64
+	transferred atomic.Int64
+}
+
+func (c *Client) newProgress(total int64, transferred int64) *progress {
+	p := &progress{fn: c.Progress, total: total}
+	p.transferred.Store(transferred)
+	return p
+}
+
+// add reports n more transferred bytes.
+func (p *progress) add(n int64) {
+	transferred := p.transferred.Add(n)
+	if p.fn != nil {
+		p.fn(transferred, p.total)
+	}
+}
+
+// set reports the transferred bytes when a transfer tracks its own offset, like sequential uploads which may resume
+// from an earlier offset.
+func (p *progress) set(transferred int64) {
+	for {
+		previous := p.transferred.Load()
+		if transferred <= previous {
+			return
+		}
+		if p.transferred.CompareAndSwap(previous, transferred) {
+			break
+		}
+	}
+	if p.fn != nil {
+		p.fn(transferred, p.total)
+	}
+}
+
+// sourceSize returns the number of bytes left in a seekable source, or -1 for any other source.
+func sourceSize(r io.Reader) int64 {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return -1
+	}
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return -1
+	}
+	if _, err := seeker.Seek(current, io.SeekStart); err != nil {
+		return -1
+	}
+	return end - current
+}
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+)
+
+const defaultChunkRetries = 3
+
+// retryableError marks chunk failures after which the chunk can be resumed.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// hashingReader hashes the source as it is read. Bytes read again after seeking back are not hashed twice, so the
+// sum always covers the source exactly once.
+type hashingReader struct {
+	r      io.Reader
+	seeker io.Seeker
+	base   int64
+	pos    int64
+	hashed int64
+	hash   hash.Hash
+}
+
+func newHashingReader(r io.Reader, hash hash.Hash) (*hashingReader, error) {
+	h := &hashingReader{r: r, hash: hash}
+
+	if seeker, ok := r.(io.Seeker); ok {
+		base, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			h.seeker = seeker
+			h.base = base
+		}
+	}
+
+	return h, nil
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if n > 0 {
+		end := h.pos + int64(n)
+		if end > h.hashed {
+			skip := int64(0)
+			if h.hashed > h.pos {
+				skip = h.hashed - h.pos
+			}
+			h.hash.Write(p[skip:n])
+			h.hashed = end
+		}
+		h.pos = end
+	}
+	return n, err
+}
+
+func (h *hashingReader) seekable() bool {
+	return h.seeker != nil
+}
+
+// seek moves the reader to a given position, relative to where the source was when the upload started.
+func (h *hashingReader) seek(pos int64) error {
+	if pos > h.hashed {
+		return fmt.Errorf("cannot seek past the hashed part of the source")
+	}
+
+	_, err := h.seeker.Seek(h.base+pos, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	h.pos = pos
+	return nil
+}
+
+func (h *hashingReader) Sum() string {
+	return hex.EncodeToString(h.hash.Sum(nil))
+}
+
+// uploadChunk sends up to size bytes of the source starting at offset and returns how many bytes were sent. When
+// the request fails midway and the source is seekable, the server is asked how much of the chunk it received and
+// only the remaining part is sent again.
+func (c *Client) uploadChunk(ctx context.Context, chunkUrl string, source *hashingReader, offset int64, size int64) (int64, error) {
+	retries := c.ChunkRetries
+	if retries == 0 {
+		retries = defaultChunkRetries
+	}
+
+	start := offset
+	for attempt := 0; ; attempt++ {
+		limit := size - (offset - start)
+		chunkReader := &io.LimitedReader{R: source, N: limit}
+
+		err := c.sendChunk(ctx, chunkUrl, offset, chunkReader)
+		if err == nil {
+			return offset - start + limit - chunkReader.N, nil
+		}
+
+		var retryable *retryableError
+		// a server which only appends chunks cannot tell how much of the chunk it received
+		if c.sequential || !source.seekable() || attempt >= retries || !errors.As(err, &retryable) || ctx.Err() != nil {
+			return 0, err
+		}
+
+		received, receivedErr := c.receivedOffset(ctx, offset)
+		if receivedErr != nil {
+			return 0, fmt.Errorf("%w, could not resume: %s", err, receivedErr)
+		}
+
+		if received > start+size {
+			received = start + size
+		}
+		if received > source.hashed {
+			received = source.hashed
+		}
+
+		err = source.seek(received)
+		if err != nil {
+			return 0, fmt.Errorf("failed to resume chunk %w", err)
+		}
+		offset = received
+
+		if offset == start+size {
+			return size, nil
+		}
+	}
+}
+
+// sendChunk sends a single chunk at a given offset, in sequential mode the offset is left to the server.
+func (c *Client) sendChunk(ctx context.Context, chunkUrl string, offset int64, body io.Reader) error {
+	if c.sequential {
+		offset = -1
+	}
+
+	_, err := c.postChunk(ctx, chunkUrl, offset, body, false)
+	return err
+}
+
+// receivedOffset returns the offset up to which the server received data contiguously from a given offset.
+func (c *Client) receivedOffset(ctx context.Context, from int64) (int64, error) {
+	regionsUrl := fmt.Sprintf("%s/%s/regions", c.Endpoint, *c.UploadId)
+
+	var resp RegionsResponse
+	err := c.doJsonRequest(ctx, http.MethodGet, regionsUrl, nil, http.StatusOK, &resp)
+	if err != nil {
+		return 0, err
+	}
+
+	received := from
+	for _, region := range resp.Regions {
+		if region.Start <= received && region.End >= received {
+			received = region.End + 1
+		}
+	}
+
+	return received, nil
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// CommittedOffsetMismatchError is returned when the server committed a different part of a strict resume upload
+// than the client expected, the chunk was not written.
+type CommittedOffsetMismatchError struct {
+	Expected  int64       `json:"expected"`
+	Committed int64       `json:"committed"`
+	Regions   []ByteRange `json:"regions"`
+}
+
+func (e *CommittedOffsetMismatchError) Error() string {
+	return fmt.Sprintf("server committed %d bytes, expected %d", e.Committed, e.Expected)
+}
+
+// decodeConflict decodes a 409 response to a chunk.
+func decodeConflict(body io.Reader) error {
+	var resp struct {
+		CommittedOffsetMismatchError
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	err := json.NewDecoder(body).Decode(&resp)
+	if err != nil {
+		return fmt.Errorf("failed to upload chunk %w", err)
+	}
+	if resp.Code != "committed_offset_mismatch" {
+		return fmt.Errorf("failed to upload chunk %s", resp.Error)
+	}
+
+	return &resp.CommittedOffsetMismatchError
+}
+
+// reconcileCommitted moves the source to the offset the server committed after a chunk was rejected for a different
+// one, it returns false when the error is not a mismatch or the source cannot be moved there.
+func (c *Client) reconcileCommitted(source *hashingReader, err error) (int64, bool) {
+	var mismatch *CommittedOffsetMismatchError
+	if !errors.As(err, &mismatch) || !source.seekable() {
+		return 0, false
+	}
+
+	if source.seek(mismatch.Committed) != nil {
+		return 0, false
+	}
+
+	c.warn("server committed %d bytes instead of %d, continuing from there", mismatch.Committed, mismatch.Expected)
+	return mismatch.Committed, true
+}
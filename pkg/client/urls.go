@@ -0,0 +1,95 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// UploadIdPlaceholder is replaced with the upload id in URL templates.
+const UploadIdPlaceholder = "{upload_id}"
+
+// URLTemplates are the URLs of the init, chunk and finish endpoints. The placeholder {upload_id} may appear in the
+// path or in the query and is escaped accordingly, relative templates are resolved against the base URL.
+type URLTemplates struct {
+	base   *url.URL
+	init   string
+	chunk  string
+	finish string
+}
+
+// NewURLTemplates validates the templates of the upload endpoints, the chunk and finish templates must contain the
+// {upload_id} placeholder.
+func NewURLTemplates(baseURL string, init string, chunk string, finish string) (*URLTemplates, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url %w", err)
+	}
+
+	t := &URLTemplates{base: base, init: init, chunk: chunk, finish: finish}
+	for name, template := range map[string]string{"init": init, "chunk": chunk, "finish": finish} {
+		if name != "init" && !strings.Contains(template, UploadIdPlaceholder) {
+			return nil, fmt.Errorf("%s url template must contain %s", name, UploadIdPlaceholder)
+		}
+		if _, err := t.expand(template, "id"); err != nil {
+			return nil, fmt.Errorf("invalid %s url template %w", name, err)
+		}
+	}
+
+	return t, nil
+}
+
+// defaultURLTemplates are the routes of NewHTTPHandler below a given endpoint.
+func defaultURLTemplates(endpoint string) (*URLTemplates, error) {
+	return NewURLTemplates(strings.TrimSuffix(endpoint, "/")+"/", "init", "{upload_id}/upload", "{upload_id}/finish")
+}
+
+// expand substitutes the upload id into a template and resolves the result against the base URL.
+func (t *URLTemplates) expand(template string, uploadId string) (string, error) {
+	query := strings.Index(template, "?")
+	var b strings.Builder
+	pos := 0
+	for {
+		i := strings.Index(template[pos:], UploadIdPlaceholder)
+		if i < 0 {
+			b.WriteString(template[pos:])
+			break
+		}
+		i += pos
+		b.WriteString(template[pos:i])
+		if query >= 0 && i > query {
+			b.WriteString(url.QueryEscape(uploadId))
+		} else {
+			// a colon would turn a relative template into a scheme
+			b.WriteString(strings.ReplaceAll(url.PathEscape(uploadId), ":", "%3A"))
+		}
+		pos = i + len(UploadIdPlaceholder)
+	}
+
+	ref, err := url.Parse(b.String())
+	if err != nil {
+		return "", err
+	}
+
+	return t.base.ResolveReference(ref).String(), nil
+}
+
+func (t *URLTemplates) InitURL() (string, error) {
+	return t.expand(t.init, "")
+}
+
+func (t *URLTemplates) ChunkURL(uploadId string) (string, error) {
+	return t.expand(t.chunk, uploadId)
+}
+
+func (t *URLTemplates) FinishURL(uploadId string) (string, error) {
+	return t.expand(t.finish, uploadId)
+}
+
+// urls returns the URL templates of the client.
+func (c *Client) urls() (*URLTemplates, error) {
+	if c.URLs != nil {
+		return c.URLs, nil
+	}
+	return defaultURLTemplates(c.Endpoint)
+}
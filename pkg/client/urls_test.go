@@ -0,0 +1,88 @@
+package client
+
+import "testing"
+
+func TestURLTemplates(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		base                string
+		init, chunk, finish string
+		uploadId            string
+		wantInit            string
+		wantChunk           string
+		wantFinish          string
+	}{
+		{
+			name: "path style", base: "https://uploads.example.com/api/",
+			init: "init", chunk: "{upload_id}/upload", finish: "{upload_id}/finish",
+			uploadId: "abc", wantInit: "https://uploads.example.com/api/init",
+			wantChunk: "https://uploads.example.com/api/abc/upload", wantFinish: "https://uploads.example.com/api/abc/finish",
+		},
+		{
+			name: "query style", base: "https://gateway.example.com/",
+			init: "upload?action=init", chunk: "upload?action=chunk&id={upload_id}", finish: "upload?action=finish&id={upload_id}",
+			uploadId: "abc", wantInit: "https://gateway.example.com/upload?action=init",
+			wantChunk: "https://gateway.example.com/upload?action=chunk&id=abc", wantFinish: "https://gateway.example.com/upload?action=finish&id=abc",
+		},
+		{
+			name: "pre-signed urls with a query", base: "https://uploads.example.com/",
+			init:     "https://signed.example.com/init?X-Signature=s1&expires=100",
+			chunk:    "https://signed.example.com/{upload_id}/upload?X-Signature=s2&expires=100",
+			finish:   "https://signed.example.com/finish?X-Signature=s3&id={upload_id}",
+			uploadId: "abc", wantInit: "https://signed.example.com/init?X-Signature=s1&expires=100",
+			wantChunk: "https://signed.example.com/abc/upload?X-Signature=s2&expires=100", wantFinish: "https://signed.example.com/finish?X-Signature=s3&id=abc",
+		},
+		{
+			name: "escaped upload id", base: "https://uploads.example.com/",
+			init: "init", chunk: "{upload_id}/upload", finish: "finish?id={upload_id}",
+			uploadId: "a b/c:d&e", wantInit: "https://uploads.example.com/init",
+			wantChunk: "https://uploads.example.com/a%20b%2Fc%3Ad&e/upload", wantFinish: "https://uploads.example.com/finish?id=a+b%2Fc%3Ad%26e",
+		},
+		{
+			name: "absolute path", base: "https://uploads.example.com/api/",
+			init: "/init", chunk: "/chunks/{upload_id}", finish: "/finish/{upload_id}",
+			uploadId: "abc", wantInit: "https://uploads.example.com/init",
+			wantChunk: "https://uploads.example.com/chunks/abc", wantFinish: "https://uploads.example.com/finish/abc",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			urls, err := NewURLTemplates(tc.base, tc.init, tc.chunk, tc.finish)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, u := range []struct {
+				name   string
+				expand func() (string, error)
+				want   string
+			}{
+				{"init", urls.InitURL, tc.wantInit},
+				{"chunk", func() (string, error) { return urls.ChunkURL(tc.uploadId) }, tc.wantChunk},
+				{"finish", func() (string, error) { return urls.FinishURL(tc.uploadId) }, tc.wantFinish},
+			} {
+				got, err := u.expand()
+				if err != nil || got != u.want {
+					t.Errorf("%s url: got %q %v, want %q", u.name, got, err, u.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewURLTemplatesValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		init, chunk, finish string
+		wantErr             bool
+	}{
+		{name: "init without placeholder", init: "init", chunk: "{upload_id}/upload", finish: "{upload_id}/finish"},
+		{name: "chunk without placeholder", init: "init", chunk: "upload", finish: "{upload_id}/finish", wantErr: true},
+		{name: "finish without placeholder", init: "init", chunk: "{upload_id}/upload", finish: "finish", wantErr: true},
+		{name: "invalid url", init: "init", chunk: "{upload_id}/upload%zz", finish: "{upload_id}/finish", wantErr: true},
+	} {
+		_, err := NewURLTemplates("https://uploads.example.com/", tc.init, tc.chunk, tc.finish)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: %v, want error %t", tc.name, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+)
+
+// verifySampleSize is the largest range compared by a single sample.
+const verifySampleSize = 64 << 10
+
+// VerifyRanges compares a number of randomly chosen ranges the server has committed with the same ranges of the
+// source, so a corrupted upload is noticed before an expensive finish. The upload must start at offset 0 of source.
+func (c *Client) VerifyRanges(ctx context.Context, source io.ReaderAt, samples int) error {
+	regionsUrl := fmt.Sprintf("%s/%s/regions", c.Endpoint, *c.UploadId)
+
+	var resp RegionsResponse
+	err := c.doJsonRequest(ctx, http.MethodGet, regionsUrl, nil, http.StatusOK, &resp)
+	if err != nil {
+		return err
+	}
+	if len(resp.Regions) == 0 {
+		return nil
+	}
+
+	for i := 0; i < samples; i++ {
+		region := resp.Regions[rand.Intn(len(resp.Regions))]
+
+		length := region.End - region.Start + 1
+		if length > verifySampleSize {
+			length = verifySampleSize
+		}
+		start := region.Start + rand.Int63n(region.End-region.Start+2-length)
+
+		err := c.verifyRange(ctx, source, start, length)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) verifyRange(ctx context.Context, source io.ReaderAt, start int64, length int64) error {
+	dataUrl := fmt.Sprintf("%s/%s/data", c.Endpoint, *c.UploadId)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dataUrl, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+length-1))
+
+	res, err := c.DoRequest(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("failed to read range %s", getJsonError(res.Body))
+	}
+
+	remote, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	local := make([]byte, length)
+	_, err = source.ReadAt(local, start)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if !bytes.Equal(remote, local) {
+		return fmt.Errorf("range %d-%d differs from the source", start, start+length-1)
+	}
+
+	return nil
+}
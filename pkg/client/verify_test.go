@@ -0,0 +1,78 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRanges(t *testing.T) {
+	remote := bytes.Repeat([]byte("0123456789"), 100)
+	regions := []ByteRange{{Start: 0, End: 199}, {Start: 500, End: 699}}
+
+	for _, tc := range []struct {
+		name    string
+		regions []ByteRange
+		// change modifies a copy of the remote data used as the local source
+		change     func(source []byte)
+		dataStatus int
+		wantErr    bool
+		wantReads  bool
+	}{
+		{name: "same data", regions: regions, wantReads: true},
+		{name: "differs in a region", regions: regions, change: func(source []byte) {
+			source[0] ^= 0xff
+			source[500] ^= 0xff
+		}, wantErr: true, wantReads: true},
+		{name: "differs outside the regions", regions: regions, change: func(source []byte) { source[300] ^= 0xff }, wantReads: true},
+		{name: "nothing committed", wantReads: false},
+		{name: "range not served", regions: regions, dataStatus: http.StatusRequestedRangeNotSatisfiable, wantErr: true, wantReads: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reads := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasSuffix(r.URL.Path, "/regions"):
+					json.NewEncoder(w).Encode(RegionsResponse{Regions: tc.regions})
+				case strings.HasSuffix(r.URL.Path, "/data"):
+					reads++
+					if tc.dataStatus != 0 {
+						w.WriteHeader(tc.dataStatus)
+						w.Write([]byte(`{"error": "range is not fully committed"}`))
+						return
+					}
+					var start, end int
+					if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					w.WriteHeader(http.StatusPartialContent)
+					w.Write(remote[start : end+1])
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			source := append([]byte(nil), remote...)
+			if tc.change != nil {
+				tc.change(source)
+			}
+
+			uploadId := "upload"
+			c := &Client{DoRequest: http.DefaultClient.Do, Endpoint: server.URL, UploadId: &uploadId}
+			err := c.VerifyRanges(context.Background(), bytes.NewReader(source), 4)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("VerifyRanges: %v, want error %t", err, tc.wantErr)
+			}
+			if (reads > 0) != tc.wantReads {
+				t.Errorf("%d ranges read, want reads %t", reads, tc.wantReads)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ChunkHash is the SHA-256 of a chunk the client sent, it is the checksum the server returns in X-Checksum. Chunk
+// checksums are SHA-256 whatever algorithm the server verifies finished uploads with.
+type ChunkHash struct {
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"`
+	Checksum string `json:"checksum"`
+}
+
+// VerifiedChunk tells whether the server stored a chunk as it was sent.
+type VerifiedChunk struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Match bool  `json:"match"`
+}
+
+type VerifyResumeResponse struct {
+	Chunks    []VerifiedChunk `json:"chunks"`
+	Committed int64           `json:"committed"`
+	Regions   []ByteRange     `json:"regions"`
+}
+
+// VerifyResume sends the hashes of the last chunks sent to the upload before resuming it. The server re-hashes them,
+// forgets everything from the first chunk it did not store as sent, like after a crash losing unsynced data, and
+// returns the committed offset the upload should continue from.
+func (c *Client) VerifyResume(ctx context.Context, chunks []ChunkHash) (*VerifyResumeResponse, error) {
+	verifyUrl := fmt.Sprintf("%s/%s/verify-resume", c.Endpoint, *c.UploadId)
+
+	args := struct {
+		Chunks []ChunkHash `json:"chunks"`
+	}{Chunks: chunks}
+
+	var resp VerifyResumeResponse
+	err := c.sendJsonRequest(ctx, verifyUrl, &args, http.StatusOK, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// LastChunkHashes hashes the last count chunks of ChunkSize below a given committed offset of the source, ready to
+// be passed to VerifyResume. The upload must start at offset 0 of source.
+func (c *Client) LastChunkHashes(source io.ReaderAt, committed int64, count int) ([]ChunkHash, error) {
+	if c.ChunkSize <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive")
+	}
+
+	first := committed/c.ChunkSize - int64(count)
+	if committed%c.ChunkSize != 0 {
+		first++
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	var chunks []ChunkHash
+	for start := first * c.ChunkSize; start < committed; start += c.ChunkSize {
+		end := start + c.ChunkSize - 1
+		if end >= committed {
+			end = committed - 1
+		}
+
+		hasher := sha256.New()
+		_, err := io.Copy(hasher, io.NewSectionReader(source, start, end-start+1))
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, ChunkHash{Start: start, End: end, Checksum: hex.EncodeToString(hasher.Sum(nil))})
+	}
+
+	return chunks, nil
+}
@@ -0,0 +1,32 @@
+// Package brotliadapter compresses the responses of the uploader with Brotli and decodes them in its client. It is a
+// separate module, so the uploader does not depend on a Brotli implementation.
+package brotliadapter
+
+import (
+	"io"
+
+	chunkeduploader "github.com/Craftserve/chunked-uploader"
+	"github.com/Craftserve/chunked-uploader/pkg/client"
+	"github.com/andybalholm/brotli"
+)
+
+// Encoder returns a response encoder compressing with Brotli at a given level between 0 and 11, to be passed to
+// chunkeduploader.WithResponseCompression before GzipEncoder so it is preferred.
+func Encoder(level int) chunkeduploader.ResponseEncoder {
+	return chunkeduploader.ResponseEncoder{
+		Encoding: "br",
+		NewWriter: func(w io.Writer) io.WriteCloser {
+			return brotli.NewWriterLevel(w, level)
+		},
+	}
+}
+
+// Decoder returns a content decoder for Brotli, to be added to client.Client.Decoders.
+func Decoder() client.ContentDecoder {
+	return client.ContentDecoder{
+		Encoding: "br",
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(brotli.NewReader(r)), nil
+		},
+	}
+}
@@ -0,0 +1,119 @@
+package brotliadapter
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	chunkeduploader "github.com/Craftserve/chunked-uploader"
+	"github.com/Craftserve/chunked-uploader/pkg/client"
+	"github.com/andybalholm/brotli"
+	"github.com/spf13/afero"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	service := chunkeduploader.NewChunkedUploaderService(afero.NewMemMapFs())
+	server := httptest.NewServer(chunkeduploader.NewHTTPHandler(service, chunkeduploader.WithResponseCompression(Encoder(5), chunkeduploader.GzipEncoder)))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNegotiation(t *testing.T) {
+	server := newTestServer(t)
+
+	resp, err := http.Get(server.URL + "/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{"br", "br"},
+		{"gzip, br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, gzip", "gzip"},
+		{"identity", ""},
+		{"*", "br"},
+	} {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/version", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		encoding := resp.Header.Get("Content-Encoding")
+		if encoding != tc.wantEncoding {
+			t.Errorf("Accept-Encoding %q: Content-Encoding %q, want %q", tc.acceptEncoding, encoding, tc.wantEncoding)
+			continue
+		}
+		var body io.Reader = resp.Body
+		switch encoding {
+		case "br":
+			body = brotli.NewReader(resp.Body)
+		case "gzip":
+			if body, err = gzip.NewReader(resp.Body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("Accept-Encoding %q: body %s, want %s", tc.acceptEncoding, got, want)
+		}
+	}
+}
+
+func TestClientDecodesBrotli(t *testing.T) {
+	server := newTestServer(t)
+
+	for _, tc := range []struct {
+		name         string
+		decoders     []client.ContentDecoder
+		wantEncoding string
+	}{
+		{name: "brotli", decoders: []client.ContentDecoder{Decoder()}, wantEncoding: "br"},
+		{name: "gzip only", wantEncoding: "gzip"},
+	} {
+		var encoding string
+		c := client.Client{
+			Endpoint: server.URL,
+			Decoders: tc.decoders,
+			DoRequest: func(req *http.Request) (*http.Response, error) {
+				resp, err := http.DefaultClient.Do(req)
+				if err == nil {
+					encoding = resp.Header.Get("Content-Encoding")
+				}
+				return resp, err
+			},
+		}
+		capabilities, err := c.Capabilities(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if encoding != tc.wantEncoding {
+			t.Errorf("%s: Content-Encoding %q, want %q", tc.name, encoding, tc.wantEncoding)
+		}
+		if !capabilities.HasFeature("metadata") {
+			t.Errorf("%s: capabilities %+v miss the metadata feature", tc.name, capabilities)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+module github.com/Craftserve/chunked-uploader/pkg/logging/logrusadapter
+
+go 1.21
+
+replace github.com/Craftserve/chunked-uploader => ../../..
+
+require (
+	github.com/Craftserve/chunked-uploader v0.0.0-00010101000000-000000000000
+	github.com/sirupsen/logrus v1.9.3
+)
+
+require (
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/gorilla/mux v1.8.1 // indirect
+	github.com/gorilla/websocket v1.5.1 // indirect
+	github.com/spf13/afero v1.11.0 // indirect
+	golang.org/x/image v0.18.0 // indirect
+	golang.org/x/net v0.19.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+)
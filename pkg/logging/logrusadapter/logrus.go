@@ -0,0 +1,47 @@
+// Package logrusadapter sends the log messages of the uploader to a logrus logger. It is a separate module, so the
+// uploader does not depend on logrus.
+package logrusadapter
+
+import (
+	chunkeduploader "github.com/Craftserve/chunked-uploader"
+	"github.com/sirupsen/logrus"
+)
+
+type Logger struct {
+	logger *logrus.Logger
+}
+
+// New creates an adapter for a given logger, a nil logger stands for logrus.StandardLogger().
+func New(logger *logrus.Logger) *Logger {
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	return &Logger{logger: logger}
+}
+
+func (l *Logger) Log(level chunkeduploader.LogLevel, msg string, fields ...chunkeduploader.LogField) {
+	logrusLevel := toLogrusLevel(level)
+	if !l.logger.IsLevelEnabled(logrusLevel) {
+		return
+	}
+
+	logrusFields := make(logrus.Fields, len(fields))
+	for _, field := range fields {
+		logrusFields[field.Key] = field.Value
+	}
+
+	l.logger.WithFields(logrusFields).Log(logrusLevel, msg)
+}
+
+func toLogrusLevel(level chunkeduploader.LogLevel) logrus.Level {
+	switch level {
+	case chunkeduploader.LogLevelDebug:
+		return logrus.DebugLevel
+	case chunkeduploader.LogLevelInfo:
+		return logrus.InfoLevel
+	case chunkeduploader.LogLevelWarn:
+		return logrus.WarnLevel
+	default:
+		return logrus.ErrorLevel
+	}
+}
@@ -0,0 +1,47 @@
+package logrusadapter
+
+import (
+	"io"
+	"testing"
+
+	chunkeduploader "github.com/Craftserve/chunked-uploader"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestLogPropagatesLevelAndFields(t *testing.T) {
+	base, hook := test.NewNullLogger()
+	base.SetLevel(logrus.DebugLevel)
+	logger := New(base)
+
+	logger.Log(chunkeduploader.LogLevelWarn, "Chunk written", chunkeduploader.LogField{Key: "upload_id", Value: "abc"}, chunkeduploader.LogField{Key: "offset", Value: int64(1024)})
+
+	if len(hook.Entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(hook.Entries))
+	}
+	entry := hook.LastEntry()
+	if entry.Level != logrus.WarnLevel || entry.Message != "Chunk written" {
+		t.Errorf("got %s %q, want warning \"Chunk written\"", entry.Level, entry.Message)
+	}
+	if entry.Data["upload_id"] != "abc" || entry.Data["offset"] != int64(1024) {
+		t.Errorf("got fields %v", entry.Data)
+	}
+}
+
+func TestLogDisabledLevelDoesNotAllocate(t *testing.T) {
+	base := logrus.New()
+	base.SetOutput(io.Discard)
+	base.SetLevel(logrus.ErrorLevel)
+	logger := New(base)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		logger.Log(chunkeduploader.LogLevelDebug, "Chunk written", chunkeduploader.LogField{Key: "upload_id", Value: "abc"})
+	})
+	if allocs != 0 {
+		t.Errorf("disabled level allocated %v times", allocs)
+	}
+}
+
+func TestNewNil(t *testing.T) {
+	New(nil).Log(chunkeduploader.LogLevelDebug, "Chunk written")
+}
@@ -0,0 +1,50 @@
+// Package slogadapter sends the log messages of the uploader to a *slog.Logger.
+package slogadapter
+
+import (
+	"context"
+	"log/slog"
+
+	chunkeduploader "github.com/Craftserve/chunked-uploader"
+)
+
+type Logger struct {
+	logger *slog.Logger
+}
+
+// New creates an adapter for a given logger, a nil logger stands for slog.Default().
+func New(logger *slog.Logger) *Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Logger{logger: logger}
+}
+
+func (l *Logger) Log(level chunkeduploader.LogLevel, msg string, fields ...chunkeduploader.LogField) {
+	slogLevel := toSlogLevel(level)
+
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, slogLevel) {
+		return
+	}
+
+	attrs := make([]slog.Attr, len(fields))
+	for i, field := range fields {
+		attrs[i] = slog.Any(field.Key, field.Value)
+	}
+
+	l.logger.LogAttrs(ctx, slogLevel, msg, attrs...)
+}
+
+func toSlogLevel(level chunkeduploader.LogLevel) slog.Level {
+	switch level {
+	case chunkeduploader.LogLevelDebug:
+		return slog.LevelDebug
+	case chunkeduploader.LogLevelInfo:
+		return slog.LevelInfo
+	case chunkeduploader.LogLevelWarn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
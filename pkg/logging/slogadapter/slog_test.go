@@ -0,0 +1,66 @@
+package slogadapter
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	chunkeduploader "github.com/Craftserve/chunked-uploader"
+)
+
+// recordHandler keeps the records it handles.
+type recordHandler struct {
+	level   slog.Level
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *recordHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.records = append(h.records, record)
+	return nil
+}
+
+func (h *recordHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+func (h *recordHandler) WithGroup(name string) slog.Handler       { return h }
+
+func TestLogPropagatesLevelAndFields(t *testing.T) {
+	handler := &recordHandler{level: slog.LevelDebug}
+	logger := New(slog.New(handler))
+
+	logger.Log(chunkeduploader.LogLevelWarn, "Chunk written", chunkeduploader.LogField{Key: "upload_id", Value: "abc"}, chunkeduploader.LogField{Key: "offset", Value: int64(1024)})
+
+	if len(handler.records) != 1 {
+		t.Fatalf("got %d records, want 1", len(handler.records))
+	}
+	record := handler.records[0]
+	if record.Level != slog.LevelWarn || record.Message != "Chunk written" {
+		t.Errorf("got %s %q, want WARN \"Chunk written\"", record.Level, record.Message)
+	}
+	fields := make(map[string]any)
+	record.Attrs(func(attr slog.Attr) bool {
+		fields[attr.Key] = attr.Value.Any()
+		return true
+	})
+	if fields["upload_id"] != "abc" || fields["offset"] != int64(1024) {
+		t.Errorf("got fields %v", fields)
+	}
+}
+
+func TestLogDisabledLevelDoesNotAllocate(t *testing.T) {
+	logger := New(slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError})))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		logger.Log(chunkeduploader.LogLevelDebug, "Chunk written", chunkeduploader.LogField{Key: "upload_id", Value: "abc"})
+	})
+	if allocs != 0 {
+		t.Errorf("disabled level allocated %v times", allocs)
+	}
+}
+
+func TestNewNil(t *testing.T) {
+	New(nil).Log(chunkeduploader.LogLevelDebug, "Chunk written")
+}
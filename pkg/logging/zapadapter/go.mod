@@ -0,0 +1,21 @@
+module github.com/Craftserve/chunked-uploader/pkg/logging/zapadapter
+
+go 1.21
+
+replace github.com/Craftserve/chunked-uploader => ../../..
+
+require (
+	github.com/Craftserve/chunked-uploader v0.0.0-00010101000000-000000000000
+	go.uber.org/zap v1.27.0
+)
+
+require (
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/gorilla/mux v1.8.1 // indirect
+	github.com/gorilla/websocket v1.5.1 // indirect
+	github.com/spf13/afero v1.11.0 // indirect
+	go.uber.org/multierr v1.10.0 // indirect
+	golang.org/x/image v0.18.0 // indirect
+	golang.org/x/net v0.19.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+)
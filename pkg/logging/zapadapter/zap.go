@@ -0,0 +1,48 @@
+// Package zapadapter sends the log messages of the uploader to a *zap.Logger. It is a separate module, so the
+// uploader does not depend on zap.
+package zapadapter
+
+import (
+	chunkeduploader "github.com/Craftserve/chunked-uploader"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type Logger struct {
+	logger *zap.Logger
+}
+
+// New creates an adapter for a given logger, a nil logger stands for zap.L().
+func New(logger *zap.Logger) *Logger {
+	if logger == nil {
+		logger = zap.L()
+	}
+	return &Logger{logger: logger}
+}
+
+func (l *Logger) Log(level chunkeduploader.LogLevel, msg string, fields ...chunkeduploader.LogField) {
+	entry := l.logger.Check(toZapLevel(level), msg)
+	if entry == nil {
+		return
+	}
+
+	zapFields := make([]zap.Field, len(fields))
+	for i, field := range fields {
+		zapFields[i] = zap.Any(field.Key, field.Value)
+	}
+
+	entry.Write(zapFields...)
+}
+
+func toZapLevel(level chunkeduploader.LogLevel) zapcore.Level {
+	switch level {
+	case chunkeduploader.LogLevelDebug:
+		return zapcore.DebugLevel
+	case chunkeduploader.LogLevelInfo:
+		return zapcore.InfoLevel
+	case chunkeduploader.LogLevelWarn:
+		return zapcore.WarnLevel
+	default:
+		return zapcore.ErrorLevel
+	}
+}
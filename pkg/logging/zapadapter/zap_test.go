@@ -0,0 +1,46 @@
+package zapadapter
+
+import (
+	"testing"
+
+	chunkeduploader "github.com/Craftserve/chunked-uploader"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogPropagatesLevelAndFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := New(zap.New(core))
+
+	logger.Log(chunkeduploader.LogLevelWarn, "Chunk written", chunkeduploader.LogField{Key: "upload_id", Value: "abc"}, chunkeduploader.LogField{Key: "offset", Value: int64(1024)})
+
+	entries := logs.AllUntimed()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.Level != zapcore.WarnLevel || entry.Message != "Chunk written" {
+		t.Errorf("got %s %q, want warn \"Chunk written\"", entry.Level, entry.Message)
+	}
+	fields := entry.ContextMap()
+	if fields["upload_id"] != "abc" || fields["offset"] != int64(1024) {
+		t.Errorf("got fields %v", fields)
+	}
+}
+
+func TestLogDisabledLevelDoesNotAllocate(t *testing.T) {
+	core, _ := observer.New(zapcore.ErrorLevel)
+	logger := New(zap.New(core))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		logger.Log(chunkeduploader.LogLevelDebug, "Chunk written", chunkeduploader.LogField{Key: "upload_id", Value: "abc"})
+	})
+	if allocs != 0 {
+		t.Errorf("disabled level allocated %v times", allocs)
+	}
+}
+
+func TestNewNil(t *testing.T) {
+	New(nil).Log(chunkeduploader.LogLevelInfo, "Chunk written")
+}
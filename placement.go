@@ -0,0 +1,409 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+)
+
+// PlacementJournal records a placement of FinishUploadTo in progress, so a placement interrupted by a crash can be
+// completed or rolled back, see RecoverPlacements. CreatedDirectories are listed parents first and removed again on
+// rollback.
+type PlacementJournal struct {
+	Source             string   `json:"source"`
+	Destination        string   `json:"destination"`
+	Manifest           string   `json:"manifest,omitempty"`
+	TempManifest       string   `json:"temp_manifest,omitempty"`
+	CreatedDirectories []string `json:"created_directories,omitempty"`
+}
+
+// FinishManifest is the manifest written by WithManifest.
+type FinishManifest struct {
+	UploadId          string                  `json:"upload_id"`
+	Filename          string                  `json:"filename,omitempty"`
+	ContentType       string                  `json:"content_type,omitempty"`
+	Size              int64                   `json:"size"`
+	Checksum          string                  `json:"checksum"`
+	ChecksumAlgorithm utils.ChecksumAlgorithm `json:"checksum_algorithm"`
+}
+
+// placement is what FinishUploadTo places below the destination root.
+type placement struct {
+	manifest       string
+	renderManifest func(meta *UploadMetadata, size int64) ([]byte, error)
+}
+
+type FinishToOption func(*placement)
+
+// WithManifest writes a FinishManifest of the upload to a given path below the destination root together with the
+// file.
+func WithManifest(destination string) FinishToOption {
+	return func(p *placement) {
+		p.manifest = destination
+		p.renderManifest = func(meta *UploadMetadata, size int64) ([]byte, error) {
+			return json.MarshalIndent(FinishManifest{
+				UploadId:          meta.UploadId,
+				Filename:          meta.Filename,
+				ContentType:       meta.ContentType,
+				Size:              size,
+				Checksum:          meta.Checksum,
+				ChecksumAlgorithm: meta.ChecksumAlgorithm,
+			}, "", "  ")
+		}
+	}
+}
+
+// WithManifestValue writes a given value encoded as JSON to a given path below the destination root together with
+// the file.
+func WithManifestValue(destination string, value interface{}) FinishToOption {
+	return func(p *placement) {
+		p.manifest = destination
+		p.renderManifest = func(meta *UploadMetadata, size int64) ([]byte, error) {
+			return json.MarshalIndent(value, "", "  ")
+		}
+	}
+}
+
+// WithManifestTemplate writes the output of a template executed with the *UploadMetadata of the finished upload to a
+// given path below the destination root together with the file.
+func WithManifestTemplate(destination string, tmpl *template.Template) FinishToOption {
+	return func(p *placement) {
+		p.manifest = destination
+		p.renderManifest = func(meta *UploadMetadata, size int64) ([]byte, error) {
+			var b bytes.Buffer
+			err := tmpl.Execute(&b, meta)
+			return b.Bytes(), err
+		}
+	}
+}
+
+// FinishUploadTo finishes an upload like FinishUpload and moves its file to a destination below the destination
+// root, see WithDestinationRoot, optionally together with a manifest. The manifest is written under a temporary name
+// first, then the file and the manifest are renamed into place. When a step fails the steps already performed are
+// rolled back, including the directories created for them, so the destinations are either complete or untouched.
+// Existing destinations fail with DestinationExistsError before the upload is finished.
+func (c *ChunkedUploaderService) FinishUploadTo(ctx context.Context, uploadId string, expectedChecksum string, destination string, opts ...FinishToOption) (path string, err error) {
+	return c.finishUploadTo(ctx, uploadId, expectedChecksum, c.checksumAlgorithm, destination, opts...)
+}
+
+func (c *ChunkedUploaderService) finishUploadTo(ctx context.Context, uploadId string, expectedChecksum string, algorithm utils.ChecksumAlgorithm, destination string, opts ...FinishToOption) (string, error) {
+	var p placement
+	for _, opt := range opts {
+		opt(&p)
+	}
+
+	journal, err := c.planPlacement(uploadId, destination, p.manifest)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.FinishUploadTo %w", err)
+	}
+
+	_, err = c.FinishUploadWithAlgorithm(ctx, uploadId, expectedChecksum, algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	err = c.place(uploadId, journal, p.renderManifest)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.FinishUploadTo %w", err)
+	}
+
+	return journal.Destination, nil
+}
+
+// planPlacement confines the destinations below the destination root and checks that they are free.
+func (c *ChunkedUploaderService) planPlacement(uploadId string, destination string, manifest string) (*PlacementJournal, error) {
+	journal := &PlacementJournal{}
+
+	var err error
+	journal.Destination, err = c.confineDestination(destination)
+	if err != nil {
+		return nil, err
+	}
+	if manifest != "" {
+		journal.Manifest, err = c.confineDestination(manifest)
+		if err != nil {
+			return nil, err
+		}
+		if journal.Manifest == journal.Destination {
+			return nil, fmt.Errorf("%w: the manifest and the file have the same destination", DestinationExistsError)
+		}
+		journal.TempManifest = filepath.Join(filepath.Dir(journal.Manifest), "."+filepath.Base(journal.Manifest)+"."+uploadId+".tmp")
+	}
+
+	err = c.checkDestinationsFree(journal)
+	if err != nil {
+		return nil, err
+	}
+
+	return journal, nil
+}
+
+// checkDestinationsFree fails with DestinationExistsError when the file or the manifest of a placement exists.
+func (c *ChunkedUploaderService) checkDestinationsFree(journal *PlacementJournal) error {
+	for _, path := range []string{journal.Destination, journal.Manifest} {
+		if path == "" {
+			continue
+		}
+		_, err := c.fs.Stat(path)
+		if err == nil {
+			return fmt.Errorf("%w: %s", DestinationExistsError, path)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to stat destination %w", err)
+		}
+	}
+	return nil
+}
+
+// place moves the file of a finished upload and writes its manifest as planned, the journal is saved in the metadata
+// before the first step and removed with the last one.
+func (c *ChunkedUploaderService) place(uploadId string, journal *PlacementJournal, renderManifest func(meta *UploadMetadata, size int64) ([]byte, error)) error {
+	unlock := c.locks.lock(uploadId)
+	defer unlock()
+
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return err
+	}
+	if meta.Placement != nil {
+		err = c.recoverPlacement(meta)
+		if err != nil {
+			return fmt.Errorf("failed to recover previous placement %w", err)
+		}
+	}
+
+	// the destinations may have been taken while the upload was verified
+	err = c.checkDestinationsFree(journal)
+	if err != nil {
+		return err
+	}
+
+	journal.Source = meta.Path
+	if journal.Source == "" {
+		journal.Source = c.getUploadFilePath(uploadId)
+	}
+	info, err := c.fs.Stat(journal.Source)
+	if err != nil {
+		return fmt.Errorf("failed to stat uploaded file %w", err)
+	}
+
+	var manifest []byte
+	if journal.Manifest != "" {
+		manifest, err = renderManifest(meta, info.Size())
+		if err != nil {
+			return fmt.Errorf("failed to render manifest %w", err)
+		}
+	}
+
+	for _, path := range []string{journal.Destination, journal.Manifest} {
+		if path != "" {
+			journal.CreatedDirectories = c.missingDirectories(filepath.Dir(path), journal.CreatedDirectories)
+		}
+	}
+	meta.Placement = journal
+	err = c.saveMetadata(meta)
+	if err != nil {
+		return fmt.Errorf("failed to save placement journal %w", err)
+	}
+
+	err = c.performPlacement(journal, manifest)
+	if err != nil {
+		c.log(LogLevelWarn, "Rolling back placement", LogField{"upload_id", uploadId}, LogField{"destination", journal.Destination}, LogField{"error", err})
+		rollbackErr := c.rollbackPlacement(journal)
+		if rollbackErr != nil {
+			// the journal stays in the metadata for RecoverPlacements
+			return fmt.Errorf("%w, rollback failed %s", err, rollbackErr)
+		}
+		meta.Placement = nil
+		if saveErr := c.saveMetadata(meta); saveErr != nil {
+			return fmt.Errorf("%w, failed to remove placement journal %s", err, saveErr)
+		}
+		return err
+	}
+
+	meta.Path = journal.Destination
+	meta.Placement = nil
+	err = c.saveMetadata(meta)
+	if err != nil {
+		return fmt.Errorf("failed to save metadata %w", err)
+	}
+
+	return nil
+}
+
+// missingDirectories appends the directories which have to be created for a given directory, parents first.
+func (c *ChunkedUploaderService) missingDirectories(dir string, created []string) []string {
+	var missing []string
+	for ; dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if _, err := c.fs.Stat(dir); err == nil {
+			break
+		}
+		missing = append([]string{dir}, missing...)
+	}
+
+	for _, dir := range missing {
+		known := false
+		for _, existing := range created {
+			known = known || existing == dir
+		}
+		if !known {
+			created = append(created, dir)
+		}
+	}
+	return created
+}
+
+// performPlacement writes the manifest under its temporary name and renames the file and then the manifest into place.
+func (c *ChunkedUploaderService) performPlacement(journal *PlacementJournal, manifest []byte) error {
+	for _, dir := range journal.CreatedDirectories {
+		err := c.fs.MkdirAll(dir, StandardAccess)
+		if err != nil {
+			return fmt.Errorf("failed to create directory %w", err)
+		}
+	}
+
+	if journal.Manifest != "" {
+		err := c.writeTempManifest(journal.TempManifest, manifest)
+		if err != nil {
+			return fmt.Errorf("failed to write manifest %w", err)
+		}
+	}
+
+	err := c.fs.Rename(journal.Source, journal.Destination)
+	if err != nil {
+		return fmt.Errorf("failed to rename uploaded file %w", err)
+	}
+
+	if journal.Manifest != "" {
+		err = c.fs.Rename(journal.TempManifest, journal.Manifest)
+		if err != nil {
+			return fmt.Errorf("failed to rename manifest %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *ChunkedUploaderService) writeTempManifest(path string, manifest []byte) error {
+	file, err := c.fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(manifest)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// rollbackPlacement undoes the steps of a placement which was not completed, it may be called more than once.
+func (c *ChunkedUploaderService) rollbackPlacement(journal *PlacementJournal) error {
+	if journal.TempManifest != "" {
+		err := c.fs.Remove(journal.TempManifest)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove temporary manifest %w", err)
+		}
+	}
+
+	if _, err := c.fs.Stat(journal.Source); errors.Is(err, fs.ErrNotExist) {
+		err = c.fs.Rename(journal.Destination, journal.Source)
+		if err != nil {
+			return fmt.Errorf("failed to move uploaded file back %w", err)
+		}
+	}
+
+	for i := len(journal.CreatedDirectories) - 1; i >= 0; i-- {
+		err := c.fs.Remove(journal.CreatedDirectories[i])
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			// someone else put files there in the meantime
+			c.log(LogLevelWarn, "Failed to remove placement directory", LogField{"path", journal.CreatedDirectories[i]}, LogField{"error", err})
+		}
+	}
+
+	return nil
+}
+
+// recoverPlacement completes a placement whose manifest was already renamed into place and rolls back any other, the
+// caller must hold the lock of the upload.
+func (c *ChunkedUploaderService) recoverPlacement(meta *UploadMetadata) error {
+	journal := meta.Placement
+
+	complete := false
+	if _, err := c.fs.Stat(journal.Source); errors.Is(err, fs.ErrNotExist) {
+		_, err = c.fs.Stat(journal.Destination)
+		complete = err == nil
+		if journal.Manifest != "" {
+			_, err = c.fs.Stat(journal.TempManifest)
+			complete = complete && errors.Is(err, fs.ErrNotExist)
+			_, err = c.fs.Stat(journal.Manifest)
+			complete = complete && err == nil
+		}
+	}
+
+	if complete {
+		meta.Path = journal.Destination
+	} else {
+		err := c.rollbackPlacement(journal)
+		if err != nil {
+			return err
+		}
+	}
+
+	meta.Placement = nil
+	return c.saveMetadata(meta)
+}
+
+// RecoverPlacements completes or rolls back the placements of FinishUploadTo interrupted by a crash and returns how
+// many it found. It is meant to be called once on startup, before the handler serves requests.
+func (c *ChunkedUploaderService) RecoverPlacements(ctx context.Context) (int, error) {
+	var interrupted []string
+	err := c.walkMetadata(func(meta *UploadMetadata) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if meta.Placement != nil {
+			interrupted = append(interrupted, meta.UploadId)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("ChunkedUploaderService.RecoverPlacements %w", err)
+	}
+
+	for _, uploadId := range interrupted {
+		err := c.recoverUploadPlacement(uploadId)
+		if err != nil {
+			return 0, fmt.Errorf("ChunkedUploaderService.RecoverPlacements failed to recover %s %w", uploadId, err)
+		}
+		c.log(LogLevelInfo, "Recovered interrupted placement", LogField{"upload_id", uploadId})
+	}
+
+	return len(interrupted), nil
+}
+
+func (c *ChunkedUploaderService) recoverUploadPlacement(uploadId string) error {
+	unlock := c.locks.lock(uploadId)
+	defer unlock()
+
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return err
+	}
+	if meta.Placement == nil {
+		return nil
+	}
+
+	return c.recoverPlacement(meta)
+}
@@ -0,0 +1,459 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/spf13/afero"
+)
+
+var errCrash = errors.New("injected crash")
+
+// crashingFs panics with errCrash on the first operation matched by crash, leaving the files as a crash at that point
+// would.
+type crashingFs struct {
+	afero.Fs
+	crash func(op string, name string) bool
+}
+
+func (fs *crashingFs) check(op string, name string) {
+	if fs.crash != nil && fs.crash(op, name) {
+		fs.crash = nil
+		panic(errCrash)
+	}
+}
+
+func (fs *crashingFs) MkdirAll(name string, perm os.FileMode) error {
+	fs.check("mkdir", name)
+	return fs.Fs.MkdirAll(name, perm)
+}
+
+func (fs *crashingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	fs.check("open", name)
+	return fs.Fs.OpenFile(name, flag, perm)
+}
+
+func (fs *crashingFs) Rename(oldname string, newname string) error {
+	fs.check("rename", newname)
+	return fs.Fs.Rename(oldname, newname)
+}
+
+// newUnfinishedTestUpload uploads all data of an upload without finishing it.
+func newUnfinishedTestUpload(t *testing.T, service *ChunkedUploaderService) (string, []byte) {
+	t.Helper()
+
+	data := randomBytes(t, 1024)
+	uploadId, err := service.CreateUpload(int64(len(data)), WithFilename("world.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+	return uploadId, data
+}
+
+func TestFinishUploadTo(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []FinishToOption
+		// wantManifest builds the expected manifest from the upload
+		wantManifest func(uploadId string, data []byte) string
+	}{
+		{name: "without manifest"},
+		{
+			name: "manifest",
+			opts: []FinishToOption{WithManifest("worlds/w1/manifest.json")},
+			wantManifest: func(uploadId string, data []byte) string {
+				b, _ := json.MarshalIndent(FinishManifest{
+					UploadId:          uploadId,
+					Filename:          "world.zip",
+					Size:              int64(len(data)),
+					Checksum:          sha256Hex(data),
+					ChecksumAlgorithm: "sha256",
+				}, "", "  ")
+				return string(b)
+			},
+		},
+		{
+			name: "manifest value",
+			opts: []FinishToOption{WithManifestValue("worlds/w1/manifest.json", map[string]string{"world": "w1"})},
+			wantManifest: func(uploadId string, data []byte) string {
+				return "{\n  \"world\": \"w1\"\n}"
+			},
+		},
+		{
+			name: "manifest template",
+			opts: []FinishToOption{WithManifestTemplate("worlds/w1/manifest.txt", template.Must(template.New("").Parse("{{.Filename}} {{.Checksum}}")))},
+			wantManifest: func(uploadId string, data []byte) string {
+				return "world.zip " + sha256Hex(data)
+			},
+		},
+		{
+			name: "manifest in another directory",
+			opts: []FinishToOption{WithManifestValue("manifests/w1.json", 1)},
+			wantManifest: func(uploadId string, data []byte) string {
+				return "1"
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			service := newTestService(fs, WithDestinationRoot("/final"))
+			uploadId, data := newUnfinishedTestUpload(t, service)
+			source := service.getUploadFilePath(uploadId)
+
+			path, err := service.FinishUploadTo(context.Background(), uploadId, sha256Hex(data), "worlds/w1/world.zip", tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path != "/final/worlds/w1/world.zip" {
+				t.Errorf("path %q, want /final/worlds/w1/world.zip", path)
+			}
+			if got, _ := afero.ReadFile(fs, path); !bytes.Equal(got, data) {
+				t.Errorf("destination holds the wrong data")
+			}
+			if exists(t, fs, source) {
+				t.Errorf("%s still exists", source)
+			}
+
+			var p placement
+			for _, opt := range tc.opts {
+				opt(&p)
+			}
+			if tc.wantManifest != nil {
+				got, err := afero.ReadFile(fs, "/final/"+p.manifest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := tc.wantManifest(uploadId, data); string(got) != want {
+					t.Errorf("manifest %q, want %q", got, want)
+				}
+			}
+
+			// only the file and the manifest are left below the destination root
+			var files []string
+			err = afero.Walk(fs, "/final", func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					files = append(files, path)
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantFiles := 1
+			if tc.wantManifest != nil {
+				wantFiles = 2
+			}
+			if len(files) != wantFiles {
+				t.Errorf("files %v, want %d", files, wantFiles)
+			}
+
+			meta, err := service.readMetadata(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Path != path || meta.Placement != nil {
+				t.Errorf("metadata path %q, placement %+v, want %q without placement", meta.Path, meta.Placement, path)
+			}
+		})
+	}
+}
+
+func TestFinishUploadToRejectsDestinations(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		root        string
+		destination string
+		manifest    string
+		// existing is written below the root before the finish
+		existing string
+		want     error
+	}{
+		{name: "without destination root", destination: "worlds/w1/world.zip", want: DestinationNotAllowedError},
+		{name: "destination root itself", root: "/final", destination: "/", want: DestinationNotAllowedError},
+		{name: "pending directory", root: "/", destination: ".pending/world.zip", want: DestinationNotAllowedError},
+		{name: "manifest at pending directory", root: "/", destination: "worlds/world.zip", manifest: ".pending/manifest.json", want: DestinationNotAllowedError},
+		{name: "existing file", root: "/final", destination: "worlds/w1/world.zip", existing: "/final/worlds/w1/world.zip", want: DestinationExistsError},
+		{name: "existing manifest", root: "/final", destination: "worlds/w1/world.zip", manifest: "worlds/w1/manifest.json", existing: "/final/worlds/w1/manifest.json", want: DestinationExistsError},
+		{name: "manifest at file", root: "/final", destination: "worlds/w1/world.zip", manifest: "worlds/w1/world.zip", want: DestinationExistsError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			var opts []ChunkedUploaderServiceOption
+			if tc.root != "" {
+				opts = append(opts, WithDestinationRoot(tc.root))
+			}
+			service := newTestService(fs, opts...)
+			uploadId, data := newUnfinishedTestUpload(t, service)
+			if tc.existing != "" {
+				if err := afero.WriteFile(fs, tc.existing, []byte("existing"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var finishOpts []FinishToOption
+			if tc.manifest != "" {
+				finishOpts = append(finishOpts, WithManifest(tc.manifest))
+			}
+			_, err := service.FinishUploadTo(context.Background(), uploadId, sha256Hex(data), tc.destination, finishOpts...)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+
+			// destinations are refused before the upload is finished
+			meta, err := service.readMetadata(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.State != UploadStateUploading {
+				t.Errorf("state %s, want %s", meta.State, UploadStateUploading)
+			}
+			if tc.existing != "" {
+				if got, _ := afero.ReadFile(fs, tc.existing); string(got) != "existing" {
+					t.Errorf("existing destination was changed")
+				}
+			}
+		})
+	}
+}
+
+func TestFinishUploadToRollsBack(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// fail matches the file of the step which fails
+		fail func(name string) bool
+	}{
+		{name: "manifest write", fail: func(name string) bool { return strings.HasSuffix(name, ".tmp") && strings.HasPrefix(name, "/final/") }},
+		{name: "file rename", fail: func(name string) bool { return name == "/final/worlds/w1/world.zip" }},
+		{name: "manifest rename", fail: func(name string) bool { return name == "/final/worlds/w1/manifest.json" }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			failing := &failingFs{Fs: afero.NewMemMapFs(), fail: func(name string) bool { return false }}
+			service := newTestService(failing, WithDestinationRoot("/final"))
+			uploadId, data := newUnfinishedTestUpload(t, service)
+			source := service.getUploadFilePath(uploadId)
+
+			failing.fail = tc.fail
+			_, err := service.FinishUploadTo(context.Background(), uploadId, sha256Hex(data), "worlds/w1/world.zip", WithManifest("worlds/w1/manifest.json"))
+			if err == nil {
+				t.Fatal("finish succeeded")
+			}
+
+			// the destination directory is untouched, not even created
+			if exists(t, failing, "/final/worlds") {
+				t.Errorf("/final/worlds was left behind")
+			}
+			if got, _ := afero.ReadFile(failing, source); !bytes.Equal(got, data) {
+				t.Errorf("uploaded file was not moved back")
+			}
+			meta, err := service.readMetadata(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Placement != nil {
+				t.Errorf("placement journal was left behind: %+v", meta.Placement)
+			}
+
+			// once the failure is gone the placement can be retried
+			failing.fail = func(name string) bool { return false }
+			path, err := service.FinishUploadTo(context.Background(), uploadId, sha256Hex(data), "worlds/w1/world.zip", WithManifest("worlds/w1/manifest.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := afero.ReadFile(failing, path); !bytes.Equal(got, data) {
+				t.Errorf("destination holds the wrong data")
+			}
+		})
+	}
+}
+
+func TestRecoverPlacements(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// crash matches the operation the service crashes at, given the upload and the underlying file system
+		crash        func(fs afero.Fs, service *ChunkedUploaderService, uploadId string) func(op string, name string) bool
+		wantComplete bool
+	}{
+		{
+			name: "before creating directories",
+			crash: func(fs afero.Fs, service *ChunkedUploaderService, uploadId string) func(op string, name string) bool {
+				return func(op string, name string) bool { return op == "mkdir" && strings.HasPrefix(name, "/final/") }
+			},
+		},
+		{
+			name: "before writing the manifest",
+			crash: func(fs afero.Fs, service *ChunkedUploaderService, uploadId string) func(op string, name string) bool {
+				return func(op string, name string) bool { return op == "open" && strings.HasPrefix(name, "/final/") }
+			},
+		},
+		{
+			name: "before renaming the file",
+			crash: func(fs afero.Fs, service *ChunkedUploaderService, uploadId string) func(op string, name string) bool {
+				return func(op string, name string) bool { return op == "rename" && name == "/final/worlds/w1/world.zip" }
+			},
+		},
+		{
+			name: "before renaming the manifest",
+			crash: func(fs afero.Fs, service *ChunkedUploaderService, uploadId string) func(op string, name string) bool {
+				return func(op string, name string) bool { return op == "rename" && name == "/final/worlds/w1/manifest.json" }
+			},
+		},
+		{
+			name: "before removing the journal",
+			crash: func(fs afero.Fs, service *ChunkedUploaderService, uploadId string) func(op string, name string) bool {
+				return func(op string, name string) bool {
+					if op != "rename" || name != service.getMetadataFilePath(uploadId) {
+						return false
+					}
+					ok, _ := afero.Exists(fs, "/final/worlds/w1/manifest.json")
+					return ok
+				}
+			},
+			wantComplete: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			underlying := afero.NewMemMapFs()
+			crashing := &crashingFs{Fs: underlying}
+			service := newTestService(crashing, WithDestinationRoot("/final"))
+			uploadId, data := newUnfinishedTestUpload(t, service)
+			source := service.getUploadFilePath(uploadId)
+
+			crashing.crash = tc.crash(underlying, service, uploadId)
+			func() {
+				defer func() {
+					if r := recover(); r != errCrash {
+						t.Fatalf("got %v, want a crash", r)
+					}
+				}()
+				service.FinishUploadTo(context.Background(), uploadId, sha256Hex(data), "worlds/w1/world.zip", WithManifest("worlds/w1/manifest.json"))
+			}()
+
+			restarted := newTestService(underlying, WithDestinationRoot("/final"))
+			n, err := restarted.RecoverPlacements(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 {
+				t.Errorf("recovered %d placements, want 1", n)
+			}
+
+			meta, err := restarted.readMetadata(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Placement != nil {
+				t.Errorf("placement journal was left behind: %+v", meta.Placement)
+			}
+
+			if tc.wantComplete {
+				if meta.Path != "/final/worlds/w1/world.zip" {
+					t.Errorf("path %q, want /final/worlds/w1/world.zip", meta.Path)
+				}
+				if got, _ := afero.ReadFile(underlying, "/final/worlds/w1/world.zip"); !bytes.Equal(got, data) {
+					t.Errorf("destination holds the wrong data")
+				}
+				if !exists(t, underlying, "/final/worlds/w1/manifest.json") {
+					t.Errorf("manifest is missing")
+				}
+				return
+			}
+
+			if exists(t, underlying, "/final/worlds") {
+				t.Errorf("/final/worlds was left behind")
+			}
+			if got, _ := afero.ReadFile(underlying, source); !bytes.Equal(got, data) {
+				t.Errorf("uploaded file was not moved back")
+			}
+
+			// nothing is left to recover on the next start
+			n, err = restarted.RecoverPlacements(context.Background())
+			if err != nil || n != 0 {
+				t.Errorf("second recovery: got %d, %v, want 0", n, err)
+			}
+
+			path, err := restarted.FinishUploadTo(context.Background(), uploadId, sha256Hex(data), "worlds/w1/world.zip", WithManifest("worlds/w1/manifest.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := afero.ReadFile(underlying, path); !bytes.Equal(got, data) {
+				t.Errorf("destination holds the wrong data")
+			}
+		})
+	}
+}
+
+func TestFinishUploadHandlerDestination(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		body     string
+		query    string
+		existing bool
+		want     int
+		wantPath string
+	}{
+		{name: "destination", body: `"destination": "worlds/w1/world.zip"`, want: http.StatusOK, wantPath: "/worlds/w1/world.zip"},
+		{name: "destination and manifest", body: `"destination": "worlds/w1/world.zip", "manifest": "worlds/w1/manifest.json"`, want: http.StatusOK, wantPath: "/worlds/w1/world.zip"},
+		{name: "manifest without destination", body: `"manifest": "worlds/w1/manifest.json"`, want: http.StatusBadRequest},
+		{name: "streamed", body: `"destination": "worlds/w1/world.zip"`, query: "?stream=true", want: http.StatusBadRequest},
+		{name: "outside of the root", body: `"destination": ".pending/world.zip"`, want: http.StatusForbidden},
+		{name: "existing destination", body: `"destination": "worlds/w1/world.zip"`, existing: true, want: http.StatusPreconditionFailed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			service := newTestService(fs, WithDestinationRoot("/"))
+			handler := NewHTTPHandler(service)
+			uploadId, data := newUnfinishedTestUpload(t, service)
+			if tc.existing {
+				if err := afero.WriteFile(fs, "/worlds/w1/world.zip", []byte("existing"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			body := fmt.Sprintf(`{"checksum": %q, %s}`, sha256Hex(data), tc.body)
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/"+uploadId+"/finish"+tc.query, strings.NewReader(body)))
+			if rec.Code != tc.want {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+			if tc.wantPath == "" {
+				return
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp["path"] != tc.wantPath {
+				t.Errorf("path %q, want %q", resp["path"], tc.wantPath)
+			}
+			if got, _ := afero.ReadFile(fs, tc.wantPath); !bytes.Equal(got, data) {
+				t.Errorf("destination holds the wrong data")
+			}
+			if strings.Contains(tc.body, "manifest") {
+				var manifest FinishManifest
+				b, err := afero.ReadFile(fs, "/worlds/w1/manifest.json")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := json.Unmarshal(b, &manifest); err != nil {
+					t.Fatal(err)
+				}
+				if manifest.UploadId != uploadId || manifest.Size != int64(len(data)) || manifest.Checksum != sha256Hex(data) {
+					t.Errorf("manifest %+v", manifest)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+	"github.com/gorilla/mux"
+)
+
+type PreflightStatus string
+
+const (
+	PreflightPassed  PreflightStatus = "passed"
+	PreflightFailed  PreflightStatus = "failed"
+	PreflightSkipped PreflightStatus = "skipped"
+)
+
+// PreflightCheck is the outcome of a single check of PreflightFinish, Detail explains failed and skipped checks.
+type PreflightCheck struct {
+	Name   string          `json:"name"`
+	Status PreflightStatus `json:"status"`
+	Detail string          `json:"detail,omitempty"`
+}
+
+// FinishPreflight reports whether an upload is ready to be finished, Ready is set when no check failed.
+type FinishPreflight struct {
+	UploadId string           `json:"upload_id"`
+	Ready    bool             `json:"ready"`
+	Checks   []PreflightCheck `json:"checks"`
+}
+
+func (p *FinishPreflight) add(name string, status PreflightStatus, format string, args ...interface{}) {
+	check := PreflightCheck{Name: name, Status: status}
+	if format != "" {
+		check.Detail = fmt.Sprintf(format, args...)
+	}
+	p.Checks = append(p.Checks, check)
+	if status == PreflightFailed {
+		p.Ready = false
+	}
+}
+
+// PreflightFinish checks whether FinishUpload of a given upload would succeed without changing the upload, so a dry
+// run never affects a later finish. It checks the state, that every byte of the declared size was written, the size
+// limits, the signature requirement and a destination below the destination root when one is given, chunks still
+// held by write coalescing count as written. The checksum is only compared when it can be computed without reading
+// the whole file, which is the case for sha256-tree checksums with WithTreeHashLeaves, otherwise the check is skipped.
+// An empty expected checksum skips it too, and the signature is read from the context like by FinishUpload.
+func (c *ChunkedUploaderService) PreflightFinish(ctx context.Context, uploadId string, expectedChecksum string, algorithm utils.ChecksumAlgorithm, destination string) (*FinishPreflight, error) {
+	if algorithm == "" {
+		algorithm = c.checksumAlgorithm
+	}
+	if !c.supportsAlgorithm(algorithm) {
+		return nil, fmt.Errorf("ChunkedUploaderService.PreflightFinish unsupported checksum algorithm %q", algorithm)
+	}
+
+	meta, err := c.readMetadata(uploadId)
+	if err != nil && !errors.Is(err, UploadNotFoundError) {
+		return nil, fmt.Errorf("ChunkedUploaderService.PreflightFinish failed to read metadata %w", err)
+	}
+
+	info, err := c.fs.Stat(c.getUploadFilePath(uploadId))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("ChunkedUploaderService.PreflightFinish %w", UploadNotFoundError)
+		}
+		return nil, fmt.Errorf("ChunkedUploaderService.PreflightFinish failed to stat file %w", err)
+	}
+
+	report := &FinishPreflight{UploadId: uploadId, Ready: true}
+
+	size := info.Size()
+	var regions []ByteRange
+	if meta != nil {
+		regions = append(regions, meta.Regions...)
+	}
+	if buffered, ok := c.bufferedRegion(uploadId); ok {
+		regions = addRegion(regions, buffered)
+		if buffered.End+1 > size {
+			size = buffered.End + 1
+		}
+	}
+
+	c.preflightState(report, meta)
+
+	switch {
+	case meta == nil:
+		report.add("coverage", PreflightSkipped, "upload has no metadata")
+		report.add("size", PreflightSkipped, "upload has no metadata")
+	case meta.FileSize < 0:
+		report.add("coverage", PreflightSkipped, "upload has no declared size")
+		report.add("size", PreflightSkipped, "upload has no declared size")
+	default:
+		missing := missingRegions(regions, meta.FileSize)
+		if len(missing) > 0 {
+			report.add("coverage", PreflightFailed, "%d bytes are missing, the first at %d", regionsLength(missing), missing[0].Start)
+		} else {
+			report.add("coverage", PreflightPassed, "")
+		}
+		if size != meta.FileSize {
+			report.add("size", PreflightFailed, "file has %d bytes, declared %d", size, meta.FileSize)
+		} else {
+			report.add("size", PreflightPassed, "")
+		}
+	}
+
+	limit := int64(0)
+	if c.maxFileSize != nil {
+		limit = *c.maxFileSize
+	}
+	if meta != nil && meta.Policy != nil && meta.Policy.MaxFileSize > 0 && (limit == 0 || meta.Policy.MaxFileSize < limit) {
+		limit = meta.Policy.MaxFileSize
+	}
+	if limit > 0 && size > limit {
+		report.add("quota", PreflightFailed, "file has %d bytes, the limit is %d", size, limit)
+	} else {
+		report.add("quota", PreflightPassed, "")
+	}
+
+	signature, ok := signatureFrom(ctx)
+	switch {
+	case c.signatureVerifier == nil && ok:
+		report.add("signature", PreflightFailed, "%s", SignaturesDisabledError)
+	case c.signatureVerifier == nil:
+		report.add("signature", PreflightSkipped, "signatures are not enabled")
+	case !ok || len(signature.Signature) == 0:
+		report.add("signature", PreflightFailed, "signature is required")
+	default:
+		// verifying the signature reads the whole file
+		report.add("signature", PreflightSkipped, "signature is only verified by the finish")
+	}
+
+	if destination != "" {
+		c.preflightDestination(report, destination)
+	}
+
+	err = c.preflightChecksum(ctx, report, meta, uploadId, expectedChecksum, algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.PreflightFinish %w", err)
+	}
+
+	return report, nil
+}
+
+// preflightState checks that the upload is still uploading and within its deadlines.
+func (c *ChunkedUploaderService) preflightState(report *FinishPreflight, meta *UploadMetadata) {
+	if meta == nil {
+		report.add("state", PreflightPassed, "upload has no metadata")
+		return
+	}
+
+	if meta.State != UploadStateUploading {
+		report.add("state", PreflightFailed, "upload is %s", meta.State)
+		return
+	}
+	if err := meta.expired(); err != nil {
+		report.add("state", PreflightFailed, "%s", err)
+		return
+	}
+	if err := c.exceededDuration(meta); err != nil {
+		report.add("state", PreflightFailed, "%s", err)
+		return
+	}
+
+	report.add("state", PreflightPassed, "")
+}
+
+// preflightDestination checks that the file can be moved to a given destination.
+func (c *ChunkedUploaderService) preflightDestination(report *FinishPreflight, destination string) {
+	path, err := c.confineDestination(destination)
+	if err != nil {
+		report.add("destination", PreflightFailed, "%s", err)
+		return
+	}
+
+	info, err := c.fs.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		report.add("destination", PreflightFailed, "%s is a directory", destination)
+	case err == nil:
+		report.add("destination", PreflightPassed, "existing file is replaced")
+	case errors.Is(err, fs.ErrNotExist):
+		report.add("destination", PreflightPassed, "")
+	default:
+		report.add("destination", PreflightFailed, "failed to stat destination: %s", err)
+	}
+}
+
+// preflightChecksum compares the checksum when the recorded tree hash leaves cover every full leaf of the file, so
+// only the last partial leaf is read.
+func (c *ChunkedUploaderService) preflightChecksum(ctx context.Context, report *FinishPreflight, meta *UploadMetadata, uploadId string, expectedChecksum string, algorithm utils.ChecksumAlgorithm) error {
+	if expectedChecksum == "" {
+		report.add("checksum", PreflightSkipped, "no checksum given")
+		return nil
+	}
+	if algorithm != utils.ChecksumSHA256Tree || !c.treeLeaves || meta == nil || c.hasWriteBuffer(uploadId) {
+		report.add("checksum", PreflightSkipped, "verifying the checksum requires reading the whole file")
+		return nil
+	}
+
+	info, err := c.fs.Stat(c.getUploadFilePath(uploadId))
+	if err != nil {
+		return fmt.Errorf("failed to stat file %w", err)
+	}
+	full := info.Size() / utils.TreeHashLeafSize
+	if int64(len(meta.TreeLeaves)) < full {
+		report.add("checksum", PreflightSkipped, "tree hash leaves are not recorded for the whole file")
+		return nil
+	}
+	for _, leaf := range meta.TreeLeaves[:full] {
+		if leaf == "" {
+			report.add("checksum", PreflightSkipped, "tree hash leaves are not recorded for the whole file")
+			return nil
+		}
+	}
+
+	checksum, err := c.computeTreeHash(ctx, uploadId)
+	if err != nil {
+		return fmt.Errorf("failed to compute checksum %w", err)
+	}
+	if checksum != expectedChecksum {
+		report.add("checksum", PreflightFailed, "expected %s, got %s", expectedChecksum, checksum)
+	} else {
+		report.add("checksum", PreflightPassed, "")
+	}
+
+	return nil
+}
+
+// preflightFinish answers FinishUploadHandler with dry_run=true, the body is optional and the destination query
+// parameter is checked like the destination of a batch finish.
+func (c *ChunkedUploaderHandler) preflightFinish(w http.ResponseWriter, r *http.Request) {
+	uploadId := mux.Vars(r)["upload_id"]
+
+	var req FinishUploadRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.Algorithm != "" && !c.service.supportsAlgorithm(req.Algorithm) {
+		writeJSONError(w, http.StatusBadRequest, "unsupported checksum algorithm")
+		return
+	}
+
+	ctx := r.Context()
+	if len(req.Signature) > 0 || req.KeyId != "" {
+		ctx = WithSignature(ctx, UploadSignature{KeyId: req.KeyId, Signature: req.Signature})
+	}
+
+	report, err := c.service.PreflightFinish(ctx, uploadId, req.Checksum, req.Algorithm, r.URL.Query().Get("destination"))
+	if err != nil {
+		if errors.Is(err, UploadNotFoundError) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to check upload: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
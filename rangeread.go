@@ -0,0 +1,131 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+var RangeNotCommittedError = errors.New("range is not fully committed")
+var RangeTooLargeError = errors.New("range is too large")
+
+const defaultMaxRangeRead = 4 << 20
+
+// WithMaxRangeRead caps the number of bytes ReadRange returns at once, it defaults to 4MB.
+func WithMaxRangeRead(maxBytes int64) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.maxRangeRead = maxBytes
+	}
+}
+
+// ReadRange reads length bytes starting at start from the pending file of an upload. The range must lie within a
+// single committed region, data which is still buffered or not written yet is never returned.
+func (c *ChunkedUploaderService) ReadRange(ctx context.Context, uploadId string, start int64, length int64) ([]byte, error) {
+	if start < 0 || length <= 0 {
+		return nil, fmt.Errorf("ChunkedUploaderService.ReadRange invalid range")
+	}
+	if length > c.maxRangeRead {
+		return nil, fmt.Errorf("ChunkedUploaderService.ReadRange %w: at most %d bytes", RangeTooLargeError, c.maxRangeRead)
+	}
+
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.ReadRange failed to read metadata %w", err)
+	}
+
+	if !regionsCover(meta.Regions, ByteRange{Start: start, End: start + length - 1}) {
+		return nil, fmt.Errorf("ChunkedUploaderService.ReadRange %w", RangeNotCommittedError)
+	}
+
+	file, err := c.fs.Open(c.getUploadFilePath(uploadId))
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.ReadRange failed to open pending file %w", err)
+	}
+	defer file.Close()
+
+	data := make([]byte, length)
+	_, err = file.ReadAt(data, start)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("ChunkedUploaderService.ReadRange failed to read pending file %w", err)
+	}
+
+	return data, nil
+}
+
+// regionsCover reports whether a range lies within one of sorted, non-overlapping regions.
+func regionsCover(regions []ByteRange, r ByteRange) bool {
+	for _, region := range regions {
+		if region.Start <= r.Start && region.End >= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadRangeHandler returns a committed byte range of the pending file of a given uploadId, requested with a
+// "Range: bytes=start-end" header. Ranges which are not fully committed get 416 with the committed regions.
+func (c *ChunkedUploaderHandler) ReadRangeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	start, end, err := parseRangeHeader(r.Header.Get("Range"))
+	if err != nil || end == -1 {
+		writeJSONError(w, http.StatusBadRequest, "Range header with a closed bytes range is required")
+		return
+	}
+
+	meta, err := c.service.GetMetadata(r.Context(), uploadId)
+	if err != nil {
+		if errors.Is(err, UploadNotFoundError) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to read metadata: "+err.Error())
+		return
+	}
+
+	if !c.requireOwner(w, r, meta) {
+		return
+	}
+
+	data, err := c.service.ReadRange(r.Context(), uploadId, start, end-start+1)
+	if err != nil {
+		switch {
+		case errors.Is(err, RangeNotCommittedError):
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   err.Error(),
+				"regions": meta.Regions,
+			})
+		case errors.Is(err, RangeTooLargeError):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to read range: "+err.Error())
+		}
+		return
+	}
+
+	total := "*"
+	if meta.FileSize > 0 {
+		total = strconv.FormatInt(meta.FileSize, 10)
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", start, end, total))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(data)
+}
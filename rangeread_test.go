@@ -0,0 +1,89 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestReadRangeHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		owner       string
+		rangeHeader string
+		maxBytes    int64
+		wantCode    int
+		// wantStart and wantEnd are the bytes of the upload expected in a 206 response
+		wantStart, wantEnd int
+	}{
+		{name: "committed range", owner: "alice", rangeHeader: "bytes=10-29", wantCode: http.StatusPartialContent, wantStart: 10, wantEnd: 29},
+		{name: "whole region", owner: "alice", rangeHeader: "bytes=0-39", wantCode: http.StatusPartialContent, wantStart: 0, wantEnd: 39},
+		{name: "second region", owner: "alice", rangeHeader: "bytes=60-79", wantCode: http.StatusPartialContent, wantStart: 60, wantEnd: 79},
+		{name: "one byte past a region", owner: "alice", rangeHeader: "bytes=30-40", wantCode: http.StatusRequestedRangeNotSatisfiable},
+		{name: "across a gap", owner: "alice", rangeHeader: "bytes=30-65", wantCode: http.StatusRequestedRangeNotSatisfiable},
+		{name: "not written", owner: "alice", rangeHeader: "bytes=85-99", wantCode: http.StatusRequestedRangeNotSatisfiable},
+		{name: "other caller", owner: "bob", rangeHeader: "bytes=10-29", wantCode: http.StatusForbidden},
+		{name: "at the cap", owner: "alice", rangeHeader: "bytes=0-15", maxBytes: 16, wantCode: http.StatusPartialContent, wantStart: 0, wantEnd: 15},
+		{name: "over the cap", owner: "alice", rangeHeader: "bytes=0-16", maxBytes: 16, wantCode: http.StatusBadRequest},
+		{name: "open range", owner: "alice", rangeHeader: "bytes=10-", wantCode: http.StatusBadRequest},
+		{name: "no range", owner: "alice", wantCode: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []ChunkedUploaderServiceOption
+			if tc.maxBytes > 0 {
+				opts = append(opts, WithMaxRangeRead(tc.maxBytes))
+			}
+			service := newTestService(afero.NewMemMapFs(), opts...)
+			handler := NewHTTPHandler(service, WithOwner(func(r *http.Request) string {
+				return r.Header.Get("X-Owner")
+			}))
+
+			data := randomBytes(t, 100)
+			uploadId, err := service.CreateUpload(int64(len(data)), WithUploadOwner("alice"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, region := range []ByteRange{{Start: 0, End: 39}, {Start: 60, End: 79}} {
+				if _, err := service.UploadChunk(uploadId, bytes.NewReader(data[region.Start:region.End+1]), region.Start); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/"+uploadId+"/data", nil)
+			req.Header.Set("X-Owner", tc.owner)
+			if tc.rangeHeader != "" {
+				req.Header.Set("Range", tc.rangeHeader)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tc.wantCode {
+				t.Fatalf("%s: %d %s, want %d", tc.rangeHeader, rec.Code, rec.Body, tc.wantCode)
+			}
+
+			switch rec.Code {
+			case http.StatusPartialContent:
+				if got := rec.Body.Bytes(); !bytes.Equal(got, data[tc.wantStart:tc.wantEnd+1]) {
+					t.Errorf("body: %d bytes, want bytes %d-%d of the upload", len(got), tc.wantStart, tc.wantEnd)
+				}
+				if got, want := rec.Header().Get("Content-Range"), tc.rangeHeader[len("bytes="):]+"/100"; got != "bytes "+want {
+					t.Errorf("Content-Range: got %q, want %q", got, "bytes "+want)
+				}
+			case http.StatusRequestedRangeNotSatisfiable:
+				var resp struct {
+					Regions []ByteRange `json:"regions"`
+				}
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				want := []ByteRange{{Start: 0, End: 39}, {Start: 60, End: 79}}
+				if len(resp.Regions) != len(want) || resp.Regions[0] != want[0] || resp.Regions[1] != want[1] {
+					t.Errorf("regions: got %v, want %v", resp.Regions, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package chunkeduploader
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRateLimitBuckets is the number of per-IP buckets kept before the full ones are dropped.
+const maxRateLimitBuckets = 10000
+
+// RateLimiter limits the request rate of a handler with a token bucket, either one shared by all clients or one per
+// client IP. Every response carries the X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers of the
+// bucket, so clients can slow down before they get 429.
+type RateLimiter struct {
+	rps   float64
+	burst float64
+	perIP bool
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// NewRateLimiter returns a limiter allowing rps requests per second with bursts of up to burst requests.
+func NewRateLimiter(rps float64, burst int, perIP bool) *RateLimiter {
+	if rps <= 0 || burst < 1 {
+		panic("chunkeduploader: rate limiter needs a positive rate and burst")
+	}
+	return &RateLimiter{rps: rps, burst: float64(burst), perIP: perIP, buckets: map[string]*tokenBucket{}}
+}
+
+// take refills the bucket of a given key and takes a token from it if there is one. It returns the tokens left.
+func (l *RateLimiter) take(key string, now time.Time) (allowed bool, tokens float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitBuckets {
+			l.dropFullBuckets(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, at: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.at).Seconds()*l.rps)
+	bucket.at = now
+
+	if bucket.tokens < 1 {
+		return false, bucket.tokens
+	}
+	bucket.tokens--
+	return true, bucket.tokens
+}
+
+// dropFullBuckets forgets the clients whose buckets refilled, they start with a full bucket anyway.
+func (l *RateLimiter) dropFullBuckets(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.at).Seconds()*l.rps >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Middleware wraps a handler with the limiter, requests over the limit get 429 with a Retry-After header.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := ""
+		if l.perIP {
+			key = clientIP(r)
+		}
+
+		now := time.Now()
+		allowed, tokens := l.take(key, now)
+
+		refill := time.Duration((l.burst - tokens) / l.rps * float64(time.Second))
+		w.Header().Set("X-RateLimit-Limit", strconv.FormatFloat(l.rps, 'f', -1, 64))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(math.Floor(tokens))))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(float64(now.Add(refill).UnixNano())/1e9)), 10))
+
+		if !allowed {
+			retryAfter := math.Ceil((1 - tokens) / l.rps)
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+			writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,77 @@
+package chunkeduploader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterHeaders(t *testing.T) {
+	type step struct {
+		ip            string
+		wantCode      int
+		wantRemaining int
+	}
+
+	for _, tc := range []struct {
+		name  string
+		perIP bool
+		steps []step
+	}{
+		{name: "shared", steps: []step{
+			{"10.0.0.1", http.StatusOK, 2},
+			{"10.0.0.2", http.StatusOK, 1},
+			{"10.0.0.1", http.StatusOK, 0},
+			{"10.0.0.2", http.StatusTooManyRequests, 0},
+		}},
+		{name: "per ip", perIP: true, steps: []step{
+			{"10.0.0.1", http.StatusOK, 2},
+			{"10.0.0.1", http.StatusOK, 1},
+			{"10.0.0.2", http.StatusOK, 2},
+			{"10.0.0.1", http.StatusOK, 0},
+			{"10.0.0.1", http.StatusTooManyRequests, 0},
+			{"10.0.0.2", http.StatusOK, 1},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// the bucket refills so slowly that it stays put during the test
+			limiter := NewRateLimiter(0.01, 3, tc.perIP)
+			handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			for i, step := range tc.steps {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = step.ip + ":1234"
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				if rec.Code != step.wantCode {
+					t.Fatalf("request %d from %s: %d, want %d", i, step.ip, rec.Code, step.wantCode)
+				}
+				if got := rec.Header().Get("X-RateLimit-Limit"); got != "0.01" {
+					t.Errorf("request %d: X-RateLimit-Limit %q, want 0.01", i, got)
+				}
+				if got := rec.Header().Get("X-RateLimit-Remaining"); got != strconv.Itoa(step.wantRemaining) {
+					t.Errorf("request %d: X-RateLimit-Remaining %q, want %d", i, got, step.wantRemaining)
+				}
+				reset, err := strconv.ParseInt(rec.Header().Get("X-RateLimit-Reset"), 10, 64)
+				if err != nil || reset < time.Now().Unix() {
+					t.Errorf("request %d: X-RateLimit-Reset %q, want a time to come", i, rec.Header().Get("X-RateLimit-Reset"))
+				}
+
+				retryAfter := rec.Header().Get("Retry-After")
+				if step.wantCode == http.StatusTooManyRequests {
+					// a token takes 100 seconds to refill
+					if seconds, err := strconv.Atoi(retryAfter); err != nil || seconds < 1 || seconds > 100 {
+						t.Errorf("request %d: Retry-After %q, want 1 to 100 seconds", i, retryAfter)
+					}
+				} else if retryAfter != "" {
+					t.Errorf("request %d: Retry-After %q on an allowed request", i, retryAfter)
+				}
+			}
+		})
+	}
+}
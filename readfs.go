@@ -0,0 +1,21 @@
+package chunkeduploader
+
+import "github.com/spf13/afero"
+
+// WithReadOnlyFs serves finished files from a separate view of the storage, like afero.NewReadOnlyFs over the main
+// filesystem or a read replica mount. OpenUploadedFile, exports and bundles read the files through it, so serving them
+// cannot modify them. Uploads, verification and scanners keep using the main filesystem, and metadata is always read
+// from it. The files must be found at the same paths on both and Rebind does not change this view.
+func WithReadOnlyFs(fs afero.Fs) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.readFs = fs
+	}
+}
+
+// servingFs returns the filesystem finished files are served from.
+func (c *ChunkedUploaderService) servingFs() afero.Fs {
+	if c.readFs != nil {
+		return c.readFs
+	}
+	return c.fs
+}
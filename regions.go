@@ -0,0 +1,160 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ByteRange is a range of bytes, both Start and End are inclusive.
+type ByteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+func (r ByteRange) Length() int64 {
+	return r.End - r.Start + 1
+}
+
+// addWrittenRegion records a region written to the pending file of a given upload and the tree hash leaves written
+// with it in its metadata.
+func (c *ChunkedUploaderService) addWrittenRegion(uploadId string, region ByteRange, leaves map[int64]string) error {
+	err := c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		meta.recordWrite(region, time.Now())
+		meta.Regions = addRegion(meta.Regions, region)
+		meta.invalidateChecksum()
+		if c.treeLeaves {
+			meta.TreeLeaves = addTreeLeaves(meta.TreeLeaves, region, leaves)
+		}
+		if err := c.limitRegions(meta); err != nil {
+			return err
+		}
+		c.setWritten(uploadId, regionsLength(meta.Regions))
+		return nil
+	})
+	if errors.Is(err, UploadNotFoundError) {
+		// uploads created before metadata was introduced are not tracked
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.addWrittenRegion failed to update metadata %w", err)
+	}
+
+	return nil
+}
+
+// GetWrittenRegions returns the sorted, non-overlapping regions written to the pending file of a given upload.
+func (c *ChunkedUploaderService) GetWrittenRegions(ctx context.Context, uploadId string) ([]ByteRange, error) {
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetWrittenRegions failed to read metadata %w", err)
+	}
+
+	return meta.Regions, nil
+}
+
+type GetRegionsResponse struct {
+	Regions    []ByteRange `json:"regions"`
+	Missing    []ByteRange `json:"missing"`
+	TotalBytes int64       `json:"total_bytes"`
+}
+
+// GetRegionsHandler returns the regions already written to a given uploadId and the regions still missing.
+func (c *ChunkedUploaderHandler) GetRegionsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	meta, err := c.service.readMetadata(uploadId)
+	if err != nil {
+		if errors.Is(err, UploadNotFoundError) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get regions: "+err.Error())
+		return
+	}
+
+	totalBytes := meta.FileSize
+	if totalBytes < 0 {
+		totalBytes = 0
+		if len(meta.Regions) > 0 {
+			totalBytes = meta.Regions[len(meta.Regions)-1].End + 1
+		}
+	}
+
+	response := GetRegionsResponse{
+		Regions:    meta.Regions,
+		Missing:    missingRegions(meta.Regions, totalBytes),
+		TotalBytes: totalBytes,
+	}
+	if response.Regions == nil {
+		response.Regions = []ByteRange{}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// addRegion merges a region into sorted, non-overlapping regions, adjacent regions are joined.
+func addRegion(regions []ByteRange, region ByteRange) []ByteRange {
+	regions = append(regions, region)
+	sort.Slice(regions, func(i, j int) bool {
+		return regions[i].Start < regions[j].Start
+	})
+
+	merged := regions[:1]
+	for _, r := range regions[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start <= last.End+1 {
+			if r.End > last.End {
+				last.End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// regionsLength returns the number of bytes covered by non-overlapping regions.
+func regionsLength(regions []ByteRange) int64 {
+	var length int64
+	for _, r := range regions {
+		length += r.Length()
+	}
+	return length
+}
+
+// missingRegions returns the complement of sorted, non-overlapping regions within [0, totalBytes).
+func missingRegions(regions []ByteRange, totalBytes int64) []ByteRange {
+	missing := []ByteRange{}
+
+	var next int64
+	for _, r := range regions {
+		if r.Start >= totalBytes {
+			break
+		}
+		if r.Start > next {
+			missing = append(missing, ByteRange{Start: next, End: r.Start - 1})
+		}
+		next = r.End + 1
+	}
+
+	if next < totalBytes {
+		missing = append(missing, ByteRange{Start: next, End: totalBytes - 1})
+	}
+
+	return missing
+}
@@ -0,0 +1,206 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+var InsufficientStorageError = errors.New("insufficient storage")
+
+// WithDiskReservations makes CreateUpload reserve the declared file size up front, so concurrent uploads cannot
+// together promise more space than the disk has. freeSpace reports the bytes currently free on the disk and reserve
+// is the number of bytes which is never handed out to uploads.
+func WithDiskReservations(freeSpace func() (int64, error), reserve int64) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.reservations = &diskReservations{
+			freeSpace: freeSpace,
+			reserve:   reserve,
+		}
+	}
+}
+
+// diskReservations is a ledger of the space promised to unfinished uploads. A reservation shrinks as the upload
+// writes data, because written bytes are already missing from the free space.
+type diskReservations struct {
+	freeSpace func() (int64, error)
+	reserve   int64
+
+	mu      sync.Mutex
+	loaded  bool
+	uploads map[string]*reservation
+}
+
+type reservation struct {
+	declared int64
+	written  int64
+}
+
+func (r *reservation) outstanding() int64 {
+	if r.written >= r.declared {
+		return 0
+	}
+	return r.declared - r.written
+}
+
+// Reservation is the space still reserved for a single upload.
+type Reservation struct {
+	UploadId string `json:"upload_id"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// Usage describes the disk space of the service.
+type Usage struct {
+	FreeBytes     int64         `json:"free_bytes"`
+	ReserveBytes  int64         `json:"reserve_bytes"`
+	ReservedBytes int64         `json:"reserved_bytes"`
+	Reservations  []Reservation `json:"reservations"`
+}
+
+// loadReservations reconstructs the ledger from the metadata of unfinished uploads, it is done once before the first use so
+// reservations survive a restart. It must be called with the ledger locked.
+func (c *ChunkedUploaderService) loadReservations() error {
+	r := c.reservations
+	if r.loaded {
+		return nil
+	}
+
+	uploads := make(map[string]*reservation)
+	err := c.walkMetadata(func(meta *UploadMetadata) error {
+		if meta.State != UploadStateUploading || meta.FileSize <= 0 {
+			return nil
+		}
+
+		uploads[meta.UploadId] = &reservation{
+			declared: meta.FileSize,
+			written:  regionsLength(meta.Regions),
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load reservations %w", err)
+	}
+
+	r.uploads = uploads
+	r.loaded = true
+	return nil
+}
+
+// reserveSpace reserves size bytes for a given upload, it returns InsufficientStorageError if they are not available.
+func (c *ChunkedUploaderService) reserveSpace(uploadId string, size int64) error {
+	r := c.reservations
+	if r == nil || size <= 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := c.loadReservations()
+	if err != nil {
+		return err
+	}
+
+	free, err := r.freeSpace()
+	if err != nil {
+		return fmt.Errorf("failed to get free space %w", err)
+	}
+
+	available := free - r.reserve - r.reserved()
+	if size > available {
+		return fmt.Errorf("%w: requested %d bytes, available %d bytes", InsufficientStorageError, size, available)
+	}
+
+	r.uploads[uploadId] = &reservation{declared: size}
+	return nil
+}
+
+// reserved returns the sum of outstanding reservations, it must be called with the ledger locked.
+func (r *diskReservations) reserved() int64 {
+	var total int64
+	for _, upload := range r.uploads {
+		total += upload.outstanding()
+	}
+	return total
+}
+
+// setWritten updates the number of bytes a given upload has written, which are no longer reserved.
+func (c *ChunkedUploaderService) setWritten(uploadId string, written int64) {
+	r := c.reservations
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if upload, ok := r.uploads[uploadId]; ok {
+		upload.written = written
+	}
+}
+
+// releaseSpace drops the reservation of a given upload.
+func (c *ChunkedUploaderService) releaseSpace(uploadId string) {
+	r := c.reservations
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.uploads, uploadId)
+}
+
+// GetUsage returns the free disk space and the space reserved for unfinished uploads. It requires disk reservations
+// to be enabled.
+func (c *ChunkedUploaderService) GetUsage(ctx context.Context) (*Usage, error) {
+	r := c.reservations
+	if r == nil {
+		return nil, errors.New("ChunkedUploaderService.GetUsage disk reservations are not enabled")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := c.loadReservations()
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetUsage %w", err)
+	}
+
+	free, err := r.freeSpace()
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetUsage failed to get free space %w", err)
+	}
+
+	usage := &Usage{
+		FreeBytes:     free,
+		ReserveBytes:  r.reserve,
+		ReservedBytes: r.reserved(),
+		Reservations:  []Reservation{},
+	}
+	for uploadId, upload := range r.uploads {
+		usage.Reservations = append(usage.Reservations, Reservation{UploadId: uploadId, Bytes: upload.outstanding()})
+	}
+	sort.Slice(usage.Reservations, func(i, j int) bool {
+		return usage.Reservations[i].UploadId < usage.Reservations[j].UploadId
+	})
+
+	return usage, nil
+}
+
+// UsageHandler returns the free disk space and the space reserved for unfinished uploads.
+func (c *ChunkedUploaderHandler) UsageHandler(w http.ResponseWriter, r *http.Request) {
+	usage, err := c.service.GetUsage(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get usage: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(usage)
+}
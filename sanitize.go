@@ -0,0 +1,185 @@
+package chunkeduploader
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxContentTypeLength = 255
+	maxFingerprintLength = 128
+	// maxUserMetadataSize caps the serialized size of the user provided fields of an upload together, so the tags of
+	// a single upload cannot bloat its metadata file even when every tag is within its own limit.
+	maxUserMetadataSize = 16 << 10
+)
+
+// MetadataFieldError is a violation of a single user provided metadata field. Tags are reported as "tags.<key>".
+type MetadataFieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// MetadataValidationError lists the user provided metadata fields violating the limits, it wraps
+// InvalidMetadataError.
+type MetadataValidationError struct {
+	Fields []MetadataFieldError
+}
+
+func (e *MetadataValidationError) Error() string {
+	reasons := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		reasons = append(reasons, field.Field+" "+field.Reason)
+	}
+	return fmt.Sprintf("%s: %s", InvalidMetadataError, strings.Join(reasons, ", "))
+}
+
+func (e *MetadataValidationError) Unwrap() error {
+	return InvalidMetadataError
+}
+
+func (e *MetadataValidationError) add(field string, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, MetadataFieldError{Field: field, Reason: fmt.Sprintf(format, args...)})
+}
+
+// stripControl removes the control characters, like null bytes and the escape starting terminal sequences, so a
+// field is safe to log or to echo in a header.
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// sanitizeField strips the control characters from a field, it reports a field which is not valid UTF-8 or longer
+// than limit bytes.
+func (e *MetadataValidationError) sanitizeField(field string, value string, limit int) (string, bool) {
+	if !utf8.ValidString(value) {
+		e.add(field, "must be valid UTF-8")
+		return value, false
+	}
+	value = stripControl(value)
+	if len(value) > limit {
+		e.add(field, "is longer than %d bytes", limit)
+		return value, false
+	}
+	return value, true
+}
+
+// sanitize strips the control characters from the user provided fields of the metadata, which are the filename,
+// content type, tags and fingerprint, and checks them together with the opaque client context against the limits.
+// Every violation is reported in a MetadataValidationError, a tag with a protected key fails with
+// ProtectedMetadataKeyError. The metadata of every upload passes through it, whether it comes from a request or from a
+// direct caller of the service.
+func (m *UploadMetadata) sanitize() error {
+	invalid := &MetadataValidationError{}
+
+	if filename, ok := invalid.sanitizeField("filename", m.Filename, maxFilenameLength); ok {
+		m.Filename = filename
+		if strings.ContainsAny(filename, "/\\") || filename == "." || filename == ".." {
+			invalid.add("filename", "must not contain path separators")
+		}
+	}
+
+	if contentType, ok := invalid.sanitizeField("content_type", m.ContentType, maxContentTypeLength); ok {
+		m.ContentType = contentType
+		if contentType != "" {
+			if _, _, err := mime.ParseMediaType(contentType); err != nil {
+				invalid.add("content_type", "is not a valid media type")
+			}
+		}
+	}
+
+	if len(m.Tags) > maxTags {
+		invalid.add("tags", "has more than %d tags", maxTags)
+	} else if len(m.Tags) > 0 {
+		keys := make([]string, 0, len(m.Tags))
+		for key := range m.Tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		tags := make(map[string]string, len(m.Tags))
+		for _, key := range keys {
+			sanitized, ok := invalid.sanitizeField("tags", key, maxTagLength)
+			if !ok {
+				continue
+			}
+			if sanitized == "" {
+				invalid.add("tags", "must not have empty keys")
+				continue
+			}
+			if protectedMetadataKeys[sanitized] {
+				return fmt.Errorf("%w: %s", ProtectedMetadataKeyError, sanitized)
+			}
+			if _, ok := tags[sanitized]; ok {
+				invalid.add("tags."+sanitized, "is given more than once")
+				continue
+			}
+			value, ok := invalid.sanitizeField("tags."+sanitized, m.Tags[key], maxTagLength)
+			if ok {
+				tags[sanitized] = value
+			}
+		}
+		m.Tags = tags
+	}
+
+	// the client context is opaque, it is only stripped of control characters where it is logged
+	if !utf8.ValidString(m.ClientContext) {
+		invalid.add("client_context", "must be valid UTF-8")
+	} else if len(m.ClientContext) > maxClientContextLength {
+		invalid.add("client_context", "is longer than %d bytes", maxClientContextLength)
+	}
+
+	if len(m.Fingerprint) > maxFingerprintLength {
+		invalid.add("fingerprint", "is longer than %d bytes", maxFingerprintLength)
+	} else if _, err := hex.DecodeString(m.Fingerprint); err != nil {
+		invalid.add("fingerprint", "must be a hex string")
+	}
+
+	if len(invalid.Fields) > 0 {
+		return invalid
+	}
+
+	encoded, err := json.Marshal(struct {
+		Filename      string            `json:"filename"`
+		ContentType   string            `json:"content_type"`
+		Tags          map[string]string `json:"tags"`
+		Fingerprint   string            `json:"fingerprint"`
+		ClientContext string            `json:"client_context"`
+	}{m.Filename, m.ContentType, m.Tags, m.Fingerprint, m.ClientContext})
+	if err != nil {
+		return fmt.Errorf("%w: %s", InvalidMetadataError, err)
+	}
+	if len(encoded) > maxUserMetadataSize {
+		invalid.add("metadata", "is larger than %d bytes", maxUserMetadataSize)
+		return invalid
+	}
+
+	return nil
+}
+
+// writeMetadataError responds to invalid metadata with 400, listing the violating fields when they are known.
+func writeMetadataError(w http.ResponseWriter, err error) {
+	var invalid *MetadataValidationError
+	if !errors.As(err, &invalid) {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  err.Error(),
+		"code":   "invalid_metadata",
+		"fields": invalid.Fields,
+	})
+}
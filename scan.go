@@ -0,0 +1,197 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+	"github.com/gorilla/mux"
+)
+
+var ScanFailedError = errors.New("upload failed post-processing")
+var UploadNotFailedError = errors.New("upload is not failed")
+
+const (
+	failureReasonScanFailed       = "scan_failed"
+	failureReasonChecksumMismatch = "post_reprocess_checksum_mismatch"
+)
+
+// ScannerFunc post-processes a verified upload, for example scans it for viruses. An error marks the upload as
+// failed.
+type ScannerFunc func(ctx context.Context, uploadId string, file io.Reader) error
+
+// WithScanner adds a scanner which runs after an upload is verified by FinishUpload, scanners run in the order they
+// were added. While they run the upload is in the verifying state.
+func WithScanner(scanner ScannerFunc) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.scanners = append(c.scanners, scanner)
+	}
+}
+
+// finished reports whether the upload no longer accepts chunks.
+func (m *UploadMetadata) finished() bool {
+	return m.State == UploadStateComplete || m.State == UploadStateVerifying || m.State == UploadStateFailed
+}
+
+// finishedWith reports whether the upload was finished with a given checksum, which is the checksum of the upload
+// before its finalize command if it had one. Uploads finished before the algorithm was recorded used the default.
+func (m *UploadMetadata) finishedWith(checksum string, algorithm utils.ChecksumAlgorithm, defaultAlgorithm utils.ChecksumAlgorithm) bool {
+	finishedAlgorithm := m.ChecksumAlgorithm
+	if finishedAlgorithm == "" {
+		finishedAlgorithm = defaultAlgorithm
+	}
+	if algorithm != finishedAlgorithm {
+		return false
+	}
+	if m.OriginalChecksum != "" {
+		return checksum == m.OriginalChecksum
+	}
+	return checksum == m.Checksum
+}
+
+// setState changes the state of a given upload, uploads without metadata have no state to change.
+func (c *ChunkedUploaderService) setState(uploadId string, state UploadState, reason string) error {
+	err := c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		meta.State = state
+		meta.FailureReason = reason
+		return nil
+	})
+	if err != nil && !errors.Is(err, UploadNotFoundError) {
+		return err
+	}
+	return nil
+}
+
+// scanUpload runs the scanners on a verified upload and moves it to the complete or failed state.
+func (c *ChunkedUploaderService) scanUpload(ctx context.Context, uploadId string) error {
+	scanErr := c.runScanners(ctx, uploadId)
+	if scanErr != nil {
+		c.log(LogLevelWarn, "Upload failed post-processing", LogField{"upload_id", uploadId}, LogField{"error", scanErr})
+
+		err := c.setState(uploadId, UploadStateFailed, failureReasonScanFailed)
+		if err != nil {
+			return fmt.Errorf("failed to update metadata %w", err)
+		}
+		return fmt.Errorf("%w: %s", ScanFailedError, scanErr)
+	}
+
+	err := c.setState(uploadId, UploadStateComplete, "")
+	if err != nil {
+		return fmt.Errorf("failed to update metadata %w", err)
+	}
+
+	return nil
+}
+
+func (c *ChunkedUploaderService) runScanners(ctx context.Context, uploadId string) error {
+	for _, scanner := range c.scanners {
+		file, err := c.openUploadedFile(c.fs, uploadId)
+		if err != nil {
+			return err
+		}
+
+		err = scanner(ctx, uploadId, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReprocessUpload verifies a failed upload again and re-runs the scanners on it, the upload ends up complete or
+// failed again. It is meant for recovering uploads which failed because a scanner was unavailable.
+func (c *ChunkedUploaderService) ReprocessUpload(ctx context.Context, uploadId string) error {
+	var checksum string
+	var algorithm utils.ChecksumAlgorithm
+	err := c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		if meta.State != UploadStateFailed {
+			return UploadNotFailedError
+		}
+		checksum = meta.Checksum
+		algorithm = meta.ChecksumAlgorithm
+		meta.State = UploadStateVerifying
+		meta.FailureReason = ""
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.ReprocessUpload failed to update metadata %w", err)
+	}
+
+	path, err := c.UploadedFilePath(uploadId)
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.ReprocessUpload failed to resolve uploaded file %w", err)
+	}
+
+	if algorithm == "" {
+		algorithm = c.checksumAlgorithm
+	}
+	computed, err := c.computeChecksumWith(ctx, path, algorithm)
+	if err != nil {
+		// leave the upload failed so it can be reprocessed again
+		stateErr := c.setState(uploadId, UploadStateFailed, failureReasonScanFailed)
+		if stateErr != nil {
+			return fmt.Errorf("ChunkedUploaderService.ReprocessUpload failed to update metadata %w", stateErr)
+		}
+		return fmt.Errorf("ChunkedUploaderService.ReprocessUpload failed to compute checksum %w", err)
+	}
+
+	if computed != checksum {
+		err = c.setState(uploadId, UploadStateFailed, failureReasonChecksumMismatch)
+		if err != nil {
+			return fmt.Errorf("ChunkedUploaderService.ReprocessUpload failed to update metadata %w", err)
+		}
+		return fmt.Errorf("ChunkedUploaderService.ReprocessUpload %w - expected: %s, got: %s", FileChecksumMismatchError, checksum, computed)
+	}
+
+	err = c.scanUpload(ctx, uploadId)
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.ReprocessUpload %w", err)
+	}
+
+	return nil
+}
+
+// ReprocessUploadHandler re-runs post-processing of a failed uploadId, it requires admin access.
+func (c *ChunkedUploaderHandler) ReprocessUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.requireAdmin(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	err := c.service.ReprocessUpload(r.Context(), uploadId)
+	if err != nil {
+		switch {
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, UploadNotFailedError):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, ScanFailedError), errors.Is(err, FileChecksumMismatchError):
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to reprocess upload: "+err.Error())
+		}
+		return
+	}
+
+	status, err := c.service.GetUploadStatus(r.Context(), uploadId)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get upload status: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,71 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// newFailedTestUpload creates an upload rejected by a scanner and returns its data.
+func newFailedTestUpload(t *testing.T, service *ChunkedUploaderService) (string, []byte) {
+	t.Helper()
+
+	data := randomBytes(t, 1024)
+	uploadId, err := service.CreateUpload(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.FinishUpload(context.Background(), uploadId, sha256Hex(data)); !errors.Is(err, ScanFailedError) {
+		t.Fatalf("got %v, want ScanFailedError", err)
+	}
+	return uploadId, data
+}
+
+func rejectingScanner(ctx context.Context, uploadId string, file io.Reader) error {
+	return errors.New("infected")
+}
+
+func TestFinishRejectsFailedUpload(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs(), WithScanner(rejectingScanner))
+	uploadId, data := newFailedTestUpload(t, service)
+
+	_, err := service.FinishUpload(context.Background(), uploadId, sha256Hex(data))
+	if !errors.Is(err, UploadAlreadyFinishedError) {
+		t.Errorf("got %v, want UploadAlreadyFinishedError", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/"+uploadId+"/finish", strings.NewReader(`{"checksum": "`+sha256Hex(data)+`"}`))
+	rec := httptest.NewRecorder()
+	NewHTTPHandler(service).ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("finish over HTTP: %d %s, want 409", rec.Code, rec.Body)
+	}
+
+	meta, err := service.readMetadata(uploadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.State != UploadStateFailed || meta.FailureReason != failureReasonScanFailed {
+		t.Errorf("got state %s (%s), want failed by the scanner", meta.State, meta.FailureReason)
+	}
+}
+
+func TestReplaceMetadataOfFailedUpload(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs(), WithScanner(rejectingScanner))
+	uploadId, _ := newFailedTestUpload(t, service)
+
+	err := service.ReplaceMetadata(context.Background(), uploadId, UploadMetadata{Filename: "renamed"})
+	if !errors.Is(err, MetadataLockedError) {
+		t.Errorf("got %v, want MetadataLockedError", err)
+	}
+}
@@ -0,0 +1,307 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultWriteTimeout bounds writing the response of the routes which neither receive nor serve file data.
+	defaultWriteTimeout = 30 * time.Second
+
+	serverReadHeaderTimeout = 10 * time.Second
+	serverIdleTimeout       = 120 * time.Second
+)
+
+// exposedHeaders are the response headers of the API which browsers may read with CORS.
+var exposedHeaders = []string{
+	"X-Checksum", "X-Checksum-Algorithm", "X-Upload-Expires", "X-Max-Parallel-Chunks", "X-Recommended-Chunk-Size", "X-Uploader-Capabilities",
+	"X-Strict-Resume", "X-Append-Sequence", "X-Upload-Generation",
+	"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After",
+	"Content-Disposition", "Content-Range", "ETag",
+}
+
+// WithCORS allows browsers on the given origins to call the handler returned by NewHTTPHandler, "*" allows every
+// origin.
+func WithCORS(origins ...string) ChunkedUploaderHandlerOption {
+	return func(c *ChunkedUploaderHandler) {
+		c.corsOrigins = origins
+	}
+}
+
+// WithRequestLogging makes the handler returned by NewHTTPHandler log every request with the service logger.
+func WithRequestLogging() ChunkedUploaderHandlerOption {
+	return func(c *ChunkedUploaderHandler) {
+		c.logRequests = true
+	}
+}
+
+// WithMetrics makes the handler returned by NewHTTPHandler count the requests by status code and serve them
+// together with the other gauges on /metrics, see MetricsHandler.
+func WithMetrics() ChunkedUploaderHandlerOption {
+	return func(c *ChunkedUploaderHandler) {
+		c.metrics = &httpMetrics{requests: make(map[int]int64)}
+	}
+}
+
+// WithAuthenticator sets the function deciding whether a request may use the handler returned by NewHTTPHandler at
+// all, other requests get 401. The health check is always served.
+func WithAuthenticator(authenticate func(r *http.Request) bool) ChunkedUploaderHandlerOption {
+	return func(c *ChunkedUploaderHandler) {
+		c.authenticate = authenticate
+	}
+}
+
+// WithRateLimiter limits the requests served by the handler returned by NewHTTPHandler.
+func WithRateLimiter(limiter *RateLimiter) ChunkedUploaderHandlerOption {
+	return func(c *ChunkedUploaderHandler) {
+		c.rateLimiter = limiter
+	}
+}
+
+// WithBuildInfo sets the build described on /version, it defaults to ReadBuildInfo.
+func WithBuildInfo(info BuildInfo) ChunkedUploaderHandlerOption {
+	return func(c *ChunkedUploaderHandler) {
+		c.buildInfo = &info
+	}
+}
+
+// httpMetrics counts the served requests by status code.
+type httpMetrics struct {
+	mu       sync.Mutex
+	requests map[int]int64
+}
+
+// NewHTTPHandler returns the whole API of a service with the middlewares enabled by the options. Responses of routes
+// which do not transfer file data get a write deadline, so no server-wide WriteTimeout is needed, see NewServer.
+func NewHTTPHandler(service *ChunkedUploaderService, opts ...ChunkedUploaderHandlerOption) http.Handler {
+	handlers := NewChunkedUploaderHandler(service, opts...)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/health", handlers.HealthHandler).Methods("GET")
+
+	api := r.NewRoute().Subrouter()
+	if handlers.authenticate != nil {
+		api.Use(handlers.authenticationMiddleware)
+	}
+	handlers.RegisterRoutes(api)
+
+	var h http.Handler = r
+	if handlers.rateLimiter != nil {
+		h = handlers.rateLimiter.Middleware(h)
+	}
+	if handlers.logRequests || handlers.metrics != nil {
+		h = handlers.recordingMiddleware(h)
+	}
+	if len(handlers.corsOrigins) > 0 {
+		h = handlers.corsMiddleware(h)
+	}
+	return h
+}
+
+// RegisterRoutes adds all routes of the API to a given router.
+func (c *ChunkedUploaderHandler) RegisterRoutes(r *mux.Router) {
+	buildInfo := ReadBuildInfo()
+	if c.buildInfo != nil {
+		buildInfo = *c.buildInfo
+	}
+
+	route := func(path string, handler http.HandlerFunc, method string) {
+		r.Handle(path, c.uploadTokenRoute(method, path, withWriteTimeout(defaultWriteTimeout, c.compressionMiddleware(handler)))).Methods(method)
+	}
+	// the routes receiving or serving file data and the long running ones are bounded by their handler timeouts
+	streamingRoute := func(path string, handler http.HandlerFunc, method string) {
+		r.Handle(path, c.uploadTokenRoute(method, path, withWriteTimeout(0, handler))).Methods(method)
+	}
+	// listings are long running but only JSON, so unlike file data they are compressed
+	listingRoute := func(path string, handler http.HandlerFunc, method string) {
+		r.Handle(path, c.uploadTokenRoute(method, path, withWriteTimeout(0, c.compressionMiddleware(handler)))).Methods(method)
+	}
+
+	route("/version", c.VersionHandler(buildInfo), "GET")
+	route("/capabilities", c.CapabilitiesHandler, "GET")
+	route("/init", c.CreateUploadHandler, "POST")
+	route("/multi-init", c.MultipartInitHandler, "POST")
+	route("/fingerprint/{fingerprint}", c.CheckFingerprintHandler, "GET")
+	streamingRoute("/batch-finish", c.BatchFinishHandler, "POST")
+	route("/usage", c.UsageHandler, "GET")
+	route("/metrics", c.MetricsHandler, "GET")
+	streamingRoute("/bundle", c.BundleHandler, "GET")
+	route("/abort", c.AbortUploadsHandler, "POST")
+	listingRoute("/admin/cleanup-preview", c.CleanupPreviewHandler, "GET")
+	listingRoute("/uploads", c.ListUploadsHandler, "GET")
+	route("/uploads", c.CancelUploadsHandler, "DELETE")
+	streamingRoute("/imports", c.ImportHandler, "POST")
+	streamingRoute("/{upload_id}/upload", c.UploadChunkHandler, "POST")
+	streamingRoute("/{upload_id}/finish", c.FinishUploadHandler, "POST")
+	streamingRoute("/{upload_id}/ws", c.WebSocketUploadHandler, "GET")
+	streamingRoute("/{upload_id}/verify-resume", c.VerifyResumeHandler, "POST")
+	route("/{upload_id}/extend", c.ExtendUploadHandler, "POST")
+	route("/{upload_id}/diagnostics", c.DiagnosticsHandler, "GET")
+	route("/{upload_id}/events", c.UploadEventsHandler, "GET")
+	streamingRoute("/{upload_id}/integrity", c.IntegrityReportHandler, "GET")
+	streamingRoute("/{upload_id}/snapshot", c.SnapshotUploadHandler, "POST")
+	route("/{upload_id}/snapshots", c.ListSnapshotsHandler, "GET")
+	streamingRoute("/{upload_id}/diff", c.DiffSnapshotsHandler, "GET")
+	streamingRoute("/{upload_id}/snapshots/{snapshot_id}/restore", c.RestoreSnapshotHandler, "POST")
+	route("/{upload_id}/transfer", c.TransferUploadHandler, "POST")
+	streamingRoute("/{upload_id}/reprocess", c.ReprocessUploadHandler, "POST")
+	route("/{upload_id}/touch", c.TouchUploadHandler, "POST")
+	streamingRoute("/{upload_id}/export", c.ExportUploadHandler, "GET")
+	route("/{upload_id}/export", c.HeadExportHandler, "HEAD")
+	streamingRoute("/{upload_id}/thumbnail", c.ThumbnailHandler, "GET")
+	route("/{upload_id}/download-token", c.GetDownloadTokenHandler, "GET")
+	route("/{upload_id}/status", c.StatusHandler, "GET")
+	route("/{upload_id}/info", c.GetUploadInfoHandler, "GET")
+	streamingRoute("/{upload_id}/data", c.ReadRangeHandler, "GET")
+	route("/{upload_id}/regions", c.GetRegionsHandler, "GET")
+	streamingRoute("/{upload_id}/checksum", c.ChecksumHandler, "GET")
+	route("/{upload_id}/metadata", c.PutMetadataHandler, "PUT")
+	route("/{upload_id}/metadata", c.PatchMetadataHandler, "PATCH")
+	route("/{upload_id}/metadata/{key}", c.DeleteMetadataKeyHandler, "DELETE")
+	route("/{upload_id}/tag", c.TagUploadHandler, "POST")
+	route("/{upload_id}/tag/{key}", c.DeleteTagHandler, "DELETE")
+	route("/{upload_id}", c.CancelUploadHandler, "DELETE")
+	streamingRoute("/{upload_id}", c.CopyUploadHandler, "COPY")
+	route("/{upload_id}", c.MoveUploadHandler, "MOVE")
+}
+
+// NewServer returns a server for a given handler with timeouts protecting against slow clients. It has no
+// WriteTimeout or ReadTimeout, as those would also cut off chunk uploads and downloads of large files, the handler of
+// NewHTTPHandler sets the deadlines per route instead.
+func NewServer(addr string, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+		IdleTimeout:       serverIdleTimeout,
+	}
+}
+
+// HealthHandler reports that the server is up.
+func (c *ChunkedUploaderHandler) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// withWriteTimeout sets the write deadline of the responses of a handler, zero clears it. The deadline stays on the
+// connection, so routes without a timeout have to clear the one left by an earlier request.
+func withWriteTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var deadline time.Time
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		// not every ResponseWriter supports deadlines
+		_ = http.NewResponseController(w).SetWriteDeadline(deadline)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *ChunkedUploaderHandler) authenticationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.authenticate(r) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authenticatedKey{}, true)))
+			return
+		}
+		// the route may still accept the upload token, see WithUploadTokens
+		if !checksUploadToken(r) {
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *ChunkedUploaderHandler) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !c.corsAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, COPY, MOVE")
+			w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *ChunkedUploaderHandler) corsAllowed(origin string) bool {
+	for _, allowed := range c.corsOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// recordingMiddleware logs and counts the requests, depending on the options.
+func (c *ChunkedUploaderHandler) recordingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if c.metrics != nil {
+			c.metrics.mu.Lock()
+			c.metrics.requests[recorder.status]++
+			c.metrics.mu.Unlock()
+		}
+		if c.logRequests {
+			c.service.log(LogLevelInfo, "Request", LogField{"method", r.Method}, LogField{"path", r.URL.Path}, LogField{"status", recorder.status}, LogField{"duration", time.Since(start)})
+		}
+	})
+}
+
+// statusRecorder remembers the status code of a response. Unwrap gives http.ResponseController access to the
+// deadlines and flushing of the underlying writer.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *statusRecorder) Flush() {
+	_ = http.NewResponseController(s.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection over, like for WebSocketUploadHandler, which reports it as switching protocols.
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(s.ResponseWriter).Hijack()
+	if err == nil && !s.wroteHeader {
+		s.status = http.StatusSwitchingProtocols
+		s.wroteHeader = true
+	}
+	return conn, rw, err
+}
@@ -0,0 +1,74 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var InvalidSignatureError = errors.New("invalid signature")
+var SignaturesDisabledError = errors.New("signature verification is not enabled")
+
+// SignatureVerifier checks a detached signature, like an ed25519 or a GPG one, made by a given key over a file.
+// Verify returns an error wrapping InvalidSignatureError if the signature does not match and any other error if it
+// could not be checked, for example because the key is unknown.
+type SignatureVerifier interface {
+	Verify(ctx context.Context, keyId string, file io.Reader, signature []byte) error
+}
+
+// UploadSignature is a detached signature of an upload, it is stored in the metadata once verified.
+type UploadSignature struct {
+	KeyId     string `json:"key_id"`
+	Signature []byte `json:"signature"`
+}
+
+// WithSignatureVerifier makes FinishUpload require a detached signature of every upload, see WithSignature. The
+// signature is verified after the checksum and an upload with an invalid one is left unfinished.
+func WithSignatureVerifier(verifier SignatureVerifier) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.signatureVerifier = verifier
+	}
+}
+
+type signatureKey struct{}
+
+// WithSignature attaches the detached signature of an upload to a finish called with the returned context.
+func WithSignature(ctx context.Context, signature UploadSignature) context.Context {
+	return context.WithValue(ctx, signatureKey{}, signature)
+}
+
+func signatureFrom(ctx context.Context) (UploadSignature, bool) {
+	signature, ok := ctx.Value(signatureKey{}).(UploadSignature)
+	return signature, ok
+}
+
+// verifySignature checks the signature a finish was called with against the pending file of a given upload. It
+// returns no signature to store when signatures are not enabled.
+func (c *ChunkedUploaderService) verifySignature(ctx context.Context, uploadId string) (*UploadSignature, error) {
+	signature, ok := signatureFrom(ctx)
+	if c.signatureVerifier == nil {
+		if ok {
+			return nil, SignaturesDisabledError
+		}
+		return nil, nil
+	}
+	if !ok || len(signature.Signature) == 0 {
+		return nil, fmt.Errorf("%w: signature is required", InvalidSignatureError)
+	}
+
+	err := c.backgroundRead(func() error {
+		file, err := c.fs.Open(c.getUploadFilePath(uploadId))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return c.signatureVerifier.Verify(ctx, signature.KeyId, file, signature.Signature)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &signature, nil
+}
@@ -0,0 +1,147 @@
+package chunkeduploader
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var SignedURLsDisabledError = errors.New("signed urls are not enabled")
+var InvalidTokenError = errors.New("invalid token")
+var ExpiredTokenError = errors.New("token expired")
+
+// WithSignedDownloads makes FinishUpload hand out download urls signed with HMAC-SHA256 using a given secret. The
+// urls point at the export handler mounted under baseURL and stay valid for expiry. Once enabled, the export
+// handler only serves requests carrying a valid signature.
+func WithSignedDownloads(secret []byte, baseURL string, expiry time.Duration) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.signer = &urlSigner{
+			secret:  secret,
+			baseURL: strings.TrimSuffix(baseURL, "/"),
+			expiry:  expiry,
+		}
+	}
+}
+
+type urlSigner struct {
+	secret  []byte
+	baseURL string
+	expiry  time.Duration
+}
+
+// sign returns the signature of an action on a given upload which is valid until expires.
+func (s *urlSigner) sign(action string, uploadId string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s:%s:%d", action, uploadId, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks a token produced by sign, expires is the unix timestamp from the signed url.
+func (s *urlSigner) verify(action string, uploadId string, token string, expires string) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || token == "" {
+		return InvalidTokenError
+	}
+
+	expected := s.sign(action, uploadId, expiresAt)
+	if !hmac.Equal([]byte(expected), []byte(token)) {
+		return InvalidTokenError
+	}
+
+	if time.Now().Unix() > expiresAt {
+		return ExpiredTokenError
+	}
+
+	return nil
+}
+
+// signedURL returns a url of an action on a given upload, signed to be valid for a given duration.
+func (s *urlSigner) signedURL(action string, uploadId string, validFor time.Duration) string {
+	expires := time.Now().Add(validFor).Unix()
+
+	query := url.Values{}
+	query.Set("token", s.sign(action, uploadId, expires))
+	query.Set("expires", strconv.FormatInt(expires, 10))
+
+	return fmt.Sprintf("%s/%s/%s?%s", s.baseURL, url.PathEscape(uploadId), action, query.Encode())
+}
+
+// SignedDownloadURL returns a time-limited url to download a given upload from the export handler.
+func (c *ChunkedUploaderService) SignedDownloadURL(uploadId string) (string, error) {
+	if c.signer == nil {
+		return "", SignedURLsDisabledError
+	}
+
+	return c.signer.signedURL("export", uploadId, c.signer.expiry), nil
+}
+
+// GetDownloadURL returns a url to download a finished upload which is valid for a given duration.
+func (c *ChunkedUploaderService) GetDownloadURL(ctx context.Context, uploadId string, validFor time.Duration) (string, error) {
+	if c.signer == nil {
+		return "", SignedURLsDisabledError
+	}
+
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.GetDownloadURL failed to read metadata %w", err)
+	}
+	if meta.State != UploadStateComplete {
+		return "", fmt.Errorf("ChunkedUploaderService.GetDownloadURL %w", UploadNotCompleteError)
+	}
+
+	return c.signer.signedURL("export", uploadId, validFor), nil
+}
+
+// GetDownloadTokenHandler returns a signed url to download a finished uploadId, the expires_in query parameter sets
+// its validity in seconds.
+func (c *ChunkedUploaderHandler) GetDownloadTokenHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	var validFor time.Duration
+	if c.service.signer != nil {
+		validFor = c.service.signer.expiry
+	}
+	if expiresIn := r.URL.Query().Get("expires_in"); expiresIn != "" {
+		seconds, err := strconv.ParseInt(expiresIn, 10, 64)
+		if err != nil || seconds <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "expires_in must be a positive number of seconds")
+			return
+		}
+		validFor = time.Duration(seconds) * time.Second
+	}
+
+	url, err := c.service.GetDownloadURL(r.Context(), uploadId, validFor)
+	if err != nil {
+		switch {
+		case errors.Is(err, SignedURLsDisabledError):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, UploadNotCompleteError):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to sign download url: "+err.Error())
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"url": url})
+}
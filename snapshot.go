@@ -0,0 +1,395 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var InvalidSnapshotNameError = errors.New("invalid snapshot name")
+var SnapshotNotFoundError = errors.New("snapshot not found")
+var SnapshotExistsError = errors.New("snapshot already exists")
+
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+const snapshotsFile = "snapshots.json"
+
+// reservedSnapshotNames are the names of the other files kept in the snapshot directory of an upload.
+var reservedSnapshotNames = map[string]bool{
+	snapshotsFile:          true,
+	snapshotsFile + ".tmp": true,
+	eventLogFile:           true,
+}
+
+// Snapshot is a read-only copy of the pending file of an upload at some point, see SnapshotUpload.
+type Snapshot struct {
+	Name      string    `json:"snapshot_id"`
+	Bytes     int64     `json:"bytes"`
+	Checksum  string    `json:"checksum"`
+	CreatedAt time.Time `json:"created_at"`
+	// Regions, Sequence and LastChunkChecksum are the upload progress restored with the snapshot.
+	Regions           []ByteRange `json:"regions,omitempty"`
+	Sequence          int64       `json:"sequence,omitempty"`
+	LastChunkChecksum string      `json:"last_chunk_checksum,omitempty"`
+}
+
+// getSnapshotDirectory returns the directory holding the snapshots of a given upload.
+func (c *ChunkedUploaderService) getSnapshotDirectory(uploadId string) string {
+	return c.getUploadFilePath(uploadId) + ".snapshots"
+}
+
+// getThumbnailDirectory returns the directory caching the thumbnails of a given upload. It is kept in the snapshot
+// directory so it goes away with the upload, under a name no snapshot can have.
+func (c *ChunkedUploaderService) getThumbnailDirectory(uploadId string) string {
+	return filepath.Join(c.getSnapshotDirectory(uploadId), ".thumbnails")
+}
+
+// excludeWriters waits for the chunks being written to a given upload and rejects new ones until the returned function
+// is called, so the pending file does not change while it is copied.
+func (c *ChunkedUploaderService) excludeWriters(ctx context.Context, uploadId string) (release func(), err error) {
+	idle, release, err := c.writers.finish(uploadId)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-idle:
+		return release, nil
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
+
+// SnapshotUpload stores a copy of the current pending file of a given upload under a given name, so the upload can
+// be restored to it later. The copy is a real copy and not a hard link, chunks are written in place and would
+// otherwise change the snapshot too. Chunks are not written while the copy is taken, and finished uploads cannot be
+// snapshotted. It returns the name of the snapshot.
+func (c *ChunkedUploaderService) SnapshotUpload(ctx context.Context, uploadId string, name string) (string, error) {
+	if !snapshotNamePattern.MatchString(name) || reservedSnapshotNames[name] {
+		return "", fmt.Errorf("ChunkedUploaderService.SnapshotUpload %w", InvalidSnapshotNameError)
+	}
+
+	release, err := c.excludeWriters(ctx, uploadId)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.SnapshotUpload %w", err)
+	}
+	defer release()
+
+	err = c.flushWriteBuffer(uploadId)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.SnapshotUpload failed to flush write buffer %w", err)
+	}
+
+	unlock := c.locks.lock(uploadId)
+	defer unlock()
+
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.SnapshotUpload failed to read metadata %w", err)
+	}
+	if meta.finished() {
+		return "", fmt.Errorf("ChunkedUploaderService.SnapshotUpload %w", UploadAlreadyFinishedError)
+	}
+
+	snapshots, err := c.readSnapshots(uploadId)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.SnapshotUpload failed to read snapshots %w", err)
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Name == name {
+			return "", fmt.Errorf("ChunkedUploaderService.SnapshotUpload %w", SnapshotExistsError)
+		}
+	}
+
+	snapshotPath := filepath.Join(c.getSnapshotDirectory(uploadId), name)
+	c.fs.Remove(snapshotPath) // left over from a snapshot which was never recorded
+	err = copyFile(c.fs, c.getUploadFilePath(uploadId), snapshotPath)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.SnapshotUpload failed to copy pending file %w", err)
+	}
+
+	checksum, err := c.computeChecksum(ctx, snapshotPath)
+	if err != nil {
+		c.fs.Remove(snapshotPath)
+		return "", fmt.Errorf("ChunkedUploaderService.SnapshotUpload failed to compute checksum %w", err)
+	}
+
+	snapshots = append(snapshots, Snapshot{
+		Name:              name,
+		Bytes:             regionsLength(meta.Regions),
+		Checksum:          checksum,
+		CreatedAt:         time.Now(),
+		Regions:           meta.Regions,
+		Sequence:          meta.Sequence,
+		LastChunkChecksum: meta.LastChunkChecksum,
+	})
+	err = c.writeSnapshots(uploadId, snapshots)
+	if err != nil {
+		c.fs.Remove(snapshotPath)
+		return "", fmt.Errorf("ChunkedUploaderService.SnapshotUpload failed to write snapshots %w", err)
+	}
+
+	return name, nil
+}
+
+// RestoreSnapshot replaces the pending file of a given upload with a copy of a given snapshot and rolls the written
+// regions back to the ones recorded with it. Chunks are not written while it is restored, and finished uploads cannot
+// be restored.
+func (c *ChunkedUploaderService) RestoreSnapshot(ctx context.Context, uploadId string, name string) error {
+	release, err := c.excludeWriters(ctx, uploadId)
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.RestoreSnapshot %w", err)
+	}
+	defer release()
+
+	c.dropWriteBuffer(uploadId)
+
+	unlock := c.locks.lock(uploadId)
+	defer unlock()
+
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.RestoreSnapshot failed to read metadata %w", err)
+	}
+	if meta.finished() {
+		return fmt.Errorf("ChunkedUploaderService.RestoreSnapshot %w", UploadAlreadyFinishedError)
+	}
+
+	snapshot, err := c.findSnapshot(uploadId, name)
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.RestoreSnapshot %w", err)
+	}
+
+	// the copy is renamed over the pending file, so a failed restore leaves the upload as it was
+	pendingPath := c.getUploadFilePath(uploadId)
+	tempPath := pendingPath + ".restore"
+	err = copyFile(c.fs, filepath.Join(c.getSnapshotDirectory(uploadId), name), tempPath)
+	if err != nil {
+		c.fs.Remove(tempPath)
+		return fmt.Errorf("ChunkedUploaderService.RestoreSnapshot failed to copy snapshot %w", err)
+	}
+	err = c.fs.Rename(tempPath, pendingPath)
+	if err != nil {
+		c.fs.Remove(tempPath)
+		return fmt.Errorf("ChunkedUploaderService.RestoreSnapshot failed to replace pending file %w", err)
+	}
+
+	meta.Regions = snapshot.Regions
+	meta.Sequence = snapshot.Sequence
+	meta.LastChunkChecksum = snapshot.LastChunkChecksum
+	// the leaves recorded since the snapshot no longer match the file
+	meta.TreeLeaves = nil
+	meta.invalidateChecksum()
+	c.setWritten(uploadId, regionsLength(meta.Regions))
+
+	err = c.saveMetadata(meta)
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.RestoreSnapshot failed to write metadata %w", err)
+	}
+
+	return nil
+}
+
+// ListSnapshots returns the snapshots of a given upload in the order they were taken.
+func (c *ChunkedUploaderService) ListSnapshots(ctx context.Context, uploadId string) ([]Snapshot, error) {
+	_, err := c.readMetadata(uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.ListSnapshots failed to read metadata %w", err)
+	}
+
+	snapshots, err := c.readSnapshots(uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.ListSnapshots failed to read snapshots %w", err)
+	}
+
+	return snapshots, nil
+}
+
+func (c *ChunkedUploaderService) findSnapshot(uploadId string, name string) (*Snapshot, error) {
+	snapshots, err := c.readSnapshots(uploadId)
+	if err != nil {
+		return nil, err
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Name == name {
+			return &snapshot, nil
+		}
+	}
+
+	return nil, SnapshotNotFoundError
+}
+
+func (c *ChunkedUploaderService) readSnapshots(uploadId string) ([]Snapshot, error) {
+	file, err := c.fs.Open(filepath.Join(c.getSnapshotDirectory(uploadId), snapshotsFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []Snapshot{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var snapshots []Snapshot
+	err = json.NewDecoder(file).Decode(&snapshots)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode snapshots: %w", err)
+	}
+
+	return snapshots, nil
+}
+
+// writeSnapshots atomically replaces the snapshots sidecar of a given upload.
+func (c *ChunkedUploaderService) writeSnapshots(uploadId string, snapshots []Snapshot) error {
+	path := filepath.Join(c.getSnapshotDirectory(uploadId), snapshotsFile)
+	tempPath := path + ".tmp"
+
+	file, err := openFile(c.fs, tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, StandardAccess)
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(file).Encode(snapshots)
+	if err != nil {
+		file.Close()
+		c.fs.Remove(tempPath)
+		return fmt.Errorf("failed to encode snapshots: %w", err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		c.fs.Remove(tempPath)
+		return fmt.Errorf("failed to close snapshots: %w", err)
+	}
+
+	err = c.fs.Rename(tempPath, path)
+	if err != nil {
+		c.fs.Remove(tempPath)
+		return fmt.Errorf("failed to rename snapshots: %w", err)
+	}
+
+	return nil
+}
+
+type SnapshotUploadRequest struct {
+	Name string `json:"name"`
+}
+
+// SnapshotUploadHandler stores a named snapshot of the current bytes of a given uploadId.
+func (c *ChunkedUploaderHandler) SnapshotUploadHandler(w http.ResponseWriter, r *http.Request) {
+	uploadId := mux.Vars(r)["upload_id"]
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	var req SnapshotUploadRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if !c.requireUploadOwner(w, r, uploadId) {
+		return
+	}
+
+	name, err := c.service.SnapshotUpload(r.Context(), uploadId, req.Name)
+	if err != nil {
+		c.writeSnapshotError(w, "Failed to snapshot upload: ", err)
+		return
+	}
+
+	snapshot, err := c.service.findSnapshot(uploadId, name)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to read snapshot: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"snapshot_id": snapshot.Name,
+		"bytes":       snapshot.Bytes,
+		"checksum":    snapshot.Checksum,
+	})
+}
+
+// ListSnapshotsHandler returns the snapshots of a given uploadId.
+func (c *ChunkedUploaderHandler) ListSnapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	uploadId := mux.Vars(r)["upload_id"]
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	if !c.requireUploadOwner(w, r, uploadId) {
+		return
+	}
+
+	snapshots, err := c.service.ListSnapshots(r.Context(), uploadId)
+	if err != nil {
+		c.writeSnapshotError(w, "Failed to list snapshots: ", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"snapshots": snapshots})
+}
+
+// RestoreSnapshotHandler rolls a given uploadId back to one of its snapshots.
+func (c *ChunkedUploaderHandler) RestoreSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	if !c.requireUploadOwner(w, r, uploadId) {
+		return
+	}
+
+	err := c.service.RestoreSnapshot(r.Context(), uploadId, vars["snapshot_id"])
+	if err != nil {
+		c.writeSnapshotError(w, "Failed to restore snapshot: ", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireUploadOwner reads the metadata of a given upload and checks that the caller owns it.
+func (c *ChunkedUploaderHandler) requireUploadOwner(w http.ResponseWriter, r *http.Request, uploadId string) bool {
+	meta, err := c.service.GetMetadata(r.Context(), uploadId)
+	if err != nil {
+		if errors.Is(err, UploadNotFoundError) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return false
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to read metadata: "+err.Error())
+		return false
+	}
+
+	return c.requireOwner(w, r, meta)
+}
+
+func (c *ChunkedUploaderHandler) writeSnapshotError(w http.ResponseWriter, prefix string, err error) {
+	switch {
+	case errors.Is(err, InvalidSnapshotNameError):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, UploadNotFoundError), errors.Is(err, SnapshotNotFoundError):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, SnapshotExistsError), errors.Is(err, UploadAlreadyFinishedError), errors.Is(err, UploadFinishingError), errors.Is(err, UploadCancelledError):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	default:
+		writeJSONError(w, http.StatusInternalServerError, prefix+err.Error())
+	}
+}
@@ -0,0 +1,198 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestSnapshotIsolation(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+
+	uploadId, err := service.CreateUpload(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, strings.NewReader("aaaa"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.SnapshotUpload(context.Background(), uploadId, "checkpoint-1"); err != nil {
+		t.Fatal(err)
+	}
+	// the pending file is allocated to the size of the upload up front
+	want := "aaaa" + strings.Repeat("\x00", 8)
+
+	// the writes after the snapshot change neither its bytes nor its recorded checksum
+	for _, chunk := range []struct {
+		data   string
+		offset int64
+	}{
+		{"bbbb", 4},
+		{"cccc", 8},
+	} {
+		if _, err := service.UploadChunk(uploadId, strings.NewReader(chunk.data), chunk.offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshotPath := filepath.Join(service.getSnapshotDirectory(uploadId), "checkpoint-1")
+	if data, err := afero.ReadFile(service.fs, snapshotPath); err != nil || string(data) != want {
+		t.Errorf("snapshot after later writes: %q %v, want %q", data, err, want)
+	}
+	snapshot, err := service.findSnapshot(uploadId, "checkpoint-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Bytes != 4 || snapshot.Checksum != sha256Hex([]byte(want)) {
+		t.Errorf("snapshot: %d bytes %s, want 4 bytes of %q", snapshot.Bytes, snapshot.Checksum, want)
+	}
+
+	if err := service.RestoreSnapshot(context.Background(), uploadId, "checkpoint-1"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := afero.ReadFile(service.fs, service.getUploadFilePath(uploadId)); err != nil || string(data) != want {
+		t.Errorf("pending file after the restore: %q %v, want %q", data, err, want)
+	}
+
+	// writes after the restore do not leak into the snapshot either
+	if _, err := service.UploadChunk(uploadId, strings.NewReader("dddd"), 4); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := afero.ReadFile(service.fs, snapshotPath); err != nil || string(data) != want {
+		t.Errorf("snapshot after the restore: %q %v, want %q", data, err, want)
+	}
+}
+
+func TestSnapshotRejectsFinishedUploads(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   []ChunkedUploaderServiceOption
+		create func(t *testing.T, service *ChunkedUploaderService) string
+	}{
+		{"complete", nil, func(t *testing.T, service *ChunkedUploaderService) string {
+			uploadId, _, _ := newFinishedTestUpload(t, service)
+			return uploadId
+		}},
+		{"failed", []ChunkedUploaderServiceOption{WithScanner(rejectingScanner)}, func(t *testing.T, service *ChunkedUploaderService) string {
+			uploadId, _ := newFailedTestUpload(t, service)
+			return uploadId
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs(), tc.opts...)
+			handler := NewHTTPHandler(service)
+			uploadId := tc.create(t, service)
+
+			if _, err := service.SnapshotUpload(context.Background(), uploadId, "checkpoint-1"); !errors.Is(err, UploadAlreadyFinishedError) {
+				t.Errorf("SnapshotUpload: %v, want UploadAlreadyFinishedError", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/"+uploadId+"/snapshot", strings.NewReader(`{"name":"checkpoint-2"}`))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusConflict {
+				t.Errorf("snapshot request: %d %s, want 409", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestSnapshotExcludesWriters(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// hold registers a writer or a finish of the upload and returns its release
+		hold func(t *testing.T, service *ChunkedUploaderService, uploadId string) func()
+		want error
+	}{
+		{"writing", func(t *testing.T, service *ChunkedUploaderService, uploadId string) func() {
+			_, done, err := service.writers.start(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return done
+		}, context.DeadlineExceeded},
+		{"finishing", func(t *testing.T, service *ChunkedUploaderService, uploadId string) func() {
+			_, release, err := service.writers.finish(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return release
+		}, UploadFinishingError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs())
+			uploadId, err := service.CreateUpload(8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := service.UploadChunk(uploadId, strings.NewReader("aaaa"), 0); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := service.SnapshotUpload(context.Background(), uploadId, "checkpoint-1"); err != nil {
+				t.Fatal(err)
+			}
+
+			release := tc.hold(t, service, uploadId)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if _, err := service.SnapshotUpload(ctx, uploadId, "checkpoint-2"); !errors.Is(err, tc.want) {
+				t.Errorf("SnapshotUpload: %v, want %v", err, tc.want)
+			}
+			if err := service.RestoreSnapshot(ctx, uploadId, "checkpoint-1"); !errors.Is(err, tc.want) {
+				t.Errorf("RestoreSnapshot: %v, want %v", err, tc.want)
+			}
+			release()
+
+			// the snapshots do not keep rejecting chunks once they gave up
+			if _, err := service.UploadChunk(uploadId, strings.NewReader("bbbb"), 4); err != nil {
+				t.Errorf("chunk after the snapshots: %v", err)
+			}
+		})
+	}
+}
+
+func TestThumbnailCacheOutsideSnapshots(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	uploadId, err := service.CreateUpload(int64(len(data)), WithContentType("image/png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+	// a snapshot may have the name the thumbnail cache had
+	if _, err := service.SnapshotUpload(context.Background(), uploadId, "thumbnails"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.FinishUpload(context.Background(), uploadId, sha256Hex(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.GetThumbnail(context.Background(), uploadId, 4, 4, "png"); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(t, service.fs, filepath.Join(service.getThumbnailDirectory(uploadId), "4x4.png")) {
+		t.Error("thumbnail was not cached")
+	}
+	snapshot, err := afero.ReadFile(service.fs, filepath.Join(service.getSnapshotDirectory(uploadId), "thumbnails"))
+	if err != nil || !bytes.Equal(snapshot, data) {
+		t.Errorf("snapshot named thumbnails: %v, want the snapshotted bytes", err)
+	}
+}
@@ -0,0 +1,149 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/afero"
+)
+
+// diffBlockSize is the size of the blocks compared by DiffSnapshots.
+const diffBlockSize = 64 << 10
+
+// DiffResult describes how the bytes of one snapshot differ from another one. ChangedRanges covers the bytes which
+// differ within the length of both snapshots and the bytes added at the end.
+type DiffResult struct {
+	ChangedRanges []ByteRange `json:"changed_ranges"`
+	AddedBytes    int64       `json:"added_bytes"`
+	RemovedBytes  int64       `json:"removed_bytes"`
+}
+
+// DiffSnapshots compares two snapshots of a given upload byte by byte.
+func (c *ChunkedUploaderService) DiffSnapshots(ctx context.Context, uploadId string, from string, to string) (*DiffResult, error) {
+	for _, name := range []string{from, to} {
+		_, err := c.findSnapshot(uploadId, name)
+		if err != nil {
+			return nil, fmt.Errorf("ChunkedUploaderService.DiffSnapshots %s %w", name, err)
+		}
+	}
+
+	fromFile, fromSize, err := c.openSnapshot(uploadId, from)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.DiffSnapshots failed to open snapshot %w", err)
+	}
+	defer fromFile.Close()
+
+	toFile, toSize, err := c.openSnapshot(uploadId, to)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.DiffSnapshots failed to open snapshot %w", err)
+	}
+	defer toFile.Close()
+
+	common := fromSize
+	if toSize < common {
+		common = toSize
+	}
+
+	result := &DiffResult{ChangedRanges: []ByteRange{}}
+	fromBlock := make([]byte, diffBlockSize)
+	toBlock := make([]byte, diffBlockSize)
+	for offset := int64(0); offset < common; offset += diffBlockSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		n := int64(diffBlockSize)
+		if common-offset < n {
+			n = common - offset
+		}
+		_, err := io.ReadFull(fromFile, fromBlock[:n])
+		if err != nil {
+			return nil, fmt.Errorf("ChunkedUploaderService.DiffSnapshots failed to read snapshot %w", err)
+		}
+		_, err = io.ReadFull(toFile, toBlock[:n])
+		if err != nil {
+			return nil, fmt.Errorf("ChunkedUploaderService.DiffSnapshots failed to read snapshot %w", err)
+		}
+
+		if bytes.Equal(fromBlock[:n], toBlock[:n]) {
+			continue
+		}
+		for i := int64(0); i < n; i++ {
+			if fromBlock[i] != toBlock[i] {
+				result.ChangedRanges = extendRanges(result.ChangedRanges, offset+i)
+			}
+		}
+	}
+
+	if toSize > fromSize {
+		result.AddedBytes = toSize - fromSize
+		result.ChangedRanges = addRegion(result.ChangedRanges, ByteRange{Start: fromSize, End: toSize - 1})
+	} else {
+		result.RemovedBytes = fromSize - toSize
+	}
+
+	return result, nil
+}
+
+// extendRanges adds a single byte to sorted ranges, the byte must not be before the end of the last range.
+func extendRanges(ranges []ByteRange, offset int64) []ByteRange {
+	if last := len(ranges) - 1; last >= 0 && ranges[last].End+1 == offset {
+		ranges[last].End = offset
+		return ranges
+	}
+	return append(ranges, ByteRange{Start: offset, End: offset})
+}
+
+func (c *ChunkedUploaderService) openSnapshot(uploadId string, name string) (afero.File, int64, error) {
+	file, err := c.fs.Open(filepath.Join(c.getSnapshotDirectory(uploadId), name))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return file, info.Size(), nil
+}
+
+// DiffSnapshotsHandler returns the byte ranges which differ between two snapshots of a given uploadId.
+func (c *ChunkedUploaderHandler) DiffSnapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	uploadId := mux.Vars(r)["upload_id"]
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if from == "" || to == "" {
+		writeJSONError(w, http.StatusBadRequest, "from and to are required")
+		return
+	}
+
+	if !c.requireUploadOwner(w, r, uploadId) {
+		return
+	}
+
+	result, err := c.service.DiffSnapshots(r.Context(), uploadId, from, to)
+	if err != nil {
+		if errors.Is(err, SnapshotNotFoundError) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to diff snapshots: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,159 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	type write struct {
+		data   []byte
+		offset int64
+	}
+
+	for _, tc := range []struct {
+		name string
+		// size is the size of the upload, zero if it is unknown
+		size   int64
+		before []write
+		// after is written between the two snapshots
+		after []write
+		want  DiffResult
+	}{
+		{
+			name: "identical", size: 200000,
+			before: []write{{bytes.Repeat([]byte{'a'}, 200000), 0}},
+			want:   DiffResult{ChangedRanges: []ByteRange{}},
+		},
+		{
+			name: "single byte", size: 200000,
+			before: []write{{bytes.Repeat([]byte{'a'}, 200000), 0}},
+			after:  []write{{[]byte("b"), 70000}},
+			want:   DiffResult{ChangedRanges: []ByteRange{{Start: 70000, End: 70000}}},
+		},
+		{
+			name: "across a block boundary", size: 200000,
+			before: []write{{bytes.Repeat([]byte{'a'}, 200000), 0}},
+			after:  []write{{bytes.Repeat([]byte{'b'}, 16), diffBlockSize - 6}},
+			want:   DiffResult{ChangedRanges: []ByteRange{{Start: diffBlockSize - 6, End: diffBlockSize + 9}}},
+		},
+		{
+			name: "separate ranges", size: 200000,
+			before: []write{{bytes.Repeat([]byte{'a'}, 200000), 0}},
+			after:  []write{{bytes.Repeat([]byte{'b'}, 10), 10}, {bytes.Repeat([]byte{'b'}, 10), 150000}},
+			want:   DiffResult{ChangedRanges: []ByteRange{{Start: 10, End: 19}, {Start: 150000, End: 150009}}},
+		},
+		{
+			name: "rewritten with the same bytes", size: 200000,
+			before: []write{{bytes.Repeat([]byte{'a'}, 200000), 0}},
+			after:  []write{{bytes.Repeat([]byte{'a'}, 1000), 5000}},
+			want:   DiffResult{ChangedRanges: []ByteRange{}},
+		},
+		{
+			name:   "added bytes",
+			before: []write{{bytes.Repeat([]byte{'a'}, 100), 0}},
+			after:  []write{{bytes.Repeat([]byte{'b'}, 50), 100}},
+			want:   DiffResult{ChangedRanges: []ByteRange{{Start: 100, End: 149}}, AddedBytes: 50},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs())
+			handler := NewHTTPHandler(service)
+			uploadId, err := service.CreateUpload(tc.size)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, writes := range [][]write{tc.before, tc.after} {
+				for _, w := range writes {
+					if _, err := service.UploadChunk(uploadId, bytes.NewReader(w.data), w.offset); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if _, err := service.SnapshotUpload(context.Background(), uploadId, []string{"checkpoint-1", "checkpoint-2"}[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+uploadId+"/diff?from=checkpoint-1&to=checkpoint-2", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("diff: %d %s", rec.Code, rec.Body)
+			}
+			var got DiffResult
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !equalDiffResults(got, tc.want) {
+				t.Errorf("diff: got %+v, want %+v", got, tc.want)
+			}
+
+			// the other way around added bytes are removed ones
+			reversed, err := service.DiffSnapshots(context.Background(), uploadId, "checkpoint-2", "checkpoint-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := DiffResult{ChangedRanges: tc.want.ChangedRanges, RemovedBytes: tc.want.AddedBytes}
+			if tc.want.AddedBytes > 0 {
+				want.ChangedRanges = []ByteRange{}
+			}
+			if !equalDiffResults(*reversed, want) {
+				t.Errorf("reversed diff: got %+v, want %+v", *reversed, want)
+			}
+		})
+	}
+}
+
+func equalDiffResults(a DiffResult, b DiffResult) bool {
+	if a.AddedBytes != b.AddedBytes || a.RemovedBytes != b.RemovedBytes || len(a.ChangedRanges) != len(b.ChangedRanges) {
+		return false
+	}
+	for i := range a.ChangedRanges {
+		if a.ChangedRanges[i] != b.ChangedRanges[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDiffSnapshotsHandlerErrors(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+	uploadId, err := service.CreateUpload(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.SnapshotUpload(context.Background(), uploadId, "checkpoint-1"); err != nil {
+		t.Fatal(err)
+	}
+	other, err := service.CreateUpload(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.SnapshotUpload(context.Background(), other, "checkpoint-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		query    string
+		wantCode int
+	}{
+		{"same snapshot", "from=checkpoint-1&to=checkpoint-1", http.StatusOK},
+		{"missing to", "from=checkpoint-1", http.StatusBadRequest},
+		{"unknown snapshot", "from=checkpoint-1&to=checkpoint-3", http.StatusNotFound},
+		{"snapshot of another upload", "from=checkpoint-1&to=checkpoint-2", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+uploadId+"/diff?"+tc.query, nil))
+		if rec.Code != tc.wantCode {
+			t.Errorf("%s: %d %s, want %d", tc.name, rec.Code, rec.Body, tc.wantCode)
+		}
+	}
+}
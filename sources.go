@@ -0,0 +1,79 @@
+package chunkeduploader
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SourcePolicy configures the uploads coming from one source, like a web UI, an API or internal imports.
+type SourcePolicy struct {
+	// Retention is how long an upload is kept. Unfinished uploads expire this long after their creation and cleanup
+	// removes uploads not modified for this long, instead of using its own duration. Zero keeps the upload forever.
+	Retention time.Duration `json:"retention"`
+	// MaxFileSize rejects uploads declaring a larger size on create, zero means no limit.
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+	// ChunkSize is the chunk size assumed for uploads which do not declare one, see WithMaxChunkCount.
+	ChunkSize int64 `json:"chunk_size,omitempty"`
+}
+
+// WithSourcePolicies applies a policy to every new upload depending on its source, see WithSource. Uploads from
+// sources without a policy get defaultPolicy. The policy is stored with the upload, so later changes of the
+// configuration only apply to new uploads.
+func WithSourcePolicies(defaultPolicy SourcePolicy, policies map[string]SourcePolicy) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.defaultSourcePolicy = defaultPolicy
+		c.sourcePolicies = policies
+	}
+}
+
+// WithSource records where an upload comes from, it selects the policy of the upload.
+func WithSource(source string) CreateUploadOption {
+	return func(m *UploadMetadata) {
+		m.Source = source
+	}
+}
+
+// applySourcePolicy stamps the policy of the source of a new upload on its metadata and applies it.
+func (c *ChunkedUploaderService) applySourcePolicy(meta *UploadMetadata) error {
+	if c.sourcePolicies == nil {
+		return nil
+	}
+
+	policy, ok := c.sourcePolicies[meta.Source]
+	if !ok {
+		policy = c.defaultSourcePolicy
+	}
+	meta.Policy = &policy
+
+	if policy.MaxFileSize > 0 && meta.FileSize > policy.MaxFileSize {
+		return fmt.Errorf("%w - the limit for source %q is %d bytes", FileSizeExceedsMaximumError, meta.Source, policy.MaxFileSize)
+	}
+
+	meta.ExpiresAt = nil
+	if policy.Retention > 0 {
+		expiresAt := meta.CreatedAt.Add(policy.Retention)
+		meta.ExpiresAt = &expiresAt
+	}
+
+	if meta.ChunkSize == 0 {
+		meta.ChunkSize = policy.ChunkSize
+	}
+
+	return nil
+}
+
+// WithSourceResolver decides the source of uploads created through the handler, for example from the credentials of
+// the caller. Without it the source given in the create request is used.
+func WithSourceResolver(resolve func(r *http.Request) string) ChunkedUploaderHandlerOption {
+	return func(c *ChunkedUploaderHandler) {
+		c.resolveSource = resolve
+	}
+}
+
+func (c *ChunkedUploaderHandler) sourceOf(r *http.Request, requested string) string {
+	if c.resolveSource != nil {
+		return c.resolveSource(r)
+	}
+	return requested
+}
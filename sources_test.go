@@ -0,0 +1,144 @@
+package chunkeduploader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var testSourcePolicies = map[string]SourcePolicy{
+	"web":      {Retention: 6 * time.Hour},
+	"api":      {Retention: 48 * time.Hour},
+	"internal": {},
+}
+
+func createUploadFrom(t *testing.T, handler http.Handler, source string, size int64) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body := fmt.Sprintf(`{"file_size": %d, "source": %q}`, size, source)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/init", strings.NewReader(body)))
+	return rec
+}
+
+func TestSourcePoliciesAgeOut(t *testing.T) {
+	sources := []string{"web", "api", "internal", "other"}
+
+	for _, tc := range []struct {
+		age         time.Duration
+		wantRemoved []string
+	}{
+		{age: time.Hour},
+		{age: 12 * time.Hour, wantRemoved: []string{"web"}},
+		// unknown sources get the default retention of a day
+		{age: 30 * time.Hour, wantRemoved: []string{"web", "other"}},
+		{age: 72 * time.Hour, wantRemoved: []string{"web", "other", "api"}},
+		{age: 1000 * time.Hour, wantRemoved: []string{"web", "other", "api"}},
+	} {
+		t.Run(tc.age.String(), func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			service := newTestService(fs, WithSourcePolicies(SourcePolicy{Retention: 24 * time.Hour}, testSourcePolicies))
+			handler := NewHTTPHandler(service)
+
+			uploads := map[string]string{}
+			for _, source := range sources {
+				rec := createUploadFrom(t, handler, source, 10)
+				if rec.Code != http.StatusCreated {
+					t.Fatalf("create from %s: %d %s", source, rec.Code, rec.Body)
+				}
+				var resp struct {
+					UploadId string `json:"upload_id"`
+				}
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				uploads[source] = resp.UploadId
+				ageFiles(t, fs, tc.age, service.getUploadFilePath(resp.UploadId), service.paths.MetaPath(resp.UploadId))
+			}
+
+			// the cleanup duration only applies to uploads without a policy
+			if _, err := service.CleanupUploads(time.Minute); err != nil {
+				t.Fatal(err)
+			}
+
+			removed := map[string]bool{}
+			for _, source := range tc.wantRemoved {
+				removed[source] = true
+			}
+			for _, source := range sources {
+				if got := !exists(t, fs, service.getUploadFilePath(uploads[source])); got != removed[source] {
+					t.Errorf("upload from %s aged %v: removed %t, want %t", source, tc.age, got, removed[source])
+				}
+			}
+		})
+	}
+}
+
+func TestSourcePolicyOnCreate(t *testing.T) {
+	policies := map[string]SourcePolicy{"web": {Retention: 6 * time.Hour, MaxFileSize: 100, ChunkSize: 10}}
+
+	for _, tc := range []struct {
+		name string
+		// resolved is the source the handler decides on, empty to use the requested one
+		resolved   string
+		requested  string
+		size       int64
+		wantCode   int
+		wantSource string
+		wantPolicy SourcePolicy
+	}{
+		{name: "requested source", requested: "web", size: 100, wantCode: http.StatusCreated, wantSource: "web", wantPolicy: policies["web"]},
+		{name: "over the size of the source", requested: "web", size: 101, wantCode: http.StatusRequestEntityTooLarge},
+		{name: "unknown source", requested: "other", size: 101, wantCode: http.StatusCreated, wantSource: "other", wantPolicy: SourcePolicy{Retention: time.Hour}},
+		{name: "resolved source", resolved: "web", requested: "other", size: 100, wantCode: http.StatusCreated, wantSource: "web", wantPolicy: policies["web"]},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs(), WithSourcePolicies(SourcePolicy{Retention: time.Hour}, policies))
+			var opts []ChunkedUploaderHandlerOption
+			if tc.resolved != "" {
+				opts = append(opts, WithSourceResolver(func(r *http.Request) string { return tc.resolved }))
+			}
+			handler := NewHTTPHandler(service, opts...)
+
+			created := time.Now()
+			rec := createUploadFrom(t, handler, tc.requested, tc.size)
+			if rec.Code != tc.wantCode {
+				t.Fatalf("create: %d %s, want %d", rec.Code, rec.Body, tc.wantCode)
+			}
+			if tc.wantCode != http.StatusCreated {
+				return
+			}
+			var resp struct {
+				UploadId string `json:"upload_id"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+resp.UploadId+"/status", nil))
+			var status UploadStatus
+			if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+				t.Fatal(err)
+			}
+			if status.Source != tc.wantSource || status.Policy == nil || *status.Policy != tc.wantPolicy {
+				t.Errorf("status: source %q policy %+v, want %q %+v", status.Source, status.Policy, tc.wantSource, tc.wantPolicy)
+			}
+
+			meta, err := service.readMetadata(resp.UploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantExpiry := created.Add(tc.wantPolicy.Retention)
+			if meta.ExpiresAt == nil || meta.ExpiresAt.Before(wantExpiry) || meta.ExpiresAt.After(time.Now().Add(tc.wantPolicy.Retention)) {
+				t.Errorf("expires at %v, want %v after the create", meta.ExpiresAt, tc.wantPolicy.Retention)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package chunkeduploader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var OpenHandlesError = errors.New("storage has open file handles")
+
+// rebindTimeout is how long Rebind waits for the open file handles to be closed.
+const rebindTimeout = 30 * time.Second
+
+// rebindableFs forwards to a filesystem which can be swapped by Rebind. It counts the files it opened, so the swap
+// only happens while no handle into the old filesystem is open.
+type rebindableFs struct {
+	mu   sync.RWMutex
+	fs   afero.Fs
+	open int64
+}
+
+func (r *rebindableFs) current() afero.Fs {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.fs
+}
+
+func (r *rebindableFs) track(file afero.File, err error) (afero.File, error) {
+	if err != nil {
+		atomic.AddInt64(&r.open, -1)
+		return nil, err
+	}
+	return &rebindableFile{File: file, fs: r}, nil
+}
+
+func (r *rebindableFs) Create(name string) (afero.File, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	atomic.AddInt64(&r.open, 1)
+	return r.track(r.fs.Create(name))
+}
+
+func (r *rebindableFs) Open(name string) (afero.File, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	atomic.AddInt64(&r.open, 1)
+	return r.track(r.fs.Open(name))
+}
+
+func (r *rebindableFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	atomic.AddInt64(&r.open, 1)
+	return r.track(r.fs.OpenFile(name, flag, perm))
+}
+
+func (r *rebindableFs) Mkdir(name string, perm os.FileMode) error {
+	return r.current().Mkdir(name, perm)
+}
+
+func (r *rebindableFs) MkdirAll(path string, perm os.FileMode) error {
+	return r.current().MkdirAll(path, perm)
+}
+
+func (r *rebindableFs) Remove(name string) error {
+	return r.current().Remove(name)
+}
+
+func (r *rebindableFs) RemoveAll(path string) error {
+	return r.current().RemoveAll(path)
+}
+
+func (r *rebindableFs) Rename(oldname string, newname string) error {
+	return r.current().Rename(oldname, newname)
+}
+
+func (r *rebindableFs) Stat(name string) (os.FileInfo, error) {
+	return r.current().Stat(name)
+}
+
+func (r *rebindableFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	fs := r.current()
+	if lstater, ok := fs.(afero.Lstater); ok {
+		return lstater.LstatIfPossible(name)
+	}
+	info, err := fs.Stat(name)
+	return info, false, err
+}
+
+func (r *rebindableFs) ReadlinkIfPossible(name string) (string, error) {
+	if reader, ok := r.current().(afero.LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: afero.ErrNoReadlink}
+}
+
+func (r *rebindableFs) Name() string {
+	return "rebindableFs"
+}
+
+func (r *rebindableFs) Chmod(name string, mode os.FileMode) error {
+	return r.current().Chmod(name, mode)
+}
+
+func (r *rebindableFs) Chown(name string, uid int, gid int) error {
+	return r.current().Chown(name, uid, gid)
+}
+
+func (r *rebindableFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return r.current().Chtimes(name, atime, mtime)
+}
+
+// rebindableFile is a file opened through rebindableFs, closing it releases its handle.
+type rebindableFile struct {
+	afero.File
+	fs     *rebindableFs
+	closed int32
+}
+
+func (f *rebindableFile) Close() error {
+	if atomic.CompareAndSwapInt32(&f.closed, 0, 1) {
+		defer atomic.AddInt64(&f.fs.open, -1)
+	}
+	return f.File.Close()
+}
+
+// Unwrap returns the file of the underlying filesystem.
+func (f *rebindableFile) Unwrap() afero.File {
+	return f.File
+}
+
+// Rebind swaps the filesystem of the service, for example after the pending directory was moved to a bigger
+// volume. Paths are resolved again on every operation, so nothing points into the old filesystem once no file handle
+// into it is open. Rebind waits for the open handles to be closed and fails with OpenHandlesError if they are not.
+//
+// The safe procedure to move the storage of a running service is:
+//  1. copy the pending directory to the new volume while the service keeps running,
+//  2. stop sending requests to the handlers and stop the background components with Shutdown,
+//  3. copy the pending directory again to pick up the files changed since the first copy,
+//  4. call Rebind with a filesystem pointing to the new volume,
+//  5. start the background components with Run and send requests again.
+//
+// Chunks held by write coalescing and files held by WithInMemoryUploads are kept in memory and written to the new
+// volume.
+func (c *ChunkedUploaderService) Rebind(newFs afero.Fs) error {
+	deadline := time.Now().Add(rebindTimeout)
+	for {
+		c.storage.mu.Lock()
+		if atomic.LoadInt64(&c.storage.open) == 0 {
+			c.storage.fs = newFs
+			c.storage.mu.Unlock()
+			c.log(LogLevelInfo, "Rebound storage", LogField{"fs", newFs.Name()})
+			return nil
+		}
+		open := atomic.LoadInt64(&c.storage.open)
+		c.storage.mu.Unlock()
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("ChunkedUploaderService.Rebind %w - open: %d", OpenHandlesError, open)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
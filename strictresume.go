@@ -0,0 +1,110 @@
+package chunkeduploader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+var ExpectedCommittedRequiredError = errors.New("X-Expected-Committed header is required for strict resume uploads")
+
+// CommittedOffsetMismatchError is returned when a chunk was sent for a different committed offset than the one the
+// upload has, usually by a client resuming from stale state.
+type CommittedOffsetMismatchError struct {
+	Expected  int64
+	Committed int64
+	Regions   []ByteRange
+}
+
+func (e *CommittedOffsetMismatchError) Error() string {
+	return fmt.Sprintf("expected committed offset %d, upload has %d", e.Expected, e.Committed)
+}
+
+// WithStrictResume makes every chunk of the upload state the committed offset it expects in X-Expected-Committed,
+// chunks sent for a different offset are rejected without being written.
+func WithStrictResume() CreateUploadOption {
+	return func(m *UploadMetadata) {
+		m.StrictResume = true
+	}
+}
+
+// committedOffset returns the end of the data written contiguously from the start of the upload.
+func committedOffset(regions []ByteRange) int64 {
+	if len(regions) == 0 || regions[0].Start != 0 {
+		return 0
+	}
+	return regions[0].End + 1
+}
+
+// checkCommittedOffset compares the committed offset of an upload with the one expected by the client. A negative
+// expected offset means the client did not send one, which is only allowed for uploads without strict resume.
+func (c *ChunkedUploaderService) checkCommittedOffset(uploadId string, expected int64) error {
+	if expected >= 0 {
+		// buffered chunks are not in the regions yet
+		err := c.flushWriteBuffer(uploadId)
+		if err != nil {
+			return fmt.Errorf("ChunkedUploaderService.checkCommittedOffset failed to flush write buffer %w", err)
+		}
+	}
+
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		// the chunk itself reports a missing upload
+		return nil
+	}
+
+	if expected < 0 {
+		if meta.StrictResume {
+			return ExpectedCommittedRequiredError
+		}
+		return nil
+	}
+
+	committed := committedOffset(meta.Regions)
+	if committed != expected {
+		return &CommittedOffsetMismatchError{Expected: expected, Committed: committed, Regions: meta.Regions}
+	}
+	return nil
+}
+
+// parseExpectedCommitted reads X-Expected-Committed, -1 when it is not set.
+func parseExpectedCommitted(r *http.Request) (int64, error) {
+	header := r.Header.Get("X-Expected-Committed")
+	if header == "" {
+		return -1, nil
+	}
+
+	expected, err := strconv.ParseInt(header, 10, 64)
+	if err != nil || expected < 0 {
+		return 0, fmt.Errorf("invalid X-Expected-Committed header")
+	}
+	return expected, nil
+}
+
+func writeCommittedOffsetError(w http.ResponseWriter, err *CommittedOffsetMismatchError) {
+	regions := err.Regions
+	if regions == nil {
+		regions = []ByteRange{}
+	}
+
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":     err.Error(),
+		"code":      "committed_offset_mismatch",
+		"expected":  err.Expected,
+		"committed": err.Committed,
+		"regions":   regions,
+	})
+}
+
+// setStrictResumeHeader advertises strict resume to the client, older clients ignore the header.
+func (c *ChunkedUploaderHandler) setStrictResumeHeader(w http.ResponseWriter, uploadId string) {
+	meta, err := c.service.readMetadata(uploadId)
+	if err != nil || !meta.StrictResume {
+		return
+	}
+
+	w.Header().Set("X-Strict-Resume", "true")
+}
@@ -0,0 +1,119 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+)
+
+// tagKeyPattern restricts the keys of tags managed one at a time.
+var tagKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// validateTagKey checks a key of a tag managed with AddTag or RemoveTag.
+func validateTagKey(key string) error {
+	if !tagKeyPattern.MatchString(key) {
+		return fmt.Errorf("%w: tag keys must be 1 to 64 letters, digits, underscores or dashes", InvalidMetadataError)
+	}
+	if protectedMetadataKeys[key] {
+		return fmt.Errorf("%w: %s", ProtectedMetadataKeyError, key)
+	}
+	return nil
+}
+
+// AddTag adds a single tag to a given upload or changes its value. Unlike ReplaceMetadata it keeps the other tags, so
+// concurrent changes of different tags do not overwrite each other.
+func (c *ChunkedUploaderService) AddTag(ctx context.Context, uploadId string, key string, value string) error {
+	err := validateTagKey(key)
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.AddTag %w", err)
+	}
+
+	err = c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		if meta.Tags == nil {
+			meta.Tags = make(map[string]string)
+		}
+		meta.Tags[key] = value
+		// the other tags count towards the limits too
+		return meta.sanitize()
+	})
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.AddTag failed to update metadata %w", err)
+	}
+
+	return nil
+}
+
+// RemoveTag removes a single tag from a given upload, it fails with MetadataKeyNotFoundError if there is no such tag.
+func (c *ChunkedUploaderService) RemoveTag(ctx context.Context, uploadId string, key string) error {
+	err := validateTagKey(key)
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.RemoveTag %w", err)
+	}
+
+	return c.DeleteMetadataKey(ctx, uploadId, key)
+}
+
+type TagUploadRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// TagUploadHandler adds the tag from the request body to a given uploadId or changes its value.
+func (c *ChunkedUploaderHandler) TagUploadHandler(w http.ResponseWriter, r *http.Request) {
+	uploadId := mux.Vars(r)["upload_id"]
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	var req TagUploadRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	err = c.service.AddTag(r.Context(), uploadId, req.Key, req.Value)
+	if err != nil {
+		writeTagError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteTagHandler removes a single tag from a given uploadId.
+func (c *ChunkedUploaderHandler) DeleteTagHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+	key := vars["key"]
+
+	if uploadId == "" || key == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id and key are required")
+		return
+	}
+
+	err := c.service.RemoveTag(r.Context(), uploadId, key)
+	if err != nil {
+		writeTagError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeTagError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, InvalidMetadataError), errors.Is(err, ProtectedMetadataKeyError):
+		writeMetadataError(w, err)
+	case errors.Is(err, UploadNotFoundError), errors.Is(err, MetadataKeyNotFoundError):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	default:
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update tags: "+err.Error())
+	}
+}
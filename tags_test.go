@@ -0,0 +1,142 @@
+package chunkeduploader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestConcurrentTagChanges(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		initial int
+		add     int
+		remove  int
+	}{
+		{name: "adds", add: 50},
+		{name: "adds and removes", initial: 25, add: 25, remove: 25},
+		{name: "up to the limit", add: maxTags},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs())
+			uploadId, err := service.CreateUpload(100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tc.initial; i++ {
+				if err := service.AddTag(context.Background(), uploadId, fmt.Sprintf("initial-%d", i), "value"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, tc.add+tc.remove)
+			for i := 0; i < tc.add; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs <- service.AddTag(context.Background(), uploadId, fmt.Sprintf("added-%d", i), fmt.Sprint(i))
+				}(i)
+			}
+			for i := 0; i < tc.remove; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs <- service.RemoveTag(context.Background(), uploadId, fmt.Sprintf("initial-%d", i))
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Error(err)
+				}
+			}
+
+			meta, err := service.GetMetadata(context.Background(), uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{}
+			for i := tc.remove; i < tc.initial; i++ {
+				want[fmt.Sprintf("initial-%d", i)] = "value"
+			}
+			for i := 0; i < tc.add; i++ {
+				want[fmt.Sprintf("added-%d", i)] = fmt.Sprint(i)
+			}
+			if !reflect.DeepEqual(meta.Tags, want) {
+				t.Errorf("tags %v, want %v", meta.Tags, want)
+			}
+		})
+	}
+}
+
+func TestTagHandlers(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	uploadId, err := service.CreateUpload(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := service.CreateUpload(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxTags; i++ {
+		if err := service.AddTag(context.Background(), full, fmt.Sprintf("tag-%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		method   string
+		uploadId string
+		path     string
+		body     string
+		want     int
+		wantTags map[string]string
+	}{
+		{name: "add", method: http.MethodPost, uploadId: uploadId, body: `{"key": "env", "value": "prod"}`, want: http.StatusNoContent, wantTags: map[string]string{"env": "prod"}},
+		{name: "add another", method: http.MethodPost, uploadId: uploadId, body: `{"key": "team_a-1", "value": "x"}`, want: http.StatusNoContent, wantTags: map[string]string{"env": "prod", "team_a-1": "x"}},
+		{name: "change", method: http.MethodPost, uploadId: uploadId, body: `{"key": "env", "value": "dev"}`, want: http.StatusNoContent, wantTags: map[string]string{"env": "dev", "team_a-1": "x"}},
+		{name: "remove", method: http.MethodDelete, uploadId: uploadId, path: "/env", want: http.StatusNoContent, wantTags: map[string]string{"team_a-1": "x"}},
+		{name: "remove missing", method: http.MethodDelete, uploadId: uploadId, path: "/env", want: http.StatusNotFound, wantTags: map[string]string{"team_a-1": "x"}},
+		{name: "empty key", method: http.MethodPost, uploadId: uploadId, body: `{"key": "", "value": "x"}`, want: http.StatusBadRequest},
+		{name: "key too long", method: http.MethodPost, uploadId: uploadId, body: `{"key": "` + strings.Repeat("k", 65) + `", "value": "x"}`, want: http.StatusBadRequest},
+		{name: "key with a dot", method: http.MethodPost, uploadId: uploadId, body: `{"key": "a.b", "value": "x"}`, want: http.StatusBadRequest},
+		{name: "protected key", method: http.MethodPost, uploadId: uploadId, body: `{"key": "owner", "value": "x"}`, want: http.StatusBadRequest},
+		{name: "remove protected key", method: http.MethodDelete, uploadId: uploadId, path: "/owner", want: http.StatusBadRequest},
+		{name: "invalid json", method: http.MethodPost, uploadId: uploadId, body: `{`, want: http.StatusBadRequest},
+		{name: "over the limit", method: http.MethodPost, uploadId: full, body: `{"key": "one-more", "value": "x"}`, want: http.StatusBadRequest},
+		{name: "change at the limit", method: http.MethodPost, uploadId: full, body: `{"key": "tag-0", "value": "changed"}`, want: http.StatusNoContent},
+		{name: "unknown upload", method: http.MethodPost, uploadId: "doesnotexist", body: `{"key": "env", "value": "prod"}`, want: http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(tc.method, "/"+tc.uploadId+"/tag"+tc.path, strings.NewReader(tc.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body)
+			continue
+		}
+		if tc.wantTags == nil {
+			continue
+		}
+
+		meta, err := service.GetMetadata(context.Background(), tc.uploadId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(meta.Tags, tc.wantTags) {
+			t.Errorf("%s: tags %v, want %v", tc.name, meta.Tags, tc.wantTags)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	defaultThumbnailSize = 200
+	maxThumbnailSize     = 1024
+	// maxThumbnailSourcePixels bounds the images decoded for thumbnails, as a small file can declare huge dimensions.
+	maxThumbnailSourcePixels = 64 << 20
+)
+
+var NotAnImageError = errors.New("upload is not an image")
+var ImageTooLargeError = errors.New("image is too large for a thumbnail")
+var InvalidThumbnailError = errors.New("invalid thumbnail")
+
+// thumbnailFormats are the formats thumbnails can be encoded in with their content types.
+var thumbnailFormats = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+}
+
+// Thumbnail is a thumbnail of an image upload.
+type Thumbnail struct {
+	Data        []byte
+	ContentType string
+	// ETag identifies the thumbnail of a given size and format of the current file of the upload.
+	ETag string
+}
+
+// GetThumbnail returns a thumbnail of a complete image upload fitting into width by height pixels, the image is
+// scaled keeping its aspect ratio and never enlarged. Thumbnails are cached in the snapshot directory of the upload,
+// so only the first request for a size and format decodes the image. Images are decoded from JPEG, PNG, GIF, WebP,
+// BMP and TIFF, thumbnails are encoded as JPEG, PNG or GIF.
+func (c *ChunkedUploaderService) GetThumbnail(ctx context.Context, uploadId string, width int, height int, format string) (*Thumbnail, error) {
+	contentType, ok := thumbnailFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetThumbnail %w: unsupported format %q", InvalidThumbnailError, format)
+	}
+	if width < 1 || height < 1 || width > maxThumbnailSize || height > maxThumbnailSize {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetThumbnail %w: width and height must be between 1 and %d", InvalidThumbnailError, maxThumbnailSize)
+	}
+
+	file, meta, err := c.openCompleteUpload(uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetThumbnail failed to open upload %w", err)
+	}
+	defer file.Close()
+
+	if meta == nil || !strings.HasPrefix(meta.ContentType, "image/") {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetThumbnail %w", NotAnImageError)
+	}
+
+	name := fmt.Sprintf("%dx%d.%s", width, height, format)
+	thumbnail := &Thumbnail{ContentType: contentType, ETag: strconv.Quote(meta.Checksum + "-" + name)}
+	path := filepath.Join(c.getThumbnailDirectory(uploadId), name)
+
+	thumbnail.Data, err = afero.ReadFile(c.fs, path)
+	if err == nil {
+		return thumbnail, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetThumbnail failed to read cached thumbnail %w", err)
+	}
+
+	err = c.backgroundRead(func() error {
+		thumbnail.Data, err = renderThumbnail(file, width, height, format)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.GetThumbnail failed to render thumbnail %w", err)
+	}
+
+	err = c.writeThumbnail(path, thumbnail.Data)
+	if err != nil {
+		// the thumbnail is still served, it is rendered again next time
+		c.log(LogLevelWarn, "Failed to cache thumbnail", LogField{"upload_id", uploadId}, LogField{"error", err})
+	}
+
+	return thumbnail, nil
+}
+
+// renderThumbnail decodes an image and encodes it scaled to fit into width by height pixels.
+func renderThumbnail(r io.ReadSeeker, width int, height int, format string) ([]byte, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", NotAnImageError, err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxThumbnailSourcePixels {
+		return nil, fmt.Errorf("%w: %dx%d", ImageTooLargeError, config.Width, config.Height)
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", NotAnImageError, err)
+	}
+
+	bounds := src.Bounds()
+	scale := min(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()), 1)
+	dst := image.NewRGBA(image.Rect(0, 0, max(int(float64(bounds.Dx())*scale), 1), max(int(float64(bounds.Dy())*scale), 1)))
+	draw.BiLinear.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	case "png":
+		err = png.Encode(&buf, dst)
+	case "gif":
+		err = gif.Encode(&buf, dst, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeThumbnail atomically writes a thumbnail to the cache, concurrent writers of the same thumbnail each use their
+// own temporary file.
+func (c *ChunkedUploaderService) writeThumbnail(path string, data []byte) error {
+	err := c.fs.MkdirAll(filepath.Dir(path), StandardAccess)
+	if err != nil {
+		return err
+	}
+
+	file, err := afero.TempFile(c.fs, filepath.Dir(path), ".thumbnail-*")
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = c.fs.Rename(tempPath, path)
+	}
+	if err != nil {
+		c.fs.Remove(tempPath)
+		return err
+	}
+
+	return nil
+}
+
+// ThumbnailHandler returns a thumbnail of a complete image upload. The width and height query parameters bound its
+// size and default to 200 pixels, format is jpeg, png or gif and defaults to jpeg. Like ExportUploadHandler it
+// requires a valid signature when signed downloads are enabled.
+func (c *ChunkedUploaderHandler) ThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	uploadId, ok := c.authorizeExport(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	width, height := defaultThumbnailSize, defaultThumbnailSize
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{{"width", &width}, {"height", &height}} {
+		if value := query.Get(param.name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid "+param.name)
+				return
+			}
+			*param.value = parsed
+		}
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "jpeg"
+	}
+
+	thumbnail, err := c.service.GetThumbnail(r.Context(), uploadId, width, height, format)
+	if err != nil {
+		switch {
+		case errors.Is(err, InvalidThumbnailError):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, NotAnImageError):
+			writeJSONError(w, http.StatusUnsupportedMediaType, err.Error())
+		case errors.Is(err, ImageTooLargeError):
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		default:
+			writeExportError(w, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", thumbnail.ETag)
+	// thumbnails have no modification time, only If-None-Match applies
+	if r.Header.Get("If-None-Match") != "" && notModified(r, thumbnail.ETag, time.Time{}) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", thumbnail.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(thumbnail.Data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(thumbnail.Data)
+}
@@ -0,0 +1,121 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var VerificationDeadlineExceededError = errors.New("verification did not finish before the deadline")
+
+// Names of the handlers with a timeout, see WithHandlerTimeouts.
+const (
+	HandlerCreateUpload = "create_upload"
+	HandlerUploadChunk  = "upload_chunk"
+	HandlerFinishUpload = "finish_upload"
+	// HandlerIntegrityReport reads the whole file of an upload, see IntegrityReportHandler.
+	HandlerIntegrityReport = "integrity_report"
+)
+
+var defaultHandlerTimeouts = map[string]time.Duration{
+	HandlerCreateUpload:    5 * time.Second,
+	HandlerUploadChunk:     60 * time.Second,
+	HandlerFinishUpload:    300 * time.Second,
+	HandlerIntegrityReport: 300 * time.Second,
+}
+
+// WithHandlerTimeouts overrides the timeouts of the handlers by name, a zero timeout disables it. Requests running
+// over their timeout are answered with 503.
+func WithHandlerTimeouts(timeouts map[string]time.Duration) ChunkedUploaderHandlerOption {
+	return func(c *ChunkedUploaderHandler) {
+		for name, timeout := range timeouts {
+			c.timeouts[name] = timeout
+		}
+	}
+}
+
+// withHandlerTimeout derives the request context with the timeout of a given handler. Reading the request body is
+// limited by the same deadline.
+func (c *ChunkedUploaderHandler) withHandlerTimeout(w http.ResponseWriter, r *http.Request, name string) (*http.Request, context.CancelFunc) {
+	timeout := c.timeouts[name]
+	if timeout <= 0 {
+		return r, func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	// not every ResponseWriter supports deadlines, the context still ends the work which checks it
+	_ = http.NewResponseController(w).SetReadDeadline(time.Now().Add(timeout))
+
+	return r.WithContext(ctx), cancel
+}
+
+// timedOut reports whether the handler timeout of a request has passed.
+func timedOut(r *http.Request) bool {
+	return errors.Is(r.Context().Err(), context.DeadlineExceeded)
+}
+
+func writeTimeoutError(w http.ResponseWriter, name string) {
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   "handler timed out",
+		"code":    "handler_timeout",
+		"handler": name,
+	})
+}
+
+type verificationDeadlineKey struct{}
+
+// WithVerificationDeadline bounds the checksum verification of a finish called with the returned context. Unlike a
+// deadline of the context itself it does not apply to the rest of the finish, so an upload which could not be verified
+// in time is left intact for a later retry.
+func WithVerificationDeadline(ctx context.Context, deadline time.Time) context.Context {
+	return context.WithValue(ctx, verificationDeadlineKey{}, deadline)
+}
+
+func verificationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Value(verificationDeadlineKey{}).(time.Time)
+	if !ok {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// parseFinalizeDeadline reads the X-Finalize-Deadline header or the finalize_deadline query parameter, either a
+// duration like 5s or an RFC 3339 time.
+func parseFinalizeDeadline(r *http.Request) (deadline time.Time, ok bool, err error) {
+	value := r.Header.Get("X-Finalize-Deadline")
+	if value == "" {
+		value = r.URL.Query().Get("finalize_deadline")
+	}
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+
+	if budget, err := time.ParseDuration(value); err == nil && budget > 0 {
+		return time.Now().Add(budget), true, nil
+	}
+
+	deadline, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid finalize deadline %q", value)
+	}
+
+	return deadline, true, nil
+}
+
+func (c *ChunkedUploaderHandler) writeVerificationDeadlineError(w http.ResponseWriter, r *http.Request, uploadId string) {
+	response := map[string]string{
+		"error":     VerificationDeadlineExceededError.Error(),
+		"code":      "finalize_deadline_exceeded",
+		"upload_id": uploadId,
+	}
+	if meta, err := c.service.GetMetadata(r.Context(), uploadId); err == nil {
+		response["state"] = string(meta.State)
+	}
+
+	w.WriteHeader(http.StatusGatewayTimeout)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,110 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// slowFs delays opening files, like a filesystem under heavy load.
+type slowFs struct {
+	afero.Fs
+	delay time.Duration
+}
+
+func (fs *slowFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	time.Sleep(fs.delay)
+	return fs.Fs.OpenFile(name, flag, perm)
+}
+
+// stallingReader stalls and then fails, like a request body read running into its deadline.
+type stallingReader struct {
+	delay time.Duration
+}
+
+func (r *stallingReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestHandlerTimeouts(t *testing.T) {
+	const delay = 50 * time.Millisecond
+
+	for _, tc := range []struct {
+		name     string
+		handler  string
+		timeouts map[string]time.Duration
+		wantCode int
+	}{
+		{name: "create", handler: HandlerCreateUpload, timeouts: map[string]time.Duration{HandlerCreateUpload: 10 * time.Millisecond}, wantCode: http.StatusServiceUnavailable},
+		{name: "create without timeout", handler: HandlerCreateUpload, timeouts: map[string]time.Duration{HandlerCreateUpload: 0}, wantCode: http.StatusCreated},
+		{name: "chunk", handler: HandlerUploadChunk, timeouts: map[string]time.Duration{HandlerUploadChunk: 10 * time.Millisecond}, wantCode: http.StatusServiceUnavailable},
+		{name: "chunk with the timeout of another handler", handler: HandlerUploadChunk, timeouts: map[string]time.Duration{HandlerFinishUpload: 10 * time.Millisecond}, wantCode: http.StatusInternalServerError},
+		{name: "finish", handler: HandlerFinishUpload, timeouts: map[string]time.Duration{HandlerFinishUpload: 10 * time.Millisecond}, wantCode: http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			var opts []ChunkedUploaderServiceOption
+			if tc.handler == HandlerFinishUpload {
+				opts = append(opts, WithScanner(func(ctx context.Context, uploadId string, file io.Reader) error {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-time.After(delay):
+						return nil
+					}
+				}))
+			}
+			service := newTestService(fs, opts...)
+			handler := NewHTTPHandler(service, WithHandlerTimeouts(tc.timeouts))
+
+			data := randomBytes(t, 100)
+			uploadId, err := service.CreateUpload(int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var req *http.Request
+			switch tc.handler {
+			case HandlerCreateUpload:
+				service.fs = &slowFs{Fs: fs, delay: delay}
+				req = httptest.NewRequest(http.MethodPost, "/init", strings.NewReader(`{"file_size": 100}`))
+			case HandlerUploadChunk:
+				req = httptest.NewRequest(http.MethodPost, "/"+uploadId+"/upload", &stallingReader{delay: delay})
+				req.Header.Set("Content-Type", "application/octet-stream")
+			case HandlerFinishUpload:
+				if _, err := service.UploadChunk(uploadId, bytes.NewReader(data), 0); err != nil {
+					t.Fatal(err)
+				}
+				req = httptest.NewRequest(http.MethodPost, "/"+uploadId+"/finish", strings.NewReader(fmt.Sprintf(`{"checksum": %q}`, sha256Hex(data))))
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tc.wantCode {
+				t.Fatalf("%s: %d %s, want %d", tc.handler, rec.Code, rec.Body, tc.wantCode)
+			}
+			if tc.wantCode != http.StatusServiceUnavailable {
+				return
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp["code"] != "handler_timeout" || resp["handler"] != tc.handler {
+				t.Errorf("response: got %v, want handler_timeout of %s", resp, tc.handler)
+			}
+		})
+	}
+}
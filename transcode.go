@@ -0,0 +1,200 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var FinalizeCommandFailedError = errors.New("finalize command failed")
+
+// finalizeStderrLimit is the number of bytes of the standard error of a failed finalize command reported back.
+const finalizeStderrLimit = 4096
+
+// FinalizeCommand describes an external command the verified file of every upload is piped through by
+// FinishUpload, for example to transcode videos. The file is streamed to the standard input of the command and its
+// standard output replaces the file of the upload.
+type FinalizeCommand struct {
+	// Name is the program to run and Args its arguments. Both are text/template templates executed with the metadata
+	// of the upload, like {{.UploadId}} or {{.Filename}}.
+	Name string
+	Args []string
+	// Timeout bounds a single run of the command, zero leaves it bounded only by the context of the finish.
+	Timeout time.Duration
+	// KeepOriginal keeps the uploaded file next to the output, see UploadMetadata.OriginalPath. It is removed
+	// together with the upload.
+	KeepOriginal bool
+	// ContentType replaces the content type of the upload when it is set.
+	ContentType string
+}
+
+// WithFinalizeCommand pipes every upload through a given command after it was verified. A command exiting with a
+// non-zero code fails the finish with FinalizeCommandFailedError and leaves the uploaded file untouched. The checksum
+// recorded for the upload is the one of the output, the verified one is kept as OriginalChecksum.
+func WithFinalizeCommand(command FinalizeCommand) ChunkedUploaderServiceOption {
+	name, err := template.New("name").Parse(command.Name)
+	if err != nil || command.Name == "" {
+		panic("chunkeduploader: invalid finalize command " + command.Name)
+	}
+	args := make([]*template.Template, len(command.Args))
+	for i, arg := range command.Args {
+		args[i], err = template.New("arg").Parse(arg)
+		if err != nil {
+			panic("chunkeduploader: invalid finalize command argument " + arg)
+		}
+	}
+
+	return func(c *ChunkedUploaderService) {
+		c.finalizeCommand = &finalizeCommand{FinalizeCommand: command, name: name, args: args}
+	}
+}
+
+type finalizeCommand struct {
+	FinalizeCommand
+	name *template.Template
+	args []*template.Template
+}
+
+// command returns the command line for a given upload.
+func (f *finalizeCommand) command(meta *UploadMetadata) (string, []string, error) {
+	execute := func(t *template.Template) (string, error) {
+		var b strings.Builder
+		err := t.Execute(&b, meta)
+		return b.String(), err
+	}
+
+	name, err := execute(f.name)
+	if err != nil {
+		return "", nil, err
+	}
+	args := make([]string, len(f.args))
+	for i, arg := range f.args {
+		args[i], err = execute(arg)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	return name, args, nil
+}
+
+// finalizeResult describes the file a finalize command produced.
+type finalizeResult struct {
+	size         int64
+	originalPath string
+}
+
+// runFinalizeCommand pipes the pending file of a given upload through the finalize command and puts the output in its
+// place. The output is written to the snapshot directory of the upload first, so a failed run changes nothing.
+func (c *ChunkedUploaderService) runFinalizeCommand(ctx context.Context, uploadId string) (*finalizeResult, error) {
+	f := c.finalizeCommand
+
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return nil, err
+	}
+	name, args, err := f.command(meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build finalize command %w", err)
+	}
+
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+
+	pendingPath := c.getUploadFilePath(uploadId)
+	input, err := c.fs.Open(pendingPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pending file %w", err)
+	}
+	defer input.Close()
+
+	snapshotDir := c.getSnapshotDirectory(uploadId)
+	outputPath := filepath.Join(snapshotDir, ".finalizing")
+	output, err := openFile(c.fs, outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, StandardAccess)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file %w", err)
+	}
+
+	stderr := &tailBuffer{limit: finalizeStderrLimit}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = input
+	cmd.Stdout = output
+	cmd.Stderr = stderr
+
+	runErr := cmd.Run()
+	closeErr := output.Close()
+	if runErr != nil {
+		c.fs.Remove(outputPath)
+		var exitErr *exec.ExitError
+		if errors.As(runErr, &exitErr) {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("%w: %s", FinalizeCommandFailedError, ctx.Err())
+			}
+			return nil, fmt.Errorf("%w: exit code %d: %s", FinalizeCommandFailedError, exitErr.ExitCode(), strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("%w: %s", FinalizeCommandFailedError, runErr)
+	}
+	if closeErr != nil {
+		c.fs.Remove(outputPath)
+		return nil, fmt.Errorf("failed to close output file %w", closeErr)
+	}
+
+	info, err := c.fs.Stat(outputPath)
+	if err != nil {
+		c.fs.Remove(outputPath)
+		return nil, fmt.Errorf("failed to stat output file %w", err)
+	}
+
+	result := &finalizeResult{size: info.Size()}
+	if f.KeepOriginal {
+		result.originalPath = filepath.Join(snapshotDir, ".original")
+		err = c.fs.Rename(pendingPath, result.originalPath)
+		if err != nil {
+			c.fs.Remove(outputPath)
+			return nil, fmt.Errorf("failed to keep original file %w", err)
+		}
+	}
+
+	err = c.fs.Rename(outputPath, pendingPath)
+	if err != nil {
+		if result.originalPath != "" {
+			c.fs.Rename(result.originalPath, pendingPath)
+		}
+		c.fs.Remove(outputPath)
+		return nil, fmt.Errorf("failed to replace pending file %w", err)
+	}
+
+	return result, nil
+}
+
+// tailBuffer keeps the last limit bytes written to it.
+type tailBuffer struct {
+	limit int
+	buf   bytes.Buffer
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) > t.limit {
+		p = p[len(p)-t.limit:]
+	}
+	if overflow := t.buf.Len() + len(p) - t.limit; overflow > 0 {
+		t.buf.Next(overflow)
+	}
+	t.buf.Write(p)
+	return n, nil
+}
+
+func (t *tailBuffer) String() string {
+	return t.buf.String()
+}
@@ -0,0 +1,81 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+var NotUploadOwnerError = errors.New("not the owner of the upload")
+
+// TransferUpload hands a complete upload from one owner to another. The upload must be owned by fromOwner.
+func (c *ChunkedUploaderService) TransferUpload(ctx context.Context, uploadId string, fromOwner string, toOwner string) error {
+	if toOwner == "" {
+		return fmt.Errorf("ChunkedUploaderService.TransferUpload %w - the new owner is empty", InvalidMetadataError)
+	}
+
+	err := c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		if meta.Owner != fromOwner {
+			return NotUploadOwnerError
+		}
+		if meta.State != UploadStateComplete {
+			return UploadNotCompleteError
+		}
+		meta.Owner = toOwner
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.TransferUpload failed to update metadata %w", err)
+	}
+
+	c.log(LogLevelInfo, "Transferred upload", LogField{"upload_id", uploadId}, LogField{"from", fromOwner}, LogField{"to", toOwner})
+	c.emit(EventUploadTransferred, uploadId, map[string]string{"from": fromOwner, "to": toOwner})
+
+	return nil
+}
+
+type TransferUploadRequest struct {
+	To string `json:"to_tenant_id"`
+}
+
+// TransferUploadHandler hands a complete upload of the caller to another owner, it requires WithOwner.
+func (c *ChunkedUploaderHandler) TransferUploadHandler(w http.ResponseWriter, r *http.Request) {
+	uploadId := mux.Vars(r)["upload_id"]
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	if c.ownerOf == nil {
+		writeJSONError(w, http.StatusForbidden, "uploads have no owners")
+		return
+	}
+
+	var req TransferUploadRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.To == "" {
+		writeJSONError(w, http.StatusBadRequest, "to_tenant_id is required")
+		return
+	}
+
+	err = c.service.TransferUpload(r.Context(), uploadId, c.ownerOf(r), req.To)
+	if err != nil {
+		switch {
+		case errors.Is(err, NotUploadOwnerError):
+			writeJSONError(w, http.StatusForbidden, err.Error())
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, UploadNotCompleteError):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to transfer upload: "+err.Error())
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
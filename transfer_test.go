@@ -0,0 +1,98 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestTransferUpload(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		caller   string
+		body     string
+		finished bool
+		uploadId string
+		wantCode int
+	}{
+		{name: "owner", caller: "alice", body: `{"to_tenant_id": "bob"}`, finished: true, wantCode: http.StatusNoContent},
+		{name: "other caller", caller: "bob", body: `{"to_tenant_id": "bob"}`, finished: true, wantCode: http.StatusForbidden},
+		{name: "unfinished upload", caller: "alice", body: `{"to_tenant_id": "bob"}`, wantCode: http.StatusConflict},
+		{name: "no new owner", caller: "alice", body: `{}`, finished: true, wantCode: http.StatusBadRequest},
+		{name: "unknown upload", caller: "alice", body: `{"to_tenant_id": "bob"}`, uploadId: "0123456789abcdef0123456789abcdef", wantCode: http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var events []Event
+			fs := afero.NewMemMapFs()
+			service := newTestService(fs, WithEventHook(func(event Event) {
+				if event.Type == EventUploadTransferred {
+					events = append(events, event)
+				}
+			}))
+			handler := NewHTTPHandler(service, WithOwner(func(r *http.Request) string {
+				return r.Header.Get("X-Owner")
+			}))
+
+			var uploadId string
+			var data []byte
+			if tc.finished {
+				uploadId, data = newExportTestUpload(t, service, 1024, WithUploadOwner("alice"))
+			} else {
+				var err error
+				uploadId, err = service.CreateUpload(1024, WithUploadOwner("alice"))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			pathBefore, _ := service.UploadedFilePath(uploadId)
+			if tc.uploadId != "" {
+				uploadId = tc.uploadId
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/"+uploadId+"/transfer", strings.NewReader(tc.body))
+			req.Header.Set("X-Owner", tc.caller)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tc.wantCode {
+				t.Fatalf("transfer: %d %s, want %d", rec.Code, rec.Body, tc.wantCode)
+			}
+			if tc.uploadId != "" {
+				return
+			}
+
+			wantOwner, wantEvents := "alice", 0
+			if tc.wantCode == http.StatusNoContent {
+				wantOwner, wantEvents = "bob", 1
+			}
+			meta, err := service.readMetadata(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Owner != wantOwner {
+				t.Errorf("owner: got %q, want %q", meta.Owner, wantOwner)
+			}
+			if len(events) != wantEvents {
+				t.Fatalf("%d transfer events, want %d", len(events), wantEvents)
+			}
+			if wantEvents > 0 && (events[0].UploadId != uploadId || events[0].Data["from"] != "alice" || events[0].Data["to"] != "bob") {
+				t.Errorf("event: got %+v, want a transfer of %s from alice to bob", events[0], uploadId)
+			}
+
+			if !tc.finished {
+				return
+			}
+			// pending paths are derived from the upload id alone, so the file stays where it is
+			path, err := service.UploadedFilePath(uploadId)
+			if err != nil || path != pathBefore {
+				t.Fatalf("path: got %q %v, want %q", path, err, pathBefore)
+			}
+			if got, err := afero.ReadFile(fs, path); err != nil || !bytes.Equal(got, data) {
+				t.Errorf("file: %d bytes %v, want the uploaded data", len(got), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package chunkeduploader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+)
+
+// WithTreeHashLeaves makes the service record the SHA-256 digests of the 1 MiB leaves of every chunk it writes, so
+// an upload finished with the "sha256-tree" algorithm is verified without reading the whole file again. Leaves which
+// were only partially written by a chunk, like the ones straddling the chunk boundaries or the last leaf of the file,
+// are read back from the file at finish.
+func WithTreeHashLeaves() ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.treeLeaves = true
+	}
+}
+
+// leafHasher hashes the bytes written at a given offset into tree hash leaves, it only keeps the digests of leaves
+// written from their first to their last byte.
+type leafHasher struct {
+	pos    int64
+	leaf   int64
+	filled int64
+	hash   hash.Hash
+	leaves map[int64]string
+}
+
+func newLeafHasher(offset int64) *leafHasher {
+	return &leafHasher{pos: offset, leaf: -1, hash: sha256.New(), leaves: map[int64]string{}}
+}
+
+func (l *leafHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		inLeaf := l.pos % utils.TreeHashLeafSize
+		take := utils.TreeHashLeafSize - inLeaf
+		if take > int64(len(p)) {
+			take = int64(len(p))
+		}
+
+		if inLeaf == 0 {
+			l.leaf = l.pos / utils.TreeHashLeafSize
+			l.filled = 0
+			l.hash.Reset()
+		}
+		if l.leaf == l.pos/utils.TreeHashLeafSize {
+			l.hash.Write(p[:take])
+			l.filled += take
+			if l.filled == utils.TreeHashLeafSize {
+				l.leaves[l.leaf] = hex.EncodeToString(l.hash.Sum(nil))
+			}
+		}
+
+		l.pos += take
+		p = p[take:]
+	}
+	return n, nil
+}
+
+// addTreeLeaves records the leaf digests of a written region, the leaves the region touched without a digest are
+// forgotten so they are read back from the file at finish.
+func addTreeLeaves(leaves []string, region ByteRange, digests map[int64]string) []string {
+	first := region.Start / utils.TreeHashLeafSize
+	last := region.End / utils.TreeHashLeafSize
+
+	for int64(len(leaves)) <= last {
+		leaves = append(leaves, "")
+	}
+	for i := first; i <= last; i++ {
+		leaves[i] = digests[i]
+	}
+
+	return leaves
+}
+
+// computeTreeHash computes the tree hash of the pending file of a given upload from the recorded leaf digests,
+// reading only the leaves without a digest from the file.
+func (c *ChunkedUploaderService) computeTreeHash(ctx context.Context, uploadId string) (checksum string, err error) {
+	err = c.backgroundRead(func() error {
+		checksum, err = c.computeTreeHashLeaves(ctx, uploadId)
+		return err
+	})
+	return checksum, err
+}
+
+func (c *ChunkedUploaderService) computeTreeHashLeaves(ctx context.Context, uploadId string) (string, error) {
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.computeTreeHash failed to read metadata %w", err)
+	}
+
+	file, err := c.fs.Open(c.getUploadFilePath(uploadId))
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.computeTreeHash failed to open file %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("ChunkedUploaderService.computeTreeHash failed to stat file %w", err)
+	}
+
+	count := (info.Size() + utils.TreeHashLeafSize - 1) / utils.TreeHashLeafSize
+	leaves := make([][]byte, 0, count)
+	for i := int64(0); i < count; i++ {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		full := (i+1)*utils.TreeHashLeafSize <= info.Size()
+		if full && i < int64(len(meta.TreeLeaves)) && meta.TreeLeaves[i] != "" {
+			digest, err := hex.DecodeString(meta.TreeLeaves[i])
+			if err == nil {
+				leaves = append(leaves, digest)
+				continue
+			}
+		}
+
+		h := sha256.New()
+		leaf := io.NewSectionReader(file, i*utils.TreeHashLeafSize, utils.TreeHashLeafSize)
+		_, err = io.Copy(h, utils.NewThrottledReader(ctx, leaf, c.backgroundReadRate()))
+		if err != nil {
+			return "", fmt.Errorf("ChunkedUploaderService.computeTreeHash failed to read leaf %d %w", i, err)
+		}
+		leaves = append(leaves, h.Sum(nil))
+	}
+
+	return hex.EncodeToString(utils.CombineTreeHash(leaves)), nil
+}
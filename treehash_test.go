@@ -0,0 +1,79 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+	"github.com/spf13/afero"
+)
+
+func treeHashHex(data []byte) string {
+	h := utils.NewTreeHash()
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func TestTreeHashLeaves(t *testing.T) {
+	const size = 3*utils.TreeHashLeafSize + 100
+
+	for _, tc := range []struct {
+		name      string
+		chunkSize int
+		// overwrite is written over the uploaded data at offset overwriteAt before finishing
+		overwrite   []byte
+		overwriteAt int64
+		// wantLeaves reports which leaves have a recorded digest before finishing
+		wantLeaves []bool
+	}{
+		{name: "aligned chunks", chunkSize: utils.TreeHashLeafSize, wantLeaves: []bool{true, true, true, false}},
+		{name: "chunks straddling leaves", chunkSize: 700 << 10, wantLeaves: []bool{false, false, false, false}},
+		{name: "whole file in one chunk", chunkSize: size, wantLeaves: []bool{true, true, true, false}},
+		{name: "overwritten range", chunkSize: utils.TreeHashLeafSize, overwrite: bytes.Repeat([]byte{'x'}, 100), overwriteAt: utils.TreeHashLeafSize + 10, wantLeaves: []bool{true, false, true, false}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs(), WithTreeHashLeaves())
+			data := randomBytes(t, size)
+			uploadId, err := service.CreateUpload(size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for offset := 0; offset < len(data); offset += tc.chunkSize {
+				chunk := data[offset:min(offset+tc.chunkSize, len(data))]
+				if _, err := service.UploadChunk(uploadId, bytes.NewReader(chunk), int64(offset)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.overwrite != nil {
+				if _, err := service.UploadChunk(uploadId, bytes.NewReader(tc.overwrite), tc.overwriteAt); err != nil {
+					t.Fatal(err)
+				}
+				copy(data[tc.overwriteAt:], tc.overwrite)
+			}
+
+			meta, err := service.readMetadata(uploadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range tc.wantLeaves {
+				var got string
+				if i < len(meta.TreeLeaves) {
+					got = meta.TreeLeaves[i]
+				}
+				if want && got != sha256Hex(data[i*utils.TreeHashLeafSize:(i+1)*utils.TreeHashLeafSize]) {
+					t.Errorf("leaf %d: got %q, want the digest of the leaf", i, got)
+				}
+				if !want && got != "" {
+					t.Errorf("leaf %d: got %q, want no digest", i, got)
+				}
+			}
+
+			_, err = service.FinishUploadWithAlgorithm(context.Background(), uploadId, treeHashHex(data), utils.ChecksumSHA256Tree)
+			if err != nil {
+				t.Errorf("finish with the tree hash: %v", err)
+			}
+		})
+	}
+}
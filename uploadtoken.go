@@ -0,0 +1,217 @@
+package chunkeduploader
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var UploadTokensDisabledError = errors.New("upload tokens are not enabled")
+var UploadTokenNotIssuedError = errors.New("upload has no token")
+
+// uploadTokenHeader carries the upload token, clients which cannot set headers send the upload_token query
+// parameter instead.
+const uploadTokenHeader = "X-Upload-Token"
+
+// uploadTokenRoutes are the routes which require the token of their upload with WithUploadTokens, keyed by method and
+// path.
+var uploadTokenRoutes = map[string]bool{
+	"POST /{upload_id}/upload": true,
+	"GET /{upload_id}/ws":      true,
+	"GET /{upload_id}/status":  true,
+	"POST /{upload_id}/finish": true,
+	"POST /{upload_id}/extend": true,
+	"DELETE /{upload_id}":      true,
+}
+
+// UploadToken is a bearer token scoped to a single upload, see WithUploadTokens.
+type UploadToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// WithUploadTokens issues a random token valid for a given duration with every new upload, only its hash is kept
+// in the metadata. The handler then requires the token of the upload, in the X-Upload-Token header or the
+// upload_token query parameter for EventSource and WebSocket clients, to send chunks, read the status, finish or
+// cancel it. The token is only required from requests rejected by WithAuthenticator, so browsers do not need the
+// credentials of the rest of the API while other clients keep using them. Uploads created before the tokens were
+// enabled have none and are checked by the authenticator alone. The extend endpoint rotates the token, expired tokens
+// are rejected with 401 and the code "token_expired".
+func WithUploadTokens(validFor time.Duration) ChunkedUploaderServiceOption {
+	return func(c *ChunkedUploaderService) {
+		c.uploadTokenTTL = validFor
+	}
+}
+
+// WithIssuedUploadToken receives the token issued for the new upload with WithUploadTokens, it is left empty
+// otherwise.
+func WithIssuedUploadToken(token *UploadToken) CreateUploadOption {
+	return func(m *UploadMetadata) {
+		m.issuedToken = token
+	}
+}
+
+// hashUploadToken returns the hex digest of a token stored in the metadata.
+func hashUploadToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueUploadToken generates a new token for an upload, replacing the previous one.
+func (c *ChunkedUploaderService) issueUploadToken(meta *UploadMetadata) (*UploadToken, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	token := &UploadToken{
+		Token:     base64.RawURLEncoding.EncodeToString(random),
+		ExpiresAt: time.Now().Add(c.uploadTokenTTL),
+	}
+	meta.TokenHash = hashUploadToken(token.Token)
+	meta.TokenExpiresAt = &token.ExpiresAt
+	return token, nil
+}
+
+// RotateUploadToken issues a new token for a given upload, the previous one stops being valid.
+func (c *ChunkedUploaderService) RotateUploadToken(ctx context.Context, uploadId string) (*UploadToken, error) {
+	if c.uploadTokenTTL <= 0 {
+		return nil, UploadTokensDisabledError
+	}
+
+	var token *UploadToken
+	err := c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		var err error
+		token, err = c.issueUploadToken(meta)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.RotateUploadToken failed to update metadata %w", err)
+	}
+
+	return token, nil
+}
+
+// VerifyUploadToken checks a token against the one issued for a given upload. It fails with
+// UploadTokenNotIssuedError for uploads without one, with InvalidTokenError for any other token and with
+// ExpiredTokenError once the token has expired.
+func (c *ChunkedUploaderService) VerifyUploadToken(ctx context.Context, uploadId string, token string) error {
+	meta, err := c.readMetadata(uploadId)
+	if err != nil {
+		return fmt.Errorf("ChunkedUploaderService.VerifyUploadToken failed to read metadata %w", err)
+	}
+	if meta.TokenHash == "" {
+		return UploadTokenNotIssuedError
+	}
+
+	expected, err := hex.DecodeString(meta.TokenHash)
+	if err != nil || len(expected) == 0 || token == "" {
+		return InvalidTokenError
+	}
+	sum := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(sum[:], expected) != 1 {
+		return InvalidTokenError
+	}
+
+	if meta.TokenExpiresAt == nil || time.Now().After(*meta.TokenExpiresAt) {
+		return ExpiredTokenError
+	}
+
+	return nil
+}
+
+// uploadTokenOf returns the upload token sent with a request, in its header or in the query. The Authorization
+// header is left to the authenticator.
+func uploadTokenOf(r *http.Request) string {
+	if token := r.Header.Get(uploadTokenHeader); token != "" {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("upload_token")
+}
+
+// authenticatedKey marks the context of a request which passed the authenticator.
+type authenticatedKey struct{}
+
+// uploadTokenHandler requires the token of the upload of the route from requests which did not pass the
+// authenticator before calling the next handler.
+type uploadTokenHandler struct {
+	c    *ChunkedUploaderHandler
+	next http.Handler
+}
+
+func (h *uploadTokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if authenticated, _ := r.Context().Value(authenticatedKey{}).(bool); authenticated {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	token := uploadTokenOf(r)
+	if token == "" && h.c.authenticate != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	err := h.c.service.VerifyUploadToken(r.Context(), mux.Vars(r)["upload_id"], token)
+	if err != nil {
+		switch {
+		case errors.Is(err, UploadTokenNotIssuedError):
+			// only the authenticator can check the request, and it already rejected it if there is one
+			if h.c.authenticate != nil {
+				writeJSONError(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+			h.next.ServeHTTP(w, r)
+		case token == "":
+			writeUploadTokenError(w, http.StatusUnauthorized, "upload token required", "token_required")
+		case errors.Is(err, ExpiredTokenError):
+			writeUploadTokenError(w, http.StatusUnauthorized, err.Error(), "token_expired")
+		case errors.Is(err, InvalidTokenError):
+			writeUploadTokenError(w, http.StatusForbidden, err.Error(), "invalid_token")
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to verify upload token: "+err.Error())
+		}
+		return
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// uploadTokenRoute wraps the handler of a route with the upload token check when it requires one.
+func (c *ChunkedUploaderHandler) uploadTokenRoute(method string, path string, handler http.Handler) http.Handler {
+	if c.service.uploadTokenTTL <= 0 || !uploadTokenRoutes[method+" "+path] {
+		return handler
+	}
+	return &uploadTokenHandler{c: c, next: handler}
+}
+
+// checksUploadToken reports whether the route of a request checks the upload token, such requests are passed on
+// by the authenticator even if it rejects them.
+func checksUploadToken(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	_, ok := route.GetHandler().(*uploadTokenHandler)
+	return ok
+}
+
+func writeUploadTokenError(w http.ResponseWriter, status int, message string, code string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="upload"`)
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message, "code": code})
+}
@@ -0,0 +1,94 @@
+package chunkeduploader
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestUploadTokenRoutes(t *testing.T) {
+	const apiKey = "Bearer api-key"
+	authenticator := func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == apiKey
+	}
+
+	for _, tc := range []struct {
+		name         string
+		authenticate bool
+		// untokened uploads were created before the tokens were enabled
+		untokened bool
+		expired   bool
+		// header and query are sent with the request, {token} is replaced with the token of the upload
+		header   map[string]string
+		query    string
+		want     int
+		wantCode string
+	}{
+		{"no token", false, false, false, nil, "", http.StatusUnauthorized, "token_required"},
+		{"token header", false, false, false, map[string]string{"X-Upload-Token": "{token}"}, "", http.StatusOK, ""},
+		{"token query", false, false, false, nil, "{token}", http.StatusOK, ""},
+		{"wrong token", false, false, false, map[string]string{"X-Upload-Token": "wrong"}, "", http.StatusForbidden, "invalid_token"},
+		{"bearer is not a token", false, false, false, map[string]string{"Authorization": "Bearer {token}"}, "", http.StatusUnauthorized, "token_required"},
+		{"expired token", false, false, true, map[string]string{"X-Upload-Token": "{token}"}, "", http.StatusUnauthorized, "token_expired"},
+		{"authenticated without token", true, false, false, map[string]string{"Authorization": apiKey}, "", http.StatusOK, ""},
+		{"authenticated with wrong token", true, false, false, map[string]string{"Authorization": apiKey, "X-Upload-Token": "wrong"}, "", http.StatusOK, ""},
+		{"token instead of authentication", true, false, false, map[string]string{"X-Upload-Token": "{token}"}, "", http.StatusOK, ""},
+		{"neither authentication nor token", true, false, false, nil, "", http.StatusUnauthorized, ""},
+		{"wrong token without authentication", true, false, false, nil, "wrong", http.StatusForbidden, "invalid_token"},
+		{"untokened upload", false, true, false, nil, "", http.StatusOK, ""},
+		{"untokened upload with authentication", true, true, false, map[string]string{"Authorization": apiKey}, "", http.StatusOK, ""},
+		{"untokened upload without authentication", true, true, false, map[string]string{"X-Upload-Token": "anything"}, "", http.StatusUnauthorized, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs(), WithUploadTokens(time.Hour))
+			var opts []ChunkedUploaderHandlerOption
+			if tc.authenticate {
+				opts = append(opts, WithAuthenticator(authenticator))
+			}
+			handler := NewHTTPHandler(service, opts...)
+
+			var token UploadToken
+			uploadId, err := service.CreateUpload(-1, WithIssuedUploadToken(&token))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.untokened || tc.expired {
+				err := service.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+					if tc.untokened {
+						meta.TokenHash = ""
+						meta.TokenExpiresAt = nil
+					} else {
+						expiredAt := time.Now().Add(-time.Minute)
+						meta.TokenExpiresAt = &expiredAt
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			target := "/" + uploadId + "/status"
+			if tc.query != "" {
+				target += "?upload_token=" + strings.ReplaceAll(tc.query, "{token}", token.Token)
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			for name, value := range tc.header {
+				req.Header.Set(name, strings.ReplaceAll(value, "{token}", token.Token))
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			var body map[string]interface{}
+			json.NewDecoder(rec.Body).Decode(&body)
+			if rec.Code != tc.want || (tc.wantCode != "" && body["code"] != tc.wantCode) {
+				t.Errorf("got %d %v, want %d %s", rec.Code, body, tc.want, tc.wantCode)
+			}
+		})
+	}
+}
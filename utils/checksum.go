@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+)
+
+type ChecksumAlgorithm string
+
+const (
+	// ChecksumSHA256 is the default algorithm, its checksums are hex encoded.
+	ChecksumSHA256 ChecksumAlgorithm = "sha256"
+	// ChecksumCRC32C is CRC32 with the Castagnoli polynomial, its checksums are the base64 encoded big-endian bytes,
+	// the same format Google Cloud Storage uses.
+	ChecksumCRC32C ChecksumAlgorithm = "crc32c"
+	// ChecksumSHA256Tree is a SHA-256 tree hash over 1 MiB leaves, see NewTreeHash. Its checksums are hex encoded.
+	ChecksumSHA256Tree ChecksumAlgorithm = "sha256-tree"
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Valid reports whether the algorithm is supported.
+func (a ChecksumAlgorithm) Valid() bool {
+	return a == ChecksumSHA256 || a == ChecksumCRC32C || a == ChecksumSHA256Tree
+}
+
+func (a ChecksumAlgorithm) newHash() hash.Hash {
+	switch a {
+	case ChecksumCRC32C:
+		return crc32.New(castagnoliTable)
+	case ChecksumSHA256Tree:
+		return NewTreeHash()
+	default:
+		return sha256.New()
+	}
+}
+
+func (a ChecksumAlgorithm) encode(sum []byte) string {
+	if a == ChecksumCRC32C {
+		return base64.StdEncoding.EncodeToString(sum)
+	}
+	return hex.EncodeToString(sum)
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestChecksumVectors(t *testing.T) {
+	for _, tc := range []struct {
+		algorithm ChecksumAlgorithm
+		data      string
+		want      string
+	}{
+		{ChecksumSHA256, "hello world", "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"},
+		// the values Google Cloud Storage reports in x-goog-hash for the same content
+		{ChecksumCRC32C, "", "AAAAAA=="},
+		{ChecksumCRC32C, "hello world", "yZRlqg=="},
+		// the check value of CRC-32C, 0xe3069283
+		{ChecksumCRC32C, "123456789", "4waSgw=="},
+	} {
+		fs := afero.NewMemMapFs()
+		if err := afero.WriteFile(fs, "file", []byte(tc.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ComputeChecksumWith(context.Background(), fs, "file", tc.algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("%s of %q: got %s, want %s", tc.algorithm, tc.data, got, tc.want)
+		}
+	}
+}
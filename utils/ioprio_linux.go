@@ -0,0 +1,44 @@
+//go:build linux
+
+package utils
+
+import (
+	"runtime"
+	"syscall"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioLowest     = 7
+)
+
+// RunWithLowIOPriority runs fn on a thread with the lowest best-effort I/O priority, so its reads yield to the I/O
+// of other threads. fn must do its I/O on the calling goroutine. If the priority cannot be changed fn runs anyway.
+func RunWithLowIOPriority(fn func() error) error {
+	runtime.LockOSThread()
+
+	tid := uintptr(syscall.Gettid())
+	previous, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, tid, 0)
+	if errno != 0 {
+		runtime.UnlockOSThread()
+		return fn()
+	}
+
+	_, _, errno = syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, tid, ioprioClassBE<<ioprioClassShift|ioprioLowest)
+	if errno != 0 {
+		runtime.UnlockOSThread()
+		return fn()
+	}
+
+	defer func() {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, tid, previous)
+		if errno == 0 {
+			runtime.UnlockOSThread()
+		}
+		// otherwise the thread stays locked and exits with the goroutine instead of slowing down other work
+	}()
+
+	return fn()
+}
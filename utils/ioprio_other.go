@@ -0,0 +1,8 @@
+//go:build !linux
+
+package utils
+
+// RunWithLowIOPriority runs fn, I/O priorities are only supported on Linux.
+func RunWithLowIOPriority(fn func() error) error {
+	return fn()
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/spf13/afero"
+)
+
+// mmapWindowSize is the size of a single mapping, it must be a multiple of the page size.
+const mmapWindowSize = 64 << 20
+
+var errMmapUnsupported = errors.New("mmap is not supported")
+
+// ComputeChecksumMmap computes the same checksum as ComputeChecksumContext, but when the file is backed by the real
+// OS filesystem it is memory mapped and hashed in large windows. Other backends fall back to the buffered copy.
+func ComputeChecksumMmap(ctx context.Context, fs afero.Fs, path string) (string, error) {
+	return ComputeChecksumMmapWith(ctx, fs, path, ChecksumSHA256)
+}
+
+// ComputeChecksumMmapWith is ComputeChecksumMmap with a given algorithm.
+func ComputeChecksumMmapWith(ctx context.Context, fs afero.Fs, path string, algorithm ChecksumAlgorithm) (string, error) {
+	file, err := fs.OpenFile(path, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := algorithm.newHash()
+
+	if osFile, ok := unwrapOsFile(file); ok {
+		err = hashMmap(ctx, osFile, hash)
+		if err == nil {
+			return algorithm.encode(hash.Sum(nil)), nil
+		}
+		if !errors.Is(err, errMmapUnsupported) {
+			return "", err
+		}
+	}
+
+	if _, err := io.Copy(hash, NewContextReader(ctx, file)); err != nil {
+		return "", err
+	}
+
+	return algorithm.encode(hash.Sum(nil)), nil
+}
+
+// unwrapOsFile returns the *os.File behind an afero file, looking through afero.BasePathFs wrappers and files with
+// an Unwrap method.
+func unwrapOsFile(file afero.File) (*os.File, bool) {
+	for {
+		switch f := file.(type) {
+		case *os.File:
+			return f, true
+		case *afero.BasePathFile:
+			file = f.File
+		case interface{ Unwrap() afero.File }:
+			file = f.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+}
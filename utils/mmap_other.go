@@ -0,0 +1,13 @@
+//go:build !unix
+
+package utils
+
+import (
+	"context"
+	"hash"
+	"os"
+)
+
+func hashMmap(ctx context.Context, file *os.File, hash hash.Hash) error {
+	return errMmapUnsupported
+}
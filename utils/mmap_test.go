@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+const benchmarkFileSize = 32 << 20
+
+func writeRandomFile(tb testing.TB, size int) (afero.Fs, string) {
+	tb.Helper()
+
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		tb.Fatal(err)
+	}
+
+	fs := afero.NewOsFs()
+	path := filepath.Join(tb.TempDir(), "file")
+	if err := afero.WriteFile(fs, path, data, 0644); err != nil {
+		tb.Fatal(err)
+	}
+	return fs, path
+}
+
+func TestComputeChecksumMmapMatchesStreaming(t *testing.T) {
+	fs, path := writeRandomFile(t, mmapWindowSize+12345)
+
+	for _, algorithm := range []ChecksumAlgorithm{ChecksumSHA256, ChecksumCRC32C, ChecksumSHA256Tree} {
+		streamed, err := ComputeChecksumWith(context.Background(), fs, path, algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mapped, err := ComputeChecksumMmapWith(context.Background(), fs, path, algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mapped != streamed {
+			t.Errorf("%s: mmap checksum %s, streaming checksum %s", algorithm, mapped, streamed)
+		}
+	}
+}
+
+func BenchmarkComputeChecksum(b *testing.B) {
+	fs, path := writeRandomFile(b, benchmarkFileSize)
+
+	b.Run("streaming", func(b *testing.B) {
+		b.SetBytes(benchmarkFileSize)
+		for i := 0; i < b.N; i++ {
+			if _, err := ComputeChecksumContext(context.Background(), fs, path); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("mmap", func(b *testing.B) {
+		b.SetBytes(benchmarkFileSize)
+		for i := 0; i < b.N; i++ {
+			if _, err := ComputeChecksumMmap(context.Background(), fs, path); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,47 @@
+//go:build unix
+
+package utils
+
+import (
+	"context"
+	"hash"
+	"os"
+	"syscall"
+)
+
+// hashMmap feeds the whole file to the hash, mapping it window by window.
+func hashMmap(ctx context.Context, file *os.File, hash hash.Hash) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	size := info.Size()
+	for offset := int64(0); offset < size; offset += mmapWindowSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		length := size - offset
+		if length > mmapWindowSize {
+			length = mmapWindowSize
+		}
+
+		data, err := syscall.Mmap(int(file.Fd()), offset, int(length), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			if offset == 0 {
+				return errMmapUnsupported
+			}
+			return err
+		}
+
+		hash.Write(data)
+
+		err = syscall.Munmap(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
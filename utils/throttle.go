@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+type throttledReader struct {
+	ctx            context.Context
+	r              io.Reader
+	bytesPerSecond int64
+	start          time.Time
+	read           int64
+}
+
+// NewThrottledReader returns a reader which reads at most bytesPerSecond on average, waiting stops once the context
+// is done. A non-positive rate does not throttle.
+func NewThrottledReader(ctx context.Context, r io.Reader, bytesPerSecond int64) io.Reader {
+	if bytesPerSecond <= 0 {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, bytesPerSecond: bytesPerSecond}
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+	if int64(len(p)) > r.bytesPerSecond {
+		p = p[:r.bytesPerSecond]
+	}
+
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+
+	due := r.start.Add(time.Duration(float64(r.read) / float64(r.bytesPerSecond) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-r.ctx.Done():
+			return n, r.ctx.Err()
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"hash"
+)
+
+// TreeHashLeafSize is the size of the leaves of a SHA-256 tree hash.
+const TreeHashLeafSize = 1 << 20
+
+// treeHash computes a SHA-256 tree hash like the one used by Glacier, the data is split into 1 MiB leaves which are
+// hashed separately and then combined pairwise until a single root digest is left.
+type treeHash struct {
+	leaf   hash.Hash
+	filled int
+	leaves [][]byte
+}
+
+// NewTreeHash returns a hash.Hash computing a SHA-256 tree hash.
+func NewTreeHash() hash.Hash {
+	return &treeHash{leaf: sha256.New()}
+}
+
+func (t *treeHash) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := TreeHashLeafSize - t.filled
+		if take > len(p) {
+			take = len(p)
+		}
+		t.leaf.Write(p[:take])
+		t.filled += take
+		p = p[take:]
+
+		if t.filled == TreeHashLeafSize {
+			t.leaves = append(t.leaves, t.leaf.Sum(nil))
+			t.leaf.Reset()
+			t.filled = 0
+		}
+	}
+	return n, nil
+}
+
+func (t *treeHash) Sum(b []byte) []byte {
+	leaves := t.leaves
+	if t.filled > 0 || len(leaves) == 0 {
+		leaves = append(leaves[:len(leaves):len(leaves)], t.leaf.Sum(nil))
+	}
+	return append(b, CombineTreeHash(leaves)...)
+}
+
+func (t *treeHash) Reset() {
+	t.leaf.Reset()
+	t.filled = 0
+	t.leaves = nil
+}
+
+func (t *treeHash) Size() int {
+	return sha256.Size
+}
+
+func (t *treeHash) BlockSize() int {
+	return sha256.BlockSize
+}
+
+// CombineTreeHash combines leaf digests into the root of a tree hash, an odd digest on a level is promoted as is.
+func CombineTreeHash(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	}
+
+	level := leaves
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			h := sha256.New()
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		level = next
+	}
+
+	return level[0]
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func sum256(parts ...[]byte) []byte {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+func TestTreeHash(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 5*TreeHashLeafSize/16)
+	leaf := func(i int, size int) []byte {
+		return sum256(data[i*TreeHashLeafSize : i*TreeHashLeafSize+size])
+	}
+
+	for _, tc := range []struct {
+		name string
+		size int
+		want []byte
+	}{
+		{"empty", 0, sum256()},
+		{"one byte", 1, sum256(data[:1])},
+		{"one byte short of a leaf", TreeHashLeafSize - 1, sum256(data[:TreeHashLeafSize-1])},
+		{"one leaf", TreeHashLeafSize, sum256(data[:TreeHashLeafSize])},
+		{"one byte past a leaf", TreeHashLeafSize + 1, sum256(leaf(0, TreeHashLeafSize), leaf(1, 1))},
+		{"two leaves", 2 * TreeHashLeafSize, sum256(leaf(0, TreeHashLeafSize), leaf(1, TreeHashLeafSize))},
+		// the odd third leaf is promoted to the next level as is
+		{"three leaves", 2*TreeHashLeafSize + 5, sum256(sum256(leaf(0, TreeHashLeafSize), leaf(1, TreeHashLeafSize)), leaf(2, 5))},
+		{"five leaves", 5 * TreeHashLeafSize, sum256(
+			sum256(sum256(leaf(0, TreeHashLeafSize), leaf(1, TreeHashLeafSize)), sum256(leaf(2, TreeHashLeafSize), leaf(3, TreeHashLeafSize))),
+			leaf(4, TreeHashLeafSize),
+		)},
+	} {
+		// writes of an odd size straddle the leaf boundaries
+		for _, writeSize := range []int{len(data), 700 << 10, 4099} {
+			h := NewTreeHash()
+			for p := data[:tc.size]; len(p) > 0; {
+				n := min(writeSize, len(p))
+				h.Write(p[:n])
+				p = p[n:]
+			}
+			if got := h.Sum(nil); !bytes.Equal(got, tc.want) {
+				t.Errorf("%s in writes of %d bytes: got %s, want %s", tc.name, writeSize, hex.EncodeToString(got), hex.EncodeToString(tc.want))
+			}
+		}
+	}
+}
@@ -1,24 +1,75 @@
 package utils
 
 import (
-	"crypto/sha256"
+	"context"
 	"encoding/hex"
+	"hash"
 	"io"
 
 	"github.com/spf13/afero"
 )
 
 func ComputeChecksum(fs afero.Fs, path string) (string, error) {
+	return ComputeChecksumContext(context.Background(), fs, path)
+}
+
+// ComputeChecksumContext computes the checksum of a file, it stops reading as soon as the context is done.
+func ComputeChecksumContext(ctx context.Context, fs afero.Fs, path string) (string, error) {
+	return ComputeChecksumWith(ctx, fs, path, ChecksumSHA256)
+}
+
+// ComputeChecksumWith computes the checksum of a file with a given algorithm, it stops reading as soon as the
+// context is done.
+func ComputeChecksumWith(ctx context.Context, fs afero.Fs, path string, algorithm ChecksumAlgorithm) (string, error) {
+	return ComputeChecksumThrottled(ctx, fs, path, algorithm, 0)
+}
+
+// ComputeChecksumThrottled is ComputeChecksumWith which reads at most bytesPerSecond, see NewThrottledReader.
+func ComputeChecksumThrottled(ctx context.Context, fs afero.Fs, path string, algorithm ChecksumAlgorithm, bytesPerSecond int64) (string, error) {
 	file, err := fs.OpenFile(path, 0, 0)
 	if err != nil {
 		return "", err
 	}
 	defer file.Close()
 
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
+	return ComputeReaderChecksum(ctx, NewThrottledReader(ctx, file, bytesPerSecond), algorithm)
+}
+
+// ComputeReaderChecksum computes the checksum of everything read from r with a given algorithm, in the encoding the
+// server uses for it. It stops reading as soon as the context is done.
+func ComputeReaderChecksum(ctx context.Context, r io.Reader, algorithm ChecksumAlgorithm) (string, error) {
+	hash := algorithm.newHash()
+	if _, err := io.Copy(hash, NewContextReader(ctx, r)); err != nil {
+		return "", err
+	}
+
+	return algorithm.encode(hash.Sum(nil)), nil
+}
+
+// ComputeReaderHash computes the hex encoded digest of everything read from r with a hash created by newHash, for
+// algorithms which are not a ChecksumAlgorithm. It stops reading as soon as the context is done.
+func ComputeReaderHash(ctx context.Context, r io.Reader, newHash func() hash.Hash) (string, error) {
+	h := newHash()
+	if _, err := io.Copy(h, NewContextReader(ctx, r)); err != nil {
 		return "", err
 	}
 
-	return hex.EncodeToString(hash.Sum(nil)), nil
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// NewContextReader returns a reader which fails with the context error once the context is done.
+func NewContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
 }
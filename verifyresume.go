@@ -0,0 +1,193 @@
+package chunkeduploader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+	"github.com/gorilla/mux"
+)
+
+// maxVerifyResumeChunks bounds the chunks a single resume verification re-hashes.
+const maxVerifyResumeChunks = 64
+
+var TooManyChunksToVerifyError = errors.New("too many chunks to verify")
+var InvalidChunkHashError = errors.New("invalid chunk hash")
+
+// ChunkHash is the SHA-256 of a chunk as the client sent it, the same checksum the server returns in X-Checksum.
+// Chunk checksums are SHA-256 whatever algorithm finished uploads are verified with.
+type ChunkHash struct {
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"`
+	Checksum string `json:"checksum"`
+}
+
+// VerifiedChunk tells whether a chunk is stored as the client sent it.
+type VerifiedChunk struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Match bool  `json:"match"`
+}
+
+// ResumeVerification is the result of VerifyResume.
+type ResumeVerification struct {
+	Chunks    []VerifiedChunk `json:"chunks"`
+	Committed int64           `json:"committed"`
+	Regions   []ByteRange     `json:"regions"`
+}
+
+// VerifyResume re-hashes the chunks a resuming client sent last and compares them with the hashes the client kept.
+// After a crash the regions recorded in the metadata may cover data which never reached the disk, so everything
+// from the first chunk which does not match is forgotten and the client has to send it again. The returned committed
+// offset is where the client should continue.
+func (c *ChunkedUploaderService) VerifyResume(ctx context.Context, uploadId string, chunks []ChunkHash) (*ResumeVerification, error) {
+	if len(chunks) > maxVerifyResumeChunks {
+		return nil, fmt.Errorf("ChunkedUploaderService.VerifyResume %w: at most %d", TooManyChunksToVerifyError, maxVerifyResumeChunks)
+	}
+	for _, chunk := range chunks {
+		if chunk.Start < 0 || chunk.End < chunk.Start {
+			return nil, fmt.Errorf("ChunkedUploaderService.VerifyResume %w: range %d-%d", InvalidChunkHashError, chunk.Start, chunk.End)
+		}
+	}
+
+	err := c.flushWriteBuffer(uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.VerifyResume failed to flush write buffer %w", err)
+	}
+
+	verification := &ResumeVerification{Chunks: make([]VerifiedChunk, 0, len(chunks))}
+	err = c.updateMetadata(uploadId, func(meta *UploadMetadata) error {
+		if meta.finished() {
+			return UploadAlreadyFinishedError
+		}
+
+		rollback := int64(-1)
+		err := c.backgroundRead(func() error {
+			file, err := c.fs.Open(c.getUploadFilePath(uploadId))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			for _, chunk := range chunks {
+				checksum, err := hashRange(ctx, file, chunk, sha256.New())
+				if err != nil {
+					return err
+				}
+
+				match := checksum == chunk.Checksum
+				verification.Chunks = append(verification.Chunks, VerifiedChunk{Start: chunk.Start, End: chunk.End, Match: match})
+				if !match && (rollback == -1 || chunk.Start < rollback) {
+					rollback = chunk.Start
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if rollback >= 0 {
+			c.log(LogLevelWarn, "Resumed upload lost data, rolling back", LogField{"upload_id", uploadId}, LogField{"offset", rollback})
+			meta.Regions = truncateRegions(meta.Regions, rollback)
+			meta.TreeLeaves = truncateTreeLeaves(meta.TreeLeaves, rollback)
+			meta.invalidateChecksum()
+			c.setWritten(uploadId, regionsLength(meta.Regions))
+		}
+		verification.Committed = committedOffset(meta.Regions)
+		verification.Regions = meta.Regions
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ChunkedUploaderService.VerifyResume failed to verify chunks %w", err)
+	}
+	if verification.Regions == nil {
+		verification.Regions = []ByteRange{}
+	}
+
+	return verification, nil
+}
+
+// hashRange returns the digest of a range of a file with a given hash, a range reaching past the end of the file
+// hashes what is there.
+func hashRange(ctx context.Context, file io.ReaderAt, chunk ChunkHash, hasher hash.Hash) (string, error) {
+	section := io.NewSectionReader(file, chunk.Start, chunk.End-chunk.Start+1)
+	_, err := io.Copy(hasher, utils.NewContextReader(ctx, section))
+	// afero's in-memory files report reads starting past the end as unexpected EOF rather than EOF
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// truncateRegions drops everything at and after a given offset from sorted, non-overlapping regions.
+func truncateRegions(regions []ByteRange, offset int64) []ByteRange {
+	truncated := make([]ByteRange, 0, len(regions))
+	for _, r := range regions {
+		if r.Start >= offset {
+			break
+		}
+		if r.End >= offset {
+			r.End = offset - 1
+		}
+		truncated = append(truncated, r)
+	}
+	return truncated
+}
+
+// truncateTreeLeaves forgets the tree hash leaves touching a given offset or coming after it.
+func truncateTreeLeaves(leaves []string, offset int64) []string {
+	first := offset / utils.TreeHashLeafSize
+	if first < int64(len(leaves)) {
+		leaves = leaves[:first]
+	}
+	return leaves
+}
+
+type VerifyResumeRequest struct {
+	Chunks []ChunkHash `json:"chunks"`
+}
+
+// VerifyResumeHandler compares the hashes of the last chunks a resuming client sent with the stored data of a given
+// uploadId and rolls the upload back to the first chunk which does not match.
+func (c *ChunkedUploaderHandler) VerifyResumeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	var req VerifyResumeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	verification, err := c.service.VerifyResume(r.Context(), uploadId, req.Chunks)
+	if err != nil {
+		switch {
+		case errors.Is(err, TooManyChunksToVerifyError), errors.Is(err, InvalidChunkHashError):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, UploadNotFoundError):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, UploadAlreadyFinishedError):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to verify resume: "+err.Error())
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(verification)
+}
@@ -0,0 +1,136 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Craftserve/chunked-uploader/pkg/client"
+	"github.com/spf13/afero"
+)
+
+func TestVerifyResumeAfterLostData(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// damage changes the pending file behind the back of the server, like a crash losing unsynced pages
+		damage        func(t *testing.T, file afero.File)
+		wantMatches   []bool
+		wantCommitted int64
+	}{
+		{name: "intact", damage: func(t *testing.T, file afero.File) {}, wantMatches: []bool{true, true, true}, wantCommitted: 4000},
+		{name: "last chunk truncated", damage: func(t *testing.T, file afero.File) {
+			if err := file.Truncate(3500); err != nil {
+				t.Fatal(err)
+			}
+		}, wantMatches: []bool{true, true, false}, wantCommitted: 3000},
+		{name: "two chunks truncated", damage: func(t *testing.T, file afero.File) {
+			if err := file.Truncate(2000); err != nil {
+				t.Fatal(err)
+			}
+		}, wantMatches: []bool{true, false, false}, wantCommitted: 2000},
+		{name: "byte lost in an earlier chunk", damage: func(t *testing.T, file afero.File) {
+			b := make([]byte, 1)
+			if _, err := file.ReadAt(b, 1500); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := file.WriteAt([]byte{^b[0]}, 1500); err != nil {
+				t.Fatal(err)
+			}
+		}, wantMatches: []bool{false, true, true}, wantCommitted: 1000},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			service := newTestService(fs)
+			handler := NewHTTPHandler(service)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			data := randomBytes(t, 4000)
+			uploadId, err := service.CreateUpload(int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			uploadOverHTTP(t, handler, uploadId, data, 1000)
+
+			file, err := fs.OpenFile(service.getUploadFilePath(uploadId), os.O_RDWR, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.damage(t, file)
+			file.Close()
+
+			c := client.Client{Endpoint: server.URL, ChunkSize: 1000, UploadId: &uploadId, DoRequest: http.DefaultClient.Do}
+			chunks, err := c.LastChunkHashes(bytes.NewReader(data), int64(len(data)), 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := c.VerifyResume(context.Background(), chunks)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(resp.Chunks) != len(tc.wantMatches) {
+				t.Fatalf("%d chunks verified, want %d", len(resp.Chunks), len(tc.wantMatches))
+			}
+			for i, chunk := range resp.Chunks {
+				if chunk.Match != tc.wantMatches[i] {
+					t.Errorf("chunk %d-%d: match %t, want %t", chunk.Start, chunk.End, chunk.Match, tc.wantMatches[i])
+				}
+			}
+			if resp.Committed != tc.wantCommitted {
+				t.Fatalf("committed %d, want %d", resp.Committed, tc.wantCommitted)
+			}
+			if len(resp.Regions) != 1 || resp.Regions[0].Start != 0 || resp.Regions[0].End != tc.wantCommitted-1 {
+				t.Errorf("regions %v, want 0-%d", resp.Regions, tc.wantCommitted-1)
+			}
+
+			// resending from the committed offset repairs the upload
+			for offset := int(resp.Committed); offset < len(data); offset += 1000 {
+				rec := postChunk(handler, uploadId, "application/octet-stream", fmt.Sprintf("bytes=%d-%d", offset, offset+999), data[offset:offset+1000])
+				if rec.Code != http.StatusOK {
+					t.Fatalf("chunk at %d: %d %s", offset, rec.Code, rec.Body)
+				}
+			}
+			if _, err := service.FinishUpload(context.Background(), uploadId, sha256Hex(data)); err != nil {
+				t.Errorf("finish after the resume: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyResumeHandlerErrors(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	handler := NewHTTPHandler(service)
+
+	uploading, err := service.CreateUpload(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished, _, _ := newFinishedTestUpload(t, service)
+
+	for _, tc := range []struct {
+		name     string
+		uploadId string
+		body     string
+		want     int
+	}{
+		{"too many chunks", uploading, `{"chunks": [` + strings.TrimSuffix(strings.Repeat(`{"start": 0, "end": 0},`, maxVerifyResumeChunks+1), ",") + `]}`, http.StatusBadRequest},
+		{"inverted range", uploading, `{"chunks": [{"start": 10, "end": 5}]}`, http.StatusBadRequest},
+		{"negative start", uploading, `{"chunks": [{"start": -1, "end": 5}]}`, http.StatusBadRequest},
+		{"invalid json", uploading, `{`, http.StatusBadRequest},
+		{"finished upload", finished, `{"chunks": []}`, http.StatusConflict},
+		{"unknown upload", "doesnotexist", `{"chunks": []}`, http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/"+tc.uploadId+"/verify-resume", strings.NewReader(tc.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body)
+		}
+	}
+}
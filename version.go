@@ -0,0 +1,202 @@
+package chunkeduploader
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+)
+
+// APIVersion is the version of the HTTP API served by ChunkedUploaderHandler.
+const APIVersion = "v1"
+
+// Version is the version of the server build, it is meant to be set at link time with
+// go build -ldflags "-X github.com/Craftserve/chunked-uploader.Version=v1.2.3". When empty ReadBuildInfo falls back
+// to the module version.
+var Version string
+
+// BuildInfo describes the build of the server, it is usually filled in at compile time with
+// go build -ldflags "-X main.Version=...", see ReadBuildInfo for a fallback.
+type BuildInfo struct {
+	Version   string
+	BuildTime string
+	GoVersion string
+}
+
+// ReadBuildInfo returns the build info embedded in the binary by the go tool.
+func ReadBuildInfo() BuildInfo {
+	info := BuildInfo{Version: "devel", GoVersion: runtime.Version()}
+	if Version != "" {
+		info.Version = Version
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if build.Main.Version != "" && Version == "" {
+		info.Version = build.Main.Version
+	}
+	for _, setting := range build.Settings {
+		if setting.Key == "vcs.time" {
+			info.BuildTime = setting.Value
+		}
+	}
+
+	return info
+}
+
+// EnabledFeatures returns the optional features the service is configured with, so clients can detect them.
+func (c *ChunkedUploaderService) EnabledFeatures() []string {
+	features := []string{"metadata", "append", "regions", "fingerprint", "batch_finish", "range_read", "websocket", "copy", "strict_resume", "verify_resume", "event_log"}
+
+	if c.signer != nil {
+		features = append(features, "signed_urls")
+	}
+	if c.reservations != nil {
+		features = append(features, "disk_reservations")
+	}
+	if c.importRoot != "" {
+		features = append(features, "imports")
+	}
+	if c.uploadTTL > 0 {
+		features = append(features, "upload_ttl")
+	}
+	if len(c.scanners) > 0 {
+		features = append(features, "scanners")
+	}
+	if c.coalescer != nil {
+		features = append(features, "write_coalescing")
+	}
+	if c.parallelChunks != nil {
+		features = append(features, "parallel_chunks")
+	}
+	if c.mmapChecksum {
+		features = append(features, "mmap_checksum")
+	}
+	if c.signatureVerifier != nil {
+		features = append(features, "signatures")
+	}
+	if c.chunkTuner != nil {
+		features = append(features, "chunk_size_tuning")
+	}
+	if c.finalizeCommand != nil {
+		features = append(features, "finalize_command")
+	}
+	if c.memory != nil {
+		features = append(features, "in_memory_uploads")
+	}
+	if c.maxUploadDuration > 0 {
+		features = append(features, "max_upload_duration")
+	}
+	if c.notifications != nil && len(c.notifications.names) > 0 {
+		features = append(features, "notifications")
+	}
+	if c.uploadTokenTTL > 0 {
+		features = append(features, "upload_tokens")
+	}
+	if c.treeLeaves {
+		features = append(features, "tree_hash")
+	}
+	if _, ok := c.layout.(FlatLayout); !ok {
+		features = append(features, "sharded_layout")
+	}
+
+	return features
+}
+
+type VersionResponse struct {
+	Version    string   `json:"version"`
+	APIVersion string   `json:"api_version"`
+	Features   []string `json:"features"`
+	BuildTime  string   `json:"build_time,omitempty"`
+	GoVersion  string   `json:"go_version"`
+	// RecommendedChunkSize is the chunk size clients should use, see WithChunkSizeTuning.
+	RecommendedChunkSize int64 `json:"recommended_chunk_size,omitempty"`
+}
+
+// VersionHandler returns a handler describing the build of the server, the API version and the enabled features.
+func (c *ChunkedUploaderHandler) VersionHandler(info BuildInfo) http.HandlerFunc {
+	if info.GoVersion == "" {
+		info.GoVersion = runtime.Version()
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(VersionResponse{
+			Version:    info.Version,
+			APIVersion: APIVersion,
+			Features:   c.features(),
+			BuildTime:  info.BuildTime,
+			GoVersion:  info.GoVersion,
+
+			RecommendedChunkSize: c.service.RecommendedChunkSize(),
+		})
+	}
+}
+
+// Capabilities describes what a server supports, derived from the options of the service, so clients can enable
+// their optional behaviors without trial and error.
+type Capabilities struct {
+	Version    string   `json:"version"`
+	APIVersion string   `json:"api_version"`
+	Features   []string `json:"features"`
+	// ChecksumAlgorithms are the algorithms a finish may use, DefaultChecksumAlgorithm the one used without any.
+	ChecksumAlgorithms       []utils.ChecksumAlgorithm `json:"checksum_algorithms"`
+	DefaultChecksumAlgorithm utils.ChecksumAlgorithm   `json:"default_checksum_algorithm"`
+	// ChunkEncodings are the content types chunks may be sent with.
+	ChunkEncodings []string `json:"chunk_encodings"`
+	// MaxParallelChunks, MaxFileSize and MaxChunkCount are zero without a limit.
+	MaxParallelChunks    int   `json:"max_parallel_chunks,omitempty"`
+	MaxFileSize          int64 `json:"max_file_size,omitempty"`
+	MaxChunkCount        int64 `json:"max_chunk_count,omitempty"`
+	RecommendedChunkSize int64 `json:"recommended_chunk_size,omitempty"`
+	SignedURLs           bool  `json:"signed_urls"`
+}
+
+// Capabilities returns what the service supports with the options it was created with.
+func (c *ChunkedUploaderService) Capabilities() Capabilities {
+	capabilities := Capabilities{
+		Version:                  ReadBuildInfo().Version,
+		APIVersion:               APIVersion,
+		Features:                 c.EnabledFeatures(),
+		ChecksumAlgorithms:       []utils.ChecksumAlgorithm{utils.ChecksumSHA256, utils.ChecksumCRC32C, utils.ChecksumSHA256Tree},
+		DefaultChecksumAlgorithm: c.checksumAlgorithm,
+		ChunkEncodings:           []string{"application/octet-stream", "application/json"},
+		MaxChunkCount:            c.maxChunkCount,
+		RecommendedChunkSize:     c.RecommendedChunkSize(),
+		SignedURLs:               c.signer != nil,
+	}
+	if c.hashFunc != nil {
+		capabilities.ChecksumAlgorithms = append(capabilities.ChecksumAlgorithms, c.checksumAlgorithm)
+	}
+	if c.parallelChunks != nil {
+		capabilities.MaxParallelChunks = c.parallelChunks.limit
+	}
+	if c.maxFileSize != nil {
+		capabilities.MaxFileSize = *c.maxFileSize
+	}
+
+	return capabilities
+}
+
+// CapabilitiesHandler returns the capabilities of the service.
+func (c *ChunkedUploaderHandler) CapabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	capabilities := c.service.Capabilities()
+	capabilities.Features = c.features()
+	if c.buildInfo != nil {
+		capabilities.Version = c.buildInfo.Version
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(capabilities)
+}
+
+// setCapabilitiesHeader advertises the enabled features in X-Uploader-Capabilities, the full capabilities are served
+// by CapabilitiesHandler.
+func (c *ChunkedUploaderHandler) setCapabilitiesHeader(w http.ResponseWriter) {
+	w.Header().Set("X-Uploader-Capabilities", strings.Join(c.features(), ","))
+}
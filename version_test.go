@@ -0,0 +1,69 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestVersionHandler(t *testing.T) {
+	info := BuildInfo{Version: "1.2.3", BuildTime: "2024-01-02T03:04:05Z", GoVersion: "go1.21.0"}
+
+	for _, tc := range []struct {
+		name        string
+		opts        []ChunkedUploaderServiceOption
+		handlerOpts []ChunkedUploaderHandlerOption
+		want        []string
+		notWant     []string
+	}{
+		{name: "defaults", want: []string{"metadata", "append", "regions"}, notWant: []string{"signed_urls", "upload_ttl", "scanners", "tree_hash", "sharded_layout", "query_parameters", "in_memory_uploads"}},
+		{name: "signed downloads", opts: []ChunkedUploaderServiceOption{WithSignedDownloads([]byte("secret"), "http://localhost", time.Minute)}, want: []string{"signed_urls"}},
+		{name: "upload ttl", opts: []ChunkedUploaderServiceOption{WithUploadTTL(time.Hour)}, want: []string{"upload_ttl"}},
+		{name: "scanners", opts: []ChunkedUploaderServiceOption{WithScanner(func(ctx context.Context, uploadId string, file io.Reader) error { return nil })}, want: []string{"scanners"}},
+		{name: "tree hash", opts: []ChunkedUploaderServiceOption{WithTreeHashLeaves()}, want: []string{"tree_hash"}},
+		{name: "sharded layout", opts: []ChunkedUploaderServiceOption{WithLayout(ShardedLayout{})}, want: []string{"sharded_layout"}},
+		{name: "in-memory uploads", opts: []ChunkedUploaderServiceOption{WithInMemoryUploads(1<<20, 8<<20)}, want: []string{"in_memory_uploads"}},
+		{name: "query parameters", handlerOpts: []ChunkedUploaderHandlerOption{WithQueryParameters()}, want: []string{"query_parameters"}},
+		{name: "several", opts: []ChunkedUploaderServiceOption{WithUploadTTL(time.Hour), WithTreeHashLeaves()}, want: []string{"upload_ttl", "tree_hash"}, notWant: []string{"scanners"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs(), tc.opts...)
+			handler := NewHTTPHandler(service, append(tc.handlerOpts, WithBuildInfo(info))...)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("version: %d %s", rec.Code, rec.Body)
+			}
+
+			var resp VersionResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Version != info.Version || resp.BuildTime != info.BuildTime || resp.GoVersion != info.GoVersion || resp.APIVersion != APIVersion {
+				t.Errorf("build info: got %+v, want %+v and api version %s", resp, info, APIVersion)
+			}
+
+			features := map[string]bool{}
+			for _, feature := range resp.Features {
+				features[feature] = true
+			}
+			for _, feature := range tc.want {
+				if !features[feature] {
+					t.Errorf("features %v miss %s", resp.Features, feature)
+				}
+			}
+			for _, feature := range tc.notWant {
+				if features[feature] {
+					t.Errorf("features %v contain %s", resp.Features, feature)
+				}
+			}
+		})
+	}
+}
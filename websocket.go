@@ -0,0 +1,238 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Craftserve/chunked-uploader/utils"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// defaultWebSocketFrameLimit bounds the frames of WebSocketUploadHandler when the service has no maximum part size,
+// every frame is held in memory while it is written.
+const defaultWebSocketFrameLimit = 4 << 20
+
+// webSocketOffsetLength is the length of the little-endian offset prefixing every binary frame.
+const webSocketOffsetLength = 8
+
+// WebSocketAck acknowledges a chunk received over a WebSocket, see WebSocketUploadHandler.
+type WebSocketAck struct {
+	Offset   int64  `json:"offset"`
+	Checksum string `json:"checksum"`
+}
+
+// WebSocketFinish is the text frame finishing an upload sent over a WebSocket.
+type WebSocketFinish struct {
+	Finish    bool                    `json:"finish"`
+	Checksum  string                  `json:"checksum"`
+	Algorithm utils.ChecksumAlgorithm `json:"algorithm,omitempty"`
+}
+
+// WebSocketUploadHandler receives the chunks of a given upload over a WebSocket, which lets browsers stream them with
+// backpressure. Every binary frame is a chunk prefixed with its 8-byte little-endian offset and is acknowledged with
+// a WebSocketAck once written. A WebSocketFinish text frame finishes the upload, the server answers with the path
+// and closes the connection. Any error is sent as a JSON frame with error and code before the connection is closed.
+// With WithCORS only the allowed origins may connect, otherwise only the origin of the server.
+func (c *ChunkedUploaderHandler) WebSocketUploadHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadId := vars["upload_id"]
+
+	if uploadId == "" {
+		writeJSONError(w, http.StatusBadRequest, "upload_id is required")
+		return
+	}
+
+	_, err := c.service.GetMetadata(r.Context(), uploadId)
+	if err != nil {
+		if errors.Is(err, UploadNotFoundError) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to read metadata: "+err.Error())
+		return
+	}
+
+	upgrader := websocket.Upgrader{}
+	if len(c.corsOrigins) > 0 {
+		upgrader.CheckOrigin = func(r *http.Request) bool {
+			return c.corsAllowed(r.Header.Get("Origin"))
+		}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already answered the request
+		return
+	}
+	defer conn.Close()
+
+	var frameLimit int64 = defaultWebSocketFrameLimit
+	if c.service.maxPartSize != nil {
+		frameLimit = *c.service.maxPartSize
+	}
+	conn.SetReadLimit(frameLimit + webSocketOffsetLength)
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				c.service.log(LogLevelWarn, "WebSocket upload failed", LogField{"upload_id", uploadId}, LogField{"error", err})
+			}
+			return
+		}
+
+		switch messageType {
+		case websocket.BinaryMessage:
+			if len(data) < webSocketOffsetLength {
+				closeWebSocket(conn, "frame is shorter than its offset", "invalid_frame")
+				return
+			}
+			offset := int64(binary.LittleEndian.Uint64(data))
+			if offset < 0 {
+				closeWebSocket(conn, "invalid offset", "invalid_frame")
+				return
+			}
+
+			chunk := data[webSocketOffsetLength:]
+			release, err := c.admitFrame(r.Context(), uploadId, offset, int64(len(chunk)))
+			if err != nil {
+				closeWebSocket(conn, err.Error(), webSocketErrorCode(err))
+				return
+			}
+
+			started := time.Now()
+			h, _, err := c.service.uploadChunk(uploadId, bytes.NewReader(chunk), offset)
+			release()
+			if err != nil {
+				closeWebSocket(conn, err.Error(), webSocketErrorCode(err))
+				return
+			}
+			c.service.recordChunk(int64(len(chunk)), time.Since(started))
+
+			err = conn.WriteJSON(WebSocketAck{Offset: offset, Checksum: h})
+			if err != nil {
+				return
+			}
+
+		case websocket.TextMessage:
+			var finish WebSocketFinish
+			if json.Unmarshal(data, &finish) != nil || !finish.Finish || finish.Checksum == "" {
+				closeWebSocket(conn, "expected a finish frame with a checksum", "invalid_frame")
+				return
+			}
+
+			algorithm := finish.Algorithm
+			if algorithm == "" {
+				algorithm = c.service.checksumAlgorithm
+			}
+			path, err := c.service.FinishUploadWithAlgorithm(r.Context(), uploadId, finish.Checksum, algorithm)
+			if err != nil {
+				closeWebSocket(conn, err.Error(), webSocketErrorCode(err))
+				return
+			}
+
+			err = conn.WriteJSON(map[string]interface{}{"finished": true, "path": path})
+			if err != nil {
+				return
+			}
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			return
+		}
+	}
+}
+
+// admitFrame admits a chunk received over a WebSocket like UploadChunkHandler admits a chunk request: it takes a
+// parallel chunk slot, reserves the length of the chunk against the in-flight budget and checks the committed
+// offset. Frames cannot carry X-Expected-Committed, so a frame of a strict resume upload must start at the committed
+// offset. The returned function releases the slot and the reserved bytes.
+func (c *ChunkedUploaderHandler) admitFrame(ctx context.Context, uploadId string, offset int64, length int64) (release func(), err error) {
+	releaseSlot, ok := c.service.acquireChunkSlot(uploadId)
+	if !ok {
+		return nil, TooManyParallelChunksError
+	}
+
+	releaseBytes, err := c.service.reserveInFlightBytes(ctx, length)
+	if err != nil {
+		releaseSlot()
+		return nil, err
+	}
+
+	err = c.service.checkCommittedOffset(uploadId, -1)
+	if errors.Is(err, ExpectedCommittedRequiredError) {
+		err = c.service.checkCommittedOffset(uploadId, offset)
+	}
+	if err != nil {
+		releaseBytes()
+		releaseSlot()
+		return nil, err
+	}
+
+	return func() {
+		releaseBytes()
+		releaseSlot()
+	}, nil
+}
+
+// webSocketErrorCode returns the code sent with an error of a WebSocket upload.
+func webSocketErrorCode(err error) string {
+	var expired *UploadExpiredError
+	var exceeded *UploadDurationExceededError
+	var mismatch *CommittedOffsetMismatchError
+	switch {
+	case errors.As(err, &expired):
+		return "upload_expired"
+	case errors.As(err, &exceeded):
+		return "deadline_exceeded"
+	case errors.Is(err, UploadAlreadyFinishedError):
+		return "upload_finished"
+	case errors.Is(err, UploadCancelledError):
+		return "cancelled"
+	case errors.Is(err, UploadFinishingError):
+		return "upload_finishing"
+	case errors.Is(err, AppendSequenceRequiredError):
+		return "append_only"
+	case errors.Is(err, FileSizeExceedsMaximumError):
+		return "file_too_large"
+	case errors.Is(err, FileChecksumMismatchError):
+		return "checksum_mismatch"
+	case errors.Is(err, InvalidSignatureError):
+		return "invalid_signature"
+	case errors.Is(err, ScanFailedError):
+		return "scan_failed"
+	case errors.Is(err, FinalizeCommandFailedError):
+		return "finalize_failed"
+	case errors.Is(err, TooFragmentedError):
+		return "too_fragmented"
+	case errors.Is(err, TooManyParallelChunksError):
+		return "too_many_parallel_chunks"
+	case errors.Is(err, InFlightBytesExceededError):
+		return "in_flight_limit"
+	case errors.As(err, &mismatch):
+		return "committed_offset_mismatch"
+	default:
+		return "internal_error"
+	}
+}
+
+// closeWebSocket sends an error frame and closes the connection.
+func closeWebSocket(conn *websocket.Conn, message string, code string) {
+	err := conn.WriteJSON(map[string]string{"error": message, "code": code})
+	if err != nil {
+		return
+	}
+
+	closeCode := websocket.ClosePolicyViolation
+	switch code {
+	case "invalid_frame":
+		closeCode = websocket.CloseUnsupportedData
+	case "internal_error":
+		closeCode = websocket.CloseInternalServerErr
+	}
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, code), time.Now().Add(time.Second))
+}
@@ -0,0 +1,123 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/spf13/afero"
+)
+
+// dialUpload opens a WebSocket upload of a given upload on a test server.
+func dialUpload(t *testing.T, service *ChunkedUploaderService, uploadId string) *websocket.Conn {
+	t.Helper()
+
+	server := httptest.NewServer(NewHTTPHandler(service))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/"+uploadId+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// sendFrame sends a chunk at a given offset and returns the frame the server answers with.
+func sendFrame(t *testing.T, conn *websocket.Conn, offset int64, chunk []byte) map[string]interface{} {
+	t.Helper()
+
+	frame := make([]byte, webSocketOffsetLength+len(chunk))
+	binary.LittleEndian.PutUint64(frame, uint64(offset))
+	copy(frame[webSocketOffsetLength:], chunk)
+	if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatal(err)
+	}
+
+	var response map[string]interface{}
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestWebSocketFramesAreAdmitted(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		opts  []ChunkedUploaderServiceOption
+		setup func(t *testing.T, service *ChunkedUploaderService, uploadId string)
+		code  string
+	}{
+		{
+			name: "parallel chunk limit",
+			opts: []ChunkedUploaderServiceOption{WithMaxParallelChunks(1)},
+			setup: func(t *testing.T, service *ChunkedUploaderService, uploadId string) {
+				release, _ := service.acquireChunkSlot(uploadId)
+				t.Cleanup(release)
+			},
+			code: "too_many_parallel_chunks",
+		},
+		{
+			name: "in-flight bytes limit",
+			opts: []ChunkedUploaderServiceOption{WithInFlightBytesLimit(1024, false)},
+			setup: func(t *testing.T, service *ChunkedUploaderService, uploadId string) {
+				release, err := service.reserveInFlightBytes(context.Background(), 1024)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(release)
+			},
+			code: "in_flight_limit",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := newTestService(afero.NewMemMapFs(), tc.opts...)
+			uploadId, err := service.CreateUpload(16)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.setup(t, service, uploadId)
+
+			response := sendFrame(t, dialUpload(t, service, uploadId), 0, randomBytes(t, 16))
+			if response["code"] != tc.code {
+				t.Errorf("got %v, want code %s", response, tc.code)
+			}
+		})
+	}
+}
+
+func TestWebSocketStrictResume(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	uploadId, err := service.CreateUpload(32, WithStrictResume())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := dialUpload(t, service, uploadId)
+
+	if response := sendFrame(t, conn, 0, randomBytes(t, 16)); response["error"] != nil {
+		t.Fatalf("frame at the committed offset: %v", response)
+	}
+	if response := sendFrame(t, conn, 0, randomBytes(t, 16)); response["code"] != "committed_offset_mismatch" {
+		t.Errorf("frame before the committed offset: got %v, want committed_offset_mismatch", response)
+	}
+}
+
+func TestWebSocketDefaultFrameLimit(t *testing.T) {
+	service := newTestService(afero.NewMemMapFs())
+	uploadId, err := service.CreateUpload(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := dialUpload(t, service, uploadId)
+
+	frame := make([]byte, webSocketOffsetLength+defaultWebSocketFrameLimit+1)
+	if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("got %v, want the connection closed for a too big message", err)
+	}
+}